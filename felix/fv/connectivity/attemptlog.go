@@ -0,0 +1,73 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// maxLoggedFailures is how many failing paths an attempt summary lists.
+const maxLoggedFailures = 5
+
+// CheckWithAttemptSummaries logs a one-line summary of each attempt at the given
+// level, for example:
+//
+//	attempt 4: 71/74 passing, failing: w2 -> w0 on port 8080, ...
+//
+// so that someone watching a long run can see whether the dataplane is
+// converging or stuck.
+func CheckWithAttemptSummaries(level log.Level) CheckerOpt {
+	return func(c *Checker) {
+		log.Debug("CheckWithAttemptSummaries set")
+		c.attemptSummaries = true
+		c.attemptSummaryLevel = level
+	}
+}
+
+// attemptSummary returns the one-line summary of the attempt.
+func attemptSummary(a *Attempt) string {
+	summary := fmt.Sprintf("attempt %d: %d/%d passing", a.Number, len(a.Results)-len(a.Mismatches), len(a.Results))
+	if len(a.Mismatches) > 0 {
+		var failing []string
+		for i, m := range a.Mismatches {
+			if i == maxLoggedFailures {
+				failing = append(failing, fmt.Sprintf("and %d more", len(a.Mismatches)-maxLoggedFailures))
+				break
+			}
+			failing = append(failing, m.Source+" -> "+m.Target)
+		}
+		summary += ", failing: " + strings.Join(failing, ", ")
+	}
+	if len(a.HarnessErrors) > 0 {
+		summary += fmt.Sprintf(", %d harness errors", len(a.HarnessErrors))
+	}
+	if n := len(a.Conntrack) + len(a.Denials) + len(a.Encap); n > 0 {
+		summary += fmt.Sprintf(", %d failed checks after connectivity", n)
+	}
+	if a.FinalTestErr != nil {
+		summary += ", final test failed"
+	}
+	return summary
+}
+
+func (c *Checker) logAttemptSummary(a *Attempt) {
+	if !c.attemptSummaries {
+		return
+	}
+	log.StandardLogger().Log(c.attemptSummaryLevel, attemptSummary(a))
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+// NewBaseline returns a Checker with the expectations and options that build
+// adds to it, for example the default allow matrix of a suite, to derive the
+// Checkers of the specs from with Clone().  The baseline itself is not meant to
+// be checked, so it isn't tracked in UnactivatedCheckers.
+//
+//	baseline := connectivity.NewBaseline(func(c *connectivity.Checker) {
+//		c.ExpectSome(w[0], w[1])
+//		c.ExpectSome(w[1], w[0])
+//	})
+//	...
+//	cc := baseline.Clone()
+//	cc.OverrideNone(w[0], w[1])
+//	cc.CheckConnectivity()
+func NewBaseline(build func(c *Checker)) *Checker {
+	c := &Checker{baseline: true}
+	build(c)
+	return c
+}
+
+// Clone returns a copy of the Checker, with its options and expectations, that
+// can be changed and checked without affecting the original.  The state of any
+// check in progress isn't copied.
+func (c *Checker) Clone() *Checker {
+	c.expectationsMutex().Lock()
+	clone := *c
+	clone.expectations = append([]Expectation(nil), c.expectations...)
+	clone.conntrackExpectations = append([]ConntrackExpectation(nil), c.conntrackExpectations...)
+	c.expectationsMutex().Unlock()
+
+	clone.lock = nil
+	clone.baseline = false
+	clone.continuous = nil
+	clone.traceCtx = nil
+	clone.lastAttempt = nil
+	clone.checkCalls = 0
+	clone.shuffleRand = nil
+	clone.lastConvergence = nil
+	clone.lastOverhead = HarnessOverhead{}
+	if len(clone.expectations) > 0 || len(clone.conntrackExpectations) > 0 {
+		markUnactivated(&clone)
+	}
+	return &clone
+}
+
+// OverrideSome is like ExpectSome(), but it replaces the expectations of the
+// same path, from the same source to the same IP and port, for example, ones
+// that the Checker was cloned with.
+func (c *Checker) OverrideSome(from ConnectionSource, to ConnectionTarget, explicitPort ...uint16) {
+	c.override(Some, from, to, ExpectWithPorts(explicitPort...))
+}
+
+// OverrideNone is like ExpectNone(), but it replaces the expectations of the
+// same path, see OverrideSome().
+func (c *Checker) OverrideNone(from ConnectionSource, to ConnectionTarget, explicitPort ...uint16) {
+	c.override(None, from, to, ExpectWithPorts(explicitPort...))
+}
+
+// Override is like Expect(), but it replaces the expectations of the same path,
+// see OverrideSome().
+func (c *Checker) Override(expected Expected, from ConnectionSource, to ConnectionTarget,
+	opts ...ExpectationOption) {
+	c.override(expected, from, to, opts...)
+}
+
+func (c *Checker) override(expected Expected, from ConnectionSource, to ConnectionTarget,
+	opts ...ExpectationOption) {
+	c.expectationsMutex().Lock()
+	n := len(c.expectations)
+	c.expectationsMutex().Unlock()
+
+	c.expect(expected, from, to, opts...)
+
+	c.expectationsMutex().Lock()
+	defer c.expectationsMutex().Unlock()
+	added := c.expectations[n:]
+	var kept []Expectation
+	for _, exp := range c.expectations[:n] {
+		overridden := false
+		for _, a := range added {
+			if exp.samePath(a) {
+				overridden = true
+				break
+			}
+		}
+		if !overridden {
+			kept = append(kept, exp)
+		}
+	}
+	c.expectations = append(kept, added...)
+	c.lastAttempt = nil
+}
+
+// samePath returns whether the expectations are of the same path, from the same
+// source to the same IP and port.
+func (e Expectation) samePath(o Expectation) bool {
+	return e.sourceName() == o.sourceName() && e.To.IP == o.To.IP && e.To.Port == o.To.Port
+}
@@ -0,0 +1,41 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+// ConnectionEndpoint is something that can both open connections and accept
+// them, a workload for example.
+type ConnectionEndpoint interface {
+	ConnectionSource
+	ConnectionTarget
+}
+
+// ExpectBothWays asserts that a can reach b and that b can reach a, on the
+// given port, with the same options in both directions.  Unlike setting
+// ReverseDirection, it covers both directions with the same Checker.
+func (c *Checker) ExpectBothWays(a, b ConnectionEndpoint, port uint16, opts ...ExpectationOption) {
+	c.expectBothWays(Some, a, b, port, opts)
+}
+
+// ExpectNoneBothWays asserts that neither a nor b can reach the other on the
+// given port.
+func (c *Checker) ExpectNoneBothWays(a, b ConnectionEndpoint, port uint16, opts ...ExpectationOption) {
+	c.expectBothWays(None, a, b, port, opts)
+}
+
+func (c *Checker) expectBothWays(expected Expected, a, b ConnectionEndpoint, port uint16, opts []ExpectationOption) {
+	opts = append([]ExpectationOption{ExpectWithPorts(port)}, opts...)
+	c.expect(expected, a, b, opts...)
+	c.expect(expected, b, a, opts...)
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// CheckWithBPFMapDump makes the Checker dump the BPF conntrack and NAT map
+// entries of the failing paths when the check finally fails in a BPF-dataplane
+// run.  The conntrack entries that involve a source IP of the failing path and
+// the NAT frontends whose frontend or backends are the target IP are added to
+// the failure message.
+func CheckWithBPFMapDump() CheckerOpt {
+	return func(c *Checker) {
+		log.Debug("CheckWithBPFMapDump set")
+		c.bpfMapDump = true
+	}
+}
+
+// bpfMapDumps holds the raw output of the map dumps of a host.
+type bpfMapDumps struct {
+	conntrack string
+	nat       string
+}
+
+func dumpBPFMapsOf(host string) bpfMapDumps {
+	return bpfMapDumps{
+		conntrack: runDiagnostic(host, []string{"calico-bpf", "conntrack", "dump"}),
+		nat:       runDiagnostic(host, []string{"calico-bpf", "nat", "dump"}),
+	}
+}
+
+// ipInTextRegexp returns a regexp that matches the IP in text but not the IPs
+// that it is a prefix or suffix of.
+func ipInTextRegexp(ip string) *regexp.Regexp {
+	return regexp.MustCompile(`(^|[^0-9a-fA-F.:])` + regexp.QuoteMeta(ip) + `([^0-9a-fA-F.]|$)`)
+}
+
+func matchingLines(text string, ips []*regexp.Regexp) []string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		for _, ip := range ips {
+			if ip.MatchString(line) {
+				lines = append(lines, line)
+				break
+			}
+		}
+	}
+	return lines
+}
+
+// matchingNATFrontends returns the frontends of the nat dump, with their
+// backends, that mention the IP.
+func matchingNATFrontends(text string, ip *regexp.Regexp) []string {
+	var lines, block []string
+	flush := func() {
+		for _, l := range block {
+			if ip.MatchString(l) {
+				lines = append(lines, block...)
+				break
+			}
+		}
+		block = nil
+	}
+	for _, line := range strings.Split(text, "\n") {
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "\t") {
+			flush()
+		}
+		block = append(block, line)
+	}
+	flush()
+	return lines
+}
+
+// dumpBPFMaps returns the text to add to the failure message with the BPF map
+// entries of the failing paths.
+func (c *Checker) dumpBPFMaps(expectations []Expectation, mismatches []MismatchDetail) string {
+	if os.Getenv("FELIX_FV_ENABLE_BPF") != "true" {
+		return ""
+	}
+
+	hosts := failingHosts(expectations, mismatches)
+	dumps := make(map[string]bpfMapDumps, len(hosts))
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	for _, host := range hosts {
+		wg.Add(1)
+		go func(host string) {
+			defer wg.Done()
+			d := dumpBPFMapsOf(host)
+			lock.Lock()
+			defer lock.Unlock()
+			dumps[host] = d
+		}(host)
+	}
+	wg.Wait()
+
+	var sb strings.Builder
+	for _, m := range mismatches {
+		exp := expectations[m.Index]
+		fmt.Fprintf(&sb, "\nBPF map entries of %s -> %s:\n", exp.sourceName(), exp.To.TargetName)
+		expHosts := pathHosts(exp)
+		if len(expHosts) == 0 {
+			sb.WriteString("    unknown, the hosts of the path are unknown\n")
+			continue
+		}
+		var srcIPs []*regexp.Regexp
+		for _, ip := range exp.sourceIPs() {
+			srcIPs = append(srcIPs, ipInTextRegexp(ip))
+		}
+		dstIP := ipInTextRegexp(exp.To.IP)
+		for _, host := range expHosts {
+			d := dumps[host]
+			fmt.Fprintf(&sb, "==== %s: conntrack\n", host)
+			for _, l := range matchingLines(d.conntrack, srcIPs) {
+				fmt.Fprintf(&sb, "    %s\n", l)
+			}
+			fmt.Fprintf(&sb, "==== %s: nat\n", host)
+			for _, l := range matchingNATFrontends(d.nat, dstIP) {
+				fmt.Fprintf(&sb, "    %s\n", l)
+			}
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// ExpectSomeFromAll asserts that every one of the sources can reach the target,
+// the usual "every client can reach the server" matrix.  Like ExpectSome, it
+// takes an optional explicit port.  A source that appears more than once in the
+// list is only expected once.  The probes of the matrix run in parallel as usual.
+func (c *Checker) ExpectSomeFromAll(sources []ConnectionSource, to ConnectionTarget, explicitPort ...uint16) {
+	for _, from := range uniqueSources(sources) {
+		c.ExpectSome(from, to, explicitPort...)
+	}
+}
+
+// ExpectNoneFromAll asserts that none of the sources can reach the target.  Like
+// ExpectNone, it takes an optional explicit port.  A source that appears more
+// than once in the list is only expected once.
+func (c *Checker) ExpectNoneFromAll(sources []ConnectionSource, to ConnectionTarget, explicitPort ...uint16) {
+	for _, from := range uniqueSources(sources) {
+		c.ExpectNone(from, to, explicitPort...)
+	}
+}
+
+// ExpectSomeToAll asserts that the source can reach every one of the targets.
+// Like ExpectSome, it takes an optional explicit port.  A target that appears
+// more than once in the list, with the same IP and port, is only expected once.
+func (c *Checker) ExpectSomeToAll(from ConnectionSource, targets []ConnectionTarget, explicitPort ...uint16) {
+	for _, to := range uniqueTargets(targets, explicitPort) {
+		c.ExpectSome(from, to, explicitPort...)
+	}
+}
+
+// ExpectNoneToAll asserts that the source can reach none of the targets.  Like
+// ExpectNone, it takes an optional explicit port.  A target that appears more
+// than once in the list, with the same IP and port, is only expected once.
+func (c *Checker) ExpectNoneToAll(from ConnectionSource, targets []ConnectionTarget, explicitPort ...uint16) {
+	for _, to := range uniqueTargets(targets, explicitPort) {
+		c.ExpectNone(from, to, explicitPort...)
+	}
+}
+
+// uniqueSources drops the sources that have the same name as an earlier one.
+func uniqueSources(sources []ConnectionSource) []ConnectionSource {
+	seen := map[string]bool{}
+	unique := make([]ConnectionSource, 0, len(sources))
+	for _, s := range sources {
+		if seen[s.SourceName()] {
+			log.WithField("source", s.SourceName()).Debug("Ignoring duplicate source")
+			continue
+		}
+		seen[s.SourceName()] = true
+		unique = append(unique, s)
+	}
+	return unique
+}
+
+// uniqueTargets drops the targets that resolve to the same IP and port as an
+// earlier one.
+func uniqueTargets(targets []ConnectionTarget, explicitPort []uint16) []ConnectionTarget {
+	seen := map[string]bool{}
+	unique := make([]ConnectionTarget, 0, len(targets))
+	for _, t := range targets {
+		m := t.ToMatcher(explicitPort...)
+		key := m.IP + ":" + m.Port
+		if seen[key] {
+			log.WithField("target", m.TargetName).Debug("Ignoring duplicate target")
+			continue
+		}
+		seen[key] = true
+		unique = append(unique, t)
+	}
+	return unique
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Bulk expectations", func() {
+	w1 := fakeSource{name: "w1", ips: []string{"10.65.0.1"}}
+	w1Again := fakeSource{name: "w1", ips: []string{"10.65.0.9"}}
+	w2 := fakeSource{name: "w2", ips: []string{"10.65.0.2"}}
+
+	DescribeTable("uniqueSources",
+		func(sources, unique []ConnectionSource) {
+			Expect(uniqueSources(sources)).To(Equal(unique))
+		},
+		Entry("no sources", nil, []ConnectionSource{}),
+		Entry("distinct sources",
+			[]ConnectionSource{w2, w1},
+			[]ConnectionSource{w2, w1}),
+		Entry("a repeated source",
+			[]ConnectionSource{w1, w2, w1},
+			[]ConnectionSource{w1, w2}),
+		Entry("sources with the same name, the first is kept",
+			[]ConnectionSource{w1Again, w1},
+			[]ConnectionSource{w1Again}),
+	)
+
+	ip1 := TargetIP("10.65.1.1")
+	ip2 := TargetIP("10.65.1.2")
+	w3 := namedTarget{name: "w3", ip: "10.65.1.1"}
+
+	DescribeTable("uniqueTargets",
+		func(targets []ConnectionTarget, port uint16, unique []ConnectionTarget) {
+			Expect(uniqueTargets(targets, []uint16{port})).To(Equal(unique))
+		},
+		Entry("no targets", nil, uint16(8055), []ConnectionTarget{}),
+		Entry("distinct targets",
+			[]ConnectionTarget{ip2, ip1}, uint16(8055),
+			[]ConnectionTarget{ip2, ip1}),
+		Entry("a repeated target",
+			[]ConnectionTarget{ip1, ip2, ip1}, uint16(8055),
+			[]ConnectionTarget{ip1, ip2}),
+		Entry("targets with the same address, the first is kept",
+			[]ConnectionTarget{w3, ip1}, uint16(8055),
+			[]ConnectionTarget{w3}),
+	)
+
+	It("should only expect each source and target once", func() {
+		c := &Checker{}
+		c.ExpectSomeFromAll([]ConnectionSource{w1, w2, w1}, ip1, 8055)
+		c.ExpectNoneToAll(w2, []ConnectionTarget{ip2, w3, ip2}, 8056)
+		Expect(c.ExpectedConnectivityPretty()).To(Equal([]string{
+			"w1 -> 10.65.1.1:8055 = true",
+			"w2 -> 10.65.1.1:8055 = true",
+			"w2 -> 10.65.1.2:8056 = false",
+			"w2 -> w3 on port 8056 = false",
+		}))
+	})
+})
@@ -0,0 +1,167 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// captureStartTimeout is how long we wait for tcpdump to start listening.
+const captureStartTimeout = 5 * time.Second
+
+// CheckWithPacketCapture makes the Checker capture the packets of a failing
+// expectation once it has failed the given number of attempts: it runs tcpdump
+// on all the interfaces of the hosts of the path, re-runs the check and saves a
+// pcap file per host in a directory per spec under dir.
+func CheckWithPacketCapture(afterFailures int, dir string) CheckerOpt {
+	return func(c *Checker) {
+		log.Debug("CheckWithPacketCapture set")
+		c.captureAfter = afterFailures
+		c.captureDir = dir
+	}
+}
+
+// packetCapture is a tcpdump running in a container, streaming its output to a
+// local file, or buffer.  tcpdump is stopped when its stdin is closed.
+type packetCapture struct {
+	proc     execProcess
+	out      io.WriteCloser
+	copyDone chan error
+}
+
+func startPacketCapture(host, filter, path string) (*packetCapture, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return startCapture(host, "-i any -U -w -", filter, file)
+}
+
+// startCapture runs tcpdump with the given arguments and filter on the host,
+// copying its stdout to out, which it closes when the capture stops.
+func startCapture(host, args, filter string, out io.WriteCloser) (*packetCapture, error) {
+	script := fmt.Sprintf("tcpdump %s '%s' & pid=$!; read _; kill -INT $pid; wait $pid", args, filter)
+	proc, err := startExec(host, true, []string{"sh", "-c", script})
+	if err != nil {
+		_ = out.Close()
+		return nil, err
+	}
+	pc := &packetCapture{
+		proc:     proc,
+		out:      out,
+		copyDone: make(chan error, 1),
+	}
+	go func() {
+		_, err := io.Copy(out, proc.Stdout())
+		pc.copyDone <- err
+	}()
+
+	// Wait for tcpdump to report that it is listening.
+	listening := make(chan struct{})
+	go func() {
+		scanner := bufio.NewScanner(proc.Stderr())
+		for scanner.Scan() {
+			log.WithField("host", host).Debug("tcpdump: " + scanner.Text())
+			if strings.Contains(scanner.Text(), "listening on") {
+				close(listening)
+				break
+			}
+		}
+		_, _ = io.Copy(io.Discard, proc.Stderr())
+	}()
+	select {
+	case <-listening:
+	case <-time.After(captureStartTimeout):
+		_ = pc.Stop()
+		return nil, fmt.Errorf("tcpdump in %s didn't start within %v", host, captureStartTimeout)
+	}
+	return pc, nil
+}
+
+func (pc *packetCapture) Stop() error {
+	_ = pc.proc.CloseStdin()
+	copyErr := <-pc.copyDone
+	waitErr := pc.proc.Wait()
+	closeErr := pc.out.Close()
+	for _, err := range []error{copyErr, waitErr, closeErr} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// captureExpectation re-runs the check of the i-th expectation while capturing
+// the packets on the hosts of its path.  It returns the pcap files.
+func (c *Checker) captureExpectation(expectations []Expectation, i int) []string {
+	exp := expectations[i]
+
+	hosts := pathHosts(exp)
+	if len(hosts) == 0 {
+		log.WithField("expectation", i).Warn("Can't capture packets, the hosts of the path are unknown.")
+		return nil
+	}
+
+	addrs := append([]string{exp.To.IP}, exp.sourceIPs()...)
+	for j, a := range addrs {
+		addrs[j] = "host " + a
+	}
+	filter := strings.Join(addrs, " or ")
+
+	dir := filepath.Join(c.captureDir, specDirName())
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		log.WithError(err).Warn("Failed to create packet capture directory")
+		return nil
+	}
+
+	var captures []*packetCapture
+	var files []string
+	for _, host := range hosts {
+		name := fmt.Sprintf("%d-%s-to-%s-on-%s.pcap", i, exp.sourceName(), exp.To.TargetName, host)
+		path := filepath.Join(dir, unsafeFileChars.ReplaceAllString(name, "_"))
+		pc, err := startPacketCapture(host, filter, path)
+		if err != nil {
+			log.WithError(err).WithField("host", host).Warn("Failed to start packet capture")
+			continue
+		}
+		captures = append(captures, pc)
+		files = append(files, path)
+	}
+	if len(captures) == 0 {
+		return nil
+	}
+
+	log.WithFields(log.Fields{
+		"source": exp.sourceName(),
+		"target": exp.To.TargetName,
+		"filter": filter,
+	}).Info("Re-running failing check with packet capture")
+	exp.From.CanConnectTo(exp.To.IP, exp.To.Port, c.protocol(), c.checkOptions(exp)...)
+
+	for _, pc := range captures {
+		if err := pc.Stop(); err != nil {
+			log.WithError(err).Warn("Packet capture didn't stop cleanly")
+		}
+	}
+	return files
+}
@@ -0,0 +1,179 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/projectcalico/calico/libcalico-go/lib/set"
+)
+
+const (
+	// TimeoutEnv names the environment variable that overrides the default
+	// timeout of CheckConnectivity(), as a duration such as "30s", so that
+	// slow CI environments can allow for more time without changing the specs.
+	TimeoutEnv = "FV_CONNCHECK_TIMEOUT"
+	// RetryIntervalEnv names the environment variable with the default
+	// interval between the attempts of CheckConnectivity(), see
+	// CheckerContext.RetryInterval.
+	RetryIntervalEnv = "FV_CONNCHECK_RETRY_INTERVAL"
+)
+
+// CheckerContext holds the state that Checkers share: the Checkers that haven't
+// been checked, see ReportUnactivated(), and the defaults of the retry policy.  Checkers
+// without a Context share DefaultCheckerContext, which is fine for suites whose
+// specs run one at a time in each process, as with ginkgo -p.  Suites that run
+// specs concurrently within a process give the Checkers of each spec their own
+// context, so that the specs don't see each other's Checkers.
+type CheckerContext struct {
+	// DefaultTimeout is the timeout of CheckConnectivity().  If zero, it is
+	// the duration in TimeoutEnv, or 10s.  Checks that give their own
+	// timeout, for example, with CheckConnectivityWithTimeout(), aren't
+	// affected.
+	DefaultTimeout time.Duration
+	// RetryInterval is how long CheckConnectivity() waits before it retries
+	// a failed attempt, unless the expectations that failed set their own
+	// interval, see ExpectWithRetries().  If zero, it is the duration in
+	// RetryIntervalEnv, or no wait.
+	RetryInterval time.Duration
+
+	// lock protects the unactivated Checkers from Checkers that are used from
+	// several goroutines.
+	lock sync.Mutex
+	// unactivated maps the Checkers that have expectations but haven't been
+	// checked to where their first expectation was added.
+	unactivated map[*Checker]string
+}
+
+// NewCheckerContext returns a context with no Checkers and the default retry
+// policy.
+func NewCheckerContext() *CheckerContext {
+	return &CheckerContext{
+		unactivated: map[*Checker]string{},
+	}
+}
+
+var (
+	envDefaultsOnce         sync.Once
+	envDefaultTimeout       time.Duration
+	envDefaultRetryInterval time.Duration
+	envDefaultsErr          error
+)
+
+// loadEnvDefaults reads TimeoutEnv and RetryIntervalEnv on first use, rather
+// than when the package is loaded, since test-connection shares the package.
+// An invalid duration fails every check that uses the defaults, rather than
+// only the first one, or quietly using the default.
+func loadEnvDefaults() {
+	envDefaultsOnce.Do(func() {
+		var timeoutErr, retryErr error
+		envDefaultTimeout, timeoutErr = durationFromEnv(TimeoutEnv, defaultConnectivityTimeout)
+		envDefaultRetryInterval, retryErr = durationFromEnv(RetryIntervalEnv, 0)
+		if timeoutErr != nil {
+			envDefaultsErr = timeoutErr
+		} else {
+			envDefaultsErr = retryErr
+		}
+	})
+	ExpectWithOffset(2, envDefaultsErr).NotTo(HaveOccurred(), "Invalid connectivity check default")
+}
+
+// durationFromEnv returns the duration in the environment variable, or def if
+// it isn't set.
+func durationFromEnv(name string, def time.Duration) (time.Duration, error) {
+	env := os.Getenv(name)
+	if env == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(env)
+	if err == nil && d < 0 {
+		err = fmt.Errorf("negative duration")
+	}
+	if err != nil {
+		log.WithError(err).WithField("value", env).Errorf("Invalid duration in %s", name)
+		return def, fmt.Errorf("invalid duration %q in %s: %w", env, name, err)
+	}
+	log.WithField("value", d).Infof("Connectivity check default overridden by %s", name)
+	return d, nil
+}
+
+// DefaultCheckerContext is the context of the Checkers that don't set one.
+var DefaultCheckerContext = NewCheckerContext()
+
+// UnactivatedCheckers holds the Checkers of DefaultCheckerContext that have
+// expectations but haven't been checked.
+//
+// Deprecated: reading it races with Checkers that are used from several
+// goroutines, use ReportUnactivated() and ClearUnactivated() instead.
+var UnactivatedCheckers = set.New[*Checker]()
+
+// context returns the context of the Checker.
+func (c *Checker) context() *CheckerContext {
+	if c.Context != nil {
+		return c.Context
+	}
+	return DefaultCheckerContext
+}
+
+func (ctx *CheckerContext) defaultTimeout() time.Duration {
+	if ctx.DefaultTimeout != 0 {
+		return ctx.DefaultTimeout
+	}
+	loadEnvDefaults()
+	return envDefaultTimeout
+}
+
+func (ctx *CheckerContext) retryInterval() time.Duration {
+	if ctx.RetryInterval != 0 {
+		return ctx.RetryInterval
+	}
+	loadEnvDefaults()
+	return envDefaultRetryInterval
+}
+
+func markUnactivated(c *Checker) {
+	if c.baseline {
+		return
+	}
+	ctx := c.context()
+	ctx.lock.Lock()
+	defer ctx.lock.Unlock()
+	if _, ok := ctx.unactivated[c]; ok {
+		return
+	}
+	if ctx.unactivated == nil {
+		ctx.unactivated = map[*Checker]string{}
+	}
+	ctx.unactivated[c] = creationSite()
+	if ctx == DefaultCheckerContext {
+		UnactivatedCheckers.Add(c)
+	}
+}
+
+func markActivated(c *Checker) {
+	ctx := c.context()
+	ctx.lock.Lock()
+	defer ctx.lock.Unlock()
+	delete(ctx.unactivated, c)
+	if ctx == DefaultCheckerContext {
+		UnactivatedCheckers.Discard(c)
+	}
+}
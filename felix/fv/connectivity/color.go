@@ -0,0 +1,88 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"os"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ColorEnv names the environment variable that controls the coloring of the
+// failure message, see CheckWithColor(): "always", "never" or "auto", the
+// default, which colors the message if stdout is a terminal.
+const ColorEnv = "FELIX_FV_COLOR"
+
+const (
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+	colorReset  = "\x1b[0m"
+)
+
+// CheckWithColor colors, or doesn't color, the rows of the expected and actual
+// connectivity in the failure message, regardless of ColorEnv: red for the
+// expectations that failed, yellow for those excused by a known issue or a
+// quarantine and green for the rest, so that the failures of a large matrix
+// stand out when debugging locally.
+func CheckWithColor(enabled bool) CheckerOpt {
+	return func(c *Checker) {
+		log.WithField("enabled", enabled).Debug("CheckWithColor set")
+		c.color = &enabled
+	}
+}
+
+// colored returns whether to color the failure message.  Without
+// CheckWithColor(), that is up to ColorEnv and, for "auto", whether stdout is a
+// terminal.  The NO_COLOR convention is honoured too.
+func (c *Checker) colored() bool {
+	if c.color != nil {
+		return *c.color
+	}
+	switch os.Getenv(ColorEnv) {
+	case "always":
+		return true
+	case "never":
+		return false
+	}
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	return isTerminal(os.Stdout)
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// rowColors returns the color of each row of the table.
+func rowColors(states []rowState) []string {
+	colors := make([]string, len(states))
+	for i, s := range states {
+		switch s {
+		case rowFailed:
+			colors[i] = colorRed
+		case rowExcused:
+			colors[i] = colorYellow
+		default:
+			colors[i] = colorGreen
+		}
+	}
+	return colors
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// expectationConflicts returns the paths that the Checker expects both to have
+// and not to have connectivity, which can only pass or fail by chance.  Exact
+// duplicate expectations are harmless but usually a mistake, so they are only
+// logged.
+func (c *Checker) expectationConflicts() []string {
+	type pathKey struct {
+		source, ip, port string
+		viaProxy         string // connecting through a proxy is a different path.
+	}
+	expected := map[pathKey]map[Expected]int{}
+	exact := map[string]bool{}
+	var conflicts []string
+	for i, exp := range c.expectationsSnapshot() {
+		k := pathKey{exp.sourceName(), exp.To.IP, exp.To.Port, exp.viaProxy}
+		if expected[k] == nil {
+			expected[k] = map[Expected]int{}
+		}
+		path := fmt.Sprintf("%s -> %s", k.source, exp.To.TargetName)
+		if addr := k.ip + ":" + k.port; addr != exp.To.TargetName {
+			path += " (" + addr + ")"
+		}
+		if k.viaProxy != "" {
+			path += " via " + redactedURL(k.viaProxy)
+		}
+		path += " " + c.protocol()
+		if first, ok := expected[k][!exp.Expected]; ok {
+			conflicts = append(conflicts, fmt.Sprintf("%s: expectations %d and %d expect both connectivity and none",
+				path, first, i))
+		}
+		if _, ok := expected[k][exp.Expected]; !ok {
+			expected[k][exp.Expected] = i
+		}
+
+		exactKey := fmt.Sprintf("%v %v %s", k, exp.Expected, strings.Join(exp.ExpSrcIPs, ","))
+		if exact[exactKey] {
+			log.WithFields(log.Fields{
+				"path":     path,
+				"expected": exp.Expected,
+				"index":    i,
+			}).Warn("Duplicate connectivity expectation")
+		}
+		exact[exactKey] = true
+	}
+	return conflicts
+}
+
+func (c *Checker) conflictError(conflicts []string) *CheckError {
+	message := fmt.Sprintf("Conflicting connectivity expectations:\n    %s",
+		strings.Join(conflicts, "\n    "))
+	if c.description != "" {
+		message += "\nDescription:\n" + c.description
+	}
+	return &CheckError{
+		Kind:      ErrorKindConflict,
+		Message:   message,
+		Conflicts: conflicts,
+	}
+}
+
+// reportConflicts fails the test if the expectations conflict and returns true
+// if they don't.  callerSkip is that of its caller.
+func (c *Checker) reportConflicts(callerSkip int) bool {
+	conflicts := c.expectationConflicts()
+	if len(conflicts) == 0 {
+		return true
+	}
+	checkErr := c.conflictError(conflicts)
+	log.Warn(checkErr.Message)
+	c.fail(checkErr, callerSkip+1)
+	return false
+}
@@ -16,11 +16,16 @@ package connectivity
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"math"
+	"math/rand"
+	"net"
 	"os/exec"
+	"reflect"
 	"regexp"
 	"strconv"
 	"strings"
@@ -30,8 +35,10 @@ import (
 	"github.com/google/uuid"
 	"github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
-	"github.com/onsi/gomega/types"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/projectcalico/calico/felix/fv/utils"
 	"github.com/projectcalico/calico/libcalico-go/lib/set"
@@ -40,27 +47,188 @@ import (
 // ConnectivityChecker records a set of connectivity expectations and supports calculating the
 // actual state of the connectivity between the given workloads.  It is expected to be used like so:
 //
-//     var cc = &connectivity.Checker{}
-//     cc.Expect(None, w[2], w[0], 1234)
-//     cc.Expect(Some, w[1], w[0], 5678)
-//     cc.Expect(Some, w[1], w[0], 4321, ExpectWithABC, ExpectWithXYZ)
-//     cc.CheckConnectivity()
-//
+//	var cc = &connectivity.Checker{}
+//	cc.Expect(None, w[2], w[0], 1234)
+//	cc.Expect(Some, w[1], w[0], 5678)
+//	cc.Expect(Some, w[1], w[0], 4321, ExpectWithABC, ExpectWithXYZ)
+//	cc.CheckConnectivity()
 type Checker struct {
+	// ReverseDirection swaps the source and target of every subsequent
+	// expectation.
+	//
+	// Deprecated: it applies to expectations far from where it is set, use the
+	// ExpectReversed() option on the expectations that need it instead.
 	ReverseDirection bool
 	Protocol         string // "tcp" or "udp"
 	expectations     []Expectation
+	lock             *sync.Mutex // protects the expectations, see expectationsMutex().
 	CheckSNAT        bool
 	RetriesDisabled  bool
 	StaggerStartBy   time.Duration
 
+	// Context holds the state that the Checker shares with the other Checkers
+	// of the spec, nil for DefaultCheckerContext.  Specs that run concurrently
+	// in the same process give their Checkers a context each.
+	Context *CheckerContext
+
+	// DefaultOptions are applied to every subsequent expectation, before its
+	// own options, so that a matrix of expectations that share options, for
+	// example, a latency bound or a send length, doesn't have to repeat them.
+	// An expectation overrides a default by giving the same option with
+	// another value; options that accumulate, such as
+	// ExpectWithResultMatcher(), add to the defaults instead.
+	DefaultOptions []ExpectationOption
+
+	// AutoProvision copies test-connection into the source containers that lack
+	// a compatible binary, so that sources can use arbitrary images.
+	AutoProvision bool
+	// AllowFallbackProbes allows basic checks to use nc/ping in the source
+	// containers where test-connection is unavailable, see WithFallbackProbes().
+	AllowFallbackProbes bool
+	// ContinuousMetricsAddr, if set, is the address on which a continuous check,
+	// see StartContinuousCheck(), serves the success rate, latency and loss of
+	// each path as Prometheus metrics, at /metrics, until it stops.
+	ContinuousMetricsAddr string
+	// TracerProvider provides the tracer for the OpenTelemetry spans of
+	// CheckConnectivity(): one for the check, one for each attempt and one for
+	// each run of test-connection, so that a trace viewer shows where the time
+	// goes.  nil means the global provider, see otel.SetTracerProvider(), which
+	// does nothing unless the test installs one with an exporter.
+	TracerProvider trace.TracerProvider
+
 	// OnFail, if set, will be called instead of ginkgo.Fail().  (Useful for testing the checker itself.)
 	OnFail func(msg string)
+	// OnFailError, if set, is called with the details of the failure before the
+	// test is failed.
+	OnFailError func(err *CheckError)
+
+	// OnAttempt, if set, is called after each attempt of CheckConnectivity().
+	OnAttempt func(a *Attempt)
+	// OnExpectationFail, if set, is called for each expectation that fails an
+	// attempt, before OnAttempt.
+	OnExpectationFail func(a *Attempt, m *MismatchDetail)
+	// OnFinalFail, if set, is called with the failure and the history of all
+	// the attempts when CheckConnectivity() gives up.
+	OnFinalFail func(err *CheckError, attempts []Attempt)
 
 	description string
 	init        func()       // called before testing starts
 	beforeRetry func()       // called when a test fails and before it is retried
 	finalTest   func() error // called after connectivity test, if it is successful, may fail the test.
+	disruption  func()       // called once long-lived connections are established.
+	validate    bool         // validate the harness before the first check.
+
+	diagnostics    bool   // collect diagnostics when the check fails.
+	diagnosticsDir string // where to write them, if not to the failure message.
+
+	conntrackExpectations []ConntrackExpectation
+
+	metricsSource MetricsSource // proves that traffic was denied by policy.
+
+	flowLogReader  FlowLogReader // verifies the flow logs of a check that passed.
+	flowLogTimeout time.Duration
+
+	leakCheck       bool // check that the probes don't leak conntrack entries.
+	leakCheckGrace  time.Duration
+	leakCheckStates []string
+
+	dropAttribution bool // report the rules that dropped the traffic of failing paths.
+	bpfMapDump      bool // report the BPF map entries of failing paths.
+
+	captureAfter int    // capture packets of expectations that failed this many attempts.
+	captureDir   string // where to write the pcap files.
+
+	resultsDir string // where to record the results of each run.
+	junitDir   string // where to write a JUnit report of each run.
+
+	attachRuns bool // attach the results of each run to the spec.
+
+	htmlReportDir string // where to write the connectivity matrix of a failure.
+
+	continuous *continuousCheck // set while a continuous check is running.
+
+	failure      *CheckError // the first failure of the run, for its CheckReport.
+	deferFailure bool        // record failures without failing the test, see TryCheckConnectivity().
+
+	probeCtx context.Context // cancels the probes of the checks, see CheckWithContext().
+	traceCtx context.Context // holds the span of the running attempt, if any.
+	attempt  int             // the number of the running attempt, for the logs of the probes.
+
+	lastAttempt *Attempt // the last attempt of the last check, for ExportDOT().
+	checkCalls  int      // calls to Check() since the last reset.
+
+	baseline bool // a baseline to Clone() from, never checked itself.
+
+	sortExpectations bool       // sort the expectations before the check.
+	shuffleRand      *rand.Rand // if set, shuffles the order of the probes.
+
+	attemptSummaries    bool      // log a summary of each attempt.
+	attemptSummaryLevel log.Level // the level to log it at.
+
+	timeoutPerExpectation time.Duration // added to the timeout per expectation.
+	timeoutMinAttempts    int           // attempts that the timeout leaves room for.
+
+	lastConvergence []Convergence // of the last run of CheckConnectivity().
+
+	overheadLogging bool            // log the overhead of the harness of each run.
+	lastOverhead    HarnessOverhead // of the last run of CheckConnectivity().
+
+	lazyPretty bool   // only describe the expectations that fail.
+	streamDir  string // where to stream the results of each attempt.
+
+	prettyOnlyFailed bool // only show the failed expectations in the failure message.
+	prettyContext    int  // with that many rows of context around each.
+	prettyMaxRows    int  // the most rows to show, 0 for no limit.
+
+	color *bool // whether to color the failure message, overriding ColorEnv.
+
+	probeLogLevelSet bool // log the probes at probeLogLevel rather than info.
+	probeLogLevel    log.Level
+
+	transcriptDir string      // where to write the transcript of each run.
+	transcript    *transcript // of the running check, if any.
+
+	probesPerSource int // the most probes to run at once from each source, 0 for no limit.
+}
+
+// lockAllocLock serialises the allocation of the expectations locks of
+// Checkers that are used before any expectation has been added.
+var lockAllocLock sync.Mutex
+
+// expectationsMutex returns the lock that protects the expectations of the
+// Checker, so that they can be added from several goroutines, for example while
+// workloads are created in parallel.  The Checker holds a pointer to it so that
+// it can still be copied by value; the copies share the lock.
+func (c *Checker) expectationsMutex() *sync.Mutex {
+	lockAllocLock.Lock()
+	defer lockAllocLock.Unlock()
+	if c.lock == nil {
+		c.lock = &sync.Mutex{}
+	}
+	return c.lock
+}
+
+// expectationsSnapshot returns a copy of the expectations, for reading them
+// while other goroutines may add or remove expectations.
+func (c *Checker) expectationsSnapshot() []Expectation {
+	c.expectationsMutex().Lock()
+	defer c.expectationsMutex().Unlock()
+	return append([]Expectation(nil), c.expectations...)
+}
+
+func (c *Checker) numExpectations() int {
+	c.expectationsMutex().Lock()
+	defer c.expectationsMutex().Unlock()
+	return len(c.expectations)
+}
+
+// resolvedExpectations resolves the targets of the expectations and returns a
+// copy of them, along with the errors of resolving them, for an attempt.
+func (c *Checker) resolvedExpectations() ([]Expectation, []error) {
+	c.expectationsMutex().Lock()
+	defer c.expectationsMutex().Unlock()
+	resolveErrs := c.resolveTargets()
+	return append([]Expectation(nil), c.expectations...), resolveErrs
 }
 
 // CheckerOpt is an option to CheckConnectivity()
@@ -96,6 +264,36 @@ func CheckWithFinalTest(f func() error) CheckerOpt {
 	}
 }
 
+// CheckWithDisruption sets a function executed once all the long-lived
+// connections of the check (see ExpectConnectionSurvives and
+// ExpectConnectionCutWithin) are established and
+// while they are still running.  Use it to apply the change that the
+// connections are expected to survive, for example a policy update or a Felix
+// restart.
+func CheckWithDisruption(f func()) CheckerOpt {
+	return func(c *Checker) {
+		log.Debug("CheckWithDisruption set")
+		c.disruption = f
+	}
+}
+
+// CheckWithContext sets a context that cancels the probes of the checks, for
+// example, when the spec is interrupted.  A cancelled probe fails with a
+// harness error.
+func CheckWithContext(ctx context.Context) CheckerOpt {
+	return func(c *Checker) {
+		log.Debug("CheckWithContext set")
+		c.probeCtx = ctx
+	}
+}
+
+func (c *Checker) probeContext() context.Context {
+	if c.probeCtx == nil {
+		return context.Background()
+	}
+	return c.probeCtx
+}
+
 // Expected defines what connectivity expectations we can have
 type Expected bool
 
@@ -115,6 +313,17 @@ func (c *Checker) ExpectSNAT(from ConnectionSource, srcIP string, to ConnectionT
 	c.expect(Some, from, to, ExpectWithPorts(explicitPort...), ExpectWithSrcIPs(srcIP))
 }
 
+// ExpectHairpin asserts that the workload can reach a service VIP that load
+// balances back to the workload itself, which needs hairpin NAT, and that the
+// server that answered was the workload, rather than another backend.
+func (c *Checker) ExpectHairpin(w ConnectionSource, vip string, port uint16, opts ...ExpectationOption) {
+	opts = append([]ExpectationOption{
+		ExpectWithPorts(port),
+		ExpectWithServerIPs(w.SourceIPs()...),
+	}, opts...)
+	c.expect(Some, w, TargetIP(vip), opts...)
+}
+
 func (c *Checker) ExpectNone(from ConnectionSource, to ConnectionTarget, explicitPort ...uint16) {
 	c.expect(None, from, to, ExpectWithPorts(explicitPort...))
 }
@@ -140,12 +349,47 @@ func (c *Checker) ExpectLoss(from ConnectionSource, to ConnectionTarget,
 	)
 }
 
+// ExpectConnectionSurvives asserts that a single connection from the source to
+// the target keeps exchanging messages for the whole duration without being
+// reset or stalling.  Combine it with CheckWithDisruption() to verify that
+// established flows survive a change to the dataplane.
+func (c *Checker) ExpectConnectionSurvives(from ConnectionSource, to ConnectionTarget, port uint16,
+	duration time.Duration) {
+
+	// A connection that did not survive cannot be fixed by retrying.
+	c.RetriesDisabled = true
+
+	c.expect(Some, from, to,
+		ExpectWithPorts(port),
+		ExpectWithSurvival(duration),
+	)
+}
+
+// ExpectConnectionCutWithin asserts that a single connection from the source to
+// the target is established and then actively terminated (reset rather than
+// left to stall) within the window after the function passed with
+// CheckWithDisruption() returns.  Typically, the disruption applies a deny policy.
+func (c *Checker) ExpectConnectionCutWithin(from ConnectionSource, to ConnectionTarget, port uint16,
+	window time.Duration) {
+
+	// Once cut, the connection cannot be re-established by retrying.
+	c.RetriesDisabled = true
+
+	c.expect(Some, from, to,
+		ExpectWithPorts(port),
+		ExpectWithCutWithin(window),
+	)
+}
+
 func (c *Checker) expect(expected Expected, from ConnectionSource, to ConnectionTarget,
 	opts ...ExpectationOption) {
 
-	UnactivatedCheckers.Add(c)
-	if c.ReverseDirection {
-		from, to = to.(ConnectionSource), from.(ConnectionTarget)
+	markUnactivated(c)
+	if len(c.DefaultOptions) > 0 {
+		opts = append(append([]ExpectationOption(nil), c.DefaultOptions...), opts...)
+	}
+	if c.reversed(from, to, opts) {
+		from, to = reverse(from, to)
 	}
 
 	e := Expectation{
@@ -161,51 +405,331 @@ func (c *Checker) expect(expected Expected, from ConnectionSource, to Connection
 	for _, option := range opts {
 		option(&e)
 	}
+	e.checkOptionsCombined(c.Protocol)
 
 	e.To = to.ToMatcher(e.explicitPorts...)
+	e.target = to
+
+	c.expectationsMutex().Lock()
+	defer c.expectationsMutex().Unlock()
+	if !e.eachSourceIP {
+		c.expectations = append(c.expectations, e)
+		return
+	}
+	defaultSrcIPs := e.Expected == Some && equalStrings(e.ExpSrcIPs, from.SourceIPs())
+	for _, ip := range from.SourceIPs() {
+		ipExp := e
+		ipExp.srcIP = ip
+		if defaultSrcIPs {
+			ipExp.ExpSrcIPs = []string{ip}
+		}
+		c.expectations = append(c.expectations, ipExp)
+	}
+}
+
+func containsString(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
 
-	c.expectations = append(c.expectations, e)
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
 }
 
 func (c *Checker) ResetExpectations() {
+	c.expectationsMutex().Lock()
 	c.expectations = nil
+	c.conntrackExpectations = nil
+	c.expectationsMutex().Unlock()
 	c.CheckSNAT = false
 	c.RetriesDisabled = false
 
 	c.description = ""
 	c.beforeRetry = nil
 	c.finalTest = nil
+	c.disruption = nil
+	c.probeCtx = nil
+	c.validate = false
+	c.diagnostics = false
+	c.diagnosticsDir = ""
+	c.metricsSource = nil
+	c.flowLogReader = nil
+	c.flowLogTimeout = 0
+	c.leakCheck = false
+	c.leakCheckGrace = 0
+	c.leakCheckStates = nil
+	c.dropAttribution = false
+	c.bpfMapDump = false
+	c.captureAfter = 0
+	c.captureDir = ""
+	c.resultsDir = ""
+	c.junitDir = ""
+	c.attachRuns = false
+	c.htmlReportDir = ""
+	c.lastAttempt = nil
+	c.checkCalls = 0
+	c.sortExpectations = false
+	c.shuffleRand = nil
+	c.attemptSummaries = false
+	c.timeoutPerExpectation = 0
+	c.timeoutMinAttempts = 0
+	c.lastConvergence = nil
+	c.overheadLogging = false
+	c.lastOverhead = HarnessOverhead{}
+	c.lazyPretty = false
+	c.streamDir = ""
+	c.prettyOnlyFailed = false
+	c.prettyContext = 0
+	c.prettyMaxRows = 0
+	c.color = nil
+	c.probeLogLevelSet = false
+	c.transcriptDir = ""
+	c.probesPerSource = 0
+}
+
+// RemoveExpectations removes the connectivity expectations that match the
+// predicate and keeps the rest, so that a Checker that is reused across the
+// phases of a spec only needs to update the expectations that a phase changes,
+// rather than ResetExpectations() and add them all again.  Unlike
+// ResetExpectations(), it leaves the options of the Checker as they are.
+func (c *Checker) RemoveExpectations(predicate func(Expectation) bool) {
+	c.expectationsMutex().Lock()
+	defer c.expectationsMutex().Unlock()
+	var kept []Expectation
+	for _, exp := range c.expectations {
+		if !predicate(exp) {
+			kept = append(kept, exp)
+		}
+	}
+	log.WithField("removed", len(c.expectations)-len(kept)).Debug("Removed connectivity expectations")
+	c.expectations = kept
+	// The indexes of the last attempt no longer match the expectations.
+	c.lastAttempt = nil
+}
+
+// RemoveExpectationsFrom removes the expectations of connectivity from the
+// source.
+func (c *Checker) RemoveExpectationsFrom(from ConnectionSource) {
+	c.RemoveExpectations(func(e Expectation) bool {
+		return e.From.SourceName() == from.SourceName()
+	})
+}
+
+// RemoveExpectationsTo removes the expectations of connectivity to the target,
+// on any of the ports that they were added with.  Targets are matched by type
+// and by name, rather than by value, since the values of some targets, such as a
+// ServiceTarget with a map-backed Resolver, can't be compared.
+func (c *Checker) RemoveExpectationsTo(to ConnectionTarget) {
+	targetType := reflect.TypeOf(to)
+	c.RemoveExpectations(func(e Expectation) bool {
+		// Only a target of the same type is sure to accept the ports of the
+		// expectation.
+		if reflect.TypeOf(e.target) != targetType {
+			return false
+		}
+		return to.ToMatcher(e.explicitPorts...).TargetName == e.To.TargetName
+	})
+}
+
+// RemoveExpectationsWithTag removes the expectations that were tagged with the
+// tag, see ExpectWithTags().
+func (c *Checker) RemoveExpectationsWithTag(tag string) {
+	c.RemoveExpectations(func(e Expectation) bool {
+		return e.HasTag(tag)
+	})
+}
+
+func (c *Checker) protocol() string {
+	if c.Protocol != "" {
+		return c.Protocol
+	}
+	return "tcp"
+}
+
+// checkOptions returns the options of the connectivity check of the expectation.
+func (c *Checker) checkOptions(exp Expectation) []CheckOption {
+	duration := exp.ExpectedPacketLoss.Duration
+	if exp.ExpectedConnRate.Duration > 0 {
+		duration = exp.ExpectedConnRate.Duration
+	}
+	if exp.longLivedDuration > 0 {
+		duration = exp.longLivedDuration
+	}
+	opts := []CheckOption{
+		WithDuration(duration),
+	}
+
+	if c.AutoProvision {
+		opts = append(opts, WithAutoProvision())
+	}
+
+	if c.AllowFallbackProbes {
+		opts = append(opts, WithFallbackProbes())
+	}
+
+	if c.probeLogLevelSet {
+		opts = append(opts, WithProbeLogLevel(c.probeLogLevel))
+	}
+
+	if exp.sendLen > 0 || exp.recvLen > 0 {
+		opts = append(opts, WithSendLen(exp.sendLen), WithRecvLen(exp.recvLen))
+	}
+
+	if exp.srcIP != "" {
+		opts = append(opts, WithSourceIP(exp.srcIP))
+	}
+
+	if exp.sourceInterface != "" {
+		opts = append(opts, WithSourceInterface(exp.sourceInterface))
+	}
+
+	if exp.vrf != "" {
+		opts = append(opts, WithVRF(exp.vrf))
+	}
+
+	if exp.sourceMAC != "" {
+		opts = append(opts, WithSourceMAC(exp.sourceMAC))
+	}
+
+	if exp.tracePath {
+		opts = append(opts, WithPathTrace())
+	}
+
+	if exp.mark != 0 {
+		opts = append(opts, WithSocketMark(exp.mark))
+	}
+
+	if exp.proxyProtocol {
+		opts = append(opts, WithProxyProtocol(exp.proxySource))
+	}
+
+	if exp.viaProxy != "" {
+		opts = append(opts, WithProxy(exp.viaProxy))
+	}
+
+	if exp.icmpReject || exp.noForwardingLoop {
+		opts = append(opts, WithICMPObservation())
+	}
+
+	if exp.tcpFastOpen {
+		opts = append(opts, WithTCPFastOpen())
+	}
+
+	if len(exp.ipv6ExtHeaders) > 0 {
+		opts = append(opts, WithIPv6ExtHeaders(exp.ipv6ExtHeaders...))
+	}
+
+	if exp.payloadPattern != "" {
+		opts = append(opts, WithPayloadPattern(exp.payloadPattern))
+	}
+
+	if exp.fuzzPayload {
+		opts = append(opts, WithFuzzedPayload(exp.fuzzSeed))
+	}
+
+	if exp.ftpMode != "" {
+		opts = append(opts, WithFTP(exp.ftpMode))
+	}
+
+	if len(exp.sctpLocalAddrs) > 0 {
+		opts = append(opts, WithSCTPLocalAddrs(exp.sctpLocalAddrs...))
+	}
+
+	if len(exp.sctpRemoteAddrs) > 0 {
+		opts = append(opts, WithSCTPRemoteAddrs(exp.sctpRemoteAddrs...))
+	}
+
+	if exp.largeSend > 0 {
+		opts = append(opts, WithLargeSend(exp.largeSend))
+	}
+
+	if exp.srcPort != 0 {
+		opts = append(opts, WithSourcePort(strconv.Itoa(int(exp.srcPort))))
+	}
+
+	if exp.parallelFlows > 1 {
+		opts = append(opts, WithParallelFlows(exp.parallelFlows))
+	}
+
+	if exp.packetRate > 0 {
+		opts = append(opts, WithPacketRate(exp.packetRate))
+	}
+
+	if exp.packetSize > 0 {
+		opts = append(opts, WithPacketSize(exp.packetSize))
+	}
+
+	if exp.idlePeriod > 0 {
+		opts = append(opts, WithIdlePeriod(exp.idlePeriod))
+	}
+	if exp.resolver != "" {
+		opts = append(opts, WithResolver(exp.resolver))
+	}
+
+	if exp.ExpectedConnRate.Duration > 0 {
+		opts = append(opts, WithConnectionRate(exp.ExpectedConnRate.AttemptRate))
+	}
+
+	if exp.longLivedDuration > 0 {
+		opts = append(opts, WithLongLivedConnection())
+	}
+
+	if exp.checksOneWayLatency() {
+		opts = append(opts, WithOneWayLatency())
+	}
+	return opts
 }
 
 // ActualConnectivity calculates the current connectivity for all the expected paths.  It returns a
 // slice containing one response for each attempted check (or nil if the check failed) along with
 // a same-length slice containing a pretty-printed description of the check and its result.
+//
+// It checks the expectations that were added when it was called; any that are
+// added while it runs are left for the next call.
 func (c *Checker) ActualConnectivity(isARetry bool) ([]*Result, []string) {
-	UnactivatedCheckers.Discard(c)
+	expectations, resolveErrs := c.resolvedExpectations()
+	responses := c.probe(expectations, resolveErrs, isARetry)
+	pretty := make([]string, len(expectations))
+	for i, exp := range expectations {
+		pretty[i] = c.resultPretty(exp, responses[i])
+	}
+	return responses, pretty
+}
+
+// probe runs the checks of the expectations, see resolvedExpectations(), and
+// returns their results.
+func (c *Checker) probe(expectations []Expectation, resolveErrs []error, isARetry bool) []*Result {
+	markActivated(c)
+
 	var wg sync.WaitGroup
-	responses := make([]*Result, len(c.expectations))
-	pretty := make([]string, len(c.expectations))
+	responses := make([]*Result, len(expectations))
 
-	p := "tcp"
-	if c.Protocol != "" {
-		p = c.Protocol
-	}
+	p := c.protocol()
 
 	// Pre-calculate the options for each connectivity check...
-	preCalcOpts := make([][]CheckOption, len(c.expectations))
-	for i, exp := range c.expectations {
-		opts := []CheckOption{
-			WithDuration(exp.ExpectedPacketLoss.Duration),
-		}
-
-		if exp.sendLen > 0 || exp.recvLen > 0 {
-			opts = append(opts, WithSendLen(exp.sendLen), WithRecvLen(exp.recvLen))
+	preCalcOpts := make([][]CheckOption, len(expectations))
+	for i, exp := range expectations {
+		preCalcOpts[i] = c.checkOptions(exp)
+		preCalcOpts[i] = append(preCalcOpts[i], WithProbeLabels(exp.sourceName(), exp.To.TargetName, c.attempt))
+		preCalcOpts[i] = append(preCalcOpts[i], WithContext(c.probeContext()))
+		if c.traceCtx != nil {
+			preCalcOpts[i] = append(preCalcOpts[i], WithTraceContext(c.traceCtx))
 		}
-
-		if exp.srcPort != 0 {
-			opts = append(opts, WithSourcePort(strconv.Itoa(int(exp.srcPort))))
+		if c.transcript != nil {
+			preCalcOpts[i] = append(preCalcOpts[i], withTranscript(c.transcript))
 		}
-		preCalcOpts[i] = opts
 	}
 
 	if isARetry {
@@ -213,7 +737,7 @@ func (c *Checker) ActualConnectivity(isARetry bool) ([]*Result, []string) {
 		// might have been leaked by an earlier run.  Important to do this first rather than in-line to avoid
 		// one checker running its cleanup in parallel with another actually doing its check.
 		log.Debug("Retry, calling pre-retry cleanup functions.")
-		for i, exp := range c.expectations {
+		for i, exp := range expectations {
 			wg.Add(1)
 			go func(i int, exp Expectation) {
 				defer ginkgo.GinkgoRecover()
@@ -224,160 +748,617 @@ func (c *Checker) ActualConnectivity(isARetry bool) ([]*Result, []string) {
 		wg.Wait()
 	}
 
+	// Long-lived connections signal when they are established so that we can
+	// run the disruption while they are up.  If one fails before it gets
+	// established, it signals when its check completes.
+	var connected sync.WaitGroup
+	connectedSignals := make([]func(), len(expectations))
+	for i, exp := range expectations {
+		if exp.longLivedDuration == 0 {
+			continue
+		}
+		connected.Add(1)
+		var once sync.Once
+		connectedSignals[i] = func() { once.Do(connected.Done) }
+		preCalcOpts[i] = append(preCalcOpts[i], WithOnConnected(connectedSignals[i]))
+	}
+	var disruptionDone chan struct{}
+	var disruptionStart, disruptionEnd time.Time
+	if c.disruption != nil {
+		disruptionDone = make(chan struct{})
+		go func() {
+			defer ginkgo.GinkgoRecover()
+			defer close(disruptionDone)
+			connected.Wait()
+			log.Info("Long-lived connections established, calling disruption function.")
+			disruptionStart = time.Now()
+			c.disruption()
+			disruptionEnd = time.Now()
+		}()
+	} else {
+		for _, exp := range expectations {
+			Expect(exp.cutWindow).To(BeZero(),
+				"ExpectConnectionCutWithin needs CheckWithDisruption() to cut the connection")
+		}
+	}
+
+	// Expectations with the same probe share its result, see probeKey.
+	dupOf := duplicateProbes(expectations)
+	probed := make([]chan struct{}, len(expectations))
+	probeResults := make([]*Result, len(expectations))
+	for i, orig := range dupOf {
+		if orig != i && probed[orig] == nil {
+			probed[orig] = make(chan struct{})
+		}
+	}
+
+	// Probes of the same source may have to take turns, see
+	// CheckWithProbesPerSource().
+	slots := c.sourceSlots(expectations)
+
 	// Actually run the checks and format the results.
-	for i, exp := range c.expectations {
+	for _, i := range c.probeOrder(len(expectations)) {
+		exp := expectations[i]
 		wg.Add(1)
 		go func(i int, exp Expectation) {
 			defer ginkgo.GinkgoRecover()
 			defer wg.Done()
-			res := exp.From.CanConnectTo(exp.To.IP, exp.To.Port, p, preCalcOpts[i]...)
-			pretty[i] += fmt.Sprintf("%s -> %s = %v", exp.From.SourceName(), exp.To.TargetName, res.HasConnectivity())
-
-			if res != nil {
-				if c.CheckSNAT {
-					srcIP := strings.Split(res.LastResponse.SourceAddr, ":")[0]
-					pretty[i] += " (from " + srcIP + ")"
+			if connectedSignals[i] != nil {
+				defer connectedSignals[i]()
+			}
+			if probed[i] != nil {
+				defer close(probed[i])
+			}
+			var res *Result
+			if resolveErrs[i] != nil {
+				res = &Result{HarnessErr: &HarnessError{Err: resolveErrs[i]}}
+			} else {
+				if orig := dupOf[i]; orig != i {
+					<-probed[orig]
+					if probeResults[orig] != nil {
+						shared := *probeResults[orig]
+						res = &shared
+					}
 				}
-				if res.ClientMTU.Start != 0 {
-					pretty[i] += fmt.Sprintf(" (client MTU %d -> %d)", res.ClientMTU.Start, res.ClientMTU.End)
+				if res == nil {
+					res = inSlot(slots[i], func() *Result {
+						return exp.From.CanConnectTo(exp.To.IP, exp.To.Port, p, preCalcOpts[i]...)
+					})
+					if probed[i] != nil && res != nil {
+						shared := *res
+						probeResults[i] = &shared
+					}
 				}
-				if exp.ExpectedPacketLoss.Duration > 0 {
-					sent := res.Stats.RequestsSent
-					lost := res.Stats.Lost()
-					pct := res.Stats.LostPercent()
-					pretty[i] += fmt.Sprintf(" (sent: %d, lost: %d / %.1f%%)", sent, lost, pct)
+			}
+			if res != nil && res.HarnessErr == nil {
+				res.Translation = translationOf(exp, res)
+				if err := exp.tooFewPacketsSent(res); err != nil {
+					res.HarnessErr = &HarnessError{Err: err}
 				}
 			}
 
 			responses[i] = res
 		}(i, exp)
-		time.Sleep(c.StaggerStartBy)
+		if dupOf[i] == i {
+			time.Sleep(c.StaggerStartBy)
+		}
 	}
 	wg.Wait()
-	return responses, pretty
+	if disruptionDone != nil {
+		<-disruptionDone
+	}
+
+	// Now that we know when the disruption happened, work out when the cut
+	// connections were cut relative to it.
+	for i, exp := range expectations {
+		res := responses[i]
+		if exp.cutWindow == 0 || res == nil || res.ConnectionCut == nil {
+			continue
+		}
+		cut := res.ConnectionCut
+		if cut.Time.Before(disruptionStart) {
+			cut.Premature = true
+		} else if cut.Time.After(disruptionEnd) {
+			cut.SinceDisruption = cut.Time.Sub(disruptionEnd)
+		}
+	}
+
+	return responses
 }
 
-// ExpectedConnectivityPretty returns one string per recorded expectation in order, encoding the expected
-// connectivity in similar format used by ActualConnectivity().
-func (c *Checker) ExpectedConnectivityPretty() []string {
-	result := make([]string, len(c.expectations))
-	for i, exp := range c.expectations {
-		result[i] = fmt.Sprintf("%s -> %s = %v", exp.From.SourceName(), exp.To.TargetName, exp.Expected)
-		if exp.Expected {
-			if c.CheckSNAT {
-				result[i] += " (from " + strings.Join(exp.ExpSrcIPs, "|") + ")"
+// resultPretty describes the result of the check of the expectation, in the
+// format of ActualConnectivity().
+func (c *Checker) resultPretty(exp Expectation, res *Result) string {
+	var b strings.Builder
+	b.WriteString(prettyHead(exp, res.HasConnectivity()))
+
+	if res != nil && res.HarnessErr != nil {
+		b.WriteString(" (" + res.HarnessErr.Error() + ")")
+	} else if exp.icmpReject {
+		b.WriteString(exp.icmpPretty(res))
+	} else if nodes := res.forwardingLoop(); len(nodes) > 0 {
+		b.WriteString(loopPretty(nodes))
+	}
+	if res != nil && res.HarnessErr == nil {
+		if res.ResolvedIP != "" {
+			b.WriteString(" (resolved to " + res.ResolvedIP + ")")
+		}
+		if res.Neighbor != nil {
+			b.WriteString(" (replied with " + res.Neighbor.MAC + ")")
+		}
+		if res.Path != nil {
+			b.WriteString(" (path " + res.Path.String() + ")")
+		}
+		if res.Translation != nil && res.Translation.CrossFamily() {
+			b.WriteString(" (" + res.Translation.String() + ")")
+		}
+		if res.Fallback != "" {
+			b.WriteString(" (fallback: " + res.Fallback + ")")
+		}
+		if c.CheckSNAT {
+			srcIP := strings.Split(res.LastResponse.SourceAddr, ":")[0]
+			b.WriteString(" (from " + srcIP + ")")
+		}
+		if len(exp.expServerIPs) > 0 && res.HasConnectivity() {
+			b.WriteString(" (served by " + res.LastResponse.ServerIP() + ")")
+		}
+		if exp.checksFiveTuple() && res.HasConnectivity() {
+			b.WriteString(" (server saw " + res.LastResponse.FiveTuple().String() + ")")
+		}
+		if (exp.proxyProtocol || exp.expProxySource != "") && res.HasConnectivity() {
+			if res.LastResponse.Proxy != nil {
+				b.WriteString(" (" + res.LastResponse.Proxy.String() + ")")
+			} else {
+				b.WriteString(" (no PROXY header)")
 			}
-			if exp.clientMTUStart != 0 || exp.clientMTUEnd != 0 {
-				result[i] += fmt.Sprintf(" (client MTU %d -> %d)", exp.clientMTUStart, exp.clientMTUEnd)
+		}
+		if exp.receivedOn != "" && res.HasConnectivity() {
+			b.WriteString(" (received on " + res.LastResponse.ReceivedOn)
+			if res.LastResponse.Namespace != "" {
+				b.WriteString(" in " + res.LastResponse.Namespace)
 			}
+			b.WriteString(")")
+		}
+		if res.ClientMTU.Start != 0 {
+			fmt.Fprintf(&b, " (client MTU %d -> %d)", res.ClientMTU.Start, res.ClientMTU.End)
+		}
+		if res.TCPInfo != nil && (exp.maxMSS != 0 || exp.checkRetransmits) {
+			fmt.Fprintf(&b, " (MSS %d, retransmits %d, RTT %v)",
+				res.TCPInfo.MSS, res.TCPInfo.Retransmits, res.TCPInfo.RTT)
 		}
 		if exp.ExpectedPacketLoss.Duration > 0 {
-			if exp.ExpectedPacketLoss.MaxNumber >= 0 {
-				result[i] += fmt.Sprintf(" (maxLoss: %d packets)", exp.ExpectedPacketLoss.MaxNumber)
+			sent := res.Stats.RequestsSent
+			lost := res.Stats.Lost()
+			pct := res.Stats.LostPercent()
+			fmt.Fprintf(&b, " (sent: %d, lost: %d / %.1f%%)", sent, lost, pct)
+			if exp.ExpectedPacketLoss.MaxBucketPercent > 0 {
+				fmt.Fprintf(&b, " (worst %v: %.1f%%)",
+					res.Stats.BucketSize, res.Stats.MaxBucketLostPercent())
+			}
+			if exp.checkReordering {
+				fmt.Fprintf(&b, " (reordered: %d, max distance %d)",
+					res.Stats.Reordered, res.Stats.MaxReorderDistance)
 			}
-			if exp.ExpectedPacketLoss.MaxPercent >= 0 {
-				result[i] += fmt.Sprintf(" (maxLoss: %.1f%%)", exp.ExpectedPacketLoss.MaxPercent)
+			if exp.noDuplicates {
+				fmt.Fprintf(&b, " (duplicates: %d)", res.Stats.Duplicates)
 			}
+			if exp.checkRequestLoss || exp.checkResponseLoss {
+				b.WriteString(lossDirectionPretty(res))
+			}
+		}
+		if exp.minBytesSent != 0 || exp.minBytesReceived != 0 {
+			b.WriteString(bytesPretty(res))
 		}
-		if exp.ErrorStr != "" {
-			result[i] += " " + exp.ErrorStr
+		if exp.checksOneWayLatency() {
+			b.WriteString(oneWayLatencyPretty(res))
+		}
+		if exp.longLivedDuration > 0 && exp.cutWindow == 0 {
+			fmt.Fprintf(&b, " (keepalives: %d/%d answered)",
+				res.Stats.ResponsesReceived, res.Stats.RequestsSent)
+		}
+		if exp.ExpectedConnRate.Duration > 0 {
+			fmt.Fprintf(&b, " (connections: %d/%d ok, %.1f cps)",
+				res.Stats.ResponsesReceived, res.Stats.RequestsSent, res.ConnectionRate)
+		}
+		if len(res.Flows) > 0 {
+			fmt.Fprintf(&b, " (flows: %d/%d ok, %d source ports, max latency %s)",
+				res.SuccessfulFlows(), len(res.Flows), len(res.FlowSourcePorts()), res.MaxFlowLatency())
+		}
+		if exp.checksSNATPorts() && res.HasConnectivity() {
+			b.WriteString(exp.snatPortsPretty(res))
+		}
+		if exp.tcpFastOpen && res.HasConnectivity() {
+			b.WriteString(fastOpenPretty(res))
+		}
+		if exp.fuzzPayload {
+			b.WriteString(payloadSeedPretty(res))
+		}
+		if exp.sctpMultihomed() && res.SCTP != nil {
+			b.WriteString(" (" + res.SCTP.String() + ")")
+		}
+		if exp.largeSend > 0 && res.HasConnectivity() {
+			b.WriteString(largeSendPretty(res))
 		}
 	}
-	return result
-}
-
-var defaultConnectivityTimeout = 10 * time.Second
-
-func (c *Checker) CheckConnectivityOffset(offset int, opts ...interface{}) {
-	c.CheckConnectivityWithTimeoutOffset(offset+2, defaultConnectivityTimeout, opts...)
-}
-
-func (c *Checker) CheckConnectivity(opts ...interface{}) {
-	c.CheckConnectivityWithTimeoutOffset(2, defaultConnectivityTimeout, opts...)
-}
 
-func (c *Checker) CheckConnectivityPacketLoss(opts ...interface{}) {
-	// Timeout is not used for packet loss test because there is no retry.
-	c.CheckConnectivityWithTimeoutOffset(2, 0*time.Second, opts...)
+	if exp.cutWindow > 0 && res != nil {
+		if cut := res.ConnectionCut; cut == nil {
+			b.WriteString(" (not cut)")
+		} else {
+			how := "stalled"
+			if cut.Reset {
+				how = "reset"
+			}
+			if cut.Premature {
+				fmt.Fprintf(&b, " (%s before disruption)", how)
+			} else {
+				fmt.Fprintf(&b, " (%s %v after disruption)", how, cut.SinceDisruption)
+			}
+		}
+	}
+	if err := exp.resultMatcherErr(res); err != nil {
+		b.WriteString(" (" + err.Error() + ")")
+	}
+	return b.String()
 }
 
-func (c *Checker) CheckConnectivityWithTimeout(timeout time.Duration, opts ...interface{}) {
-	Expect(timeout).To(BeNumerically(">", 100*time.Millisecond),
-		"Very low timeout, did you mean to multiply by time.<Unit>?")
-	c.CheckConnectivityWithTimeoutOffset(2, timeout, opts...)
+// ExpectedConnectivityPretty returns one string per recorded expectation in order, encoding the expected
+// connectivity in similar format used by ActualConnectivity().
+func (c *Checker) ExpectedConnectivityPretty() []string {
+	expectations := c.expectationsSnapshot()
+	result := make([]string, len(expectations))
+	for i, exp := range expectations {
+		result[i] = c.expectedPretty(exp)
+	}
+	return result
 }
 
-func (c *Checker) CheckConnectivityWithTimeoutOffset(callerSkip int, timeout time.Duration, opts ...interface{}) {
-	log.Info("Starting connectivity check...")
-	for _, o := range opts {
-		switch v := o.(type) {
-		case string:
-			c.description = v
-		case CheckerOpt:
-			v(c)
+// expectedPretty describes the expectation, in the format of
+// ExpectedConnectivityPretty().
+func (c *Checker) expectedPretty(exp Expectation) string {
+	result := prettyHead(exp, exp.Expected)
+	if exp.viaProxy != "" {
+		result += " (via " + redactedURL(exp.viaProxy) + ")"
+	}
+	if len(exp.ipv6ExtHeaders) > 0 {
+		result += " (IPv6 ext headers: " + joinIPv6ExtHeaders(exp.ipv6ExtHeaders) + ")"
+	}
+	if exp.payloadPattern != "" || exp.fuzzPayload {
+		result += payloadPretty(exp)
+	}
+	if exp.ftpMode != "" {
+		result += " (FTP " + string(exp.ftpMode) + ")"
+	}
+	if exp.sctpMultihomed() {
+		result += exp.sctpPretty()
+	}
+	if exp.largeSend > 0 {
+		result += fmt.Sprintf(" (large send: %d bytes", exp.largeSend)
+		if exp.maxReceivedSegment > 0 {
+			result += fmt.Sprintf(", segments <= %d bytes", exp.maxReceivedSegment)
 		}
+		result += ")"
 	}
-
-	var expConnectivity []string
-	start := time.Now()
-
-	// Track the number of attempts. If the first connectivity check fails, we want to
-	// do at least one retry before we time out.  That covers the case where the first
-	// connectivity check takes longer than the timeout.
+	if exp.noForwardingLoop {
+		result += " (no forwarding loop)"
+	}
+	if exp.icmpReject {
+		from := exp.icmpRejectFrom
+		if from == "" {
+			from = "any IP"
+		}
+		result += " (rejected with ICMP from " + from + ")"
+	}
+	if exp.Expected {
+		if c.CheckSNAT {
+			result += " (from " + strings.Join(exp.ExpSrcIPs, "|") + ")"
+		}
+		if len(exp.expServerIPs) > 0 {
+			result += " (served by " + strings.Join(exp.expServerIPs, "|") + ")"
+		}
+		if exp.receivedOn != "" {
+			result += " (received on " + exp.receivedOn + ")"
+		}
+		if exp.checksFiveTuple() {
+			result += " (server sees " + exp.fiveTuplePretty() + ")"
+		}
+		if exp.expProxySource != "" {
+			result += " (PROXY " + exp.expProxySource + ")"
+		} else if exp.proxyProtocol {
+			result += " (PROXY header)"
+		}
+		result += exp.clientMTUPretty()
+		if exp.parallelFlows > 1 {
+			result += fmt.Sprintf(" (flows: %d/%d ok)", exp.parallelFlows, exp.parallelFlows)
+		}
+		if exp.checksSNATPorts() {
+			result += fmt.Sprintf(" (SNAT ports in %d-%d)", exp.snatPortMin, exp.snatPortMax)
+		}
+		if exp.tfoAccepted {
+			result += " (TFO: SYN data accepted)"
+		}
+		if exp.maxMSS != 0 {
+			result += fmt.Sprintf(" (MSS <= %d)", exp.maxMSS)
+		}
+		if exp.idlePeriod > 0 {
+			result += fmt.Sprintf(" (after idling %v)", exp.idlePeriod)
+		}
+		if exp.mark != 0 {
+			result += fmt.Sprintf(" (mark %#x)", exp.mark)
+		}
+		if exp.sourceMAC != "" {
+			result += " (from MAC " + exp.sourceMAC + ")"
+		}
+		if len(exp.pathVia) > 0 {
+			result += " (via " + strings.Join(exp.pathVia, ", ") + ")"
+		}
+		if len(exp.pathNotVia) > 0 {
+			result += " (not via " + strings.Join(exp.pathNotVia, ", ") + ")"
+		}
+		if exp.checkEncap {
+			if exp.encap == "" {
+				result += " (not encapsulated)"
+			} else {
+				result += fmt.Sprintf(" (encapsulated in %s)", exp.encap)
+			}
+		}
+		if exp.checkRetransmits {
+			result += fmt.Sprintf(" (retransmits <= %d)", exp.maxRetransmits)
+		}
+	}
+	if exp.ExpectedConnRate.Duration > 0 {
+		result += fmt.Sprintf(" (min rate: %.1f cps)", exp.ExpectedConnRate.MinRate)
+	}
+	if exp.cutWindow > 0 {
+		result += fmt.Sprintf(" (reset within %v after disruption)", exp.cutWindow)
+	} else if exp.longLivedDuration > 0 {
+		result += fmt.Sprintf(" (survives %v)", exp.longLivedDuration)
+	}
+	if exp.ExpectedPacketLoss.Duration > 0 {
+		if exp.ExpectedPacketLoss.MaxNumber >= 0 {
+			result += fmt.Sprintf(" (maxLoss: %d packets)", exp.ExpectedPacketLoss.MaxNumber)
+		}
+		if exp.ExpectedPacketLoss.MaxPercent >= 0 {
+			result += fmt.Sprintf(" (maxLoss: %.1f%%)", exp.ExpectedPacketLoss.MaxPercent)
+		}
+		if exp.ExpectedPacketLoss.MinPacketsSent > 0 {
+			result += fmt.Sprintf(" (min sent: %d packets)", exp.ExpectedPacketLoss.MinPacketsSent)
+		}
+		if exp.ExpectedPacketLoss.MaxBucketPercent > 0 {
+			result += fmt.Sprintf(" (maxLoss per %v: %.1f%%)", LossBucketSize,
+				exp.ExpectedPacketLoss.MaxBucketPercent)
+		}
+		if exp.checkReordering {
+			result += fmt.Sprintf(" (max reordered: %d)", exp.maxReordered)
+		}
+		if exp.noDuplicates {
+			result += " (no duplicates)"
+		}
+		result += exp.lossDirectionPretty()
+	}
+	result += exp.bytesPretty()
+	result += exp.resultMatchersPretty()
+	result += exp.oneWayLatencyPretty()
+	if exp.ErrorStr != "" {
+		result += " " + exp.ErrorStr
+	}
+	if exp.knownIssue != "" {
+		result += " (known issue " + exp.knownIssue + ")"
+	}
+	return result
+}
+
+// defaultConnectivityTimeout is the timeout of CheckConnectivity() in a
+// CheckerContext that doesn't set one.
+const defaultConnectivityTimeout = 10 * time.Second
+
+func (c *Checker) CheckConnectivityOffset(offset int, opts ...interface{}) *CheckReport {
+	return c.CheckConnectivityWithTimeoutOffset(offset+2, c.context().defaultTimeout(), opts...)
+}
+
+// CheckConnectivity probes the expectations, retrying until they pass or the
+// default timeout, and fails the test if they don't.  It returns the report of
+// the run, for follow-up assertions on the results.
+func (c *Checker) CheckConnectivity(opts ...interface{}) *CheckReport {
+	return c.CheckConnectivityWithTimeoutOffset(2, c.context().defaultTimeout(), opts...)
+}
+
+func (c *Checker) CheckConnectivityPacketLoss(opts ...interface{}) *CheckReport {
+	// Timeout is not used for packet loss test because there is no retry.
+	return c.CheckConnectivityWithTimeoutOffset(2, 0*time.Second, opts...)
+}
+
+func (c *Checker) CheckConnectivityWithTimeout(timeout time.Duration, opts ...interface{}) *CheckReport {
+	Expect(timeout).To(BeNumerically(">", 100*time.Millisecond),
+		"Very low timeout, did you mean to multiply by time.<Unit>?")
+	return c.CheckConnectivityWithTimeoutOffset(2, timeout, opts...)
+}
+
+func (c *Checker) CheckConnectivityWithTimeoutOffset(callerSkip int, timeout time.Duration, opts ...interface{}) (report *CheckReport) {
+	log.Info("Starting connectivity check...")
+	c.failure = nil
+	for _, o := range opts {
+		switch v := o.(type) {
+		case string:
+			c.description = v
+		case CheckerOpt:
+			v(c)
+		}
+	}
+
+	c.startTranscript("Connectivity check")
+	defer c.endTranscript()
+
+	ctx, span := c.tracer().Start(c.probeContext(), "CheckConnectivity", trace.WithAttributes(
+		attribute.Int("expectations", c.numExpectations()),
+		attribute.String("protocol", c.protocol()),
+		attribute.String("description", c.description),
+	))
+	defer span.End()
+	defer func() { c.traceCtx = nil }()
+
+	var expConnectivity []string
+	start := time.Now()
+	overheadBefore := harnessOverheadNow()
+	defer func() { c.recordOverhead(overheadBefore, start) }()
+
+	// Track the number of attempts. If the first connectivity check fails, we want to
+	// do at least one retry before we time out.  That covers the case where the first
+	// connectivity check takes longer than the timeout.
 	completedAttempts := 0
-	var actualConn []*Result
-	var actualConnPretty []string
-	var finalErr error
+	var last Attempt
+	var attempts []Attempt
+
+	// The timeout, scaled by CheckWithScaledTimeout() as attempts are timed.
+	scaledTimeout := timeout
+	var longestAttempt time.Duration
+
+	// Failed attempts of each expectation, for packet capture.
+	expFailures := map[int]int{}
+	var pcapFiles []string
+
+	// Harness errors are retried, regardless of the retry policy, and fail the
+	// test with a distinct message if they persist.
+	harnessFailedAttempts := 0
+
+	defer func() { report = c.checkReport(start, attempts, expConnectivity) }()
+
+	c.sortExpectationsIfNeeded()
+	if !c.reportConflicts(callerSkip) {
+		return
+	}
+	if c.validate && !c.reportValidationProblems(callerSkip) {
+		return
+	}
+
+	// The leak check compares the conntrack entries of the same expectations
+	// before and after the check.
+	var ctExpectations []Expectation
+	var ctBefore conntrackSnapshot
+	if c.leakCheck {
+		ctExpectations = c.expectationsSnapshot()
+		ctBefore = c.snapshotConntrack(ctExpectations)
+	}
 
 	if c.init != nil {
 		c.init()
 	}
 
 	for {
-		checkStartTime := time.Now()
 		isARetry := completedAttempts > 0
-		actualConn, actualConnPretty = c.ActualConnectivity(isARetry)
-		failed := false
-		finalErr = nil
-		expConnectivity = c.ExpectedConnectivityPretty()
-		for i := range c.expectations {
-			exp := c.expectations[i]
-			act := actualConn[i]
-			if !exp.Matches(act, c.CheckSNAT) {
-				failed = true
-				actualConnPretty[i] += " <---- WRONG"
-				expConnectivity[i] += " <---- EXPECTED"
+		var failedExps []Expectation
+		last, expConnectivity, failedExps = c.runAttempt(ctx, completedAttempts+1, isARetry)
+		completedAttempts++
+		if c.streamDir != "" {
+			c.streamAttempt(&last)
+			if len(attempts) > 0 {
+				forgetResults(&attempts[len(attempts)-1])
 			}
 		}
+		attempts = append(attempts, last)
+		c.transcribeAttempt(&last)
+		if last.Duration > longestAttempt {
+			longestAttempt = last.Duration
+		}
+		if t := c.scaledTimeout(timeout, longestAttempt); t != scaledTimeout {
+			log.WithFields(log.Fields{
+				"timeout":      t,
+				"expectations": c.numExpectations(),
+				"longest":      longestAttempt,
+			}).Info("Scaled connectivity check timeout")
+			scaledTimeout = t
+		}
 
-		completedAttempts++
+		var retryKnownIssues bool
+		if last.Passed && len(last.KnownIssues) > 0 {
+			// Only settle for the known issues once the check can't be retried
+			// any more, their paths may still converge.
+			failedExps = knownIssueExpectations(&last)
+			retryKnownIssues = canRetryAll(failedExps, completedAttempts, start, last.Start, scaledTimeout,
+				c.RetriesDisabled)
+		}
 
-		if !failed {
-			if c.finalTest != nil {
-				finalErr = c.finalTest()
-				if finalErr != nil {
-					failed = true
-				}
+		if last.Passed && !retryKnownIssues {
+			// Success!
+			log.WithField("attempts", completedAttempts).Info("Connectivity check passed.")
+			c.transcript.printf("Connectivity check passed after %d attempts in %v", completedAttempts, time.Since(start))
+			reportKnownIssues(last.KnownIssues)
+			reportQuarantined(last.Quarantined)
+			c.recordConvergence(start, attempts)
+			span.SetAttributes(attribute.Int("attempts", completedAttempts))
+			if c.leakCheck {
+				c.reportConntrackLeaks(ctExpectations, ctBefore, callerSkip)
 			}
-			if !failed {
-				// Success!
-				log.WithField("attempts", completedAttempts).Info("Connectivity check passed.")
-				return
+			if c.flowLogReader != nil {
+				c.reportFlowLogs(last.expectations, start, callerSkip)
+			}
+			if c.resultsDir != "" || c.junitDir != "" || c.attachRuns {
+				c.reportRun(c.runResults(start, attempts, nil))
+			}
+			return
+		}
+
+		for _, m := range last.Mismatches {
+			expFailures[m.Index]++
+			if c.captureAfter > 0 && expFailures[m.Index] == c.captureAfter {
+				pcapFiles = append(pcapFiles, c.captureExpectation(last.expectations, m.Index)...)
 			}
 		}
 
-		if c.RetriesDisabled {
+		if len(last.HarnessErrors) > 0 {
+			harnessFailedAttempts++
+			if harnessFailedAttempts >= maxHarnessAttempts {
+				c.transcript.printf("Giving up after %d attempts with harness errors", harnessFailedAttempts)
+				break
+			}
+			log.WithField("errors", last.HarnessErrors).Warn("Connectivity check harness failed, retrying.")
+			c.transcript.printf("Harness failed, retrying regardless of the retry policy")
+			if retryInterval := c.context().retryInterval(); retryInterval > 0 {
+				time.Sleep(retryInterval)
+			}
+			if c.beforeRetry != nil {
+				log.Debug("calling beforeRetry")
+				c.beforeRetry()
+			}
+			continue
+		}
+
+		if onlyUnexpectedPasses(&last) {
+			log.WithField("attempts", completedAttempts).Info(
+				"Connectivity check failed, expectations with known issues passed.")
+			c.transcript.printf("Giving up after %d attempts, expectations with known issues passed",
+				completedAttempts)
 			break
 		}
 
+		if len(failedExps) == 0 {
+			// The final test failed, fall back to the Checker's retry policy.
+			failedExps = []Expectation{{}}
+		}
+
 		// Check the timeout before we execute the retry function since the retry function might take a while,
-		// effectively cutting down the timeout.  Since one check should take ~2s we also check that we started
-		// the iteration close to the end of the.  Better to be a little permissive than flaky!
-		if time.Since(start) > timeout &&
-			checkStartTime.Sub(start) > timeout-2*time.Second &&
-			completedAttempts >= 2 {
+		// effectively cutting down the timeout.  We only retry if the policies of all the failed expectations
+		// allow it.
+		retry := canRetryAll(failedExps, completedAttempts, start, last.Start, scaledTimeout, c.RetriesDisabled)
+		var retryInterval time.Duration
+		for _, exp := range failedExps {
+			if exp.retryInterval > retryInterval {
+				retryInterval = exp.retryInterval
+			}
+		}
+		if retryInterval == 0 {
+			retryInterval = c.context().retryInterval()
+		}
+		if !retry {
+			c.transcript.printf("Giving up after %d attempts in %v, the timeout is %v",
+				completedAttempts, time.Since(start), scaledTimeout)
 			break
 		}
+		c.transcript.printf("Retrying, %d attempts in %v", completedAttempts, time.Since(start))
+
+		if retryInterval > 0 {
+			time.Sleep(retryInterval)
+		}
 
 		if c.beforeRetry != nil {
 			log.Debug("calling beforeRetry")
@@ -385,32 +1366,364 @@ func (c *Checker) CheckConnectivityWithTimeoutOffset(callerSkip int, timeout tim
 		}
 	}
 
+	if c.lazyPretty {
+		c.describeAll(&last, expConnectivity)
+	}
+	message := c.attemptMessage(&last, expConnectivity, harnessFailedAttempts)
+	mismatches := last.Mismatches
+	harnessErrs := last.HarnessErrors
+
+	if c.diagnostics && len(harnessErrs) == 0 {
+		message += c.collectDiagnostics(failingHosts(last.expectations, mismatches))
+	}
+
+	var dropRules []DropRule
+	if c.dropAttribution && len(harnessErrs) == 0 && len(mismatches) > 0 {
+		var dropsMsg string
+		dropRules, dropsMsg = c.attributeDrops(last.expectations, mismatches)
+		message += dropsMsg
+	}
+
+	if c.bpfMapDump && len(harnessErrs) == 0 && len(mismatches) > 0 {
+		message += c.dumpBPFMaps(last.expectations, mismatches)
+	}
+
+	if c.htmlReportDir != "" && len(harnessErrs) == 0 {
+		message += c.writeHTMLReport(last.expectations, last.Results, expConnectivity, last.Pretty, mismatches)
+	}
+
+	if len(pcapFiles) > 0 {
+		message += "\nPacket captures:\n    " + strings.Join(pcapFiles, "\n    ") + "\n"
+	}
+
+	log.Warn("Connectivity check failed: " + message)
+	message += fmt.Sprintf("\n\n Test took %s and %d tries.\n", time.Since(start), completedAttempts)
+	message += c.scaledTimeoutMessage(timeout, scaledTimeout, longestAttempt)
+
+	c.recordConvergence(start, attempts)
+	checkErr := attemptError(&last, message)
+	checkErr.DropRules = dropRules
+	checkErr.Attempts = completedAttempts
+	checkErr.Duration = time.Since(start)
+	checkErr.Timeout = scaledTimeout
+	if c.resultsDir != "" || c.junitDir != "" || c.attachRuns {
+		c.reportRun(c.runResults(start, attempts, checkErr))
+	}
+	span.SetAttributes(attribute.Int("attempts", completedAttempts))
+	span.SetStatus(codes.Error, string(checkErr.Kind))
+	if c.OnFinalFail != nil {
+		c.OnFinalFail(checkErr, attempts)
+	}
+	c.fail(checkErr, callerSkip)
+	return
+}
+
+// Check does a single attempt of the checks and returns a *CheckError if it
+// fails, so that the Checker can be polled by gomega instead of its own retry
+// loop:
+//
+//	Eventually(cc.Check, "10s", "100ms").Should(Succeed())
+//
+// The first call after ResetExpectations() runs the CheckWithInit() function,
+// later calls are retries: they run the CheckWithBeforeRetry() function and the
+// pre-retry cleanup.  Apply CheckerOpts by calling them on the Checker, for
+// example, CheckWithFinalTest(f)(cc).  The retry policies of the expectations
+// don't apply; gomega decides when to give up.
+func (c *Checker) Check() error {
+	isARetry := c.checkCalls > 0
+	if !isARetry {
+		c.sortExpectationsIfNeeded()
+		if conflicts := c.expectationConflicts(); len(conflicts) > 0 {
+			return c.conflictError(conflicts)
+		}
+	}
+	if !isARetry && c.init != nil {
+		c.init()
+	}
+	if isARetry && c.beforeRetry != nil {
+		log.Debug("calling beforeRetry")
+		c.beforeRetry()
+	}
+	c.checkCalls++
+
+	c.startTranscript("Check")
+	defer c.endTranscript()
+
+	ctx, span := c.tracer().Start(c.probeContext(), "Check")
+	defer span.End()
+	defer func() { c.traceCtx = nil }()
+
+	attempt, expConnectivity, _ := c.runAttempt(ctx, c.checkCalls, isARetry)
+	c.transcribeAttempt(&attempt)
+	if attempt.Passed {
+		reportKnownIssues(attempt.KnownIssues)
+		reportQuarantined(attempt.Quarantined)
+		return nil
+	}
+	if c.lazyPretty {
+		c.describeAll(&attempt, expConnectivity)
+	}
+	checkErr := attemptError(&attempt, c.attemptMessage(&attempt, expConnectivity, 1))
+	span.SetStatus(codes.Error, string(checkErr.Kind))
+	return checkErr
+}
+
+// runAttempt runs all the checks once, with the given attempt number, and
+// returns the attempt, the pretty form of the expected connectivity, with the
+// mismatches marked, and the expectations that failed.  The attempt checks the
+// expectations that were added when it started and holds a copy of them, use
+// that to describe and report its results rather than c.expectations.
+func (c *Checker) runAttempt(ctx context.Context, number int, isARetry bool) (Attempt, []string, []Expectation) {
+	checkStartTime := time.Now()
+	var attemptSpan trace.Span
+	c.attempt = number
+	c.traceCtx, attemptSpan = c.tracer().Start(ctx, "attempt",
+		trace.WithAttributes(attribute.Int("number", number)))
+	expectations, resolveErrs := c.resolvedExpectations()
+	var deniedHosts []string
+	var deniedBefore deniedPacketsSnapshot
+	if c.metricsSource != nil {
+		deniedHosts = deniedExpectationHosts(expectations)
+		deniedBefore = c.snapshotDeniedPackets(deniedHosts)
+	}
+	encapCaptures := c.startEncapCaptures(expectations)
+	actualConn := c.probe(expectations, resolveErrs, isARetry)
+	encapOutput := stopEncapCaptures(encapCaptures)
+	failed := false
+
+	// With CheckWithLazyPretty(), only the expectations that need it are
+	// described, the rest are described if the check fails.
+	actualConnPretty := make([]string, len(expectations))
+	expConnectivity := make([]string, len(expectations))
+	describe := func(i int) {
+		if actualConnPretty[i] == "" {
+			actualConnPretty[i] = c.resultPretty(expectations[i], actualConn[i])
+			expConnectivity[i] = c.expectedPretty(expectations[i])
+		}
+	}
+	if !c.lazyPretty {
+		for i := range expectations {
+			describe(i)
+		}
+	}
+
+	var failedExps []Expectation
+	var mismatches []MismatchDetail
+	var knownIssues []KnownIssue
+	var quarantined []MismatchDetail
+	for i, exp := range expectations {
+		act := actualConn[i]
+		matches := exp.Matches(act, c.CheckSNAT)
+		if exp.knownIssue != "" && !matches && (act == nil || act.HarnessErr == nil) {
+			describe(i)
+			// Failed as expected, see ExpectWithKnownIssue().
+			knownIssues = append(knownIssues, KnownIssue{
+				Index:          i,
+				Issue:          exp.knownIssue,
+				Source:         exp.sourceName(),
+				Target:         exp.To.TargetName,
+				Expected:       exp.Expected,
+				ExpectedPretty: expConnectivity[i],
+				ActualPretty:   actualConnPretty[i],
+			})
+			actualConnPretty[i] += " <---- KNOWN ISSUE"
+			continue
+		}
+		if !matches && (act == nil || act.HarnessErr == nil) && quarantinedBy(exp) != nil {
+			describe(i)
+			quarantined = append(quarantined, MismatchDetail{
+				Index:          i,
+				Source:         exp.sourceName(),
+				Target:         exp.To.TargetName,
+				Expected:       exp.Expected,
+				Actual:         act,
+				ExpectedPretty: expConnectivity[i],
+				ActualPretty:   actualConnPretty[i],
+			})
+			actualConnPretty[i] += " <---- QUARANTINED"
+			continue
+		}
+		if !matches || exp.knownIssue != "" {
+			describe(i)
+			failed = true
+			failedExps = append(failedExps, exp)
+			mismatches = append(mismatches, MismatchDetail{
+				Index:          i,
+				Source:         exp.sourceName(),
+				Target:         exp.To.TargetName,
+				Expected:       exp.Expected,
+				Actual:         act,
+				ExpectedPretty: expConnectivity[i],
+				ActualPretty:   actualConnPretty[i],
+			})
+			if matches {
+				mismatches[len(mismatches)-1].KnownIssue = exp.knownIssue
+				actualConnPretty[i] += " <---- UNEXPECTEDLY PASSED"
+			} else {
+				actualConnPretty[i] += " <---- WRONG"
+			}
+			expConnectivity[i] += " <---- EXPECTED"
+		}
+	}
+
+	var ctMismatches []ConntrackMismatch
+	if !failed {
+		ctMismatches = c.conntrackMismatches()
+		if len(ctMismatches) > 0 {
+			failed = true
+		}
+	}
+
+	var denialMismatches []DenialMismatch
+	if !failed && len(deniedHosts) > 0 {
+		denialMismatches = c.denialMismatches(expectations, deniedBefore, c.snapshotDeniedPackets(deniedHosts))
+		if len(denialMismatches) > 0 {
+			failed = true
+		}
+	}
+
+	var encapMismatches []EncapMismatch
+	if !failed && len(encapCaptures) > 0 {
+		encapMismatches = c.encapMismatches(expectations, encapOutput)
+		if len(encapMismatches) > 0 {
+			failed = true
+		}
+	}
+
+	var finalErr error
+	if !failed && c.finalTest != nil {
+		finalErr = c.finalTest()
+		if finalErr != nil {
+			failed = true
+		}
+	}
+	harnessErrs := harnessErrors(actualConn)
+
+	attempt := Attempt{
+		Number:        number,
+		Start:         checkStartTime,
+		Duration:      time.Since(checkStartTime),
+		Results:       actualConn,
+		Pretty:        actualConnPretty,
+		Mismatches:    mismatches,
+		HarnessErrors: harnessErrs,
+		FinalTestErr:  finalErr,
+		Conntrack:     ctMismatches,
+		Denials:       denialMismatches,
+		Encap:         encapMismatches,
+		KnownIssues:   knownIssues,
+		Quarantined:   quarantined,
+		Passed:        !failed,
+		expectations:  expectations,
+	}
+	c.lastAttempt = &attempt
+	c.logAttemptSummary(&attempt)
+	c.reportAttempt(&attempt)
+	attemptSpan.SetAttributes(
+		attribute.Bool("passed", attempt.Passed),
+		attribute.Int("mismatches", len(mismatches)),
+		attribute.Int("harnessErrors", len(harnessErrs)),
+	)
+	attemptSpan.End()
+	return attempt, expConnectivity, failedExps
+}
+
+// attemptMessage explains why the attempt failed.  expConnectivity is the
+// pretty form of the expected connectivity.
+func (c *Checker) attemptMessage(a *Attempt, expConnectivity []string, harnessFailedAttempts int) string {
 	message := fmt.Sprintf(
 		"Connectivity was incorrect:\n\nExpected\n    %s\nto match\n    %s",
-		strings.Join(actualConnPretty, "\n    "),
-		strings.Join(expConnectivity, "\n    "),
+		strings.Join(c.prettyTable(a.Pretty, a, c.colored()), "\n    "),
+		strings.Join(c.prettyTable(expConnectivity, a, c.colored()), "\n    "),
 	)
 
-	if finalErr != nil {
-		message += "\n Final test failed: " + finalErr.Error() + "\n"
+	if len(a.HarnessErrors) > 0 {
+		errStrs := make([]string, len(a.HarnessErrors))
+		for i, e := range a.HarnessErrors {
+			errStrs[i] = e.Error()
+		}
+		message = fmt.Sprintf(
+			"Connectivity check harness failed %d times, this is not a connectivity failure:\n    %s\n\n",
+			harnessFailedAttempts, strings.Join(errStrs, "\n    "),
+		) + message
 	}
 
-	if c.description != "" {
-		message += "\nDescription:\n" + c.description
+	var fixedIssues []string
+	for _, m := range a.Mismatches {
+		if m.KnownIssue != "" {
+			fixedIssues = append(fixedIssues, fmt.Sprintf("%s: %s -> %s", m.KnownIssue, m.Source, m.Target))
+		}
+	}
+	if len(fixedIssues) > 0 {
+		message += "\n\nExpectations with known issues passed, remove ExpectWithKnownIssue() from them:\n    " +
+			strings.Join(fixedIssues, "\n    ") + "\n"
 	}
 
-	log.Warn("Connectivity check failed: " + message)
-	message += fmt.Sprintf("\n\n Test took %s and %d tries.\n", time.Since(start), completedAttempts)
+	if len(a.Conntrack) > 0 {
+		var ctStrs []string
+		for _, m := range a.Conntrack {
+			ctStrs = append(ctStrs, m.String())
+		}
+		message += "\n\nConntrack was incorrect:\n    " + strings.Join(ctStrs, "\n    ") + "\n"
+	}
 
-	if c.OnFail != nil {
-		c.OnFail(message)
-	} else {
-		ginkgo.Fail(message, callerSkip)
+	if len(a.Denials) > 0 {
+		var denialStrs []string
+		for _, m := range a.Denials {
+			denialStrs = append(denialStrs, m.String())
+		}
+		message += "\n\nTraffic was not denied by policy:\n    " + strings.Join(denialStrs, "\n    ") + "\n"
 	}
+
+	if len(a.Encap) > 0 {
+		var encapStrs []string
+		for _, m := range a.Encap {
+			encapStrs = append(encapStrs, m.String())
+		}
+		message += "\n\nEncapsulation was incorrect:\n    " + strings.Join(encapStrs, "\n    ") + "\n"
+	}
+
+	if a.FinalTestErr != nil {
+		message += "\n Final test failed: " + a.FinalTestErr.Error() + "\n"
+	}
+
+	if c.description != "" {
+		message += "\nDescription:\n" + c.description
+	}
+	return message
+}
+
+// attemptError returns the CheckError of the failed attempt.
+func attemptError(a *Attempt, message string) *CheckError {
+	checkErr := &CheckError{
+		Kind:          ErrorKindMismatch,
+		Message:       message,
+		Mismatches:    a.Mismatches,
+		HarnessErrors: a.HarnessErrors,
+		FinalTestErr:  a.FinalTestErr,
+		Conntrack:     a.Conntrack,
+		Denials:       a.Denials,
+		Encap:         a.Encap,
+		Attempts:      1,
+		Duration:      a.Duration,
+	}
+	if len(a.HarnessErrors) > 0 {
+		checkErr.Kind = ErrorKindHarness
+	} else if len(a.Mismatches) == 0 && len(a.Conntrack) > 0 {
+		checkErr.Kind = ErrorKindConntrack
+	} else if len(a.Mismatches) == 0 && len(a.Denials) > 0 {
+		checkErr.Kind = ErrorKindDenial
+	} else if len(a.Mismatches) == 0 && len(a.Encap) > 0 {
+		checkErr.Kind = ErrorKindEncap
+	} else if len(a.Mismatches) == 0 && a.FinalTestErr != nil {
+		checkErr.Kind = ErrorKindFinalTest
+	}
+	return checkErr
 }
 
 func NewRequest(payload string) Request {
 	return Request{
+		Version:   ProtocolVersion,
 		Timestamp: time.Now(),
 		ID:        uuid.NewString(),
 		Payload:   payload,
@@ -418,11 +1731,19 @@ func NewRequest(payload string) Request {
 }
 
 type Request struct {
+	// Version is the ProtocolVersion of the sender, zero for senders that
+	// predate versioning.
+	Version      int `json:",omitempty"`
 	Timestamp    time.Time
 	ID           string
 	Payload      string
 	SendSize     int
 	ResponseSize int
+	// Padding makes the request up to the packet size of a packet loss test.
+	Padding string `json:",omitempty"`
+	// LargeSend asks the server to report how it received the SendSize bytes,
+	// see WithLargeSend().
+	LargeSend bool `json:",omitempty"`
 }
 
 func (req Request) Equal(oth Request) bool {
@@ -430,11 +1751,32 @@ func (req Request) Equal(oth Request) bool {
 }
 
 type Response struct {
+	// Version is the ProtocolVersion of the sender, zero for senders that
+	// predate versioning.
+	Version   int `json:",omitempty"`
 	Timestamp time.Time
 
 	SourceAddr string
 	ServerAddr string
 
+	// ReceivedOn is the interface that the server received the request on and
+	// Namespace the path of the server's network namespace, empty for servers
+	// that can't tell.
+	ReceivedOn string `json:",omitempty"`
+	Namespace  string `json:",omitempty"`
+	// Protocol completes the 5-tuple of SourceAddr and ServerAddr, see
+	// FiveTuple().
+	Protocol string `json:",omitempty"`
+	// Proxy is the PROXY protocol header that the server received, if any.
+	Proxy *ProxyHeader `json:",omitempty"`
+	// LargeSend is how the server received the data of a large send, see
+	// WithLargeSend().
+	LargeSend *LargeSendStats `json:",omitempty"`
+	// RequestsReceived is how many requests the server has received on the
+	// client's connection, including this one, zero for servers that don't
+	// count them.
+	RequestsReceived int `json:",omitempty"`
+
 	Request  Request
 	ErrorStr string
 }
@@ -443,6 +1785,16 @@ func (r *Response) SourceIP() string {
 	return strings.Split(r.SourceAddr, ":")[0]
 }
 
+// ServerIP returns the IP that the server received the request on, after any
+// DNAT.
+func (r *Response) ServerIP() string {
+	host, _, err := net.SplitHostPort(r.ServerAddr)
+	if err != nil {
+		return r.ServerAddr
+	}
+	return host
+}
+
 type ConnectionTarget interface {
 	ToMatcher(explicitPort ...uint16) *Matcher
 }
@@ -468,12 +1820,44 @@ func (s TargetIPv4AsIPv6) ToMatcher(explicitPort ...uint16) *Matcher {
 	return TargetIP("::ffff:" + s).ToMatcher(explicitPort...)
 }
 
-func HaveConnectivityTo(target ConnectionTarget, explicitPort ...uint16) types.GomegaMatcher {
+// TargetHostname is a ConnectionTarget that test-connection resolves from the
+// network namespace of the source, see ExpectWithResolver().  The IP it was
+// resolved to is recorded in Result.ResolvedIP.
+type TargetHostname string
+
+func (s TargetHostname) ToMatcher(explicitPort ...uint16) *Matcher {
+	if len(explicitPort) != 1 {
+		panic("Explicit port needed with a hostname as a connectivity target")
+	}
+	port := fmt.Sprintf("%d", explicitPort[0])
+	return &Matcher{
+		IP:         string(s),
+		Port:       port,
+		TargetName: string(s) + ":" + port,
+		Protocol:   "tcp",
+	}
+}
+
+// HaveConnectivityTo matches a ConnectionSource that can connect to the target
+// over TCP.  Use WithProtocol() and WithOptions() on the matcher to check other
+// protocols or with ExpectationOptions, like the Checker does:
+//
+//	Expect(w[0]).To(HaveConnectivityTo(w[1]).WithProtocol("udp").WithOptions(ExpectWithSrcIPs(ip)))
+func HaveConnectivityTo(target ConnectionTarget, explicitPort ...uint16) *Matcher {
 	return target.ToMatcher(explicitPort...)
 }
 
 type Matcher struct {
 	IP, Port, TargetName, Protocol string
+
+	// Host is the container of the host that the target runs on, if known.  It
+	// is used to collect diagnostics.
+	Host string
+
+	// opts and lastResult are only used when the Matcher is used as a gomega
+	// matcher, see HaveConnectivityTo().
+	opts       []ExpectationOption
+	lastResult *Result
 }
 
 type ConnectionSource interface {
@@ -483,21 +1867,67 @@ type ConnectionSource interface {
 	SourceIPs() []string
 }
 
+// WithProtocol returns a copy of the matcher that checks the given protocol.
+func (m *Matcher) WithProtocol(protocol string) *Matcher {
+	c := *m
+	c.Protocol = protocol
+	return &c
+}
+
+// WithOptions returns a copy of the matcher that checks with the given
+// ExpectationOptions, on top of any that it already had.
+func (m *Matcher) WithOptions(opts ...ExpectationOption) *Matcher {
+	c := *m
+	c.opts = append(append([]ExpectationOption(nil), m.opts...), opts...)
+	return &c
+}
+
+// expectation returns the expectation of connectivity from the source that the
+// matcher checks.
+func (m *Matcher) expectation(src ConnectionSource) Expectation {
+	exp := Expectation{
+		From:      src,
+		To:        m,
+		Expected:  Some,
+		ExpSrcIPs: src.SourceIPs(),
+	}
+	for _, o := range m.opts {
+		o(&exp)
+	}
+	exp.checkOptionsCombined(m.Protocol)
+	return exp
+}
+
 func (m *Matcher) Match(actual interface{}) (success bool, err error) {
-	actual.(ConnectionSource).PreRetryCleanup(m.IP, m.Port, m.Protocol)
-	success = actual.(ConnectionSource).CanConnectTo(m.IP, m.Port, m.Protocol) != nil
+	src := actual.(ConnectionSource)
+	exp := m.expectation(src)
+	checker := &Checker{Protocol: m.Protocol}
+	opts := checker.checkOptions(exp)
+	src.PreRetryCleanup(m.IP, m.Port, m.Protocol, opts...)
+	m.lastResult = src.CanConnectTo(m.IP, m.Port, m.Protocol, opts...)
+	if m.lastResult != nil && m.lastResult.HarnessErr != nil {
+		return false, m.lastResult.HarnessErr
+	}
+	checkSNAT := !equalStrings(exp.ExpSrcIPs, src.SourceIPs())
+	success = exp.Matches(m.lastResult, checkSNAT)
 	return
 }
 
 func (m *Matcher) FailureMessage(actual interface{}) (message string) {
 	src := actual.(ConnectionSource)
-	message = fmt.Sprintf("Expected %v\n\t%+v\nto have connectivity to %v\n\t%v:%v\nbut it does not", src.SourceName(), src, m.TargetName, m.IP, m.Port)
+	message = fmt.Sprintf("Expected %v\n\t%+v\nto have connectivity to %v\n\t%v:%v (%s)\nbut it does not",
+		src.SourceName(), src, m.TargetName, m.IP, m.Port, m.Protocol)
+	if m.lastResult.HasConnectivity() {
+		message += fmt.Sprintf(" with the given options, the response came from %s",
+			m.lastResult.LastResponse.SourceAddr)
+	}
 	return
 }
 
 func (m *Matcher) NegatedFailureMessage(actual interface{}) (message string) {
 	src := actual.(ConnectionSource)
-	message = fmt.Sprintf("Expected %v\n\t%+v\nnot to have connectivity to %v\n\t%v:%v\nbut it does", src.SourceName(), src, m.TargetName, m.IP, m.Port)
+	message = fmt.Sprintf("Expected %v\n\t%+v\nnot to have connectivity to %v\n\t%v:%v (%s)\nbut it does",
+		src.SourceName(), src, m.TargetName, m.IP, m.Port, m.Protocol)
 	return
 }
 
@@ -509,6 +1939,56 @@ func ExpectWithSrcIPs(ips ...string) ExpectationOption {
 	}
 }
 
+// ExpectWithSourceInterface binds the probes to the given interface of the
+// source's namespace, see WithSourceInterface().  Use it to test VLAN or multi-NIC
+// setups where routing alone doesn't pick the interface under test.
+func ExpectWithSourceInterface(iface string) ExpectationOption {
+	return func(e *Expectation) {
+		e.sourceInterface = iface
+	}
+}
+
+// ExpectWithSourceMAC makes the ARP or NDP probes of the expectation claim the
+// given source MAC, typically one that isn't the workload's, see ProtocolARP.
+func ExpectWithSourceMAC(mac string) ExpectationOption {
+	return func(e *Expectation) {
+		e.sourceMAC = mac
+	}
+}
+
+// ExpectWithVRF binds the probes to the given VRF device, see WithVRF().
+func ExpectWithVRF(vrf string) ExpectationOption {
+	return func(e *Expectation) {
+		e.vrf = vrf
+	}
+}
+
+// ExpectWithSocketMark sets the fwmark of the probes, see WithSocketMark().
+func ExpectWithSocketMark(mark uint32) ExpectationOption {
+	return func(e *Expectation) {
+		e.mark = mark
+	}
+}
+
+// ExpectWithServerIPs asserts that the connection was answered by a server
+// listening on one of the IPs, for example, to check which backend a service
+// picked.
+func ExpectWithServerIPs(ips ...string) ExpectationOption {
+	return func(e *Expectation) {
+		e.expServerIPs = ips
+	}
+}
+
+// ExpectReceivedOn asserts that the server received the connection on the given
+// interface, for example, to check that the traffic came through the tunnel
+// device rather than the host interface.  Only test-workload servers report the
+// interface.
+func ExpectReceivedOn(iface string) ExpectationOption {
+	return func(e *Expectation) {
+		e.receivedOn = iface
+	}
+}
+
 func ExpectWithSrcPort(port uint16) ExpectationOption {
 	return func(e *Expectation) {
 		e.srcPort = port
@@ -546,6 +2026,31 @@ func ExpectWithClientAdjustedMTU(from, to int) ExpectationOption {
 	}
 }
 
+// ExpectWithTimeout sets how long the expectation is retried for, overriding the
+// timeout of the connectivity check.
+func ExpectWithTimeout(timeout time.Duration) ExpectationOption {
+	Expect(timeout).To(BeNumerically(">", 100*time.Millisecond),
+		"Very low timeout, did you mean to multiply by time.<Unit>?")
+
+	return func(e *Expectation) {
+		e.timeout = timeout
+	}
+}
+
+// ExpectWithRetries allows the expectation to be retried up to n times, waiting
+// interval before each retry, even if the Checker has RetriesDisabled.  Zero
+// retries disables retries for the expectation.  Unless ExpectWithTimeout is also
+// given, the timeout of the connectivity check does not apply.
+func ExpectWithRetries(n int, interval time.Duration) ExpectationOption {
+	Expect(n).To(BeNumerically(">=", 0), "Number of retries must not be negative")
+
+	return func(e *Expectation) {
+		e.retriesSet = true
+		e.maxRetries = n
+		e.retryInterval = interval
+	}
+}
+
 // ExpectWithLoss asserts that the connection has a certain loss rate
 func ExpectWithLoss(duration time.Duration, maxPacketLossPercent float64, maxPacketLossNumber int) ExpectationOption {
 	Expect(duration.Seconds()).NotTo(BeZero(),
@@ -556,47 +2061,535 @@ func ExpectWithLoss(duration time.Duration, maxPacketLossPercent float64, maxPac
 		"Either loss count or percent must be specified")
 
 	return func(e *Expectation) {
-		e.ExpectedPacketLoss = ExpPacketLoss{
-			Duration:   duration,
-			MaxPercent: maxPacketLossPercent,
-			MaxNumber:  maxPacketLossNumber,
+		e.ExpectedPacketLoss.Duration = duration
+		e.ExpectedPacketLoss.MaxPercent = maxPacketLossPercent
+		e.ExpectedPacketLoss.MaxNumber = maxPacketLossNumber
+	}
+}
+
+// ExpectWithMaxBucketLoss asserts that no LossBucketSize bucket of a packet loss
+// test (see ExpectWithLoss) lost more than maxPercent of its packets.  This
+// catches a short outage that the overall loss would average out.
+func ExpectWithMaxBucketLoss(maxPercent float64) ExpectationOption {
+	Expect(maxPercent).To(BeNumerically(">", 0), "Bucket loss percentage should be >0")
+	Expect(maxPercent).To(BeNumerically("<=", 100), "Bucket loss percentage should be <=100")
+
+	return func(e *Expectation) {
+		e.ExpectedPacketLoss.MaxBucketPercent = maxPercent
+	}
+}
+
+// ExpectWithMinPacketsSent asserts that a packet loss test (see ExpectWithLoss)
+// sent at least n packets, so that a test that barely ran, for example because
+// the probe rate was throttled, can't pass with little or no loss.  A test that
+// sent fewer is reported as a harness error.
+func ExpectWithMinPacketsSent(n int) ExpectationOption {
+	Expect(n).To(BeNumerically(">", 0), "Minimum packets sent should be >0")
+
+	return func(e *Expectation) {
+		e.ExpectedPacketLoss.MinPacketsSent = n
+	}
+}
+
+// tooFewPacketsSent returns an error if the result is of a packet loss test that
+// sent fewer packets than ExpectWithMinPacketsSent() asks for.
+func (e Expectation) tooFewPacketsSent(res *Result) error {
+	loss := e.ExpectedPacketLoss
+	if loss.Duration == 0 || loss.MinPacketsSent == 0 || res == nil || res.Stats.RequestsSent >= loss.MinPacketsSent {
+		return nil
+	}
+	return fmt.Errorf("packet loss test sent only %d packets in %v, fewer than the minimum of %d",
+		res.Stats.RequestsSent, loss.Duration, loss.MinPacketsSent)
+}
+
+// ExpectWithParallelFlows opens n simultaneous connections for the expectation
+// and asserts that all of them succeed.  Each flow uses its own ephemeral
+// source port so it cannot be combined with ExpectWithSrcPort.
+func ExpectWithParallelFlows(n int) ExpectationOption {
+	Expect(n).To(BeNumerically(">", 0), "Number of parallel flows must be positive")
+
+	return func(e *Expectation) {
+		e.parallelFlows = n
+	}
+}
+
+// defaultConnRateDuration is how long a connection rate test runs for if only
+// ExpectWithMinConnectionRate is given.
+const defaultConnRateDuration = 5 * time.Second
+
+// ExpectWithConnectionRate opens new connections at the given rate (connections
+// per second) for the duration instead of doing a single check.  Combine it with
+// ExpectWithMinConnectionRate to assert on the achieved rate.
+func ExpectWithConnectionRate(duration time.Duration, cps int) ExpectationOption {
+	Expect(duration.Seconds()).To(BeNumerically(">=", 1),
+		"Connection rate test must run for at least a second")
+	Expect(cps).To(BeNumerically(">", 0), "Connection rate must be positive")
+
+	return func(e *Expectation) {
+		e.ExpectedConnRate.Duration = duration
+		e.ExpectedConnRate.AttemptRate = cps
+	}
+}
+
+// connRateHeadroom is how much faster than the minimum rate connections are
+// attempted by default, so that a few slow or failed connections don't fail the
+// check.
+const connRateHeadroom = 2
+
+// ExpectWithMinConnectionRate asserts that at least cps connections per second
+// were established successfully.  If ExpectWithConnectionRate was not given,
+// connections are attempted at twice that rate for 5s.
+func ExpectWithMinConnectionRate(cps float64) ExpectationOption {
+	Expect(cps).To(BeNumerically(">", 0), "Connection rate must be positive")
+
+	return func(e *Expectation) {
+		e.ExpectedConnRate.MinRate = cps
+		if e.ExpectedConnRate.Duration == 0 {
+			e.ExpectedConnRate.Duration = defaultConnRateDuration
+			e.ExpectedConnRate.AttemptRate = int(math.Ceil(cps * connRateHeadroom))
 		}
 	}
 }
 
+// ExpectWithSurvival asserts that a single long-lived connection keeps
+// exchanging messages for the duration without being reset or stalling.
+func ExpectWithSurvival(duration time.Duration) ExpectationOption {
+	Expect(duration.Seconds()).To(BeNumerically(">=", 1),
+		"Long-lived connection test must run for at least a second")
+
+	return func(e *Expectation) {
+		e.longLivedDuration = duration
+	}
+}
+
+// cutTestMargin is how much longer than the window a connection cut test keeps
+// its connection open, to give the disruption time to run.
+const cutTestMargin = 10 * time.Second
+
+// ExpectWithCutWithin asserts that a single long-lived connection is
+// established and then reset within the window after the disruption.
+func ExpectWithCutWithin(window time.Duration) ExpectationOption {
+	Expect(window).To(BeNumerically(">", 0), "Connection cut window must be positive")
+
+	return func(e *Expectation) {
+		e.cutWindow = window
+		e.longLivedDuration = window + cutTestMargin
+	}
+}
+
+// ExpectMaxReordering asserts that at most n responses of a packet loss test
+// (see ExpectWithLoss) arrived out of order.
+func ExpectMaxReordering(n int) ExpectationOption {
+	Expect(n).To(BeNumerically(">=", 0), "Max reordering must not be negative")
+
+	return func(e *Expectation) {
+		e.checkReordering = true
+		e.maxReordered = n
+	}
+}
+
+// ExpectNoDuplicates asserts that no response of a packet loss test (see
+// ExpectWithLoss) was received more than once.
+func ExpectNoDuplicates() ExpectationOption {
+	return func(e *Expectation) {
+		e.noDuplicates = true
+	}
+}
+
+// checkOptionsCombined fails the test if the options of the expectation only
+// make sense with an option that it lacks, rather than quietly ignoring them, or
+// if it has options that can't be combined.  test-connection would reject the
+// combinations that it can't do, see its main(), but only when the check runs.
+// protocol is the protocol of the check, "" for tcp.
+func (e *Expectation) checkOptionsCombined(protocol string) {
+	if e.checkReordering || e.noDuplicates {
+		Expect(e.ExpectedPacketLoss.Duration).To(BeNumerically(">", 0),
+			"ExpectMaxReordering() and ExpectNoDuplicates() need ExpectWithLoss()")
+	}
+	oneOff := e.ExpectedPacketLoss.Duration == 0 && e.ExpectedConnRate.Duration == 0 && e.longLivedDuration == 0
+	if e.parallelFlows > 1 {
+		Expect(oneOff).To(BeTrue(),
+			"ExpectWithParallelFlows() can't be combined with ExpectWithLoss(), ExpectWithConnectionRate() or "+
+				"ExpectWithSurvival(), it is only supported for one off checks")
+		Expect(e.srcPort).To(BeZero(),
+			"ExpectWithParallelFlows() can't be combined with ExpectWithSrcPort(), each flow needs its own "+
+				"ephemeral source port")
+	}
+	if e.ExpectedConnRate.Duration > 0 {
+		Expect(e.longLivedDuration).To(BeZero(),
+			"ExpectWithConnectionRate() can't be combined with ExpectWithSurvival()")
+		Expect(e.srcPort).To(BeZero(),
+			"ExpectWithConnectionRate() can't be combined with ExpectWithSrcPort(), each connection needs its "+
+				"own ephemeral source port")
+	}
+	if e.checksOneWayLatency() {
+		Expect(e.ExpectedPacketLoss.Duration > 0 || e.longLivedDuration > 0).To(BeTrue(),
+			"ExpectMaxOneWayLatency() needs ExpectWithLoss() or ExpectWithSurvival()")
+		Expect(e.ExpectedConnRate.Duration).To(BeZero(),
+			"ExpectMaxOneWayLatency() can't be combined with ExpectWithConnectionRate()")
+	}
+	if e.idlePeriod > 0 {
+		Expect(oneOff && e.parallelFlows <= 1).To(BeTrue(),
+			"ExpectWithIdlePeriod() is only supported for one off checks of a single flow")
+	}
+	if e.sourceInterface != "" {
+		Expect(e.vrf).To(BeEmpty(),
+			"ExpectWithSourceInterface() can't be combined with ExpectWithVRF(), the interface implies its VRF")
+	}
+	if e.largeSend > 0 {
+		Expect(e.payloadPattern == "" && !e.fuzzPayload).To(BeTrue(),
+			"ExpectWithLargeSend() can't be combined with ExpectWithPayloadPattern() or ExpectWithFuzzedPayload()")
+	}
+
+	if protocol == "" {
+		protocol = "tcp"
+	}
+	for _, o := range []struct {
+		name string
+		set  bool
+	}{
+		{"ExpectWithProxyProtocol()", e.proxyProtocol},
+		{"ExpectViaProxy()", e.viaProxy != ""},
+		{"ExpectWithTCPFastOpen()", e.tcpFastOpen},
+		{"ExpectWithFTP()", e.ftpMode != ""},
+		{"ExpectWithLargeSend()", e.largeSend > 0},
+	} {
+		if o.set {
+			Expect(protocol).To(Equal("tcp"), "%s is only supported for tcp", o.name)
+		}
+	}
+	if len(e.sctpLocalAddrs) > 0 || len(e.sctpRemoteAddrs) > 0 {
+		Expect(protocol).To(Equal("sctp"), "ExpectWithSCTPMultihoming() is only supported for sctp")
+	}
+	for _, h := range e.ipv6ExtHeaders {
+		if h == IPv6Fragment {
+			Expect(protocol).To(HavePrefix("udp"), "ExpectWithIPv6ExtHeaders(IPv6Fragment) is only supported for udp")
+		}
+	}
+}
+
+// ExpectWithIdlePeriod makes the check stay silent for the idle period after its
+// first exchange and then resume traffic on the same connection, asserting that
+// the connection survived being idle.  This catches conntrack timeouts that are
+// too aggressive.
+func ExpectWithIdlePeriod(idle time.Duration) ExpectationOption {
+	Expect(idle).To(BeNumerically(">", 0), "Idle period must be positive")
+
+	return func(e *Expectation) {
+		e.idlePeriod = idle
+	}
+}
+
+// ExpectMSSClampedTo asserts that the negotiated MSS of a TCP connection is at
+// most mss.
+func ExpectMSSClampedTo(mss int) ExpectationOption {
+	Expect(mss).To(BeNumerically(">", 0), "MSS must be positive")
+
+	return func(e *Expectation) {
+		e.maxMSS = mss
+	}
+}
+
+// ExpectMaxRetransmits asserts that a TCP connection retransmitted at most n
+// segments.
+func ExpectMaxRetransmits(n int) ExpectationOption {
+	Expect(n).To(BeNumerically(">=", 0), "Max retransmits must not be negative")
+
+	return func(e *Expectation) {
+		e.checkRetransmits = true
+		e.maxRetransmits = n
+	}
+}
+
+// ExpectWithPacketRate sets the number of packets per second sent by a packet
+// loss test (see ExpectWithLoss).
+func ExpectWithPacketRate(pps int) ExpectationOption {
+	Expect(pps).To(BeNumerically(">", 0), "Packet rate must be positive")
+
+	return func(e *Expectation) {
+		e.packetRate = pps
+	}
+}
+
+// MaxPacketSize is the largest packet size of a packet loss test, it keeps the
+// padded packets within the buffers of test-workload and the responses that
+// echo them within those of test-connection.
+const MaxPacketSize = 8000
+
+// ExpectWithPacketSize sets the size of the packets sent by a packet loss test
+// (see ExpectWithLoss), up to MaxPacketSize.
+func ExpectWithPacketSize(bytes int) ExpectationOption {
+	Expect(bytes).To(BeNumerically(">", 0), "Packet size must be positive")
+	Expect(bytes).To(BeNumerically("<=", MaxPacketSize), "Packet size must be at most MaxPacketSize")
+
+	return func(e *Expectation) {
+		e.packetSize = bytes
+	}
+}
+
+// ExpectWithResolver sets the DNS server, as IP or IP:port, that resolves a
+// TargetHostname.  By default, the first nameserver in the resolv.conf of the
+// source's container is used.
+func ExpectWithResolver(server string) ExpectationOption {
+	return func(e *Expectation) {
+		e.resolver = server
+	}
+}
+
+// ExpectFromEachSourceIP expands the expectation into one check per IP in the
+// source's SourceIPs(), each bound to that IP, to verify that policy treats all
+// the addresses of a multi-homed workload alike.  Unless overridden, each check
+// expects its own IP as the source IP seen by the target.
+func ExpectFromEachSourceIP() ExpectationOption {
+	return func(e *Expectation) {
+		e.eachSourceIP = true
+	}
+}
+
 func ExpectWithPorts(ports ...uint16) ExpectationOption {
 	return func(e *Expectation) {
 		e.explicitPorts = ports
 	}
 }
 
+// ExpectWithTags tags the expectation, so that it can be removed along with the
+// others with the same tag, see RemoveExpectationsWithTag().
+func ExpectWithTags(tags ...string) ExpectationOption {
+	return func(e *Expectation) {
+		e.tags = append(e.tags, tags...)
+	}
+}
+
 type Expectation struct {
 	From               ConnectionSource // Workload or Container
 	To                 *Matcher         // Workload or IP, + port
 	Expected           Expected
 	ExpSrcIPs          []string
 	ExpectedPacketLoss ExpPacketLoss
+	ExpectedConnRate   ExpConnRate
 
 	explicitPorts []uint16
 
+	reversed bool // the source and target were swapped, see ExpectReversed().
+
 	sendLen int
 	recvLen int
 
-	clientMTUStart int
-	clientMTUEnd   int
+	clientMTUStart     int
+	clientMTUEnd       int
+	clientMTUMin       int // range of the MTU at the end, see ExpectClientMTUBetween().
+	clientMTUMax       int
+	clientMTUDecreased bool // expect the MTU to have decreased during the transfer.
 
 	srcPort uint16
 
+	expServerIPs []string
+	receivedOn   string
+
+	fiveTuple        *FiveTuple
+	srcPortPreserved bool
+
+	proxyProtocol  bool   // send a PROXY protocol header.
+	proxySource    string // source address to claim in the header.
+	expProxySource string // source address that the server should see in a header.
+
+	viaProxy string // URL of the proxy to connect through.
+
+	snatPortMin, snatPortMax uint16 // range of the server-seen source ports, 0 max for any.
+
+	icmpReject     bool   // expect the probe to be rejected with an ICMP error.
+	icmpRejectFrom string // IP that the ICMP error should come from, "" for any.
+
+	noForwardingLoop bool // fail if the probe's TTL ran out.
+
+	tcpFastOpen bool // send the first request in the SYN.
+	tfoAccepted bool // expect the server to accept the data in the SYN.
+
+	ipv6ExtHeaders []IPv6ExtHeader // extension headers to add to the probes.
+
+	payloadPattern string // extra data to send after the request.
+	fuzzPayload    bool   // send a random payload instead.
+	fuzzSeed       int64  // seed of the random payload, 0 for a random seed.
+
+	ftpMode FTPMode // fetch the response over the data connection of an FTP session.
+
+	sctpLocalAddrs  []string // local addresses of a multi-homed SCTP association.
+	sctpRemoteAddrs []string // extra remote addresses of a multi-homed SCTP association.
+	sctpFailover    bool     // expect the association to fail over from its primary path.
+
+	largeSend          int // bytes to send in a single write after the request.
+	maxReceivedSegment int // biggest segment of the large send that the server may see.
+
+	eachSourceIP bool
+	srcIP        string // source IP to bind to, one of From.SourceIPs().
+
+	sourceInterface string
+	vrf             string
+	mark            uint32
+	sourceMAC       string
+
+	tracePath  bool
+	pathVia    []string
+	pathNotVia []string
+
+	checkEncap bool
+	encap      Encap // "" for not encapsulated.
+
+	parallelFlows int
+
+	packetRate int
+	packetSize int
+
+	idlePeriod time.Duration
+
+	checkReordering bool
+	maxReordered    int
+	noDuplicates    bool
+
+	// Loss in each direction, see ExpectMaxRequestLoss() and
+	// ExpectMaxResponseLoss().
+	checkRequestLoss  bool
+	maxRequestLoss    float64
+	checkResponseLoss bool
+	maxResponseLoss   float64
+
+	minBytesSent     int64
+	minBytesReceived int64
+
+	resultMatchers []func(*Result) error // see ExpectWithResultMatcher().
+
+	// Bounds of the one-way latency, see ExpectMaxOneWayLatency().
+	maxForwardLatency time.Duration
+	maxReverseLatency time.Duration
+
+	maxMSS           int
+	checkRetransmits bool
+	maxRetransmits   int
+
+	longLivedDuration time.Duration
+	cutWindow         time.Duration
+
+	// Retry policy, overriding the Checker's timeout and RetriesDisabled.
+	timeout       time.Duration
+	retriesSet    bool
+	maxRetries    int
+	retryInterval time.Duration
+
+	flowLogReporters []string
+
+	resolver string
+
+	knownIssue string // see ExpectWithKnownIssue().
+
+	tags []string
+
+	// target is the ConnectionTarget that To was created from.
+	target ConnectionTarget
+
 	ErrorStr string
 }
 
+// HasTag returns whether the expectation was tagged with the tag, see
+// ExpectWithTags().
+func (e Expectation) HasTag(tag string) bool {
+	return containsString(e.tags, tag)
+}
+
+// sourceName returns the name of the source of the expectation, including the
+// source IP and interface it binds to, if any.
+func (e Expectation) sourceName() string {
+	name := e.From.SourceName()
+	if e.srcIP != "" {
+		name += " [" + e.srcIP + "]"
+	}
+	if e.sourceInterface != "" {
+		name += " (" + e.sourceInterface + ")"
+	}
+	if e.vrf != "" {
+		name += " (vrf " + e.vrf + ")"
+	}
+	return name
+}
+
+// sourceIPs returns the IPs that the traffic of the expectation may come from.
+func (e Expectation) sourceIPs() []string {
+	if e.srcIP == "" {
+		return e.From.SourceIPs()
+	}
+	return []string{e.srcIP}
+}
+
+// canRetry returns whether the retry policy of the expectation allows another
+// attempt after it failed.  Unless overridden by ExpectWithTimeout() or
+// ExpectWithRetries(), the Checker's timeout and RetriesDisabled apply.
+func (e Expectation) canRetry(attempts int, start, checkStartTime time.Time,
+	checkerTimeout time.Duration, retriesDisabled bool) bool {
+	if e.retriesSet {
+		if attempts > e.maxRetries {
+			return false
+		}
+		if e.timeout == 0 {
+			return true
+		}
+	} else if retriesDisabled {
+		return false
+	}
+
+	timeout := checkerTimeout
+	if e.timeout > 0 {
+		timeout = e.timeout
+	}
+	// Since one check should take ~2s we also check that we started the
+	// iteration close to the end of the timeout.  Better to be a little
+	// permissive than flaky!
+	return !(time.Since(start) > timeout &&
+		checkStartTime.Sub(start) > timeout-2*time.Second &&
+		attempts >= 2)
+}
+
+// canRetryAll returns whether the retry policies of all the expectations allow
+// another attempt, see canRetry().
+func canRetryAll(exps []Expectation, attempts int, start, checkStartTime time.Time,
+	checkerTimeout time.Duration, retriesDisabled bool) bool {
+	for _, exp := range exps {
+		if !exp.canRetry(attempts, start, checkStartTime, checkerTimeout, retriesDisabled) {
+			return false
+		}
+	}
+	return true
+}
+
 type ExpPacketLoss struct {
 	Duration   time.Duration // how long test will run
 	MaxPercent float64       // 10 means 10%. -1 means field not valid.
 	MaxNumber  int           // 10 means 10 packets. -1 means field not valid.
+	// 10 means 10% in each LossBucketSize bucket. 0 means field not valid, use
+	// MaxNumber 0 to expect no loss at all.
+	MaxBucketPercent float64
+	// MinPacketsSent is how many packets the test must send for its loss to
+	// count.  0 means not checked.
+	MinPacketsSent int
+}
+
+type ExpConnRate struct {
+	Duration    time.Duration // how long test will run
+	AttemptRate int           // connections per second to attempt
+	MinRate     float64       // minimum successful connections per second, 0 means not checked.
 }
 
 func (e Expectation) Matches(response *Result, checkSNAT bool) bool {
+	if response != nil && response.HarnessErr != nil {
+		return false
+	}
+
+	if e.noForwardingLoop && len(response.forwardingLoop()) > 0 {
+		return false
+	}
+
+	if e.resultMatcherErr(response) != nil {
+		return false
+	}
+
 	if e.Expected {
 		if !response.HasConnectivity() {
 			return false
@@ -615,28 +2608,116 @@ func (e Expectation) Matches(response *Result, checkSNAT bool) bool {
 			}
 		}
 
-		if e.clientMTUStart != 0 && e.clientMTUStart != response.ClientMTU.Start {
+		if len(e.expServerIPs) > 0 && !containsString(e.expServerIPs, response.LastResponse.ServerIP()) {
+			return false
+		}
+
+		if e.receivedOn != "" && response.LastResponse.ReceivedOn != e.receivedOn {
+			return false
+		}
+
+		if !e.fiveTupleMatches(response) {
+			return false
+		}
+
+		if !e.proxyMatches(response) {
+			return false
+		}
+
+		if !e.pathMatches(response) {
+			return false
+		}
+
+		if !e.clientMTUMatches(response) {
+			return false
+		}
+
+		if e.parallelFlows > 1 && response.SuccessfulFlows() != e.parallelFlows {
+			return false
+		}
+
+		if !e.snatPortsMatch(response) {
+			return false
+		}
+
+		if !e.fastOpenMatches(response) {
 			return false
 		}
-		if e.clientMTUEnd != 0 && e.clientMTUEnd != response.ClientMTU.End {
+
+		if e.sctpFailover && !response.SCTP.FailedOver() {
+			return false
+		}
+
+		if !e.largeSendMatches(response) {
 			return false
 		}
 
+		if !e.bytesMatch(response) {
+			return false
+		}
+
+		if !e.oneWayLatencyMatches(response) {
+			return false
+		}
+
+		if e.maxMSS != 0 || e.checkRetransmits {
+			if response.TCPInfo == nil {
+				return false
+			}
+			if e.maxMSS != 0 && response.TCPInfo.MSS > e.maxMSS {
+				return false
+			}
+			if e.checkRetransmits && response.TCPInfo.Retransmits > e.maxRetransmits {
+				return false
+			}
+		}
+
+		if e.cutWindow > 0 {
+			// This is a connection cut test, the connection must have been reset
+			// by the disruption.
+			cut := response.ConnectionCut
+			return cut != nil && cut.Reset && !cut.Premature && cut.SinceDisruption <= e.cutWindow
+		}
+
+		if e.ExpectedConnRate.Duration > 0 {
+			// This is a connection rate test, individual connections are allowed to fail.
+			return response.ConnectionRate >= e.ExpectedConnRate.MinRate
+		}
+
 		if e.ExpectedPacketLoss.Duration > 0 {
 			// This is a packet loss test.
 			lossCount := response.Stats.Lost()
 			lossPercent := response.Stats.LostPercent()
 
+			if e.tooFewPacketsSent(response) != nil {
+				return false
+			}
 			if e.ExpectedPacketLoss.MaxNumber >= 0 && lossCount > e.ExpectedPacketLoss.MaxNumber {
 				return false
 			}
 			if e.ExpectedPacketLoss.MaxPercent >= 0 && lossPercent > e.ExpectedPacketLoss.MaxPercent {
 				return false
 			}
+			if e.ExpectedPacketLoss.MaxBucketPercent > 0 &&
+				response.Stats.MaxBucketLostPercent() > e.ExpectedPacketLoss.MaxBucketPercent {
+				return false
+			}
+			if e.checkReordering && response.Stats.Reordered > e.maxReordered {
+				return false
+			}
+			if e.noDuplicates && response.Stats.Duplicates > 0 {
+				return false
+			}
+			if !e.lossDirectionMatches(response) {
+				return false
+			}
 		} else if response.LastResponse.ErrorStr != "" {
 			return false
 		}
 	} else {
+		if e.icmpReject && e.rejectedBy(response) == nil {
+			return false
+		}
 		if response != nil {
 			if e.ErrorStr != "" {
 				// Return a match if the error string expected is in the response
@@ -662,41 +2743,187 @@ func (e Expectation) Matches(response *Result, checkSNAT bool) bool {
 	return true
 }
 
-var UnactivatedCheckers = set.New[*Checker]()
-
 // MTUPair is a pair of MTU value recorded before and after data were transferred
 type MTUPair struct {
 	Start int
 	End   int
 }
 
+// FlowResult is the outcome of one of the flows of a parallel flows check.
+type FlowResult struct {
+	Success bool
+	Latency time.Duration
+	// SourceAddr is the source address of the flow as seen by the server.
+	SourceAddr string
+	ErrorStr   string
+}
+
 type Result struct {
 	LastResponse Response
 	Stats        Stats
 	ClientMTU    MTUPair
+	// Flows is only filled in when the check opened parallel flows.
+	Flows []FlowResult
+	// ConnectionRate is the number of successful connections per second in a
+	// connection rate test, over its wall time, including the wait for the
+	// connections that were still in flight at the end.
+	ConnectionRate float64
+	// ConnectionCut is set when a long-lived connection failed.
+	ConnectionCut *ConnectionCut
+	// TCPInfo is read from the client socket at the end of a TCP check.
+	TCPInfo *TCPInfo
+	// Fallback is set to the probe that produced the result if test-connection
+	// was unavailable, see WithFallbackProbes().
+	Fallback string `json:",omitempty"`
+	// ResolvedIP is the IP that a hostname target was resolved to.
+	ResolvedIP string `json:",omitempty"`
+	// Neighbor is the reply to an ARP or NDP probe.
+	Neighbor *NeighborReply `json:",omitempty"`
+	// Path is the path to the target, if traced, see WithPathTrace().
+	Path *PathTrace `json:",omitempty"`
+	// ICMPErrors are the ICMP destination unreachable messages that the client
+	// received, if asked to watch for them, see WithICMPObservation().
+	ICMPErrors []ICMPError `json:",omitempty"`
+	// PayloadSeed is the seed of the fuzzed payload of the check, see
+	// WithFuzzedPayload().
+	PayloadSeed int64 `json:",omitempty"`
+	// SCTP describes the paths of a multi-homed SCTP association, see
+	// WithSCTPRemoteAddrs().
+	SCTP *SCTPAssociation `json:",omitempty"`
+	// ClientAddr is the local address of the client's socket, if known.
+	ClientAddr string `json:",omitempty"`
+	// Translation is filled in by the Checker for successful checks whose client
+	// address is known.
+	Translation *AddressTranslation `json:",omitempty"`
+	// HarnessErr is set, instead of the other fields, if the check could not be
+	// done.  A harness error is neither connectivity nor a lack of it.
+	HarnessErr *HarnessError `json:"-"`
+}
+
+// TCPInfo is the subset of the kernel's TCP_INFO that we check.
+type TCPInfo struct {
+	// Retransmits is the total number of retransmitted segments.
+	Retransmits int
+	// RTT is the smoothed round trip time.
+	RTT time.Duration
+	// MSS is the negotiated send MSS.
+	MSS int
+	// SYNDataAcked is set if the server accepted the data that we sent in the
+	// SYN, with TCP Fast Open.
+	SYNDataAcked bool `json:",omitempty"`
+}
+
+// ConnectionCut records how and when a long-lived connection failed.
+type ConnectionCut struct {
+	Time time.Time
+	// Reset is true if the connection was actively terminated, false if it
+	// stalled.
+	Reset bool
+
+	// Premature and SinceDisruption are calculated by the Checker.  Premature is
+	// set if the connection failed before the disruption started.  SinceDisruption
+	// is how long after the end of the disruption the connection failed, zero if
+	// it failed while the disruption was running.
+	Premature       bool          `json:"-"`
+	SinceDisruption time.Duration `json:"-"`
+}
+
+func (r Result) PrintToStdout() {
+	Message{Type: MessageResult, Result: &r}.PrintToStdout()
+}
+
+func (r *Result) HasConnectivity() bool {
+	if r == nil || r.HarnessErr != nil {
+		return false
+	}
+	if r.Stats.ResponsesReceived == 0 {
+		return false
+	}
+	return true
+}
+
+// SuccessfulFlows returns the number of parallel flows that got a response.
+func (r *Result) SuccessfulFlows() int {
+	if r == nil {
+		return 0
+	}
+	n := 0
+	for _, f := range r.Flows {
+		if f.Success {
+			n++
+		}
+	}
+	return n
 }
 
-func (r Result) PrintToStdout() {
-	encoded, err := json.Marshal(r)
-	if err != nil {
-		log.WithError(err).Panic("Failed to marshall result to stdout")
+// FlowSourcePorts returns the distinct source ports of the successful parallel
+// flows, as seen by the server.  Useful for checking per-flow NAT port allocation.
+func (r *Result) FlowSourcePorts() []string {
+	if r == nil {
+		return nil
+	}
+	ports := set.New[string]()
+	for _, f := range r.Flows {
+		if !f.Success {
+			continue
+		}
+		if _, port, err := net.SplitHostPort(f.SourceAddr); err == nil {
+			ports.Add(port)
+		}
 	}
-	fmt.Printf("RESULT=%s\n", string(encoded))
+	return ports.Slice()
 }
 
-func (r *Result) HasConnectivity() bool {
+// MaxFlowLatency returns the latency of the slowest successful parallel flow.
+func (r *Result) MaxFlowLatency() time.Duration {
+	var max time.Duration
 	if r == nil {
-		return false
+		return max
 	}
-	if r.Stats.ResponsesReceived == 0 {
-		return false
+	for _, f := range r.Flows {
+		if f.Success && f.Latency > max {
+			max = f.Latency
+		}
 	}
-	return true
+	return max
 }
 
+// LossBucketSize is the length of the time buckets that a packet loss test is
+// broken down into.
+const LossBucketSize = time.Second
+
 type Stats struct {
 	RequestsSent      int
 	ResponsesReceived int
+
+	// Buckets breaks a packet loss test down into consecutive time buckets of
+	// BucketSize, by the time each request was sent.
+	BucketSize time.Duration
+	Buckets    []Stats
+
+	// Reordered is the number of responses of a packet loss test that arrived
+	// after the response to a later request, MaxReorderDistance is how many
+	// sequence numbers late the worst of them was.
+	Reordered          int
+	MaxReorderDistance int
+	// Duplicates is the number of responses of a packet loss test that were
+	// received more than once.  They are not counted in ResponsesReceived.
+	Duplicates int
+
+	// OneWay is the estimated one-way latency in each direction of a packet
+	// loss or long-lived connection test.
+	OneWay *OneWayLatency
+
+	// RequestsDelivered is how many of the requests reached the server, as
+	// counted by the server in the last response that came back, zero if none
+	// did or the server doesn't count them.  See RequestsLost() and
+	// ResponsesLost().
+	RequestsDelivered int `json:",omitempty"`
+	// BytesSent and BytesReceived are the totals of the data that the client
+	// sent and received, including the extra data of WithSendLen() and
+	// WithRecvLen().
+	BytesSent     int64 `json:",omitempty"`
+	BytesReceived int64 `json:",omitempty"`
 }
 
 func (s Stats) Lost() int {
@@ -707,6 +2934,20 @@ func (s Stats) LostPercent() float64 {
 	return float64(s.Lost()) * 100.0 / float64(s.RequestsSent)
 }
 
+// MaxBucketLostPercent returns the loss percentage of the worst time bucket.
+func (s Stats) MaxBucketLostPercent() float64 {
+	max := 0.0
+	for _, b := range s.Buckets {
+		if b.RequestsSent == 0 {
+			continue
+		}
+		if pct := b.LostPercent(); pct > max {
+			max = pct
+		}
+	}
+	return max
+}
+
 // CheckOption is the option format for Check()
 type CheckOption func(cmd *CheckCmd)
 
@@ -727,20 +2968,77 @@ type CheckCmd struct {
 
 	sendLen int
 	recvLen int
+
+	flows    int
+	connRate int
+
+	packetRate int
+	packetSize int
+
+	idlePeriod time.Duration
+
+	longLived   bool
+	onConnected func() // called when test-connection reports that it is connected.
+
+	oneWayLatency bool // calibrate the clocks to estimate the one-way latency.
+
+	probeStop <-chan struct{}   // if set, probe continuously until closed.
+	onProbe   func(ProbeResult) // called for each probe result of a continuous check.
+
+	onProgress func(Progress) // called for each progress report of a check that runs for a duration.
+
+	preflight bool // validate the harness instead of checking connectivity.
+
+	resolver   string // DNS server to resolve a hostname target with.
+	resolvedIP string // IP that test-connection resolved a hostname target to.
+
+	sourceInterface string          // interface to bind the sockets of the check to.
+	vrf             string          // VRF device to bind the sockets of the check to.
+	mark            uint32          // fwmark to set on the sockets of the check.
+	proxyProtocol   bool            // send a PROXY protocol header.
+	proxySource     string          // source address to claim in the header, "" for our own.
+	viaProxy        string          // URL of the proxy to tunnel the connections through.
+	observeICMP     bool            // record the ICMP errors that the probes trigger.
+	tcpFastOpen     bool            // send the first request in the SYN.
+	ipv6ExtHeaders  []IPv6ExtHeader // extension headers to add to IPv6 packets.
+	payloadPattern  string          // extra data to send after the request.
+	fuzzPayload     bool            // send a random payload instead.
+	fuzzSeed        int64           // seed of the random payload.
+	ftpMode         FTPMode         // fetch the response over an FTP data connection.
+	sctpLocalAddrs  []string        // local addresses of the SCTP association.
+	sctpRemoteAddrs []string        // extra remote addresses of the SCTP association.
+	largeSend       int             // bytes to send in a single write after the request.
+	sourceMAC       string          // MAC to claim in ARP and NDP probes.
+
+	icmpErrors []ICMPError // ICMP errors that test-connection reported.
+
+	tracePath bool       // trace the path to the target before the check.
+	path      *PathTrace // path that test-connection traced.
+
+	ctx      context.Context // cancels the check, see WithContext().
+	traceCtx context.Context // holds the span to record the spans of the check under.
+
+	autoProvision bool // copy test-connection into the container if it lacks it.
+	fallback      bool // fall back to nc/ping if test-connection is unavailable.
+
+	plan func(cName string, args []string) // if set, called instead of running the check.
+
+	// Labels and level of the log of the check, see WithProbeLabels().
+	logSource  string
+	logTarget  string
+	logAttempt int
+	logLevel   log.Level
+
+	transcript *transcript // records the output of the check, see CheckWithTranscript().
 }
 
 // BinaryName is the name of the binary that the connectivity Check() executes
 const BinaryName = "test-connection"
 
-// Run executes the check command
-func (cmd *CheckCmd) run(cName string, logMsg string) *Result {
-	// Ensure that the container has the 'test-connection' binary.
-	logCxt := log.WithField("container", cName)
-	logCxt.Debugf("Entering connectivity.Check(%v,%v,%v,%v,%v)",
-		cmd.ip, cmd.port, cmd.protocol, cmd.sendLen, cmd.recvLen)
-
-	args := []string{"exec", cName,
-		"test-connection", "--protocol=" + cmd.protocol,
+// args returns the command line of test-connection for the check.
+func (cmd *CheckCmd) args(cName string) []string {
+	args := []string{
+		binaryPath(cName), "--protocol=" + cmd.protocol,
 		fmt.Sprintf("--duration=%d", int(cmd.duration.Seconds())),
 		fmt.Sprintf("--sendlen=%d", cmd.sendLen),
 		fmt.Sprintf("--recvlen=%d", cmd.recvLen),
@@ -748,6 +3046,38 @@ func (cmd *CheckCmd) run(cName string, logMsg string) *Result {
 		cmd.nsPath, cmd.ip, cmd.port,
 	}
 
+	if cmd.flows > 1 {
+		args = append(args, fmt.Sprintf("--flows=%d", cmd.flows))
+	}
+
+	if cmd.connRate > 0 {
+		args = append(args, fmt.Sprintf("--conn-rate=%d", cmd.connRate))
+	}
+
+	if cmd.longLived {
+		args = append(args, "--long-lived")
+	}
+
+	if cmd.oneWayLatency {
+		args = append(args, "--one-way-latency")
+	}
+
+	if cmd.packetRate > 0 {
+		args = append(args, fmt.Sprintf("--packet-rate=%d", cmd.packetRate))
+	}
+
+	if cmd.packetSize > 0 {
+		args = append(args, fmt.Sprintf("--packet-size=%d", cmd.packetSize))
+	}
+
+	if cmd.idlePeriod > 0 {
+		args = append(args, fmt.Sprintf("--idle=%f", cmd.idlePeriod.Seconds()))
+	}
+
+	if cmd.probeStop != nil {
+		args = append(args, "--continuous")
+	}
+
 	if cmd.ipSource != "" {
 		args = append(args, fmt.Sprintf("--source-ip=%s", cmd.ipSource))
 	}
@@ -756,53 +3086,393 @@ func (cmd *CheckCmd) run(cName string, logMsg string) *Result {
 		args = append(args, fmt.Sprintf("--source-port=%s", cmd.portSource))
 	}
 
-	// Run 'test-connection' to the target.
-	connectionCmd := utils.Command("docker", args...)
-	connectionCmd.Env = []string{"GODEBUG=netdns=1"}
+	if cmd.resolver != "" {
+		args = append(args, "--resolver="+cmd.resolver)
+	}
+
+	if cmd.sourceInterface != "" {
+		args = append(args, "--source-interface="+cmd.sourceInterface)
+	}
+
+	if cmd.mark != 0 {
+		args = append(args, fmt.Sprintf("--mark=%#x", cmd.mark))
+	}
+
+	if cmd.proxyProtocol {
+		args = append(args, "--proxy-protocol="+proxyArg(cmd.proxySource))
+	}
+
+	if cmd.viaProxy != "" {
+		args = append(args, "--via-proxy="+cmd.viaProxy)
+	}
+
+	if cmd.observeICMP {
+		args = append(args, "--observe-icmp")
+	}
+
+	if cmd.tcpFastOpen {
+		args = append(args, "--tcp-fastopen")
+	}
+
+	if len(cmd.ipv6ExtHeaders) > 0 {
+		args = append(args, "--ipv6-ext="+joinIPv6ExtHeaders(cmd.ipv6ExtHeaders))
+	}
+
+	args = append(args, cmd.payloadArgs()...)
+
+	if cmd.ftpMode != "" {
+		args = append(args, "--ftp="+string(cmd.ftpMode))
+	}
+
+	args = append(args, cmd.sctpArgs()...)
+
+	if cmd.largeSend > 0 {
+		args = append(args, fmt.Sprintf("--large-send=%d", cmd.largeSend))
+	}
+
+	if cmd.vrf != "" {
+		args = append(args, "--vrf="+cmd.vrf)
+	}
+
+	if cmd.sourceMAC != "" {
+		args = append(args, "--source-mac="+cmd.sourceMAC)
+	}
+
+	if cmd.tracePath {
+		args = append(args, "--trace-path")
+	}
+	return args
+}
+
+// Run executes the check command.  It returns a nil Result if the connection
+// failed and a *HarnessError if the check itself could not be done.
+func (cmd *CheckCmd) run(cName string, logMsg string) (result *Result, runErr error) {
+	// Ensure that the container has the 'test-connection' binary.
+	logCxt := log.WithField("container", cName)
+	logCxt.Debugf("Entering connectivity.Check(%v,%v,%v,%v,%v)",
+		cmd.ip, cmd.port, cmd.protocol, cmd.sendLen, cmd.recvLen)
+
+	if cmd.autoProvision {
+		_, provisionSpan := cmd.startSpan("provision")
+		err := ensureProvisioned(cName)
+		endSpan(provisionSpan, err)
+		if cmd.preflight && !cmd.fallback && err != nil {
+			return &Result{LastResponse: Response{ErrorStr: err.Error()}}, nil
+		}
+		if !cmd.fallback {
+			Expect(err).NotTo(HaveOccurred())
+		}
+	}
+
+	if cmd.fallback {
+		// Decide on the fallback first, a container that needs it has no
+		// test-connection to validate.
+		if _, err := probeCapabilities(cName); err != nil {
+			if cmd.preflight {
+				return cmd.runFallbackPreflight(cName, err), nil
+			}
+			return cmd.runFallback(cName, err)
+		}
+	}
+
+	if cmd.preflight {
+		return cmd.runPreflight(cName), nil
+	}
+
+	if cmd.fuzzPayload && cmd.fuzzSeed == 0 {
+		cmd.fuzzSeed = time.Now().UnixNano()
+	}
+
+	args := cmd.args(cName)
+
+	if required := cmd.requiredFeatures(); len(required) > 0 {
+		caps := containerCapabilities(cName)
+		var missing []string
+		for _, f := range required {
+			if !caps.Supports(f) {
+				missing = append(missing, f)
+			}
+		}
+		Expect(missing).To(BeEmpty(),
+			"test-connection in container %s (protocol version %d) is too old to support %v, "+
+				"the container image needs to be rebuilt", cName, caps.Version, missing)
+	}
+
+	// Run 'test-connection' to the target.  In continuous mode, keep stdin open,
+	// test-connection probes until it is closed.
+	_, waitSpan := cmd.startSpan("wait for exec token")
+	err := waitForExecToken(cmd.context())
+	endSpan(waitSpan, err)
+	if err != nil {
+		return nil, &HarnessError{Container: cName, Err: err}
+	}
+	_, execSpan := cmd.startSpan("exec")
+	execStart := time.Now()
+	proc, err := startExecContext(cmd.context(), cName, cmd.probeStop != nil, args)
+	if err != nil {
+		endSpan(execSpan, err)
+		return nil, &HarnessError{Container: cName, Err: err}
+	}
 
-	outPipe, err := connectionCmd.StdoutPipe()
-	Expect(err).NotTo(HaveOccurred())
-	errPipe, err := connectionCmd.StderrPipe()
-	Expect(err).NotTo(HaveOccurred())
-	err = connectionCmd.Start()
-	Expect(err).NotTo(HaveOccurred())
+	if cmd.probeStop != nil {
+		go func() {
+			<-cmd.probeStop
+			_ = proc.CloseStdin()
+		}()
+	}
 
 	var wg sync.WaitGroup
 	wg.Add(2)
 	var wOut, wErr []byte
-	var outErr, errErr error
+	var outErr, errErr, parseErr error
+	var resp *Result
 
+	// Handle the messages from test-connection as they arrive.
 	go func() {
 		defer wg.Done()
-		wOut, outErr = io.ReadAll(outPipe)
+		firstMsg := true
+		r := bufio.NewReader(proc.Stdout())
+		for {
+			line, err := r.ReadBytes('\n')
+			wOut = append(wOut, line...)
+			msg, err2 := ParseMessage(line)
+			if err2 != nil {
+				// A stray line mustn't fail a probe that did report a result,
+				// so skip it, see the check of parseErr below.
+				logCxt.WithError(err2).WithField("line", string(line)).Warn(
+					"Skipping malformed message from test-connection")
+				if parseErr == nil {
+					parseErr = fmt.Errorf("failed to parse message from test-connection, is it a different version? "+
+						"Line: %s: %w", string(line), err2)
+				}
+			}
+			if msg != nil {
+				if firstMsg {
+					// Up to here, the time went on starting the exec.
+					execSpan.AddEvent("first message")
+					firstMsg = false
+				}
+				cmd.handleMessage(logCxt, msg, &resp)
+			}
+			if err != nil {
+				if err != io.EOF {
+					outErr = err
+				}
+				return
+			}
+		}
 	}()
 
 	go func() {
 		defer wg.Done()
-		wErr, errErr = io.ReadAll(errPipe)
+		wErr, errErr = io.ReadAll(proc.Stderr())
 	}()
 
 	wg.Wait()
-	Expect(outErr).NotTo(HaveOccurred())
-	Expect(errErr).NotTo(HaveOccurred())
+	err = proc.Wait()
+	execSpan.End()
+	exitErr := err
+	defer func() {
+		cmd.logProbe(logCxt, cName, logMsg, time.Since(execStart), wOut, wErr, exitErr, result, runErr)
+		cmd.transcript.probe(cName, args, time.Since(execStart), wOut, wErr, exitErr, result, runErr)
+	}()
+
+	if resp != nil {
+		// Only a probe without a result fails with its malformed lines.
+		parseErr = nil
+	}
+	for _, e := range []error{outErr, errErr, parseErr} {
+		if e != nil {
+			return nil, &HarnessError{Container: cName, Err: e, Stderr: string(wErr)}
+		}
+	}
+
+	if resp == nil {
+		// A test-connection binary that predates the message protocol prints a
+		// single RESULT= line.
+		resp = parseLegacyResult(logCxt, cName, wOut)
+	}
 
-	err = connectionCmd.Wait()
-	logCxt.WithFields(log.Fields{
-		"stdout": string(wOut),
-		"stderr": string(wErr)}).WithError(err).Info(logMsg)
+	if resp != nil && cmd.resolvedIP != "" {
+		resp.ResolvedIP = cmd.resolvedIP
+	}
 
-	var resp Result
-	r := regexp.MustCompile(`RESULT=(.*)\n`)
-	m := r.FindSubmatch(wOut)
-	if len(m) > 0 {
-		err := json.Unmarshal(m[1], &resp)
-		if err != nil {
-			logCxt.WithError(err).WithField("output", string(wOut)).Panic("Failed to parse connection check response")
+	if resp != nil && cmd.path != nil {
+		resp.Path = cmd.path
+	}
+
+	if exitCode(err) == ExitCodeInvalidArgs {
+		return nil, &HarnessError{
+			Container: cName,
+			Err:       fmt.Errorf("%s rejected its arguments %v: %w", BinaryName, args[1:], err),
+			Stderr:    string(wErr),
 		}
-		return &resp
 	}
 
-	return nil
+	if resp == nil {
+		// test-connection exits with status 1 when it fails to connect.  Anything
+		// else means it didn't get that far: docker failed to run it, it crashed
+		// or it was killed.
+		if code := exitCode(err); code != 1 {
+			return nil, &HarnessError{
+				Container: cName,
+				Err:       fmt.Errorf("%s produced no result: %w", BinaryName, err),
+				Stderr:    string(wErr),
+			}
+		}
+	}
+
+	if resp != nil && cmd.fuzzPayload {
+		resp.PayloadSeed = cmd.fuzzSeed
+	}
+
+	if len(cmd.icmpErrors) > 0 {
+		// A probe that failed outright has no result to carry the errors.
+		if resp == nil {
+			resp = &Result{}
+		}
+		resp.ICMPErrors = cmd.icmpErrors
+	}
+
+	return resp, nil
+}
+
+var legacyResultRegexp = regexp.MustCompile(`RESULT=(.*)\n`)
+
+func parseLegacyResult(logCxt *log.Entry, cName string, out []byte) *Result {
+	m := legacyResultRegexp.FindSubmatch(out)
+	if len(m) == 0 {
+		return nil
+	}
+	logCxt.Warn("Old test-connection binary, falling back to protocol version 1")
+	var resp Result
+	err := json.Unmarshal(m[1], &resp)
+	Expect(err).NotTo(HaveOccurred(),
+		"Failed to parse result from test-connection in container %s, is it a different version? Output: %s",
+		cName, string(out))
+	return &resp
+}
+
+// requiredFeatures returns the features of test-connection that the check uses,
+// beyond a basic check.
+func (cmd *CheckCmd) requiredFeatures() []string {
+	var features []string
+	if cmd.flows > 1 {
+		features = append(features, FeatureFlows)
+	}
+	if cmd.connRate > 0 {
+		features = append(features, FeatureConnRate)
+	}
+	if cmd.longLived {
+		features = append(features, FeatureLongLived)
+	}
+	if cmd.oneWayLatency {
+		features = append(features, FeatureOneWayLatency)
+	}
+	if cmd.probeStop != nil {
+		features = append(features, FeatureContinuous)
+	}
+	if cmd.packetRate > 0 {
+		features = append(features, FeaturePacketRate)
+	}
+	if cmd.packetSize > 0 {
+		features = append(features, FeaturePacketSize)
+	}
+	if cmd.idlePeriod > 0 {
+		features = append(features, FeatureIdle)
+	}
+	if cmd.resolver != "" || (cmd.ip != "" && net.ParseIP(cmd.ip) == nil) {
+		features = append(features, FeatureResolve)
+	}
+	if cmd.sourceInterface != "" {
+		features = append(features, FeatureSourceInterface)
+	}
+	if cmd.mark != 0 {
+		features = append(features, FeatureSocketMark)
+	}
+	if cmd.proxyProtocol {
+		features = append(features, FeatureProxyProtocol)
+	}
+	if cmd.viaProxy != "" {
+		features = append(features, FeatureViaProxy)
+	}
+	if cmd.observeICMP {
+		features = append(features, FeatureICMPErrors)
+	}
+	if cmd.tcpFastOpen {
+		features = append(features, FeatureTCPFastOpen)
+	}
+	if len(cmd.ipv6ExtHeaders) > 0 {
+		features = append(features, FeatureIPv6ExtHeaders)
+	}
+	if cmd.payloadPattern != "" || cmd.fuzzPayload {
+		features = append(features, FeaturePayload)
+	}
+	if cmd.ftpMode != "" {
+		features = append(features, FeatureFTP)
+	}
+	if len(cmd.sctpLocalAddrs) > 0 || len(cmd.sctpRemoteAddrs) > 0 {
+		features = append(features, FeatureSCTPMultihoming)
+	}
+	if cmd.largeSend > 0 {
+		features = append(features, FeatureLargeSend)
+	}
+	if cmd.vrf != "" {
+		features = append(features, FeatureVRF)
+	}
+	if cmd.protocol == ProtocolARP || cmd.protocol == ProtocolNDP || cmd.sourceMAC != "" {
+		features = append(features, FeatureNeighbor)
+	}
+	if cmd.tracePath {
+		features = append(features, FeaturePathTrace)
+	}
+	return features
+}
+
+func (cmd *CheckCmd) handleMessage(logCxt *log.Entry, msg *Message, resp **Result) {
+	switch msg.Type {
+	case MessageConnected:
+		if cmd.onConnected != nil {
+			cmd.onConnected()
+		}
+	case MessageProbe:
+		if cmd.onProbe != nil && msg.Probe != nil {
+			cmd.onProbe(*msg.Probe)
+		}
+	case MessageProgress:
+		if msg.Progress == nil {
+			return
+		}
+		logCxt.WithFields(log.Fields{
+			"elapsed":  msg.Progress.Elapsed,
+			"sent":     msg.Progress.Stats.RequestsSent,
+			"received": msg.Progress.Stats.ResponsesReceived,
+		}).Debug("Connection check progress")
+		if cmd.onProgress != nil {
+			cmd.onProgress(*msg.Progress)
+		}
+	case MessagePath:
+		if msg.Path != nil {
+			logCxt.WithField("path", msg.Path.String()).Info("Connection check traced its path")
+			cmd.path = msg.Path
+		}
+	case MessageResolved:
+		if msg.Resolution != nil {
+			logCxt.WithFields(log.Fields{
+				"hostname": msg.Resolution.Hostname,
+				"ip":       msg.Resolution.IP,
+				"server":   msg.Resolution.Server,
+			}).Info("Connection check resolved its target")
+			cmd.resolvedIP = msg.Resolution.IP
+		}
+	case MessageICMPErrors:
+		logCxt.WithField("errors", msg.ICMPErrors).Info("Connection check received ICMP errors")
+		cmd.icmpErrors = append(cmd.icmpErrors, msg.ICMPErrors...)
+	case MessageResult:
+		*resp = msg.Result
+	default:
+		logCxt.WithField("type", msg.Type).Warn("Ignoring unknown connection check message")
+	}
 }
 
 // WithSourceIP tell the check what source IP to use
@@ -812,6 +3482,42 @@ func WithSourceIP(ip string) CheckOption {
 	}
 }
 
+// WithSourceInterface binds the sockets of the check to the given interface of
+// the source's namespace (SO_BINDTODEVICE), so that the probes leave through it
+// whatever the routing table says.  A source IP that has to be added is added to
+// that interface.
+func WithSourceInterface(iface string) CheckOption {
+	return func(c *CheckCmd) {
+		c.sourceInterface = iface
+	}
+}
+
+// WithSourceMAC sets the source MAC that ARP and NDP probes claim, see
+// ProtocolARP.
+func WithSourceMAC(mac string) CheckOption {
+	return func(c *CheckCmd) {
+		c.sourceMAC = mac
+	}
+}
+
+// WithVRF binds the sockets of the check to the given VRF device of the source's
+// namespace, so that the probes are routed by the VRF's table.  It can't be
+// combined with WithSourceInterface(), binding to an interface of the VRF already
+// puts the probes in it.
+func WithVRF(vrf string) CheckOption {
+	return func(c *CheckCmd) {
+		c.vrf = vrf
+	}
+}
+
+// WithSocketMark sets the fwmark (SO_MARK) of the sockets of the check, so that
+// the probes match mark-based rules and routing.
+func WithSocketMark(mark uint32) CheckOption {
+	return func(c *CheckCmd) {
+		c.mark = mark
+	}
+}
+
 // WithSourcePort tell the check what source port to use
 func WithSourcePort(port string) CheckOption {
 	return func(c *CheckCmd) {
@@ -819,6 +3525,8 @@ func WithSourcePort(port string) CheckOption {
 	}
 }
 
+// WithNamespacePath runs the check in the given network namespace of the
+// container, "-" for the container's own.
 func WithNamespacePath(nsPath string) CheckOption {
 	return func(c *CheckCmd) {
 		c.nsPath = nsPath
@@ -843,12 +3551,97 @@ func WithRecvLen(l int) CheckOption {
 	}
 }
 
+// WithParallelFlows tells the check to open n simultaneous flows.
+func WithParallelFlows(n int) CheckOption {
+	return func(c *CheckCmd) {
+		c.flows = n
+	}
+}
+
+// WithConnectionRate tells the check to open new connections at the given rate
+// (connections per second) for the duration of the check.
+func WithConnectionRate(cps int) CheckOption {
+	return func(c *CheckCmd) {
+		c.connRate = cps
+	}
+}
+
+// WithPacketRate sets the packets per second sent by a packet loss test.  The
+// default is 200.
+func WithPacketRate(pps int) CheckOption {
+	return func(c *CheckCmd) {
+		c.packetRate = pps
+	}
+}
+
+// WithPacketSize pads the packets sent by a packet loss test to the given size,
+// up to MaxPacketSize.
+func WithPacketSize(bytes int) CheckOption {
+	return func(c *CheckCmd) {
+		c.packetSize = bytes
+	}
+}
+
+// WithIdlePeriod makes a one off check stay silent for the idle period after the
+// first exchange and then check the same connection again.
+func WithIdlePeriod(idle time.Duration) CheckOption {
+	return func(c *CheckCmd) {
+		c.idlePeriod = idle
+	}
+}
+
+// WithResolver sets the DNS server, as IP or IP:port, that test-connection
+// resolves a hostname target with, from the source's network namespace.
+func WithResolver(server string) CheckOption {
+	return func(c *CheckCmd) {
+		c.resolver = server
+	}
+}
+
+// WithOnProgress sets a function that is called with each progress report of a
+// check that runs for a duration, such as a packet loss test.
+func WithOnProgress(f func(Progress)) CheckOption {
+	return func(c *CheckCmd) {
+		c.onProgress = f
+	}
+}
+
+// WithLongLivedConnection tells the check to keep a single connection open for
+// the duration of the check, exchanging messages until it fails.
+func WithLongLivedConnection() CheckOption {
+	return func(c *CheckCmd) {
+		c.longLived = true
+	}
+}
+
+// WithOnConnected sets a function that is called when a long-lived connection
+// has been established.
+func WithOnConnected(f func()) CheckOption {
+	return func(c *CheckCmd) {
+		c.onConnected = f
+	}
+}
+
 func WithTimeout(t time.Duration) CheckOption {
 	return func(c *CheckCmd) {
 		c.timeout = t
 	}
 }
 
+// WithContext sets a context that cancels the check.
+func WithContext(ctx context.Context) CheckOption {
+	return func(c *CheckCmd) {
+		c.ctx = ctx
+	}
+}
+
+func (cmd *CheckCmd) context() context.Context {
+	if cmd.ctx == nil {
+		return context.Background()
+	}
+	return cmd.ctx
+}
+
 // Check executes the connectivity check
 func Check(cName, logMsg, ip, port, protocol string, opts ...CheckOption) *Result {
 
@@ -859,17 +3652,37 @@ func Check(cName, logMsg, ip, port, protocol string, opts ...CheckOption) *Resul
 		port:     port,
 		protocol: protocol,
 		timeout:  defaultPingTimeout,
+		logLevel: log.InfoLevel,
 	}
 
 	for _, opt := range opts {
 		opt(&cmd)
 	}
 
-	return cmd.run(cName, logMsg)
+	if cmd.plan != nil {
+		cmd.plan(cName, cmd.args(cName))
+		return &Result{}
+	}
+
+	var span trace.Span
+	cmd.traceCtx, span = cmd.startSpan("test-connection",
+		attribute.String("container", cName),
+		attribute.String("target", net.JoinHostPort(ip, port)),
+		attribute.String("protocol", protocol),
+	)
+	res, err := cmd.run(cName, logMsg)
+	span.SetAttributes(attribute.Bool("connected", res.HasConnectivity()))
+	endSpan(span, err)
+	if err != nil {
+		log.WithError(err).Warn("Connectivity check harness failed")
+		return &Result{HarnessErr: err.(*HarnessError)}
+	}
+	return res
 }
 
 const ConnectionTypeStream = "stream"
 const ConnectionTypePing = "ping"
+const ConnectionTypeCalibration = "calibration"
 
 type ConnConfig struct {
 	ConnType string
@@ -902,10 +3715,27 @@ func (cc ConnConfig) GetTestMessageSequence(msg string) (int, error) {
 	return seq, nil
 }
 
+// TestMessageConnection returns the part of a test message that identifies the
+// connection that it was sent on, so that a server can count the requests of
+// each connection, even when the client reuses its source port.
+func TestMessageConnection(msg string) string {
+	msg = strings.TrimSpace(msg)
+	if i := strings.LastIndex(msg, "~"); i >= 0 {
+		return msg[:i+1]
+	}
+	return ""
+}
+
 func IsMessagePartOfStream(msg string) bool {
 	return strings.HasPrefix(strings.TrimSpace(msg), ConnectionTypeStream)
 }
 
+// IsCalibrationMessage returns true for the messages of the clock calibration
+// handshake, which servers leave out of their count of a client's requests.
+func IsCalibrationMessage(msg string) bool {
+	return strings.HasPrefix(strings.TrimSpace(msg), ConnectionTypeCalibration+":")
+}
+
 // Runtime abstracts *containers.Container to avoid import loops
 type Runtime interface {
 	ExecMayFail(cmd ...string) error
@@ -983,6 +3813,9 @@ func (pc *PersistentConnection) Start() error {
 	if pc.Timeout > 0 {
 		args = append(args, fmt.Sprintf("--timeout=%d", pc.Timeout/time.Second))
 	}
+	if err := waitForExecToken(context.Background()); err != nil {
+		return err
+	}
 	runCmd := utils.Command(
 		"docker",
 		args...,
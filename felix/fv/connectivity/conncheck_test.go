@@ -0,0 +1,153 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity_test
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+
+	. "github.com/projectcalico/calico/felix/fv/connectivity"
+)
+
+// brokenSource is a ConnectionSource whose probes all fail with harness errors.
+type brokenSource struct {
+	probes *int32
+}
+
+func (s brokenSource) PreRetryCleanup(ip, port, protocol string, opts ...CheckOption) {}
+
+func (s brokenSource) CanConnectTo(ip, port, protocol string, opts ...CheckOption) *Result {
+	atomic.AddInt32(s.probes, 1)
+	return &Result{HarnessErr: &HarnessError{Container: "broken", Err: errors.New("container has gone away")}}
+}
+
+func (s brokenSource) SourceName() string {
+	return "broken"
+}
+
+func (s brokenSource) SourceIPs() []string {
+	return nil
+}
+
+var _ = Describe("Checker.CheckConnectivity", func() {
+	It("should retry harness errors of a check without a timeout", func() {
+		var probes int32
+		var failure string
+		c := Checker{
+			Context: NewCheckerContext(),
+			OnFail: func(msg string) {
+				failure = msg
+			},
+		}
+		c.ExpectSome(brokenSource{probes: &probes}, TargetIP("10.65.1.1"), 8055)
+		c.CheckConnectivityPacketLoss()
+		Expect(atomic.LoadInt32(&probes)).To(BeEquivalentTo(3))
+		Expect(failure).To(ContainSubstring("container has gone away"))
+	})
+})
+
+var _ = Describe("Checker.Expect", func() {
+	DescribeTable("should refuse options that test-connection can't combine",
+		func(protocol string, failure string, opts ...ExpectationOption) {
+			c := Checker{Protocol: protocol}
+			failures := InterceptGomegaFailures(func() {
+				c.Expect(Some, namedSource("w1"), TargetIP("10.65.1.1"), append(opts, ExpectWithPorts(8055))...)
+			})
+			if failure == "" {
+				Expect(failures).To(BeEmpty())
+			} else {
+				Expect(failures).To(ConsistOf(ContainSubstring(failure)))
+			}
+		},
+		Entry("a plain check", "", ""),
+		Entry("parallel flows with a packet loss test", "",
+			"ExpectWithParallelFlows() can't be combined with ExpectWithLoss()",
+			ExpectWithParallelFlows(2), ExpectWithLoss(5*time.Second, 0, -1)),
+		Entry("a connection rate test with a source port", "",
+			"ExpectWithConnectionRate() can't be combined with ExpectWithSrcPort()",
+			ExpectWithConnectionRate(5*time.Second, 10), ExpectWithSrcPort(1234)),
+		Entry("a connection rate test of a long-lived connection", "",
+			"ExpectWithConnectionRate() can't be combined with ExpectWithSurvival()",
+			ExpectWithConnectionRate(5*time.Second, 10), ExpectWithSurvival(5*time.Second)),
+		Entry("one-way latency of a one off check", "",
+			"ExpectMaxOneWayLatency() needs ExpectWithLoss() or ExpectWithSurvival()",
+			ExpectMaxOneWayLatency(time.Millisecond, 0)),
+		Entry("one-way latency of a packet loss test", "", "",
+			ExpectMaxOneWayLatency(time.Millisecond, 0), ExpectWithLoss(5*time.Second, 0, -1)),
+		Entry("an idle period in a packet loss test", "",
+			"ExpectWithIdlePeriod() is only supported for one off checks",
+			ExpectWithIdlePeriod(time.Second), ExpectWithLoss(5*time.Second, 0, -1)),
+		Entry("a source interface in a VRF", "",
+			"ExpectWithSourceInterface() can't be combined with ExpectWithVRF()",
+			ExpectWithSourceInterface("eth0"), ExpectWithVRF("vrf-blue")),
+		Entry("a large send with a payload", "",
+			"ExpectWithLargeSend() can't be combined with ExpectWithPayloadPattern()",
+			ExpectWithLargeSend(65536), ExpectWithPayloadPattern("ab")),
+		Entry("TCP Fast Open over udp", "udp",
+			"ExpectWithTCPFastOpen() is only supported for tcp",
+			ExpectWithTCPFastOpen()),
+		Entry("TCP Fast Open with the default protocol", "", "",
+			ExpectWithTCPFastOpen()),
+		Entry("SCTP multihoming over tcp", "tcp",
+			"ExpectWithSCTPMultihoming() is only supported for sctp",
+			ExpectWithSCTPMultihoming([]string{"10.65.0.1"}, nil)),
+		Entry("IPv6 fragments over tcp", "tcp",
+			"ExpectWithIPv6ExtHeaders(IPv6Fragment) is only supported for udp",
+			ExpectWithIPv6ExtHeaders(IPv6Fragment)),
+		Entry("IPv6 fragments over udp", "udp-noconn", "",
+			ExpectWithIPv6ExtHeaders(IPv6Fragment)),
+	)
+})
+
+// mapResolver is a ServiceResolver backed by a map, which makes the
+// ServiceTargets that use it uncomparable.
+type mapResolver map[string]*v1.Service
+
+func (r mapResolver) Service(namespace, name string) (*v1.Service, error) {
+	if svc, ok := r[namespace+"/"+name]; ok {
+		return svc, nil
+	}
+	return nil, fmt.Errorf("service %s/%s not found", namespace, name)
+}
+
+var _ = Describe("Checker.RemoveExpectationsTo", func() {
+	It("should remove the expectations to a target on all its ports, by name", func() {
+		resolver := mapResolver{}
+		svc1 := ServiceTarget{Namespace: "default", Name: "svc1", Port: "http", Resolver: resolver}
+		svc2 := ServiceTarget{Namespace: "default", Name: "svc2", Port: "http", Resolver: resolver}
+
+		c := Checker{}
+		c.ExpectSome(namedSource("w1"), svc1)
+		c.ExpectSome(namedSource("w1"), svc1, 8080)
+		c.ExpectSome(namedSource("w1"), svc2)
+		c.ExpectSome(namedSource("w1"), TargetIP("10.65.1.1"), 8055)
+
+		c.RemoveExpectationsTo(ServiceTarget{Namespace: "default", Name: "svc1", Port: "http", Resolver: resolver})
+		Expect(c.ExpectedConnectivityPretty()).To(ConsistOf(
+			ContainSubstring("svc default/svc2:http"),
+			ContainSubstring("10.65.1.1:8055"),
+		))
+
+		c.RemoveExpectationsTo(TargetIP("10.65.1.1"))
+		Expect(c.ExpectedConnectivityPretty()).To(ConsistOf(ContainSubstring("svc default/svc2:http")))
+	})
+})
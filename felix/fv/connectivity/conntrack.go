@@ -0,0 +1,425 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ConntrackExpectation is an expectation about the conntrack entry of the flows
+// from a source to a target port, see Checker.ExpectConntrackEntry().
+type ConntrackExpectation struct {
+	From   ConnectionSource
+	To     *Matcher
+	Port   uint16
+	Exists bool
+	// State, if set, is the state that the entry must be in, for example
+	// ESTABLISHED or TIME_WAIT.
+	State string
+}
+
+func (e ConntrackExpectation) String() string {
+	s := fmt.Sprintf("conntrack %s -> %s:%d", e.From.SourceName(), e.To.TargetName, e.Port)
+	if !e.Exists {
+		return s + " absent"
+	}
+	if e.State != "" {
+		return s + " in state " + e.State
+	}
+	return s + " present"
+}
+
+// ConntrackMismatch describes a conntrack expectation that didn't hold.
+type ConntrackMismatch struct {
+	// Index of the conntrack expectation in the order it was added.
+	Index       int
+	Expectation ConntrackExpectation
+	// Entries holds the matching entries that were found, per host.
+	Entries map[string][]string
+	// Err is set if the conntrack table could not be read.
+	Err error
+}
+
+func (m ConntrackMismatch) String() string {
+	if m.Err != nil {
+		return fmt.Sprintf("%v <---- failed to read conntrack: %v", m.Expectation, m.Err)
+	}
+	hosts := make([]string, 0, len(m.Entries))
+	for host := range m.Entries {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	var entries []string
+	for _, host := range hosts {
+		for _, e := range m.Entries[host] {
+			entries = append(entries, host+": "+e)
+		}
+	}
+	if len(entries) == 0 {
+		return fmt.Sprintf("%v <---- WRONG, no entry found", m.Expectation)
+	}
+	return fmt.Sprintf("%v <---- WRONG, found:\n        %s", m.Expectation, strings.Join(entries, "\n        "))
+}
+
+// ExpectConntrackEntry asserts that, once the connectivity of the check
+// matches, the conntrack table (or the BPF conntrack map in BPF mode) of the
+// hosts of the path does (exists) or does not have an entry for flows from the
+// source to the port of the target.  An entry exists if any of the hosts has
+// it.  A failed conntrack expectation fails the check like a connectivity
+// mismatch and is retried in the same way.
+func (c *Checker) ExpectConntrackEntry(from ConnectionSource, to ConnectionTarget, port uint16, exists bool) {
+	c.expectConntrack(from, to, port, exists, "")
+}
+
+// ExpectConntrackEntryInState is like ExpectConntrackEntry(), but the entry must
+// also be in the given state, for example ESTABLISHED or TIME_WAIT.
+func (c *Checker) ExpectConntrackEntryInState(from ConnectionSource, to ConnectionTarget, port uint16, state string) {
+	c.expectConntrack(from, to, port, true, state)
+}
+
+func (c *Checker) expectConntrack(from ConnectionSource, to ConnectionTarget, port uint16, exists bool, state string) {
+	markUnactivated(c)
+	if c.ReverseDirection {
+		from, to = reverse(from, to)
+	}
+	c.expectationsMutex().Lock()
+	defer c.expectationsMutex().Unlock()
+	c.conntrackExpectations = append(c.conntrackExpectations, ConntrackExpectation{
+		From:   from,
+		To:     to.ToMatcher(port),
+		Port:   port,
+		Exists: exists,
+		State:  state,
+	})
+}
+
+// conntrackMismatches checks the conntrack expectations.
+func (c *Checker) conntrackMismatches() []ConntrackMismatch {
+	bpf := os.Getenv("FELIX_FV_ENABLE_BPF") == "true"
+	c.expectationsMutex().Lock()
+	ctExpectations := append([]ConntrackExpectation(nil), c.conntrackExpectations...)
+	c.expectationsMutex().Unlock()
+
+	var mismatches []ConntrackMismatch
+	for i, ce := range ctExpectations {
+		hosts := pathHosts(Expectation{From: ce.From, To: ce.To})
+		if len(hosts) == 0 {
+			mismatches = append(mismatches, ConntrackMismatch{
+				Index:       i,
+				Expectation: ce,
+				Err:         fmt.Errorf("the hosts of the path are unknown"),
+			})
+			continue
+		}
+		entries := map[string][]string{}
+		var err error
+		for _, host := range hosts {
+			var es []string
+			es, err = conntrackEntries(host, ce, c.protocol(), bpf)
+			if err != nil {
+				break
+			}
+			if len(es) > 0 {
+				entries[host] = es
+			}
+		}
+		if err == nil && (len(entries) > 0) == ce.Exists {
+			continue
+		}
+		mismatches = append(mismatches, ConntrackMismatch{
+			Index:       i,
+			Expectation: ce,
+			Entries:     entries,
+			Err:         err,
+		})
+	}
+	return mismatches
+}
+
+// conntrackEntries returns the entries of the host's conntrack table that match
+// the expectation.
+func conntrackEntries(host string, ce ConntrackExpectation, protocol string, bpf bool) ([]string, error) {
+	var cmd []string
+	if bpf {
+		cmd = []string{"calico-bpf", "conntrack", "dump"}
+	} else {
+		family := "ipv4"
+		if net.ParseIP(ce.To.IP).To4() == nil {
+			family = "ipv6"
+		}
+		cmd = []string{"conntrack", "-L", "-f", family, "-p", protocol,
+			"--dport", strconv.Itoa(int(ce.Port))}
+	}
+	proc, err := startExec(host, false, cmd)
+	if err != nil {
+		return nil, err
+	}
+	out, stderr, err := readAll(proc)
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %w: %s", strings.Join(cmd, " "), err, stderr)
+	}
+
+	var entries []string
+	for _, line := range strings.Split(out, "\n") {
+		var match bool
+		if bpf {
+			match = bpfConntrackLineMatches(line, ce)
+		} else {
+			match = conntrackLineMatches(line, ce)
+		}
+		if match && (ce.State == "" || hasField(line, ce.State)) {
+			entries = append(entries, line)
+		}
+	}
+	return entries, nil
+}
+
+// conntrackLineMatches checks the original tuple of a line of conntrack -L.
+func conntrackLineMatches(line string, ce ConntrackExpectation) bool {
+	var src, dst string
+	for _, f := range strings.Fields(line) {
+		if src == "" && strings.HasPrefix(f, "src=") {
+			src = strings.TrimPrefix(f, "src=")
+		} else if dst == "" && strings.HasPrefix(f, "dst=") {
+			dst = strings.TrimPrefix(f, "dst=")
+		}
+	}
+	if dst != ce.To.IP {
+		return false
+	}
+	for _, ip := range ce.From.SourceIPs() {
+		if src == ip {
+			return true
+		}
+	}
+	return false
+}
+
+// bpfConntrackLineMatches checks a line of calico-bpf conntrack dump, whose keys
+// have the addresses of both ends of the flow.
+func bpfConntrackLineMatches(line string, ce ConntrackExpectation) bool {
+	dst := ce.To.IP + ":" + strconv.Itoa(int(ce.Port))
+	if !strings.Contains(line, dst) {
+		return false
+	}
+	for _, ip := range ce.From.SourceIPs() {
+		if ipInTextRegexp(ip).MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasField(line, field string) bool {
+	for _, f := range strings.Fields(line) {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultConntrackLeakStates are the states in which the conntrack entries of a
+// check's probes may remain after the leak check's grace period: closed TCP
+// connections linger in TIME_WAIT for longer than any reasonable grace period.
+var DefaultConntrackLeakStates = []string{"TIME_WAIT", "CLOSE", "CLOSED"}
+
+// ConntrackLeak is a conntrack entry created by the probes of an expectation
+// that was still there after the grace period of the leak check.
+type ConntrackLeak struct {
+	// Index of the expectation whose probes created the entry.
+	Index int
+	Host  string
+	Entry string
+}
+
+func (l ConntrackLeak) String() string {
+	return l.Host + ": " + l.Entry
+}
+
+// CheckWithConntrackLeakCheck makes the Checker verify, once the check has
+// passed, that the conntrack entries (or BPF conntrack map entries in BPF mode)
+// created by its probes on the hosts of the paths are gone after the grace
+// period, or in one of the allowed states.  If no states are given,
+// DefaultConntrackLeakStates are allowed.  Note that UDP entries time out after
+// 30s by default, so the grace period must be longer than that for UDP checks.
+func CheckWithConntrackLeakCheck(grace time.Duration, allowedStates ...string) CheckerOpt {
+	return func(c *Checker) {
+		log.Debug("CheckWithConntrackLeakCheck set")
+		c.leakCheck = true
+		c.leakCheckGrace = grace
+		c.leakCheckStates = allowedStates
+		if len(allowedStates) == 0 {
+			c.leakCheckStates = DefaultConntrackLeakStates
+		}
+	}
+}
+
+// expectationConntrack returns the ConntrackExpectation that matches the flows
+// of an expectation, false if its flows have no port.
+func (c *Checker) expectationConntrack(exp Expectation) (ConntrackExpectation, bool) {
+	port, err := strconv.Atoi(exp.To.Port)
+	if err != nil || port <= 0 || port > 65535 {
+		return ConntrackExpectation{}, false
+	}
+	switch c.protocol() {
+	case "tcp", "udp", "sctp":
+	default:
+		return ConntrackExpectation{}, false
+	}
+	return ConntrackExpectation{From: exp.From, To: exp.To, Port: uint16(port), Exists: true}, true
+}
+
+// conntrackSnapshot holds the keys of the conntrack entries of each expectation,
+// per host.
+type conntrackSnapshot []map[string]map[string]string
+
+// snapshotConntrack reads the conntrack entries of the flows of the expectations.
+func (c *Checker) snapshotConntrack(expectations []Expectation) conntrackSnapshot {
+	bpf := os.Getenv("FELIX_FV_ENABLE_BPF") == "true"
+	snap := make(conntrackSnapshot, len(expectations))
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	for i, exp := range expectations {
+		ce, ok := c.expectationConntrack(exp)
+		if !ok {
+			continue
+		}
+		snap[i] = map[string]map[string]string{}
+		for _, host := range pathHosts(exp) {
+			wg.Add(1)
+			go func(i int, host string) {
+				defer wg.Done()
+				entries, err := conntrackEntries(host, ce, c.protocol(), bpf)
+				if err != nil {
+					log.WithError(err).WithField("host", host).Warn("Failed to read conntrack for the leak check")
+					return
+				}
+				keyed := map[string]string{}
+				for _, e := range entries {
+					keyed[conntrackEntryKey(e, bpf)] = e
+				}
+				lock.Lock()
+				defer lock.Unlock()
+				snap[i][host] = keyed
+			}(i, host)
+		}
+	}
+	wg.Wait()
+	return snap
+}
+
+// conntrackEntryKey identifies an entry by its original tuple, ignoring its
+// timeout, state and counters.
+func conntrackEntryKey(line string, bpf bool) string {
+	if bpf {
+		if i := strings.Index(line, "}"); i >= 0 {
+			return line[:i+1]
+		}
+		return line
+	}
+	var key []string
+	seen := map[string]bool{}
+	for _, f := range strings.Fields(line) {
+		for _, p := range []string{"src=", "dst=", "sport=", "dport="} {
+			if strings.HasPrefix(f, p) && !seen[p] {
+				seen[p] = true
+				key = append(key, f)
+			}
+		}
+	}
+	return strings.Join(key, " ")
+}
+
+// conntrackLeaks waits for the grace period and returns the entries that
+// weren't in the snapshot taken before the check, of the same expectations, and
+// are not in an allowed state.
+func (c *Checker) conntrackLeaks(expectations []Expectation, before conntrackSnapshot) []ConntrackLeak {
+	time.Sleep(c.leakCheckGrace)
+	after := c.snapshotConntrack(expectations)
+
+	var leaks []ConntrackLeak
+	for i := range expectations {
+		if after[i] == nil {
+			continue
+		}
+		for _, host := range pathHosts(expectations[i]) {
+			keys := make([]string, 0, len(after[i][host]))
+			for k := range after[i][host] {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				if _, ok := before[i][host][k]; ok {
+					continue
+				}
+				entry := after[i][host][k]
+				allowed := false
+				for _, s := range c.leakCheckStates {
+					if hasField(entry, s) {
+						allowed = true
+						break
+					}
+				}
+				if !allowed {
+					leaks = append(leaks, ConntrackLeak{Index: i, Host: host, Entry: entry})
+				}
+			}
+		}
+	}
+	return leaks
+}
+
+// reportConntrackLeaks fails the test if the probes of the check leaked
+// conntrack entries.
+func (c *Checker) reportConntrackLeaks(expectations []Expectation, before conntrackSnapshot, callerSkip int) {
+	leaks := c.conntrackLeaks(expectations, before)
+	if len(leaks) == 0 {
+		return
+	}
+
+	var lines []string
+	for i, exp := range expectations {
+		var entries []string
+		for _, l := range leaks {
+			if l.Index == i {
+				entries = append(entries, l.String())
+			}
+		}
+		if len(entries) > 0 {
+			lines = append(lines, fmt.Sprintf("%s -> %s:\n        %s",
+				exp.From.SourceName(), exp.To.TargetName, strings.Join(entries, "\n        ")))
+		}
+	}
+	message := fmt.Sprintf("Connectivity check leaked conntrack entries, still present %v after the check "+
+		"and not in states %v:\n    %s", c.leakCheckGrace, c.leakCheckStates, strings.Join(lines, "\n    "))
+	if c.description != "" {
+		message += "\nDescription:\n" + c.description
+	}
+	c.fail(&CheckError{
+		Kind:           ErrorKindConntrackLeak,
+		Message:        message,
+		ConntrackLeaks: leaks,
+	}, callerSkip+1)
+}
@@ -0,0 +1,273 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+)
+
+// ContinuousProbeInterval is how often test-connection probes in continuous mode.
+const ContinuousProbeInterval = 100 * time.Millisecond
+
+// ProbeResult is the outcome of a single probe of a continuous check.  Each probe
+// uses a new connection.
+type ProbeResult struct {
+	Time     time.Time
+	Success  bool
+	Latency  time.Duration
+	ErrorStr string
+}
+
+func (p ProbeResult) PrintToStdout() {
+	Message{Type: MessageProbe, Probe: &p}.PrintToStdout()
+}
+
+// Outage is a window during which all probes of a continuous check failed.
+type Outage struct {
+	Start time.Time
+	End   time.Time
+}
+
+func (o Outage) Duration() time.Duration {
+	return o.End.Sub(o.Start)
+}
+
+// Outages calculates the windows during which all the probes failed.  An outage
+// starts with the first failed probe and ends with the next successful one, or
+// with the last probe if no probe succeeded afterwards.
+func Outages(probes []ProbeResult) []Outage {
+	return outagesUntil(probes, time.Time{})
+}
+
+// outagesUntil is Outages() for probes that were stopped at the given time: an
+// outage that was still going on ends when the probes stopped, rather than with
+// its last probe.
+func outagesUntil(probes []ProbeResult, stopped time.Time) []Outage {
+	probes = append([]ProbeResult(nil), probes...)
+	sort.Slice(probes, func(i, j int) bool {
+		return probes[i].Time.Before(probes[j].Time)
+	})
+
+	var outages []Outage
+	var current *Outage
+	for _, p := range probes {
+		if p.Success {
+			if current != nil {
+				current.End = p.Time
+				outages = append(outages, *current)
+				current = nil
+			}
+			continue
+		}
+		if current == nil {
+			current = &Outage{Start: p.Time}
+		}
+		current.End = p.Time
+	}
+	if current != nil {
+		if stopped.After(current.End) {
+			current.End = stopped
+		}
+		outages = append(outages, *current)
+	}
+	return outages
+}
+
+// MaxOutage returns the longest outage of the probes.
+func MaxOutage(probes []ProbeResult) time.Duration {
+	return longestOutage(Outages(probes))
+}
+
+func longestOutage(outages []Outage) time.Duration {
+	var max time.Duration
+	for _, o := range outages {
+		if o.Duration() > max {
+			max = o.Duration()
+		}
+	}
+	return max
+}
+
+type continuousCheck struct {
+	stop chan struct{}
+	wg   sync.WaitGroup
+
+	// expectations is the snapshot of the expectations that the check probes.
+	expectations []Expectation
+
+	lock        sync.Mutex
+	probes      [][]ProbeResult
+	harnessErrs []*HarnessError
+
+	metrics *continuousMetrics // nil unless Checker.ContinuousMetricsAddr is set.
+}
+
+// StartContinuousCheck starts probing all the expected paths in the background,
+// with a new connection every ContinuousProbeInterval, so that the test can
+// measure the disruption caused by changes to policy or routes while they are
+// being made.  Call StopAndAssertMaxOutage() to stop probing and check the
+// results.
+func (c *Checker) StartContinuousCheck() {
+	Expect(c.continuous).To(BeNil(), "Continuous check already started")
+	markActivated(c)
+
+	expectations := c.expectationsSnapshot()
+	for _, exp := range expectations {
+		// test-connection probes with a new connection at a time, from an
+		// ephemeral port, rather than running the check of the expectation.
+		Expect(exp.ExpectedPacketLoss.Duration == 0 && exp.ExpectedConnRate.Duration == 0 &&
+			exp.longLivedDuration == 0 && exp.parallelFlows <= 1 && exp.idlePeriod == 0 && exp.srcPort == 0).To(
+			BeTrue(), "StartContinuousCheck() can't check %s -> %s, a continuous check can't be combined with "+
+				"ExpectWithLoss(), ExpectWithConnectionRate(), ExpectWithSurvival(), ExpectWithParallelFlows(), "+
+				"ExpectWithIdlePeriod() or ExpectWithSrcPort()", exp.sourceName(), exp.To.TargetName)
+	}
+	cc := &continuousCheck{
+		stop:         make(chan struct{}),
+		expectations: expectations,
+		probes:       make([][]ProbeResult, len(expectations)),
+		harnessErrs:  make([]*HarnessError, len(expectations)),
+	}
+	c.continuous = cc
+
+	if c.ContinuousMetricsAddr != "" {
+		m, err := startContinuousMetrics(c.ContinuousMetricsAddr, expectations)
+		Expect(err).NotTo(HaveOccurred(), "Failed to serve continuous check metrics")
+		cc.metrics = m
+	}
+
+	p := c.protocol()
+	log.Info("Starting continuous connectivity check...")
+	for i, exp := range expectations {
+		opts := append(c.checkOptions(exp),
+			WithProbeLabels(exp.sourceName(), exp.To.TargetName, 0),
+			WithContext(c.probeContext()),
+			WithContinuousProbing(cc.stop),
+			WithOnProbe(func(i int) func(ProbeResult) {
+				return func(probe ProbeResult) {
+					cc.lock.Lock()
+					defer cc.lock.Unlock()
+					cc.probes[i] = append(cc.probes[i], probe)
+					if cc.metrics != nil {
+						cc.metrics.observe(i, probe)
+					}
+				}
+			}(i)),
+		)
+
+		cc.wg.Add(1)
+		go func(i int, exp Expectation) {
+			defer ginkgo.GinkgoRecover()
+			defer cc.wg.Done()
+			res := exp.From.CanConnectTo(exp.To.IP, exp.To.Port, p, opts...)
+			if res != nil && res.HarnessErr != nil {
+				cc.lock.Lock()
+				defer cc.lock.Unlock()
+				cc.harnessErrs[i] = res.HarnessErr
+			}
+		}(i, exp)
+	}
+}
+
+// StopAndAssertMaxOutage stops the continuous check started by
+// StartContinuousCheck() and fails the test if, for any expectation of
+// connectivity, there was an outage longer than maxGap.  An outage that was
+// still going on lasts until the check was stopped.  Expectations of no
+// connectivity fail if any probe succeeded.
+func (c *Checker) StopAndAssertMaxOutage(maxGap time.Duration) {
+	Expect(c.continuous).NotTo(BeNil(), "Continuous check not started")
+	cc := c.continuous
+	c.continuous = nil
+
+	stopped := time.Now()
+	close(cc.stop)
+	cc.wg.Wait()
+	if cc.metrics != nil {
+		cc.metrics.stop()
+	}
+
+	failed := false
+	var harnessErrs []*HarnessError
+	pretty := make([]string, len(cc.expectations))
+	for i, exp := range cc.expectations {
+		probes := cc.probes[i]
+		succeeded := 0
+		for _, p := range probes {
+			if p.Success {
+				succeeded++
+			}
+		}
+		pretty[i] = fmt.Sprintf("%s -> %s: %d/%d probes succeeded",
+			exp.sourceName(), exp.To.TargetName, succeeded, len(probes))
+		if herr := cc.harnessErrs[i]; herr != nil {
+			failed = true
+			harnessErrs = append(harnessErrs, herr)
+			pretty[i] += " <---- " + herr.Error()
+			continue
+		}
+
+		if exp.Expected {
+			maxOutage := longestOutage(outagesUntil(probes, stopped))
+			pretty[i] += fmt.Sprintf(", max outage %v", maxOutage)
+			if len(probes) == 0 || succeeded == 0 || maxOutage > maxGap {
+				failed = true
+				pretty[i] += fmt.Sprintf(" <---- WRONG, expected max outage %v", maxGap)
+			}
+		} else if succeeded > 0 {
+			failed = true
+			pretty[i] += " <---- WRONG, expected no connectivity"
+		}
+	}
+
+	log.WithField("results", pretty).Info("Continuous connectivity check finished.")
+	if !failed {
+		return
+	}
+
+	message := fmt.Sprintf("Continuous connectivity check failed:\n    %s",
+		strings.Join(pretty, "\n    "))
+	if c.description != "" {
+		message += "\nDescription:\n" + c.description
+	}
+
+	c.fail(&CheckError{
+		Kind:          ErrorKindOutage,
+		Message:       message,
+		HarnessErrors: harnessErrs,
+	}, 1)
+}
+
+// WithContinuousProbing tells the check to probe with a new connection every
+// ContinuousProbeInterval until the stop channel is closed.
+func WithContinuousProbing(stop <-chan struct{}) CheckOption {
+	return func(c *CheckCmd) {
+		c.probeStop = stop
+	}
+}
+
+// WithOnProbe sets a function that is called with the result of each probe of a
+// continuous check.
+func WithOnProbe(f func(ProbeResult)) CheckOption {
+	return func(c *CheckCmd) {
+		c.onProbe = f
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Outages", func() {
+	t0 := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	ms := time.Millisecond
+
+	// probes returns probes 100ms apart, "+" for a success and "-" for a
+	// failure.
+	probes := func(s string) []ProbeResult {
+		var ps []ProbeResult
+		for i, c := range s {
+			ps = append(ps, ProbeResult{Time: t0.Add(time.Duration(i) * 100 * ms), Success: c == '+'})
+		}
+		return ps
+	}
+
+	DescribeTable("should find the windows in which all the probes failed",
+		func(s string, stopAfter time.Duration, expected []time.Duration) {
+			stopped := time.Time{}
+			if stopAfter > 0 {
+				stopped = t0.Add(stopAfter)
+			}
+			var durations []time.Duration
+			for _, o := range outagesUntil(probes(s), stopped) {
+				durations = append(durations, o.Duration())
+			}
+			Expect(durations).To(Equal(expected))
+		},
+		Entry("no outage", "+++", time.Duration(0), []time.Duration(nil)),
+		Entry("an outage that ended", "+--+", time.Duration(0), []time.Duration{200 * ms}),
+		Entry("two outages", "+-+--+", time.Duration(0), []time.Duration{100 * ms, 200 * ms}),
+		Entry("an open outage ends with its last probe", "++--", time.Duration(0), []time.Duration{100 * ms}),
+		Entry("an open outage ends when the probes stopped", "++--", time.Second, []time.Duration{800 * ms}),
+		Entry("a single failed probe before the stop", "++-", time.Second, []time.Duration{800 * ms}),
+		Entry("the stop doesn't extend an outage that ended", "+-+", time.Second, []time.Duration{100 * ms}),
+	)
+
+	It("should order the probes by time", func() {
+		ps := probes("+-+")
+		ps[0], ps[2] = ps[2], ps[0]
+		Expect(MaxOutage(ps)).To(Equal(100 * ms))
+	})
+})
@@ -0,0 +1,120 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+)
+
+// continuousMetrics serves the probes of a continuous check as Prometheus
+// metrics, per path, see Checker.ContinuousMetricsAddr.
+type continuousMetrics struct {
+	server *http.Server
+	labels []prometheus.Labels
+
+	probes       *prometheus.CounterVec
+	latency      *prometheus.HistogramVec
+	successRatio *prometheus.GaugeVec
+
+	lock      sync.Mutex
+	succeeded []int
+	total     []int
+}
+
+func startContinuousMetrics(addr string, exps []Expectation) (*continuousMetrics, error) {
+	pathLabels := []string{"index", "source", "target", "expected"}
+	m := &continuousMetrics{
+		probes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "felix_fv_connectivity_probes_total",
+			Help: "Number of probes of a continuous connectivity check, by path and result.",
+		}, append(pathLabels, "result")),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "felix_fv_connectivity_probe_latency_seconds",
+			Help:    "Latency of the successful probes of a continuous connectivity check.",
+			Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14),
+		}, pathLabels),
+		successRatio: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "felix_fv_connectivity_success_ratio",
+			Help: "Fraction of the probes of a path that succeeded since the continuous check started.",
+		}, pathLabels),
+		succeeded: make([]int, len(exps)),
+		total:     make([]int, len(exps)),
+	}
+	for i, exp := range exps {
+		m.labels = append(m.labels, prometheus.Labels{
+			"index":    fmt.Sprint(i),
+			"source":   exp.sourceName(),
+			"target":   exp.To.TargetName,
+			"expected": fmt.Sprint(exp.Expected),
+		})
+	}
+
+	registry := prometheus.NewRegistry()
+	for _, c := range []prometheus.Collector{m.probes, m.latency, m.successRatio} {
+		if err := registry.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	m.server = &http.Server{Handler: mux}
+	go func() {
+		if err := m.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.WithError(err).Warn("Continuous check metrics server failed")
+		}
+	}()
+	log.WithField("addr", listener.Addr()).Info("Serving continuous check metrics")
+	return m, nil
+}
+
+// observe records a probe of the expectation with the given index.
+func (m *continuousMetrics) observe(i int, probe ProbeResult) {
+	labels := m.labels[i]
+	result := "failure"
+	if probe.Success {
+		result = "success"
+		m.latency.With(labels).Observe(probe.Latency.Seconds())
+	}
+	withResult := prometheus.Labels{"result": result}
+	for k, v := range labels {
+		withResult[k] = v
+	}
+	m.probes.With(withResult).Inc()
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.total[i]++
+	if probe.Success {
+		m.succeeded[i]++
+	}
+	m.successRatio.With(labels).Set(float64(m.succeeded[i]) / float64(m.total[i]))
+}
+
+func (m *continuousMetrics) stop() {
+	if err := m.server.Close(); err != nil {
+		log.WithError(err).Warn("Failed to stop the continuous check metrics server")
+	}
+}
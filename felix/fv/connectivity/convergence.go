@@ -0,0 +1,103 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Convergence records how long an expectation took to first pass in a run of
+// CheckConnectivity(), the data needed to tune timeouts and to spot convergence
+// getting slower over releases.
+type Convergence struct {
+	Source string
+	Target string
+	// Passed is whether the expectation passed any attempt.
+	Passed bool
+	// Attempts is the number of the first attempt that the expectation passed,
+	// or the number of attempts if it never did.
+	Attempts int
+	// Time is the time from the start of the run to the end of that attempt.
+	Time time.Duration
+}
+
+// LastConvergence returns how long each expectation took to first pass in the
+// last run of CheckConnectivity(), in the order of the expectations.
+func (c *Checker) LastConvergence() []Convergence {
+	return c.lastConvergence
+}
+
+// convergence works out when each expectation first passed in the attempts of a
+// run, going by the expectations of the last attempt.
+func (c *Checker) convergence(start time.Time, attempts []Attempt) []Convergence {
+	if len(attempts) == 0 {
+		return nil
+	}
+	expectations := attempts[len(attempts)-1].expectations
+	conv := make([]Convergence, len(expectations))
+	for i, exp := range expectations {
+		conv[i] = Convergence{
+			Source:   exp.sourceName(),
+			Target:   exp.To.TargetName,
+			Attempts: len(attempts),
+		}
+	}
+	for _, a := range attempts {
+		failed := map[int]bool{}
+		for _, m := range a.Mismatches {
+			failed[m.Index] = true
+		}
+		for i := range conv {
+			if conv[i].Passed || failed[i] || i >= len(a.Results) {
+				continue
+			}
+			conv[i].Passed = true
+			conv[i].Attempts = a.Number
+			conv[i].Time = a.Start.Add(a.Duration).Sub(start)
+		}
+	}
+	return conv
+}
+
+// recordConvergence records and logs the convergence of the run.
+func (c *Checker) recordConvergence(start time.Time, attempts []Attempt) {
+	c.lastConvergence = c.convergence(start, attempts)
+	passed := 0
+	var slowest *Convergence
+	for i, conv := range c.lastConvergence {
+		if !conv.Passed {
+			continue
+		}
+		passed++
+		if slowest == nil || conv.Time > slowest.Time {
+			slowest = &c.lastConvergence[i]
+		}
+	}
+	logCxt := log.WithFields(log.Fields{
+		"passed":       passed,
+		"expectations": len(c.lastConvergence),
+		"attempts":     len(attempts),
+	})
+	if slowest != nil {
+		logCxt = logCxt.WithFields(log.Fields{
+			"slowest":         slowest.Source + " -> " + slowest.Target,
+			"slowestAttempts": slowest.Attempts,
+			"slowestTime":     slowest.Time,
+		})
+	}
+	logCxt.Info("Connectivity convergence")
+}
@@ -0,0 +1,180 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/onsi/ginkgo"
+	log "github.com/sirupsen/logrus"
+)
+
+// HostedSource is implemented by connection sources that know the container of
+// the host they run on, so that the Checker can collect diagnostics from it.
+type HostedSource interface {
+	HostContainerName() string
+}
+
+// diagnosticsCommands are run in the host containers of the failing paths.
+var diagnosticsCommands = [][]string{
+	{"ip", "rule"},
+	{"ip", "route", "show", "table", "all"},
+	{"ip", "-6", "route", "show", "table", "all"},
+	{"ip", "neigh"},
+	{"ip", "-6", "neigh"},
+	{"conntrack", "-L"},
+}
+
+var iptablesDiagnosticsCommands = [][]string{
+	{"iptables-save", "-c"},
+	{"ip6tables-save", "-c"},
+	{"nft", "list", "ruleset"},
+}
+
+var bpfDiagnosticsCommands = [][]string{
+	{"calico-bpf", "counters", "dump"},
+	{"calico-bpf", "conntrack", "dump"},
+}
+
+// CheckWithDiagnostics makes the Checker collect a diagnostics bundle from the
+// hosts of the failing paths when the check finally fails: routes and rules,
+// neighbours, conntrack and the iptables/nft rules or BPF counters.  If dir is
+// empty, the bundle is attached to the failure message; otherwise, it is
+// written to a directory per spec under dir and the failure message points at
+// it.
+func CheckWithDiagnostics(dir string) CheckerOpt {
+	return func(c *Checker) {
+		log.Debug("CheckWithDiagnostics set")
+		c.diagnostics = true
+		c.diagnosticsDir = dir
+	}
+}
+
+// failingHosts returns the host containers of the sources and targets of the
+// mismatched expectations.
+func failingHosts(expectations []Expectation, mismatches []MismatchDetail) []string {
+	var hosts []string
+	seen := map[string]bool{}
+	add := func(h string) {
+		if h != "" && !seen[h] {
+			seen[h] = true
+			hosts = append(hosts, h)
+		}
+	}
+	for _, m := range mismatches {
+		for _, h := range pathHosts(expectations[m.Index]) {
+			add(h)
+		}
+	}
+	return hosts
+}
+
+// pathHosts returns the host containers of the source and target of the
+// expectation, where known.
+func pathHosts(exp Expectation) []string {
+	var hosts []string
+	if hs, ok := exp.From.(HostedSource); ok && hs.HostContainerName() != "" {
+		hosts = append(hosts, hs.HostContainerName())
+	}
+	if exp.To.Host != "" && (len(hosts) == 0 || hosts[0] != exp.To.Host) {
+		hosts = append(hosts, exp.To.Host)
+	}
+	return hosts
+}
+
+// collectDiagnostics gathers the diagnostics bundle from the hosts and returns
+// the text to add to the failure message.
+func (c *Checker) collectDiagnostics(hosts []string) string {
+	if len(hosts) == 0 {
+		return "\nNo diagnostics collected: the hosts of the failing paths are unknown.\n"
+	}
+
+	cmds := append([][]string(nil), diagnosticsCommands...)
+	if os.Getenv("FELIX_FV_ENABLE_BPF") == "true" {
+		cmds = append(cmds, bpfDiagnosticsCommands...)
+	} else {
+		cmds = append(cmds, iptablesDiagnosticsCommands...)
+	}
+
+	outputs := make([]string, len(hosts))
+	var wg sync.WaitGroup
+	for i, host := range hosts {
+		wg.Add(1)
+		go func(i int, host string) {
+			defer wg.Done()
+			var sb strings.Builder
+			for _, cmd := range cmds {
+				fmt.Fprintf(&sb, "==== %s: %s\n%s\n", host, strings.Join(cmd, " "), runDiagnostic(host, cmd))
+			}
+			outputs[i] = sb.String()
+		}(i, host)
+	}
+	wg.Wait()
+
+	if c.diagnosticsDir == "" {
+		return "\nDiagnostics:\n" + strings.Join(outputs, "")
+	}
+
+	dir := filepath.Join(c.diagnosticsDir, specDirName(), time.Now().Format("20060102-150405.000"))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		log.WithError(err).Warn("Failed to create diagnostics directory")
+		return "\nDiagnostics:\n" + strings.Join(outputs, "")
+	}
+	for i, host := range hosts {
+		file := filepath.Join(dir, host+".txt")
+		if err := os.WriteFile(file, []byte(outputs[i]), 0o644); err != nil {
+			log.WithError(err).WithField("file", file).Warn("Failed to write diagnostics")
+		}
+	}
+	return "\nDiagnostics written to " + dir + "\n"
+}
+
+func runDiagnostic(host string, cmd []string) string {
+	proc, err := startExec(host, false, cmd)
+	if err != nil {
+		return "failed: " + err.Error()
+	}
+	stdout, stderr, err := readAll(proc)
+	out := stdout + stderr
+	if err != nil {
+		out += "failed: " + err.Error() + "\n"
+	}
+	return out
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// specDirName returns a directory name for the running spec.
+func specDirName() string {
+	return fileNameOf(ginkgo.CurrentGinkgoTestDescription().FullTestText)
+}
+
+// fileNameOf returns a file name for the text of a spec.
+func fileNameOf(spec string) string {
+	name := unsafeFileChars.ReplaceAllString(spec, "_")
+	if len(name) > 200 {
+		name = name[:200]
+	}
+	if name == "" {
+		name = "unknown-spec"
+	}
+	return name
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+)
+
+// ExpectReversed swaps the source and target of the expectation, so that the
+// target, which must then also be a ConnectionSource, connects to the source.
+// It replaces the Checker-wide ReverseDirection flag, which silently applies to
+// every later expectation.  The option takes precedence over the flag, an
+// expectation with ExpectReversed() is reversed once, whether the flag is set or
+// not.
+func ExpectReversed() ExpectationOption {
+	return func(e *Expectation) {
+		e.reversed = true
+	}
+}
+
+// reversed returns whether the expectation from the given arguments should be
+// reversed, from its options or the ReverseDirection flag.
+func (c *Checker) reversed(from ConnectionSource, to ConnectionTarget, opts []ExpectationOption) bool {
+	var e Expectation
+	for _, o := range opts {
+		o(&e)
+	}
+	if e.reversed && c.ReverseDirection {
+		log.WithFields(log.Fields{
+			"from": from.SourceName(),
+			"to":   to.ToMatcher(e.explicitPorts...).TargetName,
+		}).Warn("ExpectReversed() used with ReverseDirection set, reversing the expectation once; " +
+			"ReverseDirection is deprecated, use ExpectReversed() alone")
+	}
+	return e.reversed || c.ReverseDirection
+}
+
+// reverse swaps the source and target of a reversed expectation.  It fails the
+// expectation if either of them can't take the other's role, for example, a
+// target that is only an IP.
+func reverse(from ConnectionSource, to ConnectionTarget) (ConnectionSource, ConnectionTarget) {
+	newFrom, ok := to.(ConnectionSource)
+	Expect(ok).To(BeTrue(), "Cannot reverse the expectation from %s: its target, %T, is not a ConnectionSource",
+		from.SourceName(), to)
+	newTo, ok := from.(ConnectionTarget)
+	Expect(ok).To(BeTrue(), "Cannot reverse the expectation from %s: it is a %T, not a ConnectionTarget",
+		from.SourceName(), from)
+	return newFrom, newTo
+}
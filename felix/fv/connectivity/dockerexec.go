@@ -0,0 +1,449 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/projectcalico/calico/felix/fv/utils"
+)
+
+const defaultDockerSocket = "/var/run/docker.sock"
+
+// execProcess is a process that runs in a container, started by startExec().
+type execProcess interface {
+	Stdout() io.Reader
+	Stderr() io.Reader
+	// CloseStdin closes the stdin of the process.  Only valid if it was started
+	// with stdin.
+	CloseStdin() error
+	// Wait waits for the process to exit, after its output has been read, and
+	// returns an error if it failed.
+	Wait() error
+}
+
+// startExec runs a command in a container.  It talks to the Docker Engine API
+// directly when the daemon is reachable over a unix socket, which saves forking
+// the docker CLI for every check.  Otherwise, it falls back to "docker exec".
+func startExec(container string, stdin bool, cmd []string) (execProcess, error) {
+	return startExecContext(context.Background(), container, stdin, cmd)
+}
+
+// startExecContext is like startExec but gives up on the process when the
+// context is done: it stops waiting for its output and, for the local commands
+// that run it, kills them.  The Docker API has no way to kill an exec, an exec
+// that is given up on runs until it exits by itself.
+func startExecContext(ctx context.Context, container string, stdin bool, cmd []string) (execProcess, error) {
+	proc, err := startUncountedExec(ctx, container, stdin, cmd)
+	if err != nil {
+		return nil, err
+	}
+	return countExec(proc), nil
+}
+
+func startUncountedExec(ctx context.Context, container string, stdin bool, cmd []string) (execProcess, error) {
+	cmd = platformCommand(container, cmd)
+	if h := sshHostFor(container); h != nil {
+		return startSSHExec(ctx, h, stdin, cmd)
+	}
+	if socket := dockerSocket(); socket != "" {
+		if _, err := os.Stat(socket); err == nil {
+			return startAPIExec(ctx, socket, container, stdin, cmd)
+		}
+	}
+	return startCLIExec(ctx, container, stdin, cmd)
+}
+
+// readAll reads all the output of the process and waits for it to exit.
+func readAll(proc execProcess) (stdout, stderr string, err error) {
+	stderrC := make(chan []byte, 1)
+	go func() {
+		b, _ := io.ReadAll(proc.Stderr())
+		stderrC <- b
+	}()
+	out, _ := io.ReadAll(proc.Stdout())
+	errOut := <-stderrC
+	return string(out), string(errOut), proc.Wait()
+}
+
+// dockerSocket returns the path of the docker daemon's unix socket, or "" if
+// DOCKER_HOST points somewhere else.
+func dockerSocket() string {
+	host := os.Getenv("DOCKER_HOST")
+	if host == "" {
+		return defaultDockerSocket
+	}
+	if strings.HasPrefix(host, "unix://") {
+		return strings.TrimPrefix(host, "unix://")
+	}
+	return ""
+}
+
+type cliExec struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.Reader
+	stderr io.Reader
+
+	done chan struct{} // closed once the command has exited.
+}
+
+// startCLIExec runs the process with "docker exec" and kills it if the context
+// is done before it exits.
+func startCLIExec(ctx context.Context, container string, stdin bool, cmd []string) (*cliExec, error) {
+	args := []string{"exec"}
+	if stdin {
+		args = append(args, "-i")
+	}
+	args = append(args, container)
+	args = append(args, cmd...)
+	return startCmdExec(ctx, utils.Command("docker", args...), stdin)
+}
+
+// startCmdExec starts a local command that runs the process, such as the docker
+// CLI or ssh, and kills it if the context is done before it exits.
+func startCmdExec(ctx context.Context, cmd *exec.Cmd, stdin bool) (*cliExec, error) {
+	e := &cliExec{cmd: cmd, done: make(chan struct{})}
+	var err error
+	if e.stdout, err = e.cmd.StdoutPipe(); err != nil {
+		return nil, err
+	}
+	if e.stderr, err = e.cmd.StderrPipe(); err != nil {
+		return nil, err
+	}
+	if stdin {
+		if e.stdin, err = e.cmd.StdinPipe(); err != nil {
+			return nil, err
+		}
+	}
+	if err := e.cmd.Start(); err != nil {
+		return nil, err
+	}
+	atomic.AddInt64(&harnessCounters.subprocesses, 1)
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				_ = e.cmd.Process.Kill()
+			case <-e.done:
+			}
+		}()
+	}
+	return e, nil
+}
+
+func (e *cliExec) Stdout() io.Reader {
+	return e.stdout
+}
+
+func (e *cliExec) Stderr() io.Reader {
+	return e.stderr
+}
+
+func (e *cliExec) CloseStdin() error {
+	return e.stdin.Close()
+}
+
+func (e *cliExec) Wait() error {
+	defer close(e.done)
+	return e.cmd.Wait()
+}
+
+// apiExec is a process started with the exec create/start endpoints of the
+// Docker Engine API.  The start request hijacks the connection, which then
+// carries the process's stdin and its multiplexed stdout and stderr.
+type apiExec struct {
+	ctx    context.Context
+	client *http.Client
+	id     string
+
+	conn   net.Conn
+	stdout *io.PipeReader
+	stderr *io.PipeReader
+
+	demuxDone chan struct{}
+}
+
+// dockerClientIdleTimeout is how long the connections to the Docker daemon are
+// kept open for reuse.
+const dockerClientIdleTimeout = 30 * time.Second
+
+var (
+	dockerClientsLock sync.Mutex
+	dockerClients     = map[string]*http.Client{}
+)
+
+// dockerClient returns the client of the daemon at the socket, which is shared
+// by all the execs so that they reuse its connections.
+func dockerClient(socket string) *http.Client {
+	dockerClientsLock.Lock()
+	defer dockerClientsLock.Unlock()
+	if c, ok := dockerClients[socket]; ok {
+		return c
+	}
+	c := &http.Client{Transport: &http.Transport{
+		DialContext:     dockerDialer(socket),
+		IdleConnTimeout: dockerClientIdleTimeout,
+	}}
+	dockerClients[socket] = c
+	return c
+}
+
+func dockerDialer(socket string) func(ctx context.Context, _, _ string) (net.Conn, error) {
+	return func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", socket)
+	}
+}
+
+func startAPIExec(ctx context.Context, socket, container string, stdin bool, cmd []string) (*apiExec, error) {
+	log.Debugf("Creating exec in %s over the Docker API: %s", container, strings.Join(cmd, " "))
+	client := dockerClient(socket)
+
+	body, err := json.Marshal(struct {
+		AttachStdin  bool
+		AttachStdout bool
+		AttachStderr bool
+		Tty          bool
+		Cmd          []string
+	}{
+		AttachStdin:  stdin,
+		AttachStdout: true,
+		AttachStderr: true,
+		Cmd:          cmd,
+	})
+	if err != nil {
+		return nil, err
+	}
+	createReq, err := http.NewRequestWithContext(ctx, "POST",
+		"http://docker/containers/"+url.PathEscape(container)+"/exec", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	createReq.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(createReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create exec in %s: %w", container, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return nil, fmt.Errorf("failed to create exec in %s: %s", container, dockerAPIError(resp))
+	}
+	var created struct {
+		Id string
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		return nil, fmt.Errorf("failed to parse exec created in %s: %w", container, err)
+	}
+
+	// Start the exec on a connection of our own, since it gets hijacked.
+	conn, err := dockerDialer(socket)(ctx, "", "")
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", "http://docker/exec/"+created.Id+"/start",
+		strings.NewReader(`{"Detach":false,"Tty":false}`))
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "tcp")
+	if err := req.Write(conn); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to start exec in %s: %w", container, err)
+	}
+	br := bufio.NewReader(conn)
+	startResp, err := http.ReadResponse(br, req)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to start exec in %s: %w", container, err)
+	}
+	if startResp.StatusCode != http.StatusSwitchingProtocols && startResp.StatusCode != http.StatusOK {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to start exec in %s: %s", container, dockerAPIError(startResp))
+	}
+
+	outR, outW := io.Pipe()
+	errR, errW := io.Pipe()
+	e := &apiExec{
+		ctx:       ctx,
+		client:    client,
+		id:        created.Id,
+		conn:      conn,
+		stdout:    outR,
+		stderr:    errR,
+		demuxDone: make(chan struct{}),
+	}
+	go e.demux(br, outW, errW)
+	if ctx.Done() != nil {
+		// Closing the connection ends the demux, and so the output, early.
+		go func() {
+			select {
+			case <-ctx.Done():
+				_ = conn.Close()
+			case <-e.demuxDone:
+			}
+		}()
+	}
+	return e, nil
+}
+
+// demux splits the stream of the hijacked connection into stdout and stderr.
+// Each frame has an 8 byte header: the stream type, 3 bytes of padding and the
+// big-endian length of the payload.
+func (e *apiExec) demux(r io.Reader, stdout, stderr *io.PipeWriter) {
+	defer close(e.demuxDone)
+
+	header := make([]byte, 8)
+	var err error
+	for {
+		if _, err = io.ReadFull(r, header); err != nil {
+			break
+		}
+		var w io.Writer
+		switch header[0] {
+		case 1:
+			w = stdout
+		case 2:
+			w = stderr
+		default:
+			w = io.Discard
+		}
+		if _, err = io.CopyN(w, r, int64(binary.BigEndian.Uint32(header[4:]))); err != nil {
+			break
+		}
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	_ = stdout.CloseWithError(err)
+	_ = stderr.CloseWithError(err)
+}
+
+func (e *apiExec) Stdout() io.Reader {
+	return e.stdout
+}
+
+func (e *apiExec) Stderr() io.Reader {
+	return e.stderr
+}
+
+func (e *apiExec) CloseStdin() error {
+	if cw, ok := e.conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return e.conn.Close()
+}
+
+func (e *apiExec) Wait() error {
+	<-e.demuxDone
+	_ = e.conn.Close()
+
+	// The exec can still be reported as running for a moment after its output
+	// has been closed.
+	start := time.Now()
+	for {
+		req, err := http.NewRequestWithContext(e.ctx, "GET", "http://docker/exec/"+e.id+"/json", nil)
+		if err != nil {
+			return err
+		}
+		resp, err := e.client.Do(req)
+		if err != nil {
+			return fmt.Errorf("failed to inspect exec: %w", err)
+		}
+		var inspect struct {
+			Running  bool
+			ExitCode int
+		}
+		if resp.StatusCode != http.StatusOK {
+			err = fmt.Errorf("failed to inspect exec: %s", dockerAPIError(resp))
+		} else {
+			err = json.NewDecoder(resp.Body).Decode(&inspect)
+		}
+		_ = resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		if !inspect.Running {
+			if inspect.ExitCode != 0 {
+				return &exitError{code: inspect.ExitCode}
+			}
+			return nil
+		}
+		if time.Since(start) > 5*time.Second {
+			return fmt.Errorf("exec still running after its output was closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// exitError is returned by apiExec.Wait() if the process exits with a non-zero
+// status, like exec.ExitError.
+type exitError struct {
+	code int
+}
+
+func (e *exitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.code)
+}
+
+func (e *exitError) ExitCode() int {
+	return e.code
+}
+
+// exitCode returns the exit status of a process from the error returned by
+// execProcess.Wait(), -1 if the process did not exit normally.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var ee interface{ ExitCode() int }
+	if errors.As(err, &ee) {
+		return ee.ExitCode()
+	}
+	return -1
+}
+
+// dockerAPIError formats the status and error message of a failed API request.
+func dockerAPIError(resp *http.Response) string {
+	var msg struct {
+		Message string
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if json.Unmarshal(body, &msg) == nil && msg.Message != "" {
+		return resp.Status + ": " + msg.Message
+	}
+	return resp.Status + ": " + strings.TrimSpace(string(body))
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// ExportDOT renders the expectations as a Graphviz DOT graph with an edge from
+// each source to each target: solid where connectivity is expected, dashed
+// where it isn't.  After a check, the edges are colored by the outcome of its
+// last attempt, green for passed and red for failed.  Render with, for example,
+// "dot -Tsvg".
+func (c *Checker) ExportDOT(w io.Writer) error {
+	failed := map[int]bool{}
+	expectations := c.expectationsSnapshot()
+	if c.lastAttempt != nil {
+		expectations = c.lastAttempt.expectations
+		for _, m := range c.lastAttempt.Mismatches {
+			failed[m.Index] = true
+		}
+		for _, m := range c.lastAttempt.Denials {
+			failed[m.Index] = true
+		}
+		for _, m := range c.lastAttempt.Encap {
+			failed[m.Index] = true
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph connectivity {")
+	fmt.Fprintln(bw, "  rankdir=LR;")
+	fmt.Fprintln(bw, "  node [shape=box, fontname=\"sans-serif\"];")
+	seen := map[string]bool{}
+	node := func(name, shape string) {
+		if !seen[name] {
+			seen[name] = true
+			fmt.Fprintf(bw, "  %q [shape=%s];\n", name, shape)
+		}
+	}
+	for i, exp := range expectations {
+		src, dst := exp.sourceName(), exp.To.TargetName
+		node(src, "box")
+		node(dst, "ellipse")
+		style := "solid"
+		if !exp.Expected {
+			style = "dashed"
+		}
+		color := "black"
+		if c.lastAttempt != nil {
+			color = "darkgreen"
+			if failed[i] {
+				color = "red"
+			}
+		}
+		fmt.Fprintf(bw, "  %q -> %q [style=%s, color=%s, tooltip=%q];\n",
+			src, dst, style, color, fmt.Sprintf("%s -> %s = %v", src, dst, exp.Expected))
+	}
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
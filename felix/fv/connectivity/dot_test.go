@@ -0,0 +1,89 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"errors"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+// failingWriter is an io.Writer that always fails.
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+var _ = Describe("Checker.ExportDOT", func() {
+	w1 := fakeSource{name: "w1"}
+	w2 := fakeSource{name: "w2"}
+
+	newChecker := func() *Checker {
+		c := &Checker{}
+		c.ExpectSome(w1, TargetIP("10.65.1.1"), 8055)
+		c.ExpectNone(w1, TargetIP("10.65.1.2"), 8055)
+		c.ExpectSome(w2, TargetIP("10.65.1.1"), 8055)
+		return c
+	}
+	header := []string{
+		"digraph connectivity {",
+		"  rankdir=LR;",
+		`  node [shape=box, fontname="sans-serif"];`,
+		`  "w1" [shape=box];`,
+		`  "10.65.1.1:8055" [shape=ellipse];`,
+	}
+
+	DescribeTable("should render an edge per expectation",
+		func(attempt func(c *Checker) *Attempt, edges []string) {
+			c := newChecker()
+			if attempt != nil {
+				c.lastAttempt = attempt(c)
+			}
+			var sb strings.Builder
+			Expect(c.ExportDOT(&sb)).To(Succeed())
+			Expect(strings.Split(strings.TrimSuffix(sb.String(), "\n"), "\n")).To(Equal(append(header, edges...)))
+		},
+		Entry("before a check", nil, []string{
+			`  "w1" -> "10.65.1.1:8055" [style=solid, color=black, tooltip="w1 -> 10.65.1.1:8055 = true"];`,
+			`  "10.65.1.2:8055" [shape=ellipse];`,
+			`  "w1" -> "10.65.1.2:8055" [style=dashed, color=black, tooltip="w1 -> 10.65.1.2:8055 = false"];`,
+			`  "w2" [shape=box];`,
+			`  "w2" -> "10.65.1.1:8055" [style=solid, color=black, tooltip="w2 -> 10.65.1.1:8055 = true"];`,
+			"}",
+		}),
+		Entry("after a check", func(c *Checker) *Attempt {
+			return &Attempt{
+				expectations: c.expectationsSnapshot(),
+				Mismatches:   []MismatchDetail{{Index: 1}},
+				Denials:      []DenialMismatch{{Index: 2}},
+			}
+		}, []string{
+			`  "w1" -> "10.65.1.1:8055" [style=solid, color=darkgreen, tooltip="w1 -> 10.65.1.1:8055 = true"];`,
+			`  "10.65.1.2:8055" [shape=ellipse];`,
+			`  "w1" -> "10.65.1.2:8055" [style=dashed, color=red, tooltip="w1 -> 10.65.1.2:8055 = false"];`,
+			`  "w2" [shape=box];`,
+			`  "w2" -> "10.65.1.1:8055" [style=solid, color=red, tooltip="w2 -> 10.65.1.1:8055 = true"];`,
+			"}",
+		}),
+	)
+
+	It("should return the error of the writer", func() {
+		Expect(newChecker().ExportDOT(failingWriter{})).To(MatchError("disk full"))
+	})
+})
@@ -0,0 +1,212 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DropRule is a Calico iptables rule that dropped the packets of a failing path.
+type DropRule struct {
+	// Index of the expectation whose packets were dropped.
+	Index int
+	Host  string
+	Table string
+	Chain string
+	// Rule is the rule as printed by iptables-save, without its counters.
+	Rule string
+	// Packets is the number of packets that the rule dropped while the check
+	// was re-run.
+	Packets int64
+}
+
+func (d DropRule) String() string {
+	return fmt.Sprintf("%s: table %s: %s (+%d packets)", d.Host, d.Table, d.Rule, d.Packets)
+}
+
+// CheckWithDropAttribution makes the Checker find out which Calico iptables
+// rules dropped the traffic of the expectations of connectivity that failed.
+// When the check finally fails, it snapshots the rule counters of the hosts of
+// the failing paths, re-runs the failing checks and reports the DROP and REJECT
+// rules of Calico chains whose counters increased.  Drop attribution is not
+// supported in BPF mode.
+func CheckWithDropAttribution() CheckerOpt {
+	return func(c *Checker) {
+		log.Debug("CheckWithDropAttribution set")
+		c.dropAttribution = true
+	}
+}
+
+// iptablesCounterLine matches the rules of iptables-save -c.
+var iptablesCounterLine = regexp.MustCompile(`^\[(\d+):\d+\] (-A (\S+) .*)$`)
+
+type ruleKey struct {
+	table string
+	rule  string
+}
+
+type ruleCounters map[ruleKey]int64
+
+func parseIptablesCounters(save string) ruleCounters {
+	counters := ruleCounters{}
+	table := ""
+	for _, line := range strings.Split(save, "\n") {
+		if strings.HasPrefix(line, "*") {
+			table = line[1:]
+			continue
+		}
+		m := iptablesCounterLine.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		packets, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		// Identical rules in the same chain are summed.
+		counters[ruleKey{table: table, rule: m[2]}] += packets
+	}
+	return counters
+}
+
+func isCalicoDropRule(rule string) bool {
+	fields := strings.Fields(rule)
+	if len(fields) < 2 || !strings.HasPrefix(fields[1], "cali-") {
+		return false
+	}
+	for i, f := range fields[:len(fields)-1] {
+		if f == "-j" && (fields[i+1] == "DROP" || fields[i+1] == "REJECT") {
+			return true
+		}
+	}
+	return false
+}
+
+func snapshotCounters(host string, ipv6 bool) (ruleCounters, error) {
+	cmd := "iptables-save"
+	if ipv6 {
+		cmd = "ip6tables-save"
+	}
+	proc, err := startExec(host, false, []string{cmd, "-c"})
+	if err != nil {
+		return nil, err
+	}
+	out, stderr, err := readAll(proc)
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %w: %s", cmd, err, stderr)
+	}
+	return parseIptablesCounters(out), nil
+}
+
+// attributeDrops re-runs the checks of the failing expectations of
+// connectivity and returns the Calico rules that dropped their packets, along
+// with the text to add to the failure message.
+func (c *Checker) attributeDrops(expectations []Expectation, mismatches []MismatchDetail) ([]DropRule, string) {
+	if os.Getenv("FELIX_FV_ENABLE_BPF") == "true" {
+		return nil, "\nDrop attribution is not supported in BPF mode.\n"
+	}
+
+	var drops []DropRule
+	var sb strings.Builder
+	for _, m := range mismatches {
+		exp := expectations[m.Index]
+		if !exp.Expected {
+			continue
+		}
+		hosts := pathHosts(exp)
+		fmt.Fprintf(&sb, "\nDrops of %s -> %s:\n", exp.sourceName(), exp.To.TargetName)
+		if len(hosts) == 0 {
+			sb.WriteString("    unknown, the hosts of the path are unknown\n")
+			continue
+		}
+		ipv6 := net.ParseIP(exp.To.IP).To4() == nil
+
+		before := make([]ruleCounters, len(hosts))
+		var errs []string
+		snapshot := func(into []ruleCounters) {
+			var wg sync.WaitGroup
+			var lock sync.Mutex
+			for i, host := range hosts {
+				wg.Add(1)
+				go func(i int, host string) {
+					defer wg.Done()
+					counters, err := snapshotCounters(host, ipv6)
+					if err != nil {
+						lock.Lock()
+						errs = append(errs, fmt.Sprintf("%s: %v", host, err))
+						lock.Unlock()
+					}
+					into[i] = counters
+				}(i, host)
+			}
+			wg.Wait()
+		}
+		snapshot(before)
+		exp.From.CanConnectTo(exp.To.IP, exp.To.Port, c.protocol(), c.checkOptions(exp)...)
+		after := make([]ruleCounters, len(hosts))
+		snapshot(after)
+
+		found := false
+		for i, host := range hosts {
+			if before[i] == nil || after[i] == nil {
+				continue
+			}
+			keys := make([]ruleKey, 0, len(after[i]))
+			for k := range after[i] {
+				keys = append(keys, k)
+			}
+			sort.Slice(keys, func(a, b int) bool {
+				if keys[a].table != keys[b].table {
+					return keys[a].table < keys[b].table
+				}
+				return keys[a].rule < keys[b].rule
+			})
+			for _, k := range keys {
+				packets := after[i][k]
+				delta := packets - before[i][k]
+				if delta <= 0 || !isCalicoDropRule(k.rule) {
+					continue
+				}
+				d := DropRule{
+					Index:   m.Index,
+					Host:    host,
+					Table:   k.table,
+					Chain:   strings.Fields(k.rule)[1],
+					Rule:    k.rule,
+					Packets: delta,
+				}
+				drops = append(drops, d)
+				fmt.Fprintf(&sb, "    %s\n", d)
+				found = true
+			}
+		}
+		for _, e := range errs {
+			fmt.Fprintf(&sb, "    failed to read counters from %s\n", e)
+		}
+		if !found {
+			sb.WriteString("    no Calico DROP or REJECT rule counted the packets\n")
+		}
+	}
+	return drops, sb.String()
+}
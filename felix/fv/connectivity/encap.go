@@ -0,0 +1,363 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Encap is a kind of overlay encapsulation.
+type Encap string
+
+const (
+	EncapIPIP      Encap = "ipip"
+	EncapVXLAN     Encap = "vxlan"
+	EncapWireGuard Encap = "wireguard"
+)
+
+const (
+	// WireGuardDevice and WireGuardDeviceV6 are the WireGuard devices that Felix
+	// creates by default, through which ExpectEncrypted() expects traffic to go.
+	WireGuardDevice   = "wireguard.cali"
+	WireGuardDeviceV6 = "wg-v6.cali"
+	// WireGuardUnderlayInterface is the interface of the hosts on which the
+	// traffic of ExpectEncrypted() must not show in the clear.
+	WireGuardUnderlayInterface = "eth0"
+)
+
+const (
+	// encapFilter is the tcpdump filter for the IPIP and VXLAN traffic.
+	encapFilter = "ip proto 4 or udp port 4789"
+	// wireGuardFilter is the tcpdump filter for the traffic on a WireGuard
+	// device.
+	wireGuardFilter = "ip or ip6"
+	// underlayFilter is the tcpdump filter for the traffic on the underlay that
+	// WireGuard didn't encrypt.
+	underlayFilter = "(ip or ip6) and not udp portrange 51820-51821"
+)
+
+// ExpectEncapsulated asserts that the traffic of the check leaves the source's
+// host in the given encapsulation.  While the check runs, the Checker captures
+// the IPIP and VXLAN traffic on all the interfaces of the source's host and looks
+// for packets whose inner header goes from the source to the target IP, or to
+// one of the IPs of ExpectWithServerIPs().  For EncapWireGuard, it captures on
+// the WireGuard device instead, and on the underlay to check that none of the
+// traffic leaks in the clear.  It only applies to expected connectivity.
+func ExpectEncapsulated(encap Encap) ExpectationOption {
+	return func(e *Expectation) {
+		e.checkEncap = true
+		e.encap = encap
+	}
+}
+
+// ExpectEncrypted asserts that the source can reach the target and that the
+// traffic goes through WireGuard: it is seen on the WireGuard device of the
+// source's host, and never in the clear on WireGuardUnderlayInterface.
+func (c *Checker) ExpectEncrypted(from ConnectionSource, to ConnectionTarget, opts ...ExpectationOption) {
+	opts = append(opts, ExpectEncapsulated(EncapWireGuard))
+	c.expect(Some, from, to, opts...)
+}
+
+// ExpectNotEncapsulated asserts that none of the traffic of the check leaves the
+// source's host in IPIP or VXLAN, see ExpectEncapsulated().  WireGuard encrypts
+// the inner header, so traffic that goes through it always passes, see
+// ExpectEncrypted() for that.
+func ExpectNotEncapsulated() ExpectationOption {
+	return func(e *Expectation) {
+		e.checkEncap = true
+		e.encap = ""
+	}
+}
+
+// EncapMismatch describes an expectation whose traffic was, or wasn't,
+// encapsulated against ExpectEncapsulated() or ExpectNotEncapsulated().
+type EncapMismatch struct {
+	// Index of the expectation in the order it was added to the Checker.
+	Index  int
+	Source string
+	Target string
+	// Expected is the expected encapsulation, "" for none.
+	Expected Encap
+	// Seen holds the encapsulations that the traffic was seen in.
+	Seen []Encap
+	// LeakedOn is the interface on which traffic that should have been
+	// encrypted was seen in the clear, if any.
+	LeakedOn string
+	// Err is set if the traffic could not be captured.
+	Err error
+}
+
+func (m EncapMismatch) String() string {
+	if m.Err != nil {
+		return fmt.Sprintf("%s -> %s <---- failed to capture encapsulated traffic: %v", m.Source, m.Target, m.Err)
+	}
+	if m.LeakedOn != "" {
+		return fmt.Sprintf("%s -> %s <---- WRONG, sent unencrypted on %s", m.Source, m.Target, m.LeakedOn)
+	}
+	if m.Expected == "" {
+		return fmt.Sprintf("%s -> %s <---- WRONG, encapsulated in %v, expected no encapsulation",
+			m.Source, m.Target, m.Seen)
+	}
+	if len(m.Seen) == 0 {
+		return fmt.Sprintf("%s -> %s <---- WRONG, not encapsulated, expected %s", m.Source, m.Target, m.Expected)
+	}
+	return fmt.Sprintf("%s -> %s <---- WRONG, encapsulated in %v, expected %s",
+		m.Source, m.Target, m.Seen, m.Expected)
+}
+
+// captureBuffer holds the text output of a tcpdump.
+type captureBuffer struct {
+	bytes.Buffer
+}
+
+func (b *captureBuffer) Close() error {
+	return nil
+}
+
+// encapCaptureKey identifies a capture of the traffic on an interface of a
+// host, "any" for all of them.
+type encapCaptureKey struct {
+	host  string
+	iface string
+}
+
+// encapCapture is the capture of the traffic on an interface, or the error
+// starting it.
+type encapCapture struct {
+	pc  *packetCapture
+	out *captureBuffer
+	err error
+}
+
+// encapCaptureOutput is the text output of an encapCapture, or its error.
+type encapCaptureOutput struct {
+	text string
+	err  error
+}
+
+// sourceHost returns the host of the source of the expectation, "" if unknown.
+func sourceHost(exp Expectation) string {
+	if hs, ok := exp.From.(HostedSource); ok {
+		return hs.HostContainerName()
+	}
+	return ""
+}
+
+func wireGuardDeviceFor(exp Expectation) string {
+	if isIPv6(exp.To.IP) {
+		return WireGuardDeviceV6
+	}
+	return WireGuardDevice
+}
+
+// encapCaptureFilters returns the filter of each capture that the
+// expectations with an encapsulation assertion need.
+func encapCaptureFilters(expectations []Expectation) map[encapCaptureKey]string {
+	filters := map[encapCaptureKey]string{}
+	for _, exp := range expectations {
+		if !exp.checkEncap || exp.Expected != Some {
+			continue
+		}
+		host := sourceHost(exp)
+		if host == "" {
+			continue
+		}
+		if exp.encap == EncapWireGuard {
+			filters[encapCaptureKey{host, wireGuardDeviceFor(exp)}] = wireGuardFilter
+			filters[encapCaptureKey{host, WireGuardUnderlayInterface}] = underlayFilter
+		} else {
+			filters[encapCaptureKey{host, "any"}] = encapFilter
+		}
+	}
+	return filters
+}
+
+// startEncapCaptures starts the captures that the expectations with an
+// encapsulation assertion need.  It returns nil if there are none.
+func (c *Checker) startEncapCaptures(expectations []Expectation) map[encapCaptureKey]*encapCapture {
+	filters := encapCaptureFilters(expectations)
+	if len(filters) == 0 {
+		return nil
+	}
+	captures := map[encapCaptureKey]*encapCapture{}
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	for key, filter := range filters {
+		wg.Add(1)
+		go func(key encapCaptureKey, filter string) {
+			defer wg.Done()
+			ec := &encapCapture{out: &captureBuffer{}}
+			ec.pc, ec.err = startCapture(key.host, "-i "+key.iface+" -n -l", filter, ec.out)
+			if ec.err != nil {
+				log.WithError(ec.err).WithFields(log.Fields{
+					"host":  key.host,
+					"iface": key.iface,
+				}).Warn("Failed to start encapsulation capture")
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			captures[key] = ec
+		}(key, filter)
+	}
+	wg.Wait()
+	return captures
+}
+
+func stopEncapCaptures(captures map[encapCaptureKey]*encapCapture) map[encapCaptureKey]encapCaptureOutput {
+	outputs := map[encapCaptureKey]encapCaptureOutput{}
+	for key, ec := range captures {
+		if ec.err != nil {
+			outputs[key] = encapCaptureOutput{err: ec.err}
+			continue
+		}
+		if err := ec.pc.Stop(); err != nil {
+			log.WithError(err).WithField("host", key.host).Warn("Encapsulation capture didn't stop cleanly")
+		}
+		outputs[key] = encapCaptureOutput{text: ec.out.String()}
+	}
+	return outputs
+}
+
+// encapMismatches checks the captured traffic against the encapsulation
+// assertions of the expectations.
+func (c *Checker) encapMismatches(expectations []Expectation,
+	outputs map[encapCaptureKey]encapCaptureOutput) []EncapMismatch {
+	var mismatches []EncapMismatch
+	for i, exp := range expectations {
+		if !exp.checkEncap || exp.Expected != Some {
+			continue
+		}
+		m := EncapMismatch{
+			Index:    i,
+			Source:   exp.sourceName(),
+			Target:   exp.To.TargetName,
+			Expected: exp.encap,
+		}
+		host := sourceHost(exp)
+		if host == "" {
+			m.Err = fmt.Errorf("the host of the source is unknown")
+			mismatches = append(mismatches, m)
+			continue
+		}
+		srcs := exp.sourceIPs()
+		dsts := append([]string{exp.To.IP}, exp.expServerIPs...)
+
+		if exp.encap == EncapWireGuard {
+			tunnel := outputs[encapCaptureKey{host, wireGuardDeviceFor(exp)}]
+			underlay := outputs[encapCaptureKey{host, WireGuardUnderlayInterface}]
+			if tunnel.err != nil {
+				m.Err = tunnel.err
+			} else if underlay.err != nil {
+				m.Err = underlay.err
+			} else {
+				if len(encapsOfMatches(tunnel.text, srcs, dsts)) > 0 {
+					m.Seen = []Encap{EncapWireGuard}
+				}
+				if encapsOfMatches(underlay.text, srcs, dsts)[""] {
+					m.LeakedOn = WireGuardUnderlayInterface
+				}
+			}
+			if m.Err != nil || len(m.Seen) == 0 || m.LeakedOn != "" {
+				mismatches = append(mismatches, m)
+			}
+			continue
+		}
+
+		out := outputs[encapCaptureKey{host, "any"}]
+		if out.err != nil {
+			m.Err = out.err
+			mismatches = append(mismatches, m)
+			continue
+		}
+		found := encapsOfMatches(out.text, srcs, dsts)
+		for _, e := range []Encap{EncapIPIP, EncapVXLAN} {
+			if found[e] {
+				m.Seen = append(m.Seen, e)
+			}
+		}
+		if exp.encap == "" && len(m.Seen) == 0 {
+			continue
+		}
+		if exp.encap != "" && len(m.Seen) == 1 && m.Seen[0] == exp.encap {
+			continue
+		}
+		mismatches = append(mismatches, m)
+	}
+	return mismatches
+}
+
+// encapsOfMatches parses the text output of tcpdump for packets whose header,
+// or inner header, goes from one of the sources to one of the destinations, and
+// returns their encapsulations, "" for packets in the clear.  tcpdump prints the
+// inner header of IPIP on the same line as the outer one, and that of VXLAN on
+// the line after it.
+func encapsOfMatches(text string, srcs, dsts []string) map[Encap]bool {
+	found := map[Encap]bool{}
+	afterVXLAN := false
+	for _, line := range strings.Split(text, "\n") {
+		if afterVXLAN {
+			afterVXLAN = false
+			if innerMatches(line, srcs, dsts) {
+				found[EncapVXLAN] = true
+			}
+			continue
+		}
+		if strings.Contains(line, ": VXLAN") {
+			afterVXLAN = true
+			continue
+		}
+		if i := strings.Index(line, ": IP"); i >= 0 {
+			if innerMatches(line[i+2:], srcs, dsts) {
+				found[EncapIPIP] = true
+			}
+			continue
+		}
+		if i := strings.Index(line, " IP"); i >= 0 && innerMatches(line[i+1:], srcs, dsts) {
+			found[""] = true
+		}
+	}
+	return found
+}
+
+// innerMatches returns whether the header that tcpdump printed, such as
+// "IP 10.65.0.2.40000 > 10.65.1.2.8055: Flags [S]", goes from one of the sources
+// to one of the destinations.
+func innerMatches(header string, srcs, dsts []string) bool {
+	fields := strings.Fields(header)
+	if len(fields) < 4 || (fields[0] != "IP" && fields[0] != "IP6") || fields[2] != ">" {
+		return false
+	}
+	src := addrWithoutPort(fields[1])
+	dst := addrWithoutPort(strings.TrimSuffix(fields[3], ":"))
+	return containsString(srcs, src) && containsString(dsts, dst)
+}
+
+// addrWithoutPort strips the port from an address as tcpdump prints it, for
+// example, "10.65.0.2.8055" or "fd00::2.8055".
+func addrWithoutPort(addr string) string {
+	if net.ParseIP(addr) != nil {
+		return addr
+	}
+	if i := strings.LastIndex(addr, "."); i >= 0 {
+		return addr[:i]
+	}
+	return addr
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"time"
+
+	"github.com/onsi/ginkgo"
+)
+
+// ErrorKind classifies why a Checker failed.
+type ErrorKind string
+
+const (
+	// ErrorKindMismatch means that the connectivity didn't match the expectations.
+	ErrorKindMismatch ErrorKind = "mismatch"
+	// ErrorKindFinalTest means that the connectivity matched but the final test,
+	// see CheckWithFinalTest(), failed.
+	ErrorKindFinalTest ErrorKind = "final-test"
+	// ErrorKindConntrack means that the connectivity matched but the conntrack
+	// expectations, see Checker.ExpectConntrackEntry(), didn't.
+	ErrorKindConntrack ErrorKind = "conntrack"
+	// ErrorKindDenial means that the connectivity matched but the traffic of
+	// an expectation of no connectivity was not counted as denied by policy, see
+	// CheckWithDeniedPacketMetrics().
+	ErrorKindDenial ErrorKind = "denial"
+	// ErrorKindEncap means that the connectivity matched but the traffic of an
+	// expectation was, or wasn't, encapsulated against ExpectEncapsulated() or
+	// ExpectNotEncapsulated().
+	ErrorKindEncap ErrorKind = "encap"
+	// ErrorKindConntrackLeak means that the probes of a check that passed left
+	// conntrack entries behind, see CheckWithConntrackLeakCheck().
+	ErrorKindConntrackLeak ErrorKind = "conntrack-leak"
+	// ErrorKindFlowLog means that the check passed but the flow logs didn't
+	// match, see CheckWithFlowLogs().
+	ErrorKindFlowLog ErrorKind = "flow-log"
+	// ErrorKindHarness means that the checks could not be done, see HarnessError.
+	ErrorKindHarness ErrorKind = "harness"
+	// ErrorKindValidation means that Validate() found problems with the harness.
+	ErrorKindValidation ErrorKind = "validation"
+	// ErrorKindConflict means that the Checker expects both connectivity and no
+	// connectivity on the same path.
+	ErrorKindConflict ErrorKind = "conflict"
+	// ErrorKindOutage means that a continuous check saw too long an outage, or
+	// unexpected connectivity.
+	ErrorKindOutage ErrorKind = "outage"
+)
+
+// CheckError describes a failed check for code that embeds the Checker and
+// wants to react to failures, see Checker.OnFailError.
+type CheckError struct {
+	Kind ErrorKind
+	// Message is the message that the test fails with.
+	Message string
+
+	// Mismatches holds the expectations that failed on the last attempt.
+	Mismatches []MismatchDetail
+	// HarnessErrors holds the harness errors of the last attempt.
+	HarnessErrors []*HarnessError
+	// FinalTestErr is the error returned by the final test.
+	FinalTestErr error
+	// Conntrack holds the conntrack expectations that failed on the last attempt.
+	Conntrack []ConntrackMismatch
+	// Denials holds the expectations of no connectivity whose traffic was not
+	// counted as denied by policy on the last attempt.
+	Denials []DenialMismatch
+	// Encap holds the expectations whose traffic was, or wasn't, encapsulated
+	// against their expectation on the last attempt.
+	Encap []EncapMismatch
+	// FlowLogs holds the expectations without the expected flow logs.
+	FlowLogs []FlowLogMismatch
+	// ConntrackLeaks holds the entries found by the conntrack leak check.
+	ConntrackLeaks []ConntrackLeak
+	// ValidationProblems holds the problems found by Validate().
+	ValidationProblems []string
+	// Conflicts holds the paths with conflicting expectations.
+	Conflicts []string
+	// DropRules holds the rules that dropped the traffic of the failing paths,
+	// see CheckWithDropAttribution().
+	DropRules []DropRule
+
+	Attempts int
+	Duration time.Duration
+	// Timeout is the timeout of the check, after CheckWithScaledTimeout().
+	Timeout time.Duration
+}
+
+func (e *CheckError) Error() string {
+	return e.Message
+}
+
+// MismatchDetail describes an expectation that didn't match the actual
+// connectivity.
+type MismatchDetail struct {
+	// Index of the expectation in the order it was added to the Checker.
+	Index    int
+	Source   string
+	Target   string
+	Expected Expected
+
+	// Actual is the result of the check, nil if there was no connectivity.
+	Actual *Result
+
+	ExpectedPretty string
+	ActualPretty   string
+
+	// KnownIssue is set if the expectation was marked with ExpectWithKnownIssue()
+	// but passed, the mismatch is that the issue seems to be fixed.
+	KnownIssue string
+}
+
+// fail records the error for the CheckReport of the run and reports it through
+// OnFailError and then OnFail or ginkgo.Fail(), unless TryCheckConnectivity() is
+// running.
+func (c *Checker) fail(err *CheckError, callerSkip int) {
+	if c.failure == nil {
+		c.failure = err
+	}
+	c.transcript.printf("Failed (%s):\n%s", err.Kind, err.Message)
+	if c.deferFailure {
+		return
+	}
+	if c.OnFailError != nil {
+		c.OnFailError(err)
+	}
+	if c.OnFail != nil {
+		c.OnFail(err.Message)
+	} else {
+		failTest(err.Message, callerSkip+1)
+	}
+}
+
+// failTest is ginkgo.Fail(), the tests replace it to check the location that a
+// failure is reported at.
+var failTest = ginkgo.Fail
@@ -0,0 +1,82 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// callerLine returns the line that it was called from.
+func callerLine() int {
+	_, _, line, _ := runtime.Caller(1)
+	return line
+}
+
+var _ = Describe("Checker failure location", func() {
+	w1 := fakeSource{name: "w1", ips: []string{"10.65.0.1"}}
+	target := TargetIP("10.65.1.1")
+
+	var location string
+	var c *Checker
+
+	BeforeEach(func() {
+		location = ""
+		// Like ginkgo.Fail(), a skip of 0 is the caller of failTest.
+		failTest = func(message string, callerSkip ...int) {
+			_, file, line, _ := runtime.Caller(callerSkip[0] + 1)
+			location = fmt.Sprintf("%s:%d", filepath.Base(file), line)
+		}
+		c = &Checker{Context: NewCheckerContext()}
+	})
+
+	AfterEach(func() {
+		failTest = Fail
+	})
+
+	at := func(line int) string {
+		return fmt.Sprintf("errors_test.go:%d", line)
+	}
+
+	It("should report a failed check at the call of the check", func() {
+		c.ExpectSome(w1, target, 8055)
+		line := callerLine() + 1
+		c.CheckConnectivityPacketLoss()
+		Expect(location).To(Equal(at(line)))
+	})
+
+	It("should report conflicting expectations at the call of the check", func() {
+		c.ExpectSome(w1, target, 8055)
+		c.ExpectNone(w1, target, 8055)
+		line := callerLine() + 1
+		c.CheckConnectivity()
+		Expect(location).To(Equal(at(line)))
+	})
+
+	It("should report at the call of a helper with an offset", func() {
+		check := func() {
+			c.CheckConnectivityOffset(1)
+		}
+		c.ExpectSome(w1, target, 8055)
+		c.ExpectNone(w1, target, 8055)
+		line := callerLine() + 1
+		check()
+		Expect(location).To(Equal(at(line)))
+	})
+})
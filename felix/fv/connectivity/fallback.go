@@ -0,0 +1,186 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	FallbackNC   = "nc"
+	FallbackPing = "ping"
+)
+
+// WithFallbackProbes allows the check to fall back to the nc or ping of the
+// container, when test-connection is unavailable, for basic reachability
+// checks.  TCP and UDP checks use nc, raw ICMP checks use ping, and there is no
+// fallback for other protocols, such as SCTP.  The Result is marked with the
+// fallback that produced it and lacks the source address, MTU and statistics of
+// a test-connection check.
+func WithFallbackProbes() CheckOption {
+	return func(c *CheckCmd) {
+		c.fallback = true
+	}
+}
+
+// isBasic returns whether the check only needs basic reachability, which the
+// fallback probes can provide.
+func (cmd *CheckCmd) isBasic() bool {
+	return len(cmd.requiredFeatures()) == 0 &&
+		cmd.duration == 0 &&
+		cmd.sendLen == 0 && cmd.recvLen == 0 &&
+		(cmd.ipSource == "" || cmd.ipSource == "0.0.0.0" || cmd.ipSource == "::") &&
+		(cmd.portSource == "" || cmd.portSource == "0")
+}
+
+// fallbackProbe returns the fallback, and the script that runs it, that checks
+// the reachability of the target over the protocol of the check, or false if
+// there is none.  A UDP probe sends a request and needs the response, since
+// there is no handshake to tell that the port is open.  ping only stands in for
+// ICMP, it says nothing about the ports of other protocols.  The script exits
+// with status 127 if the container lacks the fallback.
+func (cmd *CheckCmd) fallbackProbe(timeoutSecs int) (fallback, script string, ok bool) {
+	switch {
+	case cmd.protocol == "tcp":
+		fallback = FallbackNC
+		script = fmt.Sprintf("nc -w %d %s %s </dev/null", timeoutSecs, cmd.ip, cmd.port)
+	case strings.HasPrefix(cmd.protocol, "udp"):
+		fallback = FallbackNC
+		script = fmt.Sprintf("echo '{}' | nc -u -w %d %s %s | grep -q .", timeoutSecs, cmd.ip, cmd.port)
+	case isICMPProtocol(cmd.protocol):
+		fallback = FallbackPing
+		script = fmt.Sprintf("ping -c 1 -W %d %s", timeoutSecs, cmd.ip)
+	default:
+		return "", "", false
+	}
+	return fallback, fmt.Sprintf("command -v %s >/dev/null || exit 127; %s", fallback, script), true
+}
+
+// isICMPProtocol returns whether the protocol is raw ICMP, see the raw IP
+// protocols of test-connection.
+func isICMPProtocol(protocol string) bool {
+	switch protocol {
+	case "ip4:icmp", "ip4:1", "ip6:ipv6-icmp", "ip6:58":
+		return true
+	}
+	return false
+}
+
+// runFallback checks reachability with the nc or ping of the container.  Like
+// test-connection, the probe fails to connect with status 1, anything else is a
+// harness error, for example, a container that lacks the fallback.
+func (cmd *CheckCmd) runFallback(cName string, cause error) (*Result, error) {
+	Expect(containerPlatform(cName)).To(Equal(PlatformLinux),
+		"test-connection is unavailable in container %s (%v) and there are no fallback probes for %s containers",
+		cName, cause, containerPlatform(cName))
+	Expect(cmd.isBasic()).To(BeTrue(),
+		"test-connection is unavailable in container %s (%v) and the check needs more than the fallback "+
+			"probes provide", cName, cause)
+
+	timeoutSecs := int(math.Ceil(cmd.timeout.Seconds()))
+	if timeoutSecs < 1 {
+		timeoutSecs = 1
+	}
+	fallback, script, ok := cmd.fallbackProbe(timeoutSecs)
+	Expect(ok).To(BeTrue(),
+		"test-connection is unavailable in container %s (%v) and there is no fallback probe for %s",
+		cName, cause, cmd.protocol)
+	logCxt := log.WithFields(log.Fields{
+		"container": cName,
+		"fallback":  fallback,
+	})
+	logCxt.WithError(cause).Warn("test-connection unavailable, falling back to a basic probe")
+
+	res := &Result{
+		Fallback: fallback,
+		LastResponse: Response{
+			Timestamp: time.Now(),
+		},
+		Stats: Stats{
+			RequestsSent: 1,
+		},
+	}
+	out, errOut, err := cmd.execScript(cName, script)
+	logCxt.WithFields(log.Fields{
+		"stdout": string(out),
+		"stderr": string(errOut),
+	}).WithError(err).Info("Fallback probe finished")
+
+	if code := exitCode(err); code != 0 && code != 1 {
+		return nil, &HarnessError{
+			Container: cName,
+			Err:       fmt.Errorf("%s fallback probe failed: %w", fallback, err),
+			Stderr:    string(errOut),
+		}
+	}
+	if err != nil {
+		res.LastResponse.ErrorStr = fmt.Sprintf("%s failed: %v: %s", fallback, err,
+			strings.TrimSpace(string(errOut)))
+		return res, nil
+	}
+	res.Stats.ResponsesReceived = 1
+	return res, nil
+}
+
+// runFallbackPreflight checks that the container can run the fallback probe of
+// the check, for a container that lacks test-connection.  Any problem is
+// returned in LastResponse.ErrorStr, like runPreflight().
+func (cmd *CheckCmd) runFallbackPreflight(cName string, cause error) *Result {
+	res := &Result{}
+	fallback, _, ok := cmd.fallbackProbe(1)
+	if containerPlatform(cName) != PlatformLinux || !ok {
+		res.LastResponse.ErrorStr = fmt.Sprintf("%s is unavailable (%v) and there is no fallback probe for %s",
+			BinaryName, cause, cmd.protocol)
+		return res
+	}
+	_, errOut, err := cmd.execScript(cName, "command -v "+fallback)
+	if err != nil {
+		res.LastResponse.ErrorStr = fmt.Sprintf("%s is unavailable (%v) and so is the %s fallback: %v: %s",
+			BinaryName, cause, fallback, err, strings.TrimSpace(string(errOut)))
+	}
+	return res
+}
+
+// execScript runs the shell script in the container, in the namespace of the
+// check, and returns its output and the error of the exec, or of its exit
+// status.
+func (cmd *CheckCmd) execScript(cName, script string) (stdout, stderr []byte, err error) {
+	probe := []string{"sh", "-c", script}
+	if cmd.nsPath != "" && cmd.nsPath != "-" {
+		probe = append([]string{"nsenter", "--net=" + cmd.nsPath}, probe...)
+	}
+	if err := waitForExecToken(cmd.context()); err != nil {
+		return nil, nil, err
+	}
+	proc, err := startExecContext(cmd.context(), cName, false, probe)
+	if err != nil {
+		return nil, nil, err
+	}
+	outC := make(chan []byte, 1)
+	go func() {
+		out, _ := io.ReadAll(proc.Stdout())
+		outC <- out
+	}()
+	stderr, _ = io.ReadAll(proc.Stderr())
+	stdout = <-outC
+	return stdout, stderr, proc.Wait()
+}
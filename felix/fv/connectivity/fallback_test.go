@@ -0,0 +1,40 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = DescribeTable("fallbackProbe",
+	func(protocol, expFallback, expScript string) {
+		cmd := CheckCmd{protocol: protocol, ip: "10.65.1.1", port: "8055"}
+		fallback, script, ok := cmd.fallbackProbe(2)
+		if expFallback == "" {
+			Expect(ok).To(BeFalse())
+			return
+		}
+		Expect(ok).To(BeTrue())
+		Expect(fallback).To(Equal(expFallback))
+		Expect(script).To(Equal("command -v " + expFallback + " >/dev/null || exit 127; " + expScript))
+	},
+	Entry("tcp", "tcp", FallbackNC, "nc -w 2 10.65.1.1 8055 </dev/null"),
+	Entry("udp needs a response", "udp", FallbackNC, "echo '{}' | nc -u -w 2 10.65.1.1 8055 | grep -q ."),
+	Entry("unconnected udp", "udp-noconn", FallbackNC, "echo '{}' | nc -u -w 2 10.65.1.1 8055 | grep -q ."),
+	Entry("raw ICMP", "ip4:icmp", FallbackPing, "ping -c 1 -W 2 10.65.1.1"),
+	Entry("sctp", "sctp", "", ""),
+	Entry("other raw IP protocols", "ip4:253", "", ""),
+)
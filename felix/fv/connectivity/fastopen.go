@@ -0,0 +1,62 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+// WithTCPFastOpen makes the client of a TCP check send its first request in the
+// SYN, with TCP Fast Open.  It first makes a connection to get a TFO cookie, if
+// it has none for the server.  Whether the server accepted the data in the SYN is
+// reported in Result.TCPInfo.SYNDataAcked.  The server needs TFO enabled, which
+// test-workload only does with --tcp-fast-open, see workload.WithTCPFastOpen().
+func WithTCPFastOpen() CheckOption {
+	return func(c *CheckCmd) {
+		c.tcpFastOpen = true
+	}
+}
+
+// ExpectWithTCPFastOpen checks the connectivity of a TCP Fast Open connection,
+// see WithTCPFastOpen(), whether or not the server accepts the data in the SYN.
+func ExpectWithTCPFastOpen() ExpectationOption {
+	return func(e *Expectation) {
+		e.tcpFastOpen = true
+	}
+}
+
+// ExpectTCPFastOpenAccepted checks the connectivity of a TCP Fast Open
+// connection and asserts that the server accepted the data in the SYN, which
+// policy and NAT on the path mustn't break.  The target must have TFO enabled,
+// see WithTCPFastOpen().
+func ExpectTCPFastOpenAccepted() ExpectationOption {
+	return func(e *Expectation) {
+		e.tcpFastOpen = true
+		e.tfoAccepted = true
+	}
+}
+
+func (e Expectation) fastOpenMatches(res *Result) bool {
+	if !e.tfoAccepted {
+		return true
+	}
+	return res.TCPInfo != nil && res.TCPInfo.SYNDataAcked
+}
+
+func fastOpenPretty(res *Result) string {
+	if res.TCPInfo == nil {
+		return " (TFO: unknown)"
+	}
+	if res.TCPInfo.SYNDataAcked {
+		return " (TFO: SYN data accepted)"
+	}
+	return " (TFO: SYN data not accepted)"
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// FiveTuple is a connection as the server saw it, after any NAT.  When used to
+// match a connection, the zero value of each field matches anything.
+type FiveTuple struct {
+	SrcIP    string
+	SrcPort  uint16
+	DstIP    string
+	DstPort  uint16
+	Protocol string
+}
+
+func (t FiveTuple) String() string {
+	return fmt.Sprintf("%s %s -> %s", t.Protocol,
+		net.JoinHostPort(t.SrcIP, portString(t.SrcPort)),
+		net.JoinHostPort(t.DstIP, portString(t.DstPort)))
+}
+
+func portString(port uint16) string {
+	if port == 0 {
+		return "*"
+	}
+	return strconv.Itoa(int(port))
+}
+
+// Matches returns whether the tuple matches the pattern, whose zero fields
+// match anything.
+func (t FiveTuple) Matches(pattern FiveTuple) bool {
+	return (pattern.SrcIP == "" || pattern.SrcIP == t.SrcIP) &&
+		(pattern.SrcPort == 0 || pattern.SrcPort == t.SrcPort) &&
+		(pattern.DstIP == "" || pattern.DstIP == t.DstIP) &&
+		(pattern.DstPort == 0 || pattern.DstPort == t.DstPort) &&
+		(pattern.Protocol == "" || pattern.Protocol == t.Protocol)
+}
+
+// FiveTuple returns the connection as the server saw it.
+func (r *Response) FiveTuple() FiveTuple {
+	srcIP, srcPort := splitAddr(r.SourceAddr)
+	dstIP, dstPort := splitAddr(r.ServerAddr)
+	return FiveTuple{
+		SrcIP:    srcIP,
+		SrcPort:  srcPort,
+		DstIP:    dstIP,
+		DstPort:  dstPort,
+		Protocol: r.Protocol,
+	}
+}
+
+func splitAddr(addr string) (string, uint16) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr, 0
+	}
+	p, _ := strconv.ParseUint(port, 10, 16)
+	return host, uint16(p)
+}
+
+// ExpectWithFiveTuple asserts on the connection as the server saw it, the zero
+// fields of the pattern match anything.  For example, to check that a service
+// DNATs to port 8080 of a backend:
+//
+//	cc.Expect(Some, w[0], TargetIP(svcIP), ExpectWithPorts(80),
+//		ExpectWithFiveTuple(FiveTuple{DstIP: w[1].IP, DstPort: 8080}))
+func ExpectWithFiveTuple(pattern FiveTuple) ExpectationOption {
+	return func(e *Expectation) {
+		e.fiveTuple = &pattern
+	}
+}
+
+// ExpectSrcPortPreserved asserts that the server saw the connection come from
+// the client's source port, for example, through an SNAT that preserves ports.
+func ExpectSrcPortPreserved() ExpectationOption {
+	return func(e *Expectation) {
+		e.srcPortPreserved = true
+	}
+}
+
+func (e Expectation) fiveTupleMatches(res *Result) bool {
+	seen := res.LastResponse.FiveTuple()
+	if e.fiveTuple != nil && !seen.Matches(*e.fiveTuple) {
+		return false
+	}
+	if e.srcPortPreserved {
+		_, clientPort := splitAddr(res.ClientAddr)
+		if clientPort == 0 || clientPort != seen.SrcPort {
+			return false
+		}
+	}
+	return true
+}
+
+func (e Expectation) checksFiveTuple() bool {
+	return e.fiveTuple != nil || e.srcPortPreserved
+}
+
+func (e Expectation) fiveTuplePretty() string {
+	var parts string
+	if e.fiveTuple != nil {
+		parts = e.fiveTuple.String()
+	}
+	if e.srcPortPreserved {
+		if parts != "" {
+			parts += ", "
+		}
+		parts += "source port preserved"
+	}
+	return parts
+}
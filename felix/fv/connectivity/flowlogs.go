@@ -0,0 +1,192 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	FlowLogActionAllow = "allow"
+	FlowLogActionDeny  = "deny"
+
+	FlowLogReporterSrc = "src"
+	FlowLogReporterDst = "dst"
+)
+
+// FlowLogEntry is the part of a flow log that the Checker correlates with its
+// expectations.
+type FlowLogEntry struct {
+	SrcIP    string
+	DstIP    string
+	DstPort  string
+	Protocol string
+	// Action is FlowLogActionAllow or FlowLogActionDeny.
+	Action string
+	// Reporter is FlowLogReporterSrc or FlowLogReporterDst.
+	Reporter string
+}
+
+func (f FlowLogEntry) String() string {
+	return fmt.Sprintf("%s %s -> %s:%s %s by %s", f.Protocol, f.SrcIP, f.DstIP, f.DstPort, f.Action, f.Reporter)
+}
+
+// FlowLogReader reads the flow logs emitted since a time.
+type FlowLogReader interface {
+	FlowLogs(since time.Time) ([]FlowLogEntry, error)
+}
+
+// CheckWithFlowLogs makes the Checker verify, once the check has passed, that
+// the flow logs read by the reader have an entry for each expectation, with its
+// 5-tuple and allowed or denied as expected.  Use ExpectFlowLogReporters() to
+// require entries from specific reporters.  Flow logs are flushed
+// periodically, so the Checker polls the reader until the timeout.
+func CheckWithFlowLogs(reader FlowLogReader, timeout time.Duration) CheckerOpt {
+	return func(c *Checker) {
+		log.Debug("CheckWithFlowLogs set")
+		c.flowLogReader = reader
+		c.flowLogTimeout = timeout
+	}
+}
+
+// ExpectFlowLogReporters requires, when flow logs are verified (see
+// CheckWithFlowLogs()), an entry for the expectation from each of the
+// reporters.  By default, an entry from any reporter will do.
+func ExpectFlowLogReporters(reporters ...string) ExpectationOption {
+	for _, r := range reporters {
+		Expect(r).To(BeElementOf(FlowLogReporterSrc, FlowLogReporterDst), "Unknown flow log reporter")
+	}
+
+	return func(e *Expectation) {
+		e.flowLogReporters = reporters
+	}
+}
+
+// FlowLogMismatch describes an expectation without the expected flow logs.
+type FlowLogMismatch struct {
+	// Index of the expectation in the order it was added to the Checker.
+	Index  int
+	Source string
+	Target string
+	// Missing holds the reporters without an entry, or "any" if there was no
+	// entry at all.
+	Missing []string
+}
+
+func (m FlowLogMismatch) String() string {
+	return fmt.Sprintf("%s -> %s <---- WRONG, no flow log reported by %s",
+		m.Source, m.Target, strings.Join(m.Missing, ", "))
+}
+
+// flowLogMatches returns whether the flow log entry is for the flows of the
+// expectation.
+func (c *Checker) flowLogMatches(exp Expectation, f FlowLogEntry) bool {
+	action := FlowLogActionDeny
+	if exp.Expected {
+		action = FlowLogActionAllow
+	}
+	if f.Action != action || f.DstIP != exp.To.IP || f.DstPort != exp.To.Port ||
+		!strings.EqualFold(f.Protocol, c.protocol()) {
+		return false
+	}
+	for _, ip := range exp.sourceIPs() {
+		if f.SrcIP == ip {
+			return true
+		}
+	}
+	return false
+}
+
+// flowLogMismatches checks the flow logs against the expectations.
+func (c *Checker) flowLogMismatches(expectations []Expectation, flows []FlowLogEntry) []FlowLogMismatch {
+	var mismatches []FlowLogMismatch
+	for i, exp := range expectations {
+		reported := map[string]bool{}
+		for _, f := range flows {
+			if c.flowLogMatches(exp, f) {
+				reported[f.Reporter] = true
+			}
+		}
+		var missing []string
+		if len(exp.flowLogReporters) == 0 {
+			if len(reported) == 0 {
+				missing = []string{"any"}
+			}
+		} else {
+			for _, r := range exp.flowLogReporters {
+				if !reported[r] {
+					missing = append(missing, r)
+				}
+			}
+		}
+		if len(missing) > 0 {
+			mismatches = append(mismatches, FlowLogMismatch{
+				Index:   i,
+				Source:  exp.sourceName(),
+				Target:  exp.To.TargetName,
+				Missing: missing,
+			})
+		}
+	}
+	return mismatches
+}
+
+// reportFlowLogs polls the flow logs emitted since the start of the check
+// until they match the expectations, and fails the test if they don't before
+// the timeout.
+func (c *Checker) reportFlowLogs(expectations []Expectation, since time.Time, callerSkip int) {
+	deadline := time.Now().Add(c.flowLogTimeout)
+	var mismatches []FlowLogMismatch
+	var err error
+	for {
+		var flows []FlowLogEntry
+		flows, err = c.flowLogReader.FlowLogs(since)
+		if err == nil {
+			mismatches = c.flowLogMismatches(expectations, flows)
+			if len(mismatches) == 0 {
+				return
+			}
+		}
+		if time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Second)
+	}
+
+	var message string
+	if err != nil {
+		message = fmt.Sprintf("Failed to read flow logs: %v", err)
+	} else {
+		var strs []string
+		for _, m := range mismatches {
+			strs = append(strs, m.String())
+		}
+		message = fmt.Sprintf("Flow logs were incorrect after %v:\n    %s",
+			c.flowLogTimeout, strings.Join(strs, "\n    "))
+	}
+	if c.description != "" {
+		message += "\nDescription:\n" + c.description
+	}
+	c.fail(&CheckError{
+		Kind:     ErrorKindFlowLog,
+		Message:  message,
+		FlowLogs: mismatches,
+	}, callerSkip+1)
+}
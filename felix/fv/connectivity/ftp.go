@@ -0,0 +1,123 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// FTPMode is how the data connection of an FTP check is set up.
+type FTPMode string
+
+const (
+	// FTPPassive has the client connect to a port that the server announces
+	// with PASV, or EPSV over IPv6.
+	FTPPassive FTPMode = "passive"
+	// FTPActive has the server connect back to a port that the client announces
+	// with PORT, or EPRT over IPv6.
+	FTPActive FTPMode = "active"
+)
+
+// WithFTP makes a TCP check an FTP-style session: the client logs in over the
+// connection to the target, which is the control connection, negotiates a data
+// connection on a dynamic port and fetches the server's response over that.  The
+// check only succeeds if both connections work, so it tests the conntrack FTP
+// helper and the policy for related connections.  The kernel only applies its
+// FTP helper to port 21, unless told otherwise.  The Result describes the data
+// connection.  test-workload speaks FTP on all of its TCP ports.
+func WithFTP(mode FTPMode) CheckOption {
+	return func(c *CheckCmd) {
+		c.ftpMode = mode
+	}
+}
+
+// ExpectWithFTP checks the connectivity of an FTP-style session, see WithFTP().
+func ExpectWithFTP(mode FTPMode) ExpectationOption {
+	return func(e *Expectation) {
+		e.ftpMode = mode
+	}
+}
+
+// FTPHostPort formats an IPv4 address as the argument of PORT, and the reply to
+// PASV, h1,h2,h3,h4,p1,p2.
+func FTPHostPort(addr *net.TCPAddr) (string, error) {
+	ip := addr.IP.To4()
+	if ip == nil {
+		return "", fmt.Errorf("%v is not an IPv4 address", addr.IP)
+	}
+	return fmt.Sprintf("%d,%d,%d,%d,%d,%d", ip[0], ip[1], ip[2], ip[3], addr.Port>>8, addr.Port&0xff), nil
+}
+
+// ParseFTPHostPort parses the h1,h2,h3,h4,p1,p2 of FTPHostPort().
+func ParseFTPHostPort(s string) (*net.TCPAddr, error) {
+	parts := strings.Split(strings.TrimSpace(s), ",")
+	if len(parts) != 6 {
+		return nil, fmt.Errorf("malformed FTP address %q", s)
+	}
+	var b [6]byte
+	for i, p := range parts {
+		n, err := strconv.ParseUint(p, 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("malformed FTP address %q: %w", s, err)
+		}
+		b[i] = byte(n)
+	}
+	return &net.TCPAddr{
+		IP:   net.IPv4(b[0], b[1], b[2], b[3]),
+		Port: int(b[4])<<8 | int(b[5]),
+	}, nil
+}
+
+// FTPExtAddr formats an address as the argument of EPRT, |af|ip|port|.  Without
+// an IP, it is the reply to EPSV, |||port|.
+func FTPExtAddr(ip net.IP, port int) string {
+	if ip == nil {
+		return fmt.Sprintf("|||%d|", port)
+	}
+	af := 2
+	if ip.To4() != nil {
+		af = 1
+	}
+	return fmt.Sprintf("|%d|%s|%d|", af, ip, port)
+}
+
+// ParseFTPExtAddr parses the |af|ip|port| of FTPExtAddr().  The IP is nil if it
+// was left out.
+func ParseFTPExtAddr(s string) (net.IP, int, error) {
+	s = strings.TrimSpace(s)
+	if len(s) < 2 {
+		return nil, 0, fmt.Errorf("malformed FTP address %q", s)
+	}
+	// The delimiter is the first character, usually '|'.
+	parts := strings.Split(s, s[:1])
+	if len(parts) != 5 || parts[0] != "" || parts[4] != "" {
+		return nil, 0, fmt.Errorf("malformed FTP address %q", s)
+	}
+	var ip net.IP
+	if parts[2] != "" {
+		ip = net.ParseIP(parts[2])
+		if ip == nil {
+			return nil, 0, fmt.Errorf("malformed IP in FTP address %q", s)
+		}
+	}
+	port, err := strconv.ParseUint(parts[3], 10, 16)
+	if err != nil {
+		return nil, 0, fmt.Errorf("malformed port in FTP address %q: %w", s, err)
+	}
+	return ip, int(port), nil
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxHarnessAttempts is how many times the Checker attempts a check that hits
+// harness errors before it fails the test, whatever the retry policy and the
+// timeout of the check.  It waits for the retry interval between the attempts,
+// like any other retry.
+const maxHarnessAttempts = 3
+
+// HarnessError is a failure of the test harness rather than of the connectivity
+// under test.  For example, docker exec failing because the container has gone
+// away, or test-connection crashing.
+type HarnessError struct {
+	Container string
+	Err       error
+	Stderr    string
+}
+
+func (e *HarnessError) Error() string {
+	msg := fmt.Sprintf("harness error: %v", e.Err)
+	if e.Container != "" {
+		msg = fmt.Sprintf("harness error in container %s: %v", e.Container, e.Err)
+	}
+	if stderr := strings.TrimSpace(e.Stderr); stderr != "" {
+		lines := strings.Split(stderr, "\n")
+		msg += ": " + lines[len(lines)-1]
+	}
+	return msg
+}
+
+func (e *HarnessError) Unwrap() error {
+	return e.Err
+}
+
+// harnessErrors returns the harness errors of the results.
+func harnessErrors(results []*Result) []*HarnessError {
+	var errs []*HarnessError
+	for _, r := range results {
+		if r != nil && r.HarnessErr != nil {
+			errs = append(errs, r.HarnessErr)
+		}
+	}
+	return errs
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"time"
+)
+
+// Attempt records one attempt of CheckConnectivity(), for the OnAttempt,
+// OnExpectationFail and OnFinalFail hooks of the Checker.
+type Attempt struct {
+	// Number counts the attempts from 1.
+	Number   int
+	Start    time.Time
+	Duration time.Duration
+
+	// Results and Pretty hold the result of each expectation, in the order the
+	// expectations were added to the Checker.
+	Results []*Result
+	Pretty  []string
+
+	Mismatches    []MismatchDetail
+	HarnessErrors []*HarnessError
+	FinalTestErr  error
+	Conntrack     []ConntrackMismatch
+	Denials       []DenialMismatch
+	Encap         []EncapMismatch
+	// KnownIssues holds the expectations that failed because of their known
+	// issues, see ExpectWithKnownIssue().  They don't fail the attempt.
+	KnownIssues []KnownIssue
+	// Quarantined holds the expectations that failed on paths that are
+	// quarantined, see QuarantineRule.  They don't fail the attempt.
+	Quarantined []MismatchDetail
+
+	Passed bool
+
+	// The expectations that the attempt checked, in the order of Results, the
+	// indexes of the mismatches are into it.
+	expectations []Expectation
+}
+
+func (c *Checker) reportAttempt(a *Attempt) {
+	if c.OnExpectationFail != nil {
+		for i := range a.Mismatches {
+			c.OnExpectationFail(a, &a.Mismatches[i])
+		}
+	}
+	if c.OnAttempt != nil {
+		c.OnAttempt(a)
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"fmt"
+)
+
+// HostSource is a ConnectionSource that probes from the host network namespace
+// of a felix node, rather than from a workload, so that host endpoint policy can
+// be tested with the same expectations as workload policy.
+type HostSource struct {
+	// Container is the name of the container that runs the node.
+	Container string
+	// IP is the source IP of the probes.  It must belong to the host, unless the
+	// test means to spoof it.  If empty, the kernel picks the source IP.
+	IP string
+	// Interface, if set, is the host interface that the probes' sockets are bound
+	// to, so that they leave through it whatever the routing table says.
+	Interface string
+}
+
+func (h HostSource) SourceName() string {
+	name := "host " + h.Container
+	if h.Interface != "" {
+		name += fmt.Sprintf(" (%s)", h.Interface)
+	}
+	if h.IP != "" {
+		name += " " + h.IP
+	}
+	return name
+}
+
+func (h HostSource) SourceIPs() []string {
+	if h.IP == "" {
+		return nil
+	}
+	return []string{h.IP}
+}
+
+// HostContainerName returns the name of the container of the node.
+func (h HostSource) HostContainerName() string {
+	return h.Container
+}
+
+func (h HostSource) PreRetryCleanup(ip, port, protocol string, opts ...CheckOption) {
+}
+
+func (h HostSource) CanConnectTo(ip, port, protocol string, opts ...CheckOption) *Result {
+	// Our options go first so that those of the expectation can override them.
+	var hostOpts []CheckOption
+	if h.IP != "" {
+		hostOpts = append(hostOpts, WithSourceIP(h.IP))
+	}
+	if h.Interface != "" {
+		hostOpts = append(hostOpts, WithSourceInterface(h.Interface))
+	}
+	opts = append(hostOpts, opts...)
+	return Check(h.Container, "Connection test from host", ip, port, protocol, opts...)
+}
@@ -0,0 +1,178 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"html/template"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/onsi/ginkgo"
+	log "github.com/sirupsen/logrus"
+)
+
+// CheckWithHTMLReport makes the Checker render the expected and actual
+// connectivity as an HTML table, with the sources as rows and the targets as
+// columns, when the check finally fails.  The file is written to a directory per
+// spec under dir and the failure message points at it.
+func CheckWithHTMLReport(dir string) CheckerOpt {
+	return func(c *Checker) {
+		log.Debug("CheckWithHTMLReport set")
+		c.htmlReportDir = dir
+	}
+}
+
+type matrixCell struct {
+	Text  string
+	Title string
+	Class string
+}
+
+type matrixRow struct {
+	Source string
+	Cells  []matrixCell
+}
+
+type matrixReport struct {
+	Spec     string
+	Time     string
+	Targets  []string
+	Rows     []matrixRow
+	Failures int
+	Total    int
+}
+
+var matrixTemplate = template.Must(template.New("matrix").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Connectivity: {{.Spec}}</title>
+<style>
+body { font-family: sans-serif; font-size: 13px; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #999; padding: 3px 6px; text-align: center; }
+th.source { text-align: left; }
+thead th { writing-mode: vertical-rl; transform: rotate(180deg); }
+td.pass { background: #c8e6c9; }
+td.fail { background: #ef9a9a; font-weight: bold; }
+td.none { background: #eee; }
+</style>
+</head>
+<body>
+<h1>{{.Spec}}</h1>
+<p>{{.Failures}} of {{.Total}} expectations failed at {{.Time}}.  Cells show the expected connectivity, then the
+actual connectivity if it differed; hover for details.</p>
+<table>
+<thead><tr><th></th>{{range .Targets}}<th>{{.}}</th>{{end}}</tr></thead>
+<tbody>
+{{range .Rows}}<tr><th class="source">{{.Source}}</th>{{range .Cells}}<td class="{{.Class}}" title="{{.Title}}">{{.Text}}</td>{{end}}</tr>
+{{end}}</tbody>
+</table>
+</body>
+</html>
+`))
+
+func connectivitySymbol(connected bool) string {
+	if connected {
+		return "✔"
+	}
+	return "✘"
+}
+
+// matrixReport arranges the results of the last attempt as a matrix.
+// expPretty and actualPretty are the pretty forms of the expected and actual
+// connectivity.
+func (c *Checker) matrixReport(expectations []Expectation, actual []*Result, expPretty, actualPretty []string,
+	mismatches []MismatchDetail) matrixReport {
+	failed := map[int]bool{}
+	for _, m := range mismatches {
+		failed[m.Index] = true
+	}
+
+	var sources, targets []string
+	sourceIdx := map[string]int{}
+	targetIdx := map[string]int{}
+	for _, exp := range expectations {
+		if _, ok := sourceIdx[exp.sourceName()]; !ok {
+			sourceIdx[exp.sourceName()] = len(sources)
+			sources = append(sources, exp.sourceName())
+		}
+		if _, ok := targetIdx[exp.To.TargetName]; !ok {
+			targetIdx[exp.To.TargetName] = len(targets)
+			targets = append(targets, exp.To.TargetName)
+		}
+	}
+
+	report := matrixReport{
+		Spec:     ginkgo.CurrentGinkgoTestDescription().FullTestText,
+		Time:     time.Now().Format(time.RFC3339),
+		Targets:  targets,
+		Failures: len(failed),
+		Total:    len(expectations),
+	}
+	for _, s := range sources {
+		row := matrixRow{Source: s, Cells: make([]matrixCell, len(targets))}
+		for i := range row.Cells {
+			row.Cells[i].Class = "none"
+		}
+		report.Rows = append(report.Rows, row)
+	}
+	for i, exp := range expectations {
+		cell := &report.Rows[sourceIdx[exp.sourceName()]].Cells[targetIdx[exp.To.TargetName]]
+		text := connectivitySymbol(bool(exp.Expected))
+		if failed[i] {
+			text += " → " + connectivitySymbol(actual[i].HasConnectivity())
+		}
+		title := "Expected: " + expPretty[i] + "\nActual: " + actualPretty[i]
+		if cell.Class == "none" {
+			cell.Class = "pass"
+		} else {
+			// Several expectations between the same source and target, for
+			// example, with different options.
+			cell.Text += " "
+			cell.Title += "\n\n"
+		}
+		if failed[i] {
+			cell.Class = "fail"
+		}
+		cell.Text += text
+		cell.Title += title
+	}
+	return report
+}
+
+// writeHTMLReport writes the matrix of the last attempt and returns the text to
+// add to the failure message.
+func (c *Checker) writeHTMLReport(expectations []Expectation, actual []*Result, expPretty, actualPretty []string,
+	mismatches []MismatchDetail) string {
+	dir := filepath.Join(c.htmlReportDir, specDirName())
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		log.WithError(err).Warn("Failed to create HTML report directory")
+		return ""
+	}
+	file := filepath.Join(dir, "connectivity-"+time.Now().Format("20060102-150405.000")+".html")
+	var out strings.Builder
+	if err := matrixTemplate.Execute(&out, c.matrixReport(expectations, actual, expPretty, actualPretty, mismatches)); err != nil {
+		log.WithError(err).Warn("Failed to render HTML report")
+		return ""
+	}
+	if err := os.WriteFile(file, []byte(out.String()), 0o644); err != nil {
+		log.WithError(err).WithField("file", file).Warn("Failed to write HTML report")
+		return ""
+	}
+	return "\nConnectivity matrix written to " + file + "\n"
+}
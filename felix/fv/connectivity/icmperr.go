@@ -0,0 +1,165 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ICMPError is an ICMP destination unreachable or time exceeded message that the
+// client of a check received about its probes.
+type ICMPError struct {
+	// From is the IP of the node that sent the error, the one that rejected the
+	// probe.
+	From string
+	// V6 is set for ICMPv6, which numbers the types and codes differently.
+	V6   bool `json:",omitempty"`
+	Type int
+	Code int
+}
+
+// AdminProhibited returns whether the error says that the probe was rejected by
+// policy, as opposed to, say, a missing route.
+func (e ICMPError) AdminProhibited() bool {
+	if e.V6 {
+		return e.Type == 1 && e.Code == 1
+	}
+	return e.Type == 3 && (e.Code == 9 || e.Code == 10 || e.Code == 13)
+}
+
+// TTLExceeded returns whether the error says that the probe's TTL ran out, which,
+// for a target that is only a few hops away, means that it was in a loop.
+func (e ICMPError) TTLExceeded() bool {
+	if e.V6 {
+		return e.Type == 3
+	}
+	return e.Type == 11
+}
+
+func (e ICMPError) String() string {
+	return fmt.Sprintf("%s from %s", e.description(), e.From)
+}
+
+func (e ICMPError) description() string {
+	if e.V6 {
+		switch {
+		case e.Type == 1 && e.Code == 1:
+			return "admin-prohibited"
+		case e.Type == 1 && e.Code == 3:
+			return "address-unreachable"
+		case e.Type == 1 && e.Code == 4:
+			return "port-unreachable"
+		case e.Type == 1 && e.Code == 6:
+			return "reject-route"
+		case e.Type == 3:
+			return "ttl-exceeded"
+		}
+		return fmt.Sprintf("ICMPv6 type %d code %d", e.Type, e.Code)
+	}
+	switch {
+	case e.Type == 3 && e.Code == 1:
+		return "host-unreachable"
+	case e.Type == 3 && e.Code == 3:
+		return "port-unreachable"
+	case e.Type == 3 && (e.Code == 9 || e.Code == 10 || e.Code == 13):
+		return "admin-prohibited"
+	case e.Type == 11:
+		return "ttl-exceeded"
+	}
+	return fmt.Sprintf("ICMP type %d code %d", e.Type, e.Code)
+}
+
+// PrintICMPErrors reports the ICMP errors that the client received.
+func PrintICMPErrors(errs []ICMPError) {
+	Message{Type: MessageICMPErrors, ICMPErrors: errs}.PrintToStdout()
+}
+
+// WithICMPObservation makes the client of the check watch for ICMP destination
+// unreachable and time exceeded messages about its probes, and record them in
+// Result.ICMPErrors.
+func WithICMPObservation() CheckOption {
+	return func(c *CheckCmd) {
+		c.observeICMP = true
+	}
+}
+
+// ExpectRejectedWithICMPFrom asserts that the probe was rejected with an ICMP
+// destination unreachable message from ip, "" for any IP, rather than silently
+// dropped, as a policy with a Reject action does.  Use it with ExpectNone(), or
+// Expect(None, ...).
+func ExpectRejectedWithICMPFrom(ip string) ExpectationOption {
+	return func(e *Expectation) {
+		e.icmpReject = true
+		e.icmpRejectFrom = ip
+	}
+}
+
+// rejectedBy returns the ICMP error that the expectation expects the result to
+// have, if any.
+func (e Expectation) rejectedBy(res *Result) *ICMPError {
+	if res == nil {
+		return nil
+	}
+	for i, ie := range res.ICMPErrors {
+		if ie.TTLExceeded() {
+			continue
+		}
+		if e.icmpRejectFrom == "" || ie.From == e.icmpRejectFrom {
+			return &res.ICMPErrors[i]
+		}
+	}
+	return nil
+}
+
+func (e Expectation) icmpPretty(res *Result) string {
+	if res == nil || len(res.ICMPErrors) == 0 {
+		return " (no ICMP error)"
+	}
+	errs := make([]string, len(res.ICMPErrors))
+	for i, ie := range res.ICMPErrors {
+		errs[i] = ie.String()
+	}
+	return " (" + strings.Join(errs, ", ") + ")"
+}
+
+// ExpectNoForwardingLoop asserts that none of the probes' packets ran out of TTL
+// on the way, which, with the few hops of our test topologies, means that a NAT
+// or routing misconfiguration sent them round in a loop.  It fails the
+// expectation, whether connectivity is expected or not, with the nodes that
+// reported the loop, rather than with a generic timeout.
+func ExpectNoForwardingLoop() ExpectationOption {
+	return func(e *Expectation) {
+		e.noForwardingLoop = true
+	}
+}
+
+// forwardingLoop returns the nodes that reported that the probe's TTL ran out.
+func (r *Result) forwardingLoop() []string {
+	if r == nil {
+		return nil
+	}
+	var nodes []string
+	for _, ie := range r.ICMPErrors {
+		if ie.TTLExceeded() && !containsString(nodes, ie.From) {
+			nodes = append(nodes, ie.From)
+		}
+	}
+	return nodes
+}
+
+func loopPretty(nodes []string) string {
+	return " (forwarding loop: TTL exceeded at " + strings.Join(nodes, ", ") + ")"
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import "strings"
+
+// IPv6ExtHeader is an IPv6 extension header that probes can carry, see
+// WithIPv6ExtHeaders().
+type IPv6ExtHeader string
+
+const (
+	// IPv6HopByHop is a hop-by-hop options header with only padding.
+	IPv6HopByHop IPv6ExtHeader = "hop-by-hop"
+	// IPv6DestOpts is a destination options header with only padding.
+	IPv6DestOpts IPv6ExtHeader = "dst-opts"
+	// IPv6Routing is a segment routing header whose only segment is the target.
+	// Linux drops packets with one unless net.ipv6.conf.<iface>.seg6_enabled is
+	// set on the interface that receives them.
+	IPv6Routing IPv6ExtHeader = "routing"
+	// IPv6Fragment fragments UDP probes, which then carry fragment headers.
+	IPv6Fragment IPv6ExtHeader = "fragment"
+)
+
+// WithIPv6ExtHeaders adds the extension headers to the packets of an IPv6 check.
+// Fragment headers are only supported for UDP, whose probes are padded to be
+// fragmented at the minimum IPv6 MTU.
+func WithIPv6ExtHeaders(hdrs ...IPv6ExtHeader) CheckOption {
+	return func(c *CheckCmd) {
+		c.ipv6ExtHeaders = hdrs
+	}
+}
+
+// ExpectWithIPv6ExtHeaders adds the extension headers to the probes of the
+// expectation, see WithIPv6ExtHeaders(), so that it asserts that policy delivers
+// or drops packets with them:
+//
+//	cc.Expect(None, w[0], w[1], ExpectWithIPv6ExtHeaders(IPv6Routing))
+func ExpectWithIPv6ExtHeaders(hdrs ...IPv6ExtHeader) ExpectationOption {
+	return func(e *Expectation) {
+		e.ipv6ExtHeaders = hdrs
+	}
+}
+
+func joinIPv6ExtHeaders(hdrs []IPv6ExtHeader) string {
+	s := make([]string, len(hdrs))
+	for i, h := range hdrs {
+		s[i] = string(h)
+	}
+	return strings.Join(s, ",")
+}
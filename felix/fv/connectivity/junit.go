@@ -0,0 +1,144 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/onsi/ginkgo"
+	log "github.com/sirupsen/logrus"
+)
+
+// CheckWithJUnitReport makes the Checker write a JUnit XML file per spec under
+// dir, with a test suite per run of CheckConnectivity() and a test case per
+// expectation, so that CI shows which paths failed.
+func CheckWithJUnitReport(dir string) CheckerOpt {
+	return func(c *Checker) {
+		log.Debug("CheckWithJUnitReport set")
+		c.junitDir = dir
+	}
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Time      float64         `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      float64       `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// junitSuite converts a run to a test suite.  All the expectations of a run are
+// checked together, so each test case takes the duration of the run.
+func junitSuite(spec string, number int, run RunResults) junitTestSuite {
+	suite := junitTestSuite{
+		Name:      fmt.Sprintf("%s (check %d)", spec, number),
+		Time:      run.Duration.Seconds(),
+		Timestamp: run.Start.Format(time.RFC3339),
+	}
+	var lastPretty []string
+	if len(run.Attempts) > 0 {
+		lastPretty = run.Attempts[len(run.Attempts)-1].Pretty
+	}
+	for i, e := range run.Expectations {
+		tc := junitTestCase{
+			Name:      e.Path(),
+			ClassName: spec,
+			Time:      run.Duration.Seconds(),
+		}
+		if !e.Passed {
+			text := "Expected: " + e.Pretty
+			if i < len(lastPretty) {
+				text += "\nActual:   " + lastPretty[i]
+			}
+			text += fmt.Sprintf("\nAfter %d attempts.", len(run.Attempts))
+			tc.Failure = &junitFailure{
+				Message: e.Path() + " was incorrect",
+				Type:    string(run.Kind),
+				Text:    text,
+			}
+			suite.Failures++
+		}
+		suite.Cases = append(suite.Cases, tc)
+	}
+	if !run.Passed && suite.Failures == 0 {
+		// The run failed on something other than an expectation, such as the
+		// final test.
+		suite.Cases = append(suite.Cases, junitTestCase{
+			Name:      "checks after connectivity",
+			ClassName: spec,
+			Time:      run.Duration.Seconds(),
+			Failure: &junitFailure{
+				Message: fmt.Sprintf("the %s check failed", run.Kind),
+				Type:    string(run.Kind),
+			},
+		})
+		suite.Failures++
+	}
+	suite.Tests = len(suite.Cases)
+	return suite
+}
+
+// writeJUnitReport adds the run to the JUnit report of the spec.
+func (c *Checker) writeJUnitReport(run RunResults) {
+	if err := os.MkdirAll(c.junitDir, 0o755); err != nil {
+		log.WithError(err).Warn("Failed to create JUnit report directory")
+		return
+	}
+	path := filepath.Join(c.junitDir, specDirName()+".xml")
+	var report junitTestSuites
+	if data, err := os.ReadFile(path); err == nil {
+		if err := xml.Unmarshal(data, &report); err != nil {
+			log.WithError(err).WithField("file", path).Warn("Failed to parse JUnit report, overwriting it")
+			report = junitTestSuites{}
+		}
+	} else if !os.IsNotExist(err) {
+		log.WithError(err).WithField("file", path).Warn("Failed to read JUnit report, overwriting it")
+	}
+	spec := ginkgo.CurrentGinkgoTestDescription().FullTestText
+	report.Suites = append(report.Suites, junitSuite(spec, len(report.Suites)+1, run))
+
+	data, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		log.WithError(err).Warn("Failed to encode JUnit report")
+		return
+	}
+	data = append([]byte(xml.Header), data...)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		log.WithError(err).WithField("file", path).Warn("Failed to write JUnit report")
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("junitSuite", func() {
+	start := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	passed := ExpectationResult{
+		Source: "w1", Target: "w2 on port 8055", Expected: Some, Pretty: "w1 -> w2 on port 8055 = true", Passed: true,
+	}
+	failed := ExpectationResult{
+		Source: "w1", Target: "w3 on port 8055", Expected: None, Pretty: "w1 -> w3 on port 8055 = false",
+	}
+	run := func(passedRun bool, kind ErrorKind, exps ...ExpectationResult) RunResults {
+		return RunResults{
+			Start:        start,
+			Duration:     2 * time.Second,
+			Passed:       passedRun,
+			Kind:         kind,
+			Expectations: exps,
+			Attempts: []AttemptResult{
+				{Number: 1, Pretty: []string{"w1 -> w2 on port 8055 = false", "w1 -> w3 on port 8055 = true"}},
+				{Number: 2, Pretty: []string{"w1 -> w2 on port 8055 = true", "w1 -> w3 on port 8055 = true"}},
+			},
+		}
+	}
+
+	DescribeTable("should have a test case per expectation",
+		func(r RunResults, cases []junitTestCase) {
+			suite := junitSuite("policy allows w1 to w2", 2, r)
+			Expect(suite.Name).To(Equal("policy allows w1 to w2 (check 2)"))
+			Expect(suite.Time).To(Equal(2.0))
+			Expect(suite.Timestamp).To(Equal("2023-01-01T12:00:00Z"))
+			Expect(suite.Cases).To(Equal(cases))
+			Expect(suite.Tests).To(Equal(len(cases)))
+			failures := 0
+			for _, tc := range cases {
+				if tc.Failure != nil {
+					failures++
+				}
+			}
+			Expect(suite.Failures).To(Equal(failures))
+		},
+		Entry("a run that passed", run(true, "", passed), []junitTestCase{
+			{Name: "w1 -> w2 on port 8055", ClassName: "policy allows w1 to w2", Time: 2},
+		}),
+		Entry("a failed expectation", run(false, ErrorKindMismatch, passed, failed), []junitTestCase{
+			{Name: "w1 -> w2 on port 8055", ClassName: "policy allows w1 to w2", Time: 2},
+			{Name: "w1 -> w3 on port 8055", ClassName: "policy allows w1 to w2", Time: 2, Failure: &junitFailure{
+				Message: "w1 -> w3 on port 8055 was incorrect",
+				Type:    "mismatch",
+				Text: "Expected: w1 -> w3 on port 8055 = false\n" +
+					"Actual:   w1 -> w3 on port 8055 = true\n" +
+					"After 2 attempts.",
+			}},
+		}),
+		Entry("a run that failed after the expectations passed", run(false, ErrorKindFinalTest, passed),
+			[]junitTestCase{
+				{Name: "w1 -> w2 on port 8055", ClassName: "policy allows w1 to w2", Time: 2},
+				{Name: "checks after connectivity", ClassName: "policy allows w1 to w2", Time: 2, Failure: &junitFailure{
+					Message: "the final-test check failed",
+					Type:    "final-test",
+				}},
+			}),
+	)
+})
@@ -0,0 +1,96 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ExpectWithKnownIssue marks the expectation as failing because of a known
+// issue, for example "CORE-1234", so that the spec keeps covering the path
+// until the issue is fixed.  The expectation is still checked, but its failure
+// is only reported, as a KnownIssue, rather than failing the spec.  If it passes,
+// the spec fails so that the marking is removed once the issue is fixed.  Only
+// the final attempt of the check decides: an attempt where the known issues
+// passed is not retried, while one where they failed is retried, like any other
+// failure, until the retries or the timeout run out.
+func ExpectWithKnownIssue(issue string) ExpectationOption {
+	return func(e *Expectation) {
+		e.knownIssue = issue
+	}
+}
+
+// KnownIssue records an expectation with a known issue that failed, as it was
+// expected to.
+type KnownIssue struct {
+	// Index of the expectation in the order it was added to the Checker.
+	Index    int
+	Issue    string
+	Source   string
+	Target   string
+	Expected Expected
+
+	ExpectedPretty string
+	ActualPretty   string
+}
+
+func (k KnownIssue) String() string {
+	return fmt.Sprintf("%s: %s (expected %v)", k.Issue, k.ActualPretty, k.Expected)
+}
+
+// reportKnownIssues reports the expectations that failed because of their known
+// issues in a check that passed otherwise.
+func reportKnownIssues(issues []KnownIssue) {
+	if len(issues) == 0 {
+		return
+	}
+	strs := make([]string, len(issues))
+	for i, k := range issues {
+		strs[i] = k.String()
+		log.WithFields(log.Fields{
+			"issue":  k.Issue,
+			"source": k.Source,
+			"target": k.Target,
+		}).Warn("Connectivity expectation failed because of a known issue")
+	}
+	AttachToSpec("Known connectivity issues", strs)
+}
+
+// knownIssueExpectations returns the expectations of the known issues of the
+// attempt, whose retry policies decide whether they are retried.
+func knownIssueExpectations(a *Attempt) []Expectation {
+	exps := make([]Expectation, len(a.KnownIssues))
+	for i, k := range a.KnownIssues {
+		exps[i] = a.expectations[k.Index]
+	}
+	return exps
+}
+
+// onlyUnexpectedPasses returns whether the attempt failed only because
+// expectations with known issues passed, which retrying the check can't fix.
+func onlyUnexpectedPasses(a *Attempt) bool {
+	if len(a.Mismatches) == 0 || len(a.HarnessErrors) > 0 || a.FinalTestErr != nil ||
+		len(a.Conntrack) > 0 || len(a.Denials) > 0 || len(a.Encap) > 0 {
+		return false
+	}
+	for _, m := range a.Mismatches {
+		if m.KnownIssue == "" {
+			return false
+		}
+	}
+	return true
+}
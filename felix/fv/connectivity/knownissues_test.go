@@ -0,0 +1,77 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Known issues", func() {
+	fixed := MismatchDetail{Index: 0, KnownIssue: "CORE-1234"}
+	unexpected := MismatchDetail{Index: 1}
+
+	DescribeTable("onlyUnexpectedPasses",
+		func(a Attempt, expected bool) {
+			Expect(onlyUnexpectedPasses(&a)).To(Equal(expected))
+		},
+		Entry("no mismatches", Attempt{}, false),
+		Entry("a known issue that passed", Attempt{Mismatches: []MismatchDetail{fixed}}, true),
+		Entry("an unexpected failure", Attempt{Mismatches: []MismatchDetail{unexpected}}, false),
+		Entry("a known issue that passed and an unexpected failure",
+			Attempt{Mismatches: []MismatchDetail{fixed, unexpected}}, false),
+		Entry("a known issue that passed and a harness error", Attempt{
+			Mismatches:    []MismatchDetail{fixed},
+			HarnessErrors: []*HarnessError{{Container: "w1", Err: errors.New("gone")}},
+		}, false),
+		Entry("a known issue that passed and a failed final test", Attempt{
+			Mismatches:   []MismatchDetail{fixed},
+			FinalTestErr: errors.New("failed"),
+		}, false),
+		Entry("a known issue that passed and a conntrack mismatch", Attempt{
+			Mismatches: []MismatchDetail{fixed},
+			Conntrack:  []ConntrackMismatch{{}},
+		}, false),
+		Entry("a known issue that passed and a denial mismatch", Attempt{
+			Mismatches: []MismatchDetail{fixed},
+			Denials:    []DenialMismatch{{}},
+		}, false),
+		Entry("a known issue that passed and an encapsulation mismatch", Attempt{
+			Mismatches: []MismatchDetail{fixed},
+			Encap:      []EncapMismatch{{}},
+		}, false),
+	)
+
+	It("should return the expectations of the known issues", func() {
+		exps := []Expectation{
+			{From: fakeSource{name: "w1"}},
+			{From: fakeSource{name: "w2"}, knownIssue: "CORE-1234"},
+			{From: fakeSource{name: "w3"}, knownIssue: "CORE-5678"},
+		}
+		a := &Attempt{
+			expectations: exps,
+			KnownIssues:  []KnownIssue{{Index: 2}, {Index: 1}},
+		}
+		Expect(knownIssueExpectations(a)).To(Equal([]Expectation{exps[2], exps[1]}))
+	})
+
+	It("should describe a known issue", func() {
+		k := KnownIssue{Issue: "CORE-1234", Expected: Some, ActualPretty: "w1 -> w2 (no connectivity)"}
+		Expect(k.String()).To(Equal("CORE-1234: w1 -> w2 (no connectivity) (expected true)"))
+	})
+})
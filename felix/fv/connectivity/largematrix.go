@@ -0,0 +1,91 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// CheckWithLazyPretty only describes the expectations that fail an attempt, so
+// that checks of huge matrices don't spend their time formatting the results of
+// the paths that pass.  The rest are described if the check fails.  In the
+// Attempt passed to the hooks, and in the results files, Pretty is "" for the
+// expectations that passed.
+func CheckWithLazyPretty() CheckerOpt {
+	return func(c *Checker) {
+		log.Debug("CheckWithLazyPretty set")
+		c.lazyPretty = true
+	}
+}
+
+// CheckWithResultStream appends the results of each attempt to a file per spec
+// under dir, one JSON AttemptResult per line, as the attempts complete.  Since
+// the results are then on disk, the Checker only keeps the results of the last
+// attempt in memory, the earlier attempts passed to OnFinalFail() and recorded
+// by CheckWithResultsDir() have no Results or Pretty.
+func CheckWithResultStream(dir string) CheckerOpt {
+	return func(c *Checker) {
+		log.Debug("CheckWithResultStream set")
+		c.streamDir = dir
+	}
+}
+
+// describeAll fills in the descriptions of the expectations that a lazy attempt
+// skipped.
+func (c *Checker) describeAll(a *Attempt, expConnectivity []string) {
+	for i, exp := range a.expectations {
+		if i >= len(a.Results) || a.Pretty[i] != "" {
+			continue
+		}
+		a.Pretty[i] = c.resultPretty(exp, a.Results[i])
+		expConnectivity[i] = c.expectedPretty(exp)
+	}
+}
+
+// streamAttempt appends the attempt to the stream file of the spec.
+func (c *Checker) streamAttempt(a *Attempt) {
+	if err := os.MkdirAll(c.streamDir, 0o755); err != nil {
+		log.WithError(err).Warn("Failed to create result stream directory")
+		return
+	}
+	path := filepath.Join(c.streamDir, specDirName()+".jsonl")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		log.WithError(err).WithField("file", path).Warn("Failed to open result stream")
+		return
+	}
+	defer f.Close()
+	err = json.NewEncoder(f).Encode(AttemptResult{
+		Number:   a.Number,
+		Start:    a.Start,
+		Duration: a.Duration,
+		Passed:   a.Passed,
+		Results:  a.Results,
+		Pretty:   a.Pretty,
+	})
+	if err != nil {
+		log.WithError(err).WithField("file", path).Warn("Failed to write result stream")
+	}
+}
+
+// forgetResults drops the results of an attempt that has been streamed.
+func forgetResults(a *Attempt) {
+	a.Results = nil
+	a.Pretty = nil
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import "fmt"
+
+// WithLargeSend makes the client of a TCP check send bytes of extra data in a
+// single write after its request, so that the stack hands GSO packets of up to
+// 64KiB to the devices on the path, and asks the server to report how the data
+// arrived, in Response.LargeSend.  Tunnel devices that mishandle GSO drop big
+// packets silently, which stalls the check.
+func WithLargeSend(bytes int) CheckOption {
+	return func(c *CheckCmd) {
+		c.largeSend = bytes
+	}
+}
+
+// ExpectWithLargeSend checks the connectivity with a large send, see
+// WithLargeSend(), and asserts that the server received all of the data.
+func ExpectWithLargeSend(bytes int) ExpectationOption {
+	return func(e *Expectation) {
+		e.largeSend = bytes
+	}
+}
+
+// ExpectMaxReceivedSegment asserts that the segments of a large send, see
+// ExpectWithLargeSend(), were no bigger than size when they reached the server,
+// for example, to check that the MSS allows for the encap overhead.
+func ExpectMaxReceivedSegment(size int) ExpectationOption {
+	return func(e *Expectation) {
+		e.maxReceivedSegment = size
+	}
+}
+
+// LargeSendStats describes how the server received the extra data of a large
+// send, see WithLargeSend().
+type LargeSendStats struct {
+	// Bytes is how much of the data the server received.
+	Bytes int
+	// Reads is how many reads the server took to receive it and MaxRead the
+	// biggest of them.
+	Reads   int
+	MaxRead int
+	// SegmentSize is the size of the biggest segments that the server
+	// received, before GRO merged them, as the kernel measures it to estimate
+	// the MSS of the client.
+	SegmentSize int
+}
+
+// AddRead records a read of n bytes.
+func (s *LargeSendStats) AddRead(n int) {
+	s.Bytes += n
+	s.Reads++
+	if n > s.MaxRead {
+		s.MaxRead = n
+	}
+}
+
+func (e Expectation) largeSendMatches(res *Result) bool {
+	if e.largeSend == 0 {
+		return true
+	}
+	stats := res.LastResponse.LargeSend
+	if stats == nil || stats.Bytes != e.largeSend {
+		return false
+	}
+	return e.maxReceivedSegment == 0 || (stats.SegmentSize > 0 && stats.SegmentSize <= e.maxReceivedSegment)
+}
+
+func largeSendPretty(res *Result) string {
+	stats := res.LastResponse.LargeSend
+	if stats == nil {
+		return " (large send: not reported)"
+	}
+	return fmt.Sprintf(" (large send: %d bytes in %d reads, max read %d, segments %d bytes)",
+		stats.Bytes, stats.Reads, stats.MaxRead, stats.SegmentSize)
+}
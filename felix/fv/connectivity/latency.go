@@ -0,0 +1,150 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"fmt"
+	"time"
+)
+
+// LatencySample holds the timestamps of one request/response exchange.  Sent and
+// Received are taken from the client's clock, Server from the server's.
+type LatencySample struct {
+	Sent     time.Time
+	Server   time.Time
+	Received time.Time
+}
+
+func (s LatencySample) RTT() time.Duration {
+	return s.Received.Sub(s.Sent)
+}
+
+// EstimateClockOffset estimates how far the server's clock is ahead of the
+// client's from the samples of a calibration handshake.
+//
+// It uses the sample with the lowest RTT, which is the least affected by
+// queueing.  Like NTP, it assumes that the server's timestamp is at the midpoint
+// of the round trip of that sample, so the estimate is off by half the
+// asymmetry of its delays.
+func EstimateClockOffset(calibration []LatencySample) time.Duration {
+	if len(calibration) == 0 {
+		return 0
+	}
+	best := calibration[0]
+	for _, s := range calibration[1:] {
+		if s.RTT() < best.RTT() {
+			best = s
+		}
+	}
+	forward := best.Server.Sub(best.Sent)
+	reverse := best.Received.Sub(best.Server)
+	return (forward - reverse) / 2
+}
+
+// OneWayLatency accumulates the estimated one-way delays of a series of
+// exchanges, compensated for the ClockOffset between client and server.
+type OneWayLatency struct {
+	ClockOffset time.Duration
+	Samples     int
+
+	ForwardMean time.Duration // client to server
+	ForwardMax  time.Duration
+	ReverseMean time.Duration // server to client
+	ReverseMax  time.Duration
+
+	forwardTotal time.Duration
+	reverseTotal time.Duration
+}
+
+func NewOneWayLatency(clockOffset time.Duration) *OneWayLatency {
+	return &OneWayLatency{
+		ClockOffset: clockOffset,
+	}
+}
+
+func (l *OneWayLatency) Add(s LatencySample) {
+	forward := s.Server.Sub(s.Sent) - l.ClockOffset
+	reverse := s.Received.Sub(s.Server) + l.ClockOffset
+
+	l.Samples++
+	l.forwardTotal += forward
+	l.reverseTotal += reverse
+	l.ForwardMean = l.forwardTotal / time.Duration(l.Samples)
+	l.ReverseMean = l.reverseTotal / time.Duration(l.Samples)
+	if forward > l.ForwardMax {
+		l.ForwardMax = forward
+	}
+	if reverse > l.ReverseMax {
+		l.ReverseMax = reverse
+	}
+}
+
+// ExpectMaxOneWayLatency asserts that the worst estimated one-way delay of a
+// packet loss test (see ExpectWithLoss) or a long-lived connection stays within
+// the bound in each direction, forward being from the client to the server.
+// Zero doesn't check that direction.  Only the probes of expectations with a
+// bound do the calibration handshake that the estimate needs.
+func ExpectMaxOneWayLatency(forward, reverse time.Duration) ExpectationOption {
+	return func(e *Expectation) {
+		e.maxForwardLatency = forward
+		e.maxReverseLatency = reverse
+	}
+}
+
+// WithOneWayLatency tells test-connection to calibrate its clock against the
+// server's before a packet loss test or long-lived connection, and to report
+// the one-way latency of the test.
+func WithOneWayLatency() CheckOption {
+	return func(c *CheckCmd) {
+		c.oneWayLatency = true
+	}
+}
+
+func (e Expectation) checksOneWayLatency() bool {
+	return e.maxForwardLatency > 0 || e.maxReverseLatency > 0
+}
+
+func (e Expectation) oneWayLatencyMatches(res *Result) bool {
+	if !e.checksOneWayLatency() {
+		return true
+	}
+	l := res.Stats.OneWay
+	if l == nil || l.Samples == 0 {
+		return false
+	}
+	if e.maxForwardLatency > 0 && l.ForwardMax > e.maxForwardLatency {
+		return false
+	}
+	if e.maxReverseLatency > 0 && l.ReverseMax > e.maxReverseLatency {
+		return false
+	}
+	return true
+}
+
+func (e Expectation) oneWayLatencyPretty() string {
+	if !e.checksOneWayLatency() {
+		return ""
+	}
+	return fmt.Sprintf(" (max one-way latency: %v forward, %v reverse)", e.maxForwardLatency, e.maxReverseLatency)
+}
+
+func oneWayLatencyPretty(res *Result) string {
+	l := res.Stats.OneWay
+	if l == nil {
+		return " (one-way latency: unknown)"
+	}
+	return fmt.Sprintf(" (one-way latency: max %v forward, %v reverse, clock offset %v)",
+		l.ForwardMax, l.ReverseMax, l.ClockOffset)
+}
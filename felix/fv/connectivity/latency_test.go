@@ -0,0 +1,104 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/projectcalico/calico/felix/fv/connectivity"
+)
+
+var _ = Describe("One-way latency", func() {
+	t0 := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	ms := time.Millisecond
+
+	// sample returns an exchange sent at t0+at that took forward and reverse,
+	// with the server's clock ahead of the client's by offset.
+	sample := func(at, forward, reverse, offset time.Duration) LatencySample {
+		return LatencySample{
+			Sent:     t0.Add(at),
+			Server:   t0.Add(at + forward + offset),
+			Received: t0.Add(at + forward + reverse),
+		}
+	}
+
+	Describe("EstimateClockOffset", func() {
+		It("should be zero without samples", func() {
+			Expect(EstimateClockOffset(nil)).To(BeZero())
+		})
+
+		It("should be zero for a shared clock and a symmetric path", func() {
+			Expect(EstimateClockOffset([]LatencySample{
+				sample(0, 2*ms, 2*ms, 0),
+				sample(10*ms, 5*ms, 2*ms, 0),
+			})).To(BeZero())
+		})
+
+		It("should estimate a small skew that leaves both raw delays positive", func() {
+			Expect(EstimateClockOffset([]LatencySample{
+				sample(0, 4*ms, 4*ms, 2*ms),
+				sample(30*ms, 9*ms, 6*ms, 2*ms),
+			})).To(Equal(2 * ms))
+		})
+
+		It("should be off by half the asymmetry of the fastest exchange", func() {
+			Expect(EstimateClockOffset([]LatencySample{
+				sample(0, 10*ms, 10*ms, 2*ms),
+				sample(30*ms, 3*ms, 1*ms, 2*ms),
+				sample(60*ms, 1*ms, 7*ms, 2*ms),
+			})).To(Equal(3 * ms))
+		})
+
+		It("should estimate a server clock that is behind from the fastest exchange", func() {
+			Expect(EstimateClockOffset([]LatencySample{
+				sample(0, 10*ms, 10*ms, -50*ms),
+				sample(30*ms, 2*ms, 2*ms, -50*ms),
+				sample(60*ms, 8*ms, 1*ms, -50*ms),
+			})).To(Equal(-50 * ms))
+		})
+
+		It("should estimate a server clock that is ahead from the fastest exchange", func() {
+			Expect(EstimateClockOffset([]LatencySample{
+				sample(0, 1*ms, 1*ms, 40*ms),
+				sample(30*ms, 7*ms, 3*ms, 40*ms),
+			})).To(Equal(40 * ms))
+		})
+	})
+
+	Describe("OneWayLatency", func() {
+		It("should accumulate the compensated delays", func() {
+			l := NewOneWayLatency(-50 * ms)
+			l.Add(sample(0, 2*ms, 4*ms, -50*ms))
+			l.Add(sample(10*ms, 6*ms, 2*ms, -50*ms))
+			l.Add(sample(20*ms, 4*ms, 3*ms, -50*ms))
+
+			Expect(l.Samples).To(Equal(3))
+			Expect(l.ForwardMean).To(Equal(4 * ms))
+			Expect(l.ForwardMax).To(Equal(6 * ms))
+			Expect(l.ReverseMean).To(Equal(3 * ms))
+			Expect(l.ReverseMax).To(Equal(4 * ms))
+		})
+
+		It("should start empty", func() {
+			l := NewOneWayLatency(0)
+			Expect(l.Samples).To(BeZero())
+			Expect(l.ForwardMax).To(BeZero())
+			Expect(l.ReverseMax).To(BeZero())
+		})
+	})
+})
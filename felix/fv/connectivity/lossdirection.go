@@ -0,0 +1,145 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"fmt"
+
+	. "github.com/onsi/gomega"
+)
+
+// RequestsLost returns how many of the requests of the test didn't reach the
+// server, going by the server's count of the requests it received.  If no
+// response came back, or the server doesn't count requests, the server's count
+// is unknown and all of the loss is put down to requests.
+func (s Stats) RequestsLost() int {
+	return s.RequestsSent - s.requestsDelivered()
+}
+
+// ResponsesLost returns how many responses to requests that reached the server
+// didn't make it back to the client.
+func (s Stats) ResponsesLost() int {
+	return s.requestsDelivered() - s.ResponsesReceived
+}
+
+// RequestLossPercent returns RequestsLost() as a percentage of the requests
+// sent.
+func (s Stats) RequestLossPercent() float64 {
+	if s.RequestsSent == 0 {
+		return 0
+	}
+	return float64(s.RequestsLost()) * 100.0 / float64(s.RequestsSent)
+}
+
+// ResponseLossPercent returns ResponsesLost() as a percentage of the requests
+// that reached the server.
+func (s Stats) ResponseLossPercent() float64 {
+	delivered := s.requestsDelivered()
+	if delivered == 0 {
+		return 0
+	}
+	return float64(s.ResponsesLost()) * 100.0 / float64(delivered)
+}
+
+// requestsDelivered clamps the server's count between the responses received
+// and the requests sent, the server counts duplicated requests and its count
+// lags behind for requests whose responses were lost at the end of the test.
+func (s Stats) requestsDelivered() int {
+	delivered := s.RequestsDelivered
+	if delivered > s.RequestsSent {
+		delivered = s.RequestsSent
+	}
+	if delivered < s.ResponsesReceived {
+		delivered = s.ResponsesReceived
+	}
+	return delivered
+}
+
+// ExpectMaxRequestLoss asserts that at most the given percentage of the
+// requests of a packet loss test (see ExpectWithLoss) were lost on the way to
+// the server, whatever happened to the responses.  Use it with
+// ExpectMaxResponseLoss() to tell asymmetric loss, for example, from a policy
+// that drops the return traffic, from loss in both directions.
+func ExpectMaxRequestLoss(percent float64) ExpectationOption {
+	Expect(percent).To(BeNumerically(">=", 0), "Max request loss should be >=0")
+
+	return func(e *Expectation) {
+		e.checkRequestLoss = true
+		e.maxRequestLoss = percent
+	}
+}
+
+// ExpectMaxResponseLoss asserts that at most the given percentage of the
+// responses to requests that reached the server were lost on the way back.
+func ExpectMaxResponseLoss(percent float64) ExpectationOption {
+	Expect(percent).To(BeNumerically(">=", 0), "Max response loss should be >=0")
+
+	return func(e *Expectation) {
+		e.checkResponseLoss = true
+		e.maxResponseLoss = percent
+	}
+}
+
+// ExpectMinBytes asserts that the client sent and received at least the given
+// number of bytes in total, including the extra data of WithSendLen() and
+// WithRecvLen().  Zero doesn't check that direction.
+func ExpectMinBytes(sent, received int64) ExpectationOption {
+	return func(e *Expectation) {
+		e.minBytesSent = sent
+		e.minBytesReceived = received
+	}
+}
+
+func (e Expectation) lossDirectionMatches(res *Result) bool {
+	if e.checkRequestLoss && res.Stats.RequestLossPercent() > e.maxRequestLoss {
+		return false
+	}
+	if e.checkResponseLoss && res.Stats.ResponseLossPercent() > e.maxResponseLoss {
+		return false
+	}
+	return true
+}
+
+func (e Expectation) bytesMatch(res *Result) bool {
+	return res.Stats.BytesSent >= e.minBytesSent && res.Stats.BytesReceived >= e.minBytesReceived
+}
+
+func (e Expectation) lossDirectionPretty() string {
+	result := ""
+	if e.checkRequestLoss {
+		result += fmt.Sprintf(" (maxLoss to server: %.1f%%)", e.maxRequestLoss)
+	}
+	if e.checkResponseLoss {
+		result += fmt.Sprintf(" (maxLoss from server: %.1f%%)", e.maxResponseLoss)
+	}
+	return result
+}
+
+func (e Expectation) bytesPretty() string {
+	if e.minBytesSent == 0 && e.minBytesReceived == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (min bytes: %d sent, %d received)", e.minBytesSent, e.minBytesReceived)
+}
+
+func lossDirectionPretty(res *Result) string {
+	return fmt.Sprintf(" (lost to server: %d / %.1f%%, lost from server: %d / %.1f%%)",
+		res.Stats.RequestsLost(), res.Stats.RequestLossPercent(),
+		res.Stats.ResponsesLost(), res.Stats.ResponseLossPercent())
+}
+
+func bytesPretty(res *Result) string {
+	return fmt.Sprintf(" (bytes: %d sent, %d received)", res.Stats.BytesSent, res.Stats.BytesReceived)
+}
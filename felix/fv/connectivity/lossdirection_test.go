@@ -0,0 +1,51 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	. "github.com/projectcalico/calico/felix/fv/connectivity"
+)
+
+var _ = Describe("Stats loss direction", func() {
+	DescribeTable("should split the loss between requests and responses",
+		func(s Stats, requestsLost, responsesLost int, requestPercent, responsePercent float64) {
+			Expect(s.RequestsLost()).To(Equal(requestsLost), "requests lost")
+			Expect(s.ResponsesLost()).To(Equal(responsesLost), "responses lost")
+			Expect(s.RequestLossPercent()).To(BeNumerically("~", requestPercent, 0.001), "request loss percent")
+			Expect(s.ResponseLossPercent()).To(BeNumerically("~", responsePercent, 0.001), "response loss percent")
+			Expect(s.RequestsLost()+s.ResponsesLost()).To(Equal(s.Lost()), "total")
+		},
+		Entry("no loss",
+			Stats{RequestsSent: 100, ResponsesReceived: 100, RequestsDelivered: 100}, 0, 0, 0.0, 0.0),
+		Entry("lost requests",
+			Stats{RequestsSent: 100, ResponsesReceived: 90, RequestsDelivered: 90}, 10, 0, 10.0, 0.0),
+		Entry("lost responses",
+			Stats{RequestsSent: 100, ResponsesReceived: 80, RequestsDelivered: 100}, 0, 20, 0.0, 20.0),
+		Entry("both",
+			Stats{RequestsSent: 100, ResponsesReceived: 72, RequestsDelivered: 80}, 20, 8, 20.0, 10.0),
+		Entry("server count unknown, all put down to requests",
+			Stats{RequestsSent: 100, ResponsesReceived: 90}, 10, 0, 10.0, 0.0),
+		Entry("server counted duplicated requests",
+			Stats{RequestsSent: 100, ResponsesReceived: 95, RequestsDelivered: 103}, 0, 5, 0.0, 5.0),
+		Entry("nothing came back",
+			Stats{RequestsSent: 100}, 100, 0, 100.0, 0.0),
+		Entry("nothing sent",
+			Stats{}, 0, 0, 0.0, 0.0),
+	)
+})
@@ -0,0 +1,215 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// MetricsSource reads a counter of the packets that policy denied on a host,
+// identified by the name of its container.
+type MetricsSource interface {
+	DeniedPackets(host string) (float64, error)
+}
+
+// PrometheusMetricsSource is a MetricsSource that scrapes the metrics endpoint
+// of the hosts and sums all the series of a counter.
+type PrometheusMetricsSource struct {
+	// Metric is the name of the counter, without labels.
+	Metric string
+	// Port of the metrics endpoint.
+	Port int
+	// HostIP returns the IP of the metrics endpoint of a host.
+	HostIP func(host string) string
+}
+
+func (s PrometheusMetricsSource) DeniedPackets(host string) (float64, error) {
+	client := http.Client{Timeout: time.Second}
+	defer client.CloseIdleConnections()
+	resp, err := client.Get(fmt.Sprintf("http://%s:%d/metrics", s.HostIP(host), s.Port))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("bad response (%v) from metrics server", resp.StatusCode)
+	}
+
+	var total float64
+	found := false
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		name := line
+		if i := strings.IndexAny(line, "{ "); i >= 0 {
+			name = line[:i]
+		}
+		if name != s.Metric {
+			continue
+		}
+		fields := strings.Fields(line[strings.LastIndex(line, "}")+1:])
+		if len(fields) == 0 {
+			continue
+		}
+		v, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return 0, fmt.Errorf("bad value of %s: %w", s.Metric, err)
+		}
+		total += v
+		found = true
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	if !found {
+		return 0, fmt.Errorf("metric %s not found", s.Metric)
+	}
+	return total, nil
+}
+
+// CheckWithDeniedPacketMetrics makes the Checker prove that the traffic of the
+// expectations of no connectivity was dropped by policy rather than lost
+// elsewhere: it reads the denied-packet counters of the hosts of their paths
+// before and after each attempt, and the check fails unless a counter
+// incremented on one of the hosts of each path.
+func CheckWithDeniedPacketMetrics(src MetricsSource) CheckerOpt {
+	return func(c *Checker) {
+		log.Debug("CheckWithDeniedPacketMetrics set")
+		c.metricsSource = src
+	}
+}
+
+// DenialMismatch describes an expectation of no connectivity whose traffic was
+// not counted as denied by policy.
+type DenialMismatch struct {
+	// Index of the expectation in the order it was added to the Checker.
+	Index  int
+	Source string
+	Target string
+	// Deltas holds the increments of the denied-packet counters, per host.
+	Deltas map[string]float64
+	// Err is set if the counters could not be read.
+	Err error
+}
+
+func (m DenialMismatch) String() string {
+	if m.Err != nil {
+		return fmt.Sprintf("%s -> %s <---- failed to read denied packets: %v", m.Source, m.Target, m.Err)
+	}
+	return fmt.Sprintf("%s -> %s <---- WRONG, no packets denied by policy: %v", m.Source, m.Target, m.Deltas)
+}
+
+// deniedPacketsSnapshot holds the denied-packet counter of each host, or the
+// error reading it.
+type deniedPacketsSnapshot struct {
+	counts map[string]float64
+	errs   map[string]error
+}
+
+// deniedExpectationHosts returns the hosts of the paths of the expectations of
+// no connectivity.
+func deniedExpectationHosts(expectations []Expectation) []string {
+	var hosts []string
+	seen := map[string]bool{}
+	for _, exp := range expectations {
+		if exp.Expected {
+			continue
+		}
+		for _, h := range pathHosts(exp) {
+			if !seen[h] {
+				seen[h] = true
+				hosts = append(hosts, h)
+			}
+		}
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+func (c *Checker) snapshotDeniedPackets(hosts []string) deniedPacketsSnapshot {
+	snap := deniedPacketsSnapshot{
+		counts: map[string]float64{},
+		errs:   map[string]error{},
+	}
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	for _, host := range hosts {
+		wg.Add(1)
+		go func(host string) {
+			defer wg.Done()
+			count, err := c.metricsSource.DeniedPackets(host)
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil {
+				snap.errs[host] = err
+				return
+			}
+			snap.counts[host] = count
+		}(host)
+	}
+	wg.Wait()
+	return snap
+}
+
+// denialMismatches compares the counters from before and after an attempt.
+func (c *Checker) denialMismatches(expectations []Expectation, before, after deniedPacketsSnapshot) []DenialMismatch {
+	var mismatches []DenialMismatch
+	for i, exp := range expectations {
+		if exp.Expected {
+			continue
+		}
+		m := DenialMismatch{
+			Index:  i,
+			Source: exp.sourceName(),
+			Target: exp.To.TargetName,
+			Deltas: map[string]float64{},
+		}
+		hosts := pathHosts(exp)
+		if len(hosts) == 0 {
+			m.Err = fmt.Errorf("the hosts of the path are unknown")
+		}
+		denied := false
+		for _, h := range hosts {
+			if err := before.errs[h]; err != nil {
+				m.Err = err
+				break
+			}
+			if err := after.errs[h]; err != nil {
+				m.Err = err
+				break
+			}
+			m.Deltas[h] = after.counts[h] - before.counts[h]
+			if m.Deltas[h] > 0 {
+				denied = true
+			}
+		}
+		if m.Err != nil || !denied {
+			mismatches = append(mismatches, m)
+		}
+	}
+	return mismatches
+}
@@ -0,0 +1,68 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import "fmt"
+
+// ExpectClientMTUBetween asserts that the MTU of the client's connection was
+// between lo and hi, inclusive, at the end of the transfer.  Unlike
+// ExpectWithClientAdjustedMTU(), it allows for the MTU differing a little
+// between kernels and encap modes.
+func ExpectClientMTUBetween(lo, hi int) ExpectationOption {
+	return func(e *Expectation) {
+		e.clientMTUMin = lo
+		e.clientMTUMax = hi
+	}
+}
+
+// ExpectClientMTUDecreased asserts that the MTU of the client's connection was
+// lower at the end of the transfer than at the start, for example, because
+// path MTU discovery found a smaller MTU on the path, whatever it was.
+func ExpectClientMTUDecreased() ExpectationOption {
+	return func(e *Expectation) {
+		e.clientMTUDecreased = true
+	}
+}
+
+func (e Expectation) clientMTUMatches(res *Result) bool {
+	mtu := res.ClientMTU
+	if e.clientMTUStart != 0 && e.clientMTUStart != mtu.Start {
+		return false
+	}
+	if e.clientMTUEnd != 0 && e.clientMTUEnd != mtu.End {
+		return false
+	}
+	if (e.clientMTUMin != 0 || e.clientMTUMax != 0) && (mtu.End < e.clientMTUMin || mtu.End > e.clientMTUMax) {
+		return false
+	}
+	if e.clientMTUDecreased && (mtu.Start == 0 || mtu.End >= mtu.Start) {
+		return false
+	}
+	return true
+}
+
+func (e Expectation) clientMTUPretty() string {
+	result := ""
+	if e.clientMTUStart != 0 || e.clientMTUEnd != 0 {
+		result += fmt.Sprintf(" (client MTU %d -> %d)", e.clientMTUStart, e.clientMTUEnd)
+	}
+	if e.clientMTUMin != 0 || e.clientMTUMax != 0 {
+		result += fmt.Sprintf(" (client MTU in %d-%d)", e.clientMTUMin, e.clientMTUMax)
+	}
+	if e.clientMTUDecreased {
+		result += " (client MTU decreased)"
+	}
+	return result
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"fmt"
+	"net"
+
+	. "github.com/onsi/gomega"
+)
+
+// NAT64WellKnownPrefix is the prefix of RFC 6052 that NAT64 gateways use unless
+// configured otherwise.
+const NAT64WellKnownPrefix = "64:ff9b::/96"
+
+// NAT64Address returns the IPv6 address that represents the IPv4 address behind a
+// NAT64 gateway with the given /96 prefix.
+func NAT64Address(prefix, ipv4 string) (string, error) {
+	_, cidr, err := net.ParseCIDR(prefix)
+	if err != nil {
+		return "", err
+	}
+	if ones, bits := cidr.Mask.Size(); ones != 96 || bits != 128 {
+		return "", fmt.Errorf("NAT64 prefix %s is not an IPv6 /96", prefix)
+	}
+	ip := net.ParseIP(ipv4).To4()
+	if ip == nil {
+		return "", fmt.Errorf("%s is not an IPv4 address", ipv4)
+	}
+	addr := make(net.IP, net.IPv6len)
+	copy(addr, cidr.IP)
+	copy(addr[12:], ip)
+	return addr.String(), nil
+}
+
+// TargetNAT64 is an IPv4 target that an IPv6-only source reaches through a NAT64
+// gateway, at the address that embeds the IPv4 address in the Prefix, by
+// default NAT64WellKnownPrefix.
+type TargetNAT64 struct {
+	IP     string
+	Prefix string
+}
+
+func (t TargetNAT64) ToMatcher(explicitPort ...uint16) *Matcher {
+	prefix := t.Prefix
+	if prefix == "" {
+		prefix = NAT64WellKnownPrefix
+	}
+	addr, err := NAT64Address(prefix, t.IP)
+	Expect(err).NotTo(HaveOccurred())
+	m := TargetIP(addr).ToMatcher(explicitPort...)
+	m.TargetName = fmt.Sprintf("%s (NAT64 %s)", m.TargetName, t.IP)
+	return m
+}
+
+// AddressTranslation records the addresses of a connection as the client and as
+// the server saw them, so that tests can assert on NAT64, NAT46 or any other
+// translation on the path.
+type AddressTranslation struct {
+	// OriginalSource and OriginalDestination are the IPs of the client's socket.
+	OriginalSource      string
+	OriginalDestination string
+	// TranslatedSource and TranslatedDestination are the IPs of the server's
+	// socket.
+	TranslatedSource      string
+	TranslatedDestination string
+}
+
+func (t AddressTranslation) Translated() bool {
+	return t.OriginalSource != t.TranslatedSource || t.OriginalDestination != t.TranslatedDestination
+}
+
+// CrossFamily returns whether the connection was translated between IPv4 and
+// IPv6, as by NAT64 or NAT46.
+func (t AddressTranslation) CrossFamily() bool {
+	return isIPv6(t.OriginalDestination) != isIPv6(t.TranslatedDestination)
+}
+
+func isIPv6(ip string) bool {
+	parsed := net.ParseIP(ip)
+	return parsed != nil && parsed.To4() == nil
+}
+
+func (t AddressTranslation) String() string {
+	return fmt.Sprintf("%s -> %s translated to %s -> %s",
+		t.OriginalSource, t.OriginalDestination, t.TranslatedSource, t.TranslatedDestination)
+}
+
+// translationOf works out the translation of a successful connection from the
+// addresses that the client and the server reported.
+func translationOf(exp Expectation, res *Result) *AddressTranslation {
+	if !res.HasConnectivity() || res.ClientAddr == "" {
+		return nil
+	}
+	dst := exp.To.IP
+	if res.ResolvedIP != "" {
+		dst = res.ResolvedIP
+	}
+	return &AddressTranslation{
+		OriginalSource:        hostOf(res.ClientAddr),
+		OriginalDestination:   dst,
+		TranslatedSource:      hostOf(res.LastResponse.SourceAddr),
+		TranslatedDestination: res.LastResponse.ServerIP(),
+	}
+}
+
+func hostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// ExpectNAT64 asserts that the IPv6 source can reach the IPv4 target through a
+// NAT64 gateway with the given /96 prefix, "" for NAT64WellKnownPrefix, and that
+// the server saw the connection on its IPv4 address.
+func (c *Checker) ExpectNAT64(from ConnectionSource, ipv4 string, prefix string, port uint16,
+	opts ...ExpectationOption) {
+	opts = append([]ExpectationOption{
+		ExpectWithPorts(port),
+		ExpectWithServerIPs(ipv4),
+	}, opts...)
+	c.expect(Some, from, TargetNAT64{IP: ipv4, Prefix: prefix}, opts...)
+}
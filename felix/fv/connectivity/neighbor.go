@@ -0,0 +1,33 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+// Protocols of L2 probes.  With Checker.Protocol set to one of them, each check
+// sends a single ARP request, or NDP neighbor solicitation, for the target's IP
+// out of the source's eth0, or the interface of WithSourceInterface(), and has
+// connectivity if a reply arrives.  The port of the target is ignored.  Combine
+// with ExpectWithSourceMAC(), and a source that claims another IP, such as a
+// SpoofedWorkload, to check that spoofed requests go unanswered, or check which
+// IPs proxy ARP answers for.
+const (
+	ProtocolARP = "arp"
+	ProtocolNDP = "ndp"
+)
+
+// NeighborReply is the reply to an ARP or NDP probe.
+type NeighborReply struct {
+	// MAC is the MAC that the reply claims for the target IP.
+	MAC string
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"math/rand"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// CheckWithSortedExpectations sorts the expectations by source, target and port
+// before the check, so that the output is the same across runs, whatever the
+// order that the expectations were added in.
+func CheckWithSortedExpectations() CheckerOpt {
+	return func(c *Checker) {
+		log.Debug("CheckWithSortedExpectations set")
+		c.sortExpectations = true
+	}
+}
+
+// CheckWithShuffledProbes starts the probes of each attempt in a different
+// random order, to flush out bugs that depend on the order, for example,
+// conntrack entries created by earlier probes masking later failures.  The
+// results are still reported in the order of the expectations.  The seed is
+// logged; pass it to reproduce the orders of a run, or 0 for a random seed.
+func CheckWithShuffledProbes(seed int64) CheckerOpt {
+	return func(c *Checker) {
+		log.Debug("CheckWithShuffledProbes set")
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		log.WithField("seed", seed).Info("Shuffling the order of connectivity probes")
+		c.shuffleRand = rand.New(rand.NewSource(seed))
+	}
+}
+
+// sortExpectationsIfNeeded sorts the expectations, if CheckWithSortedExpectations
+// is set.
+func (c *Checker) sortExpectationsIfNeeded() {
+	if !c.sortExpectations {
+		return
+	}
+	c.expectationsMutex().Lock()
+	defer c.expectationsMutex().Unlock()
+	sort.SliceStable(c.expectations, func(i, j int) bool {
+		a, b := c.expectations[i], c.expectations[j]
+		if a.sourceName() != b.sourceName() {
+			return a.sourceName() < b.sourceName()
+		}
+		if a.To.TargetName != b.To.TargetName {
+			return a.To.TargetName < b.To.TargetName
+		}
+		if a.To.IP != b.To.IP {
+			return a.To.IP < b.To.IP
+		}
+		return a.To.Port < b.To.Port
+	})
+}
+
+// probeOrder returns the order to start the probes of n expectations in.
+func (c *Checker) probeOrder(n int) []int {
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	if c.shuffleRand != nil {
+		c.shuffleRand.Shuffle(n, func(i, j int) {
+			order[i], order[j] = order[j], order[i]
+		})
+		log.WithField("order", order).Debug("Shuffled connectivity probes")
+	}
+	return order
+}
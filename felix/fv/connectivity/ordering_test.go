@@ -0,0 +1,97 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Expectation ordering", func() {
+	w1 := fakeSource{name: "w1"}
+	w2 := fakeSource{name: "w2"}
+
+	// paths returns the paths of the expectations of the Checker, in order.
+	paths := func(c *Checker) []string {
+		var paths []string
+		for _, e := range c.expectations {
+			paths = append(paths, fmt.Sprintf("%s -> %s:%s", e.sourceName(), e.To.IP, e.To.Port))
+		}
+		return paths
+	}
+
+	DescribeTable("sortExpectationsIfNeeded",
+		func(sorted bool, expected []string) {
+			c := &Checker{}
+			if sorted {
+				CheckWithSortedExpectations()(c)
+			}
+			c.ExpectSome(w2, TargetIP("10.65.1.1"), 8055)
+			c.ExpectSome(w1, TargetIP("10.65.1.2"), 8055)
+			c.ExpectNone(w1, TargetIP("10.65.1.1"), 8056)
+			c.ExpectSome(w1, TargetIP("10.65.1.1"), 8055)
+			c.sortExpectationsIfNeeded()
+			Expect(paths(c)).To(Equal(expected))
+		},
+		Entry("unsorted", false, []string{
+			"w2 -> 10.65.1.1:8055",
+			"w1 -> 10.65.1.2:8055",
+			"w1 -> 10.65.1.1:8056",
+			"w1 -> 10.65.1.1:8055",
+		}),
+		Entry("sorted by source, target and port", true, []string{
+			"w1 -> 10.65.1.1:8055",
+			"w1 -> 10.65.1.1:8056",
+			"w1 -> 10.65.1.2:8055",
+			"w2 -> 10.65.1.1:8055",
+		}),
+	)
+
+	DescribeTable("probeOrder",
+		func(seed int64, n int) {
+			c := &Checker{}
+			if seed != 0 {
+				CheckWithShuffledProbes(seed)(c)
+			}
+			order := c.probeOrder(n)
+			Expect(order).To(HaveLen(n))
+			var indexes []interface{}
+			inOrder := []int{}
+			for i := 0; i < n; i++ {
+				indexes = append(indexes, i)
+				inOrder = append(inOrder, i)
+			}
+			Expect(order).To(ConsistOf(indexes...))
+
+			if seed == 0 {
+				Expect(order).To(Equal(inOrder))
+				return
+			}
+			if n > 1 {
+				Expect(order).NotTo(Equal(inOrder))
+			}
+			again := &Checker{}
+			CheckWithShuffledProbes(seed)(again)
+			Expect(again.probeOrder(n)).To(Equal(order), "the same seed should give the same order")
+		},
+		Entry("in order without shuffling", int64(0), 10),
+		Entry("shuffled with a seed", int64(42), 10),
+		Entry("shuffled with another seed", int64(7), 50),
+		Entry("no probes", int64(42), 0),
+	)
+})
@@ -0,0 +1,128 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// HarnessOverhead measures the work that the harness did for a run of
+// CheckConnectivity(), to find out whether the checks perturb the tests that
+// are sensitive to load.  The counters are shared by all the Checkers, so
+// they include the work of any that ran at the same time.
+type HarnessOverhead struct {
+	// Execs counts the commands run in containers.
+	Execs int64
+	// Subprocesses counts the local processes forked to run them, such as the
+	// docker CLI or ssh; execs over the Docker API don't fork.
+	Subprocesses int64
+	// OutputBytes counts the bytes of output read from the commands.
+	OutputBytes int64
+	// ExecTime is the total time that the commands ran for.
+	ExecTime time.Duration
+	// WallTime is the duration of the run.
+	WallTime time.Duration
+}
+
+// CheckWithOverheadLogging logs the HarnessOverhead of each run.
+func CheckWithOverheadLogging() CheckerOpt {
+	return func(c *Checker) {
+		log.Debug("CheckWithOverheadLogging set")
+		c.overheadLogging = true
+	}
+}
+
+// LastHarnessOverhead returns the overhead of the harness in the last run of
+// CheckConnectivity().
+func (c *Checker) LastHarnessOverhead() HarnessOverhead {
+	return c.lastOverhead
+}
+
+var harnessCounters struct {
+	execs        int64
+	subprocesses int64
+	outputBytes  int64
+	execNanos    int64
+}
+
+func harnessOverheadNow() HarnessOverhead {
+	return HarnessOverhead{
+		Execs:        atomic.LoadInt64(&harnessCounters.execs),
+		Subprocesses: atomic.LoadInt64(&harnessCounters.subprocesses),
+		OutputBytes:  atomic.LoadInt64(&harnessCounters.outputBytes),
+		ExecTime:     time.Duration(atomic.LoadInt64(&harnessCounters.execNanos)),
+	}
+}
+
+// recordOverhead records the overhead of the run since the counters were at
+// before.
+func (c *Checker) recordOverhead(before HarnessOverhead, start time.Time) {
+	now := harnessOverheadNow()
+	c.lastOverhead = HarnessOverhead{
+		Execs:        now.Execs - before.Execs,
+		Subprocesses: now.Subprocesses - before.Subprocesses,
+		OutputBytes:  now.OutputBytes - before.OutputBytes,
+		ExecTime:     now.ExecTime - before.ExecTime,
+		WallTime:     time.Since(start),
+	}
+	if c.overheadLogging {
+		log.WithFields(log.Fields{
+			"execs":        c.lastOverhead.Execs,
+			"subprocesses": c.lastOverhead.Subprocesses,
+			"outputBytes":  c.lastOverhead.OutputBytes,
+			"execTime":     c.lastOverhead.ExecTime,
+			"wallTime":     c.lastOverhead.WallTime,
+		}).Info("Connectivity check harness overhead")
+	}
+}
+
+// countedExec counts the output and the run time of an execProcess.
+type countedExec struct {
+	execProcess
+	start time.Time
+}
+
+func countExec(proc execProcess) execProcess {
+	atomic.AddInt64(&harnessCounters.execs, 1)
+	return &countedExec{execProcess: proc, start: time.Now()}
+}
+
+func (e *countedExec) Stdout() io.Reader {
+	return countingReader{e.execProcess.Stdout()}
+}
+
+func (e *countedExec) Stderr() io.Reader {
+	return countingReader{e.execProcess.Stderr()}
+}
+
+func (e *countedExec) Wait() error {
+	err := e.execProcess.Wait()
+	atomic.AddInt64(&harnessCounters.execNanos, int64(time.Since(e.start)))
+	return err
+}
+
+type countingReader struct {
+	r io.Reader
+}
+
+func (r countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	atomic.AddInt64(&harnessCounters.outputBytes, int64(n))
+	return n, err
+}
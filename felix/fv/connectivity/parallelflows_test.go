@@ -0,0 +1,95 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	. "github.com/projectcalico/calico/felix/fv/connectivity"
+)
+
+var _ = Describe("Parallel flows", func() {
+	ms := time.Millisecond
+
+	// result returns the result of a parallel flows check whose flows came from
+	// the given source ports, with an empty port for a flow that failed.
+	result := func(ports ...string) *Result {
+		r := &Result{}
+		for i, port := range ports {
+			if port == "" {
+				r.Flows = append(r.Flows, FlowResult{ErrorStr: "timed out"})
+				continue
+			}
+			r.Flows = append(r.Flows, FlowResult{
+				Success:    true,
+				Latency:    time.Duration(i+1) * ms,
+				SourceAddr: "10.65.0.2:" + port,
+			})
+			r.Stats.RequestsSent++
+			r.Stats.ResponsesReceived++
+		}
+		return r
+	}
+
+	Describe("Result", func() {
+		It("should count the successful flows", func() {
+			Expect(result("1000", "", "1001").SuccessfulFlows()).To(Equal(2))
+		})
+
+		It("should list the distinct source ports of the successful flows", func() {
+			Expect(result("1000", "", "1001", "1000").FlowSourcePorts()).To(ConsistOf("1000", "1001"))
+		})
+
+		It("should report the latency of the slowest successful flow", func() {
+			r := result("1000", "1001", "")
+			r.Flows[2].Latency = time.Second
+			Expect(r.MaxFlowLatency()).To(Equal(2 * ms))
+		})
+
+		It("should be empty for a nil result", func() {
+			var r *Result
+			Expect(r.SuccessfulFlows()).To(BeZero())
+			Expect(r.FlowSourcePorts()).To(BeEmpty())
+			Expect(r.MaxFlowLatency()).To(BeZero())
+		})
+	})
+
+	DescribeTable("Expectation.Matches",
+		func(flows int, r *Result, matches bool) {
+			e := Expectation{Expected: true}
+			ExpectWithParallelFlows(flows)(&e)
+			Expect(e.Matches(r, false)).To(Equal(matches))
+		},
+		Entry("all flows succeeded", 3, result("1000", "1001", "1002"), true),
+		Entry("one flow failed", 3, result("1000", "", "1002"), false),
+		Entry("fewer flows than expected", 3, result("1000", "1001"), false),
+		Entry("a single flow is a plain check", 1, result(), false),
+		Entry("a single flow that succeeded", 1, &Result{Stats: Stats{RequestsSent: 1, ResponsesReceived: 1}}, true),
+	)
+
+	It("should refuse a fixed source port", func() {
+		var c Checker
+		failures := InterceptGomegaFailures(func() {
+			c.Expect(Some, namedSource("w1"), TargetIP("10.65.1.1"),
+				ExpectWithPorts(8055), ExpectWithParallelFlows(3), ExpectWithSrcPort(1234))
+		})
+		Expect(failures).To(ConsistOf(And(
+			ContainSubstring("ExpectWithParallelFlows()"), ContainSubstring("ExpectWithSrcPort()"))))
+	})
+})
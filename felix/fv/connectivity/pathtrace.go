@@ -0,0 +1,116 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	// PathTraceMaxHops is how many hops a path trace probes before giving up.
+	PathTraceMaxHops = 10
+	// PathTraceHopTimeout is how long a path trace waits for each hop to answer.
+	PathTraceHopTimeout = 500 * time.Millisecond
+)
+
+// PathTraceSilentHop stands for a hop that didn't answer in a PathTrace.
+const PathTraceSilentHop = "*"
+
+// PathTrace is the path to the target found by probing with increasing TTLs, like
+// traceroute, before the check.
+type PathTrace struct {
+	// Hops are the IPs that answered each TTL, in order, PathTraceSilentHop for
+	// those that didn't.  The target itself is the last hop if it was reached.
+	Hops    []string
+	Reached bool
+}
+
+func (p PathTrace) PrintToStdout() {
+	Message{Type: MessagePath, Path: &p}.PrintToStdout()
+}
+
+func (p PathTrace) Via(ip string) bool {
+	for _, h := range p.Hops {
+		if h == ip {
+			return true
+		}
+	}
+	return false
+}
+
+func (p PathTrace) String() string {
+	s := strings.Join(p.Hops, " > ")
+	if !p.Reached {
+		s += " (target not reached)"
+	}
+	return s
+}
+
+// WithPathTrace traces the path to the target before the check, see PathTrace.
+func WithPathTrace() CheckOption {
+	return func(c *CheckCmd) {
+		c.tracePath = true
+	}
+}
+
+// ExpectWithPathTrace records the path to the target in the Result of the check,
+// see PathTrace.
+func ExpectWithPathTrace() ExpectationOption {
+	return func(e *Expectation) {
+		e.tracePath = true
+	}
+}
+
+// ExpectPathVia asserts that the path to the target goes through the given hop,
+// for example, a gateway, tunnel endpoint or egress node.  It implies
+// ExpectWithPathTrace().  Path assertions only apply to expected connectivity.
+func ExpectPathVia(ip string) ExpectationOption {
+	return func(e *Expectation) {
+		e.tracePath = true
+		e.pathVia = append(e.pathVia, ip)
+	}
+}
+
+// ExpectPathNotVia asserts that the path to the target avoids the given hop.  It
+// implies ExpectWithPathTrace().
+func ExpectPathNotVia(ip string) ExpectationOption {
+	return func(e *Expectation) {
+		e.tracePath = true
+		e.pathNotVia = append(e.pathNotVia, ip)
+	}
+}
+
+// pathMatches returns whether the traced path meets the path assertions of the
+// expectation.
+func (e Expectation) pathMatches(response *Result) bool {
+	if len(e.pathVia) == 0 && len(e.pathNotVia) == 0 {
+		return true
+	}
+	if response.Path == nil {
+		return false
+	}
+	for _, ip := range e.pathVia {
+		if !response.Path.Via(ip) {
+			return false
+		}
+	}
+	for _, ip := range e.pathNotVia {
+		if response.Path.Via(ip) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,141 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+)
+
+// WithPayloadPattern makes the client send the pattern, repeated or cut to the
+// length given by WithSendLen(), as the extra data after its request, rather
+// than zeros.  Without a send length, the pattern is sent once.  The pattern may
+// hold any bytes.
+func WithPayloadPattern(pattern string) CheckOption {
+	return func(c *CheckCmd) {
+		c.payloadPattern = pattern
+	}
+}
+
+// WithFuzzedPayload makes the client send a random payload as the extra data
+// after its request: binary, very long or a lookalike of another protocol, to
+// shake out the parsing assumptions of L7 components on the path.  The payload
+// is generated from the seed, pass 0 for a random seed.  The seed is recorded in
+// Result.PayloadSeed; FuzzedPayload() regenerates the payload from it.
+func WithFuzzedPayload(seed int64) CheckOption {
+	return func(c *CheckCmd) {
+		c.fuzzPayload = true
+		c.fuzzSeed = seed
+	}
+}
+
+// ExpectWithPayloadPattern checks the connectivity with the given extra data,
+// see WithPayloadPattern().
+func ExpectWithPayloadPattern(pattern string) ExpectationOption {
+	return func(e *Expectation) {
+		e.payloadPattern = pattern
+	}
+}
+
+// ExpectWithFuzzedPayload checks the connectivity with a random payload, see
+// WithFuzzedPayload().
+func ExpectWithFuzzedPayload(seed int64) ExpectationOption {
+	return func(e *Expectation) {
+		e.fuzzPayload = true
+		e.fuzzSeed = seed
+	}
+}
+
+// PatternPayload returns the pattern repeated or cut to n bytes, or the pattern
+// itself if n is 0.
+func PatternPayload(pattern string, n int) []byte {
+	if n == 0 || pattern == "" {
+		return []byte(pattern)
+	}
+	return bytes.Repeat([]byte(pattern), n/len(pattern)+1)[:n]
+}
+
+// lookalikes are the starts of messages of other protocols, for FuzzedPayload()
+// to mangle.
+var lookalikes = [][]byte{
+	[]byte("GET / HTTP/1.1\r\nHost: "),
+	[]byte("POST /api HTTP/1.1\r\nContent-Length: 18446744073709551616\r\nTransfer-Encoding: chunked\r\n\r\n"),
+	[]byte("PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"),
+	{0x16, 0x03, 0x01, 0x02, 0x00, 0x01, 0x00, 0x01, 0xfc, 0x03, 0x03},
+	{0x00, 0x00, 0x00, 0x1c, 0x00, 0x00, 0x00, 0x00},
+	[]byte("{\"Version\":2,\"ID\":\"\",\"Payload\":\"" + ConnectionTypeStream),
+	[]byte("SSH-2.0-OpenSSH_9.0\r\n"),
+	[]byte("\x05\x01\x00"),
+	[]byte("*1\r\n$4\r\nPING\r\n"),
+}
+
+// FuzzedPayload returns the payload that WithFuzzedPayload() sends for the
+// seed.
+func FuzzedPayload(seed int64) []byte {
+	r := rand.New(rand.NewSource(seed))
+	randomBytes := func(n int) []byte {
+		b := make([]byte, n)
+		r.Read(b)
+		return b
+	}
+
+	switch r.Intn(3) {
+	case 0:
+		// Binary.
+		return randomBytes(1 + r.Intn(4096))
+	case 1:
+		// Very long, but still fits in a UDP datagram.
+		return PatternPayload(string(randomBytes(1+r.Intn(64))), 16384+r.Intn(45000))
+	default:
+		// The start of another protocol, with random bytes flipped and a
+		// random tail.
+		p := append([]byte(nil), lookalikes[r.Intn(len(lookalikes))]...)
+		for i := r.Intn(4); i > 0; i-- {
+			p[r.Intn(len(p))] ^= byte(1 + r.Intn(255))
+		}
+		return append(p, randomBytes(r.Intn(256))...)
+	}
+}
+
+// payloadArgs returns the test-connection arguments for the payload of the check.
+func (cmd *CheckCmd) payloadArgs() []string {
+	var args []string
+	if cmd.payloadPattern != "" {
+		args = append(args, "--payload-pattern="+hex.EncodeToString([]byte(cmd.payloadPattern)))
+	}
+	if cmd.fuzzPayload {
+		args = append(args, fmt.Sprintf("--fuzz-seed=%d", cmd.fuzzSeed))
+	}
+	return args
+}
+
+func payloadPretty(exp Expectation) string {
+	if exp.fuzzPayload {
+		if exp.fuzzSeed == 0 {
+			return " (fuzzed payload)"
+		}
+		return fmt.Sprintf(" (fuzzed payload, seed %d)", exp.fuzzSeed)
+	}
+	return fmt.Sprintf(" (payload %q)", exp.payloadPattern)
+}
+
+func payloadSeedPretty(res *Result) string {
+	if res == nil || res.PayloadSeed == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (payload seed %d)", res.PayloadSeed)
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	. "github.com/projectcalico/calico/felix/fv/connectivity"
+)
+
+var _ = Describe("Payloads", func() {
+	DescribeTable("PatternPayload",
+		func(pattern string, n int, expected string) {
+			Expect(string(PatternPayload(pattern, n))).To(Equal(expected))
+		},
+		Entry("no length", "abc", 0, "abc"),
+		Entry("repeated", "abc", 7, "abcabca"),
+		Entry("exact multiple", "abc", 6, "abcabc"),
+		Entry("cut", "abcdef", 4, "abcd"),
+		Entry("binary", "\x00\xff", 5, "\x00\xff\x00\xff\x00"),
+		Entry("empty pattern", "", 5, ""),
+	)
+
+	Describe("FuzzedPayload", func() {
+		It("should regenerate the same payload from the seed", func() {
+			for seed := int64(1); seed <= 50; seed++ {
+				Expect(FuzzedPayload(seed)).To(Equal(FuzzedPayload(seed)), "seed %d", seed)
+			}
+		})
+
+		It("should vary with the seed", func() {
+			payloads := map[string]bool{}
+			for seed := int64(1); seed <= 50; seed++ {
+				payloads[string(FuzzedPayload(seed))] = true
+			}
+			Expect(len(payloads)).To(BeNumerically(">", 40))
+		})
+
+		It("should generate payloads that fit in a UDP datagram", func() {
+			for seed := int64(1); seed <= 200; seed++ {
+				p := FuzzedPayload(seed)
+				Expect(p).NotTo(BeEmpty(), "seed %d", seed)
+				Expect(len(p)).To(BeNumerically("<=", 65507), "seed %d", seed)
+			}
+		})
+	})
+})
@@ -0,0 +1,80 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// PlannedProbe is a probe that the Checker would run, see Plan().
+type PlannedProbe struct {
+	Source   string
+	Target   string
+	Expected Expected
+
+	// Container is the container that the probe would be run in.
+	Container string
+	IP        string
+	Port      string
+	Protocol  string
+	// Command is the command line of test-connection in the container.
+	Command []string
+}
+
+func (p PlannedProbe) String() string {
+	return fmt.Sprintf("%s -> %s = %v: in %s: %s",
+		p.Source, p.Target, p.Expected, p.Container, strings.Join(p.Command, " "))
+}
+
+// Plan returns the probes that CheckConnectivity() would run for the
+// expectations, with the exact test-connection commands, without running them,
+// and logs them.  Use it to review a generated matrix or to see how the options
+// of the expectations reach test-connection.  Probes that the check runs on top
+// of those, like conntrack or packet captures, aren't included.
+func (c *Checker) Plan() []PlannedProbe {
+	expectations := c.expectationsSnapshot()
+
+	p := c.protocol()
+	probes := make([]PlannedProbe, len(expectations))
+	for i, exp := range expectations {
+		probe := &probes[i]
+		*probe = PlannedProbe{
+			Source:   exp.sourceName(),
+			Target:   exp.To.TargetName,
+			Expected: exp.Expected,
+			IP:       exp.To.IP,
+			Port:     exp.To.Port,
+			Protocol: p,
+		}
+		opts := append(c.checkOptions(exp), withPlan(func(cName string, args []string) {
+			probe.Container = cName
+			probe.Command = args
+		}))
+		exp.From.CanConnectTo(exp.To.IP, exp.To.Port, p, opts...)
+		log.WithField("probe", probe.String()).Info("Planned connectivity probe")
+	}
+	return probes
+}
+
+// withPlan makes the check pass its container and command line to the function
+// instead of running, see Plan().
+func withPlan(f func(cName string, args []string)) CheckOption {
+	return func(c *CheckCmd) {
+		c.plan = f
+	}
+}
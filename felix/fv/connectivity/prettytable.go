@@ -0,0 +1,210 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// CheckWithPrettyContext shows only the expectations that failed in the
+// expected and actual connectivity of the failure message, each with the given
+// number of rows of the expectations around it, so that the message of a large
+// matrix doesn't bury the failures.  The rows left out are counted in their
+// place.  If no expectation failed, for example, because the final test did,
+// all the rows are shown.
+func CheckWithPrettyContext(rows int) CheckerOpt {
+	return func(c *Checker) {
+		log.Debug("CheckWithPrettyContext set")
+		c.prettyOnlyFailed = true
+		c.prettyContext = rows
+	}
+}
+
+// CheckWithPrettyMaxRows limits the expected and actual connectivity of the
+// failure message to the given number of rows each, the rest are counted at
+// the end.
+func CheckWithPrettyMaxRows(rows int) CheckerOpt {
+	return func(c *Checker) {
+		log.Debug("CheckWithPrettyMaxRows set")
+		c.prettyMaxRows = rows
+	}
+}
+
+// prettyHead is the start of the description of an expectation, to which the
+// details are appended, see expectedPretty() and resultPretty().
+func prettyHead(exp Expectation, value interface{}) string {
+	return fmt.Sprintf("%s -> %s = %v", exp.sourceName(), exp.To.TargetName, value)
+}
+
+// prettyColumns splits the description of an expectation into the columns of
+// the table: the source, the target, the value and the details.  A line that
+// isn't the description of the expectation is a single column.
+func prettyColumns(exp Expectation, line string) []string {
+	prefix := exp.sourceName() + " -> " + exp.To.TargetName + " = "
+	if !strings.HasPrefix(line, prefix) {
+		return []string{line}
+	}
+	value, details := line[len(prefix):], ""
+	if i := strings.IndexByte(value, ' '); i >= 0 {
+		value, details = value[:i], value[i:]
+	}
+	return []string{exp.sourceName(), exp.To.TargetName, value, details}
+}
+
+// prettyTable lays out the descriptions of the expectations, as in Attempt.Pretty
+// or the expected connectivity, as a table with aligned columns.  The rows are
+// in the order of the expectations, whatever order they were probed in, and
+// limited by CheckWithPrettyContext() and CheckWithPrettyMaxRows(), given the
+// expectations that failed the attempt.  If colored, the rows are colored by
+// whether they failed, see CheckWithColor().
+func (c *Checker) prettyTable(lines []string, a *Attempt, colored bool) []string {
+	rows := make([][]string, len(lines))
+	for i, line := range lines {
+		if i < len(a.expectations) {
+			rows[i] = prettyColumns(a.expectations[i], line)
+		} else {
+			rows[i] = []string{line}
+		}
+	}
+	states := rowStates(len(lines), a)
+	var colors []string
+	if colored {
+		colors = rowColors(states)
+	}
+	return renderPrettyTable(rows, c.prettyShown(states), colors)
+}
+
+// rowState is the outcome of the expectation of a row of the table.
+type rowState int
+
+const (
+	rowPassed rowState = iota
+	rowFailed
+	// rowExcused failed because of a known issue or on a quarantined path.
+	rowExcused
+)
+
+// rowStates returns the outcomes of the n expectations in the attempt.
+func rowStates(n int, a *Attempt) []rowState {
+	states := make([]rowState, n)
+	if a == nil {
+		return states
+	}
+	set := func(i int, s rowState) {
+		if i >= 0 && i < n {
+			states[i] = s
+		}
+	}
+	for _, k := range a.KnownIssues {
+		set(k.Index, rowExcused)
+	}
+	for _, m := range a.Quarantined {
+		set(m.Index, rowExcused)
+	}
+	for _, m := range a.Mismatches {
+		set(m.Index, rowFailed)
+	}
+	for _, m := range a.Denials {
+		set(m.Index, rowFailed)
+	}
+	for _, m := range a.Encap {
+		set(m.Index, rowFailed)
+	}
+	return states
+}
+
+// prettyShown returns which rows of the table to show.
+func (c *Checker) prettyShown(states []rowState) []bool {
+	n := len(states)
+	shown := make([]bool, n)
+	anyFailed := false
+	if c.prettyOnlyFailed {
+		for f, s := range states {
+			if s == rowPassed {
+				continue
+			}
+			anyFailed = true
+			for i := f - c.prettyContext; i <= f+c.prettyContext; i++ {
+				if i >= 0 && i < n {
+					shown[i] = true
+				}
+			}
+		}
+	}
+	if !anyFailed {
+		for i := range shown {
+			shown[i] = true
+		}
+	}
+
+	if c.prettyMaxRows > 0 {
+		count := 0
+		for i := range shown {
+			if shown[i] {
+				count++
+				shown[i] = count <= c.prettyMaxRows
+			}
+		}
+	}
+	return shown
+}
+
+// renderPrettyTable pads the source, target and value columns of the rows to
+// the same widths and replaces each run of rows that aren't shown with a count.
+// colors, if not nil, holds the ANSI escape sequence to color each row with.
+func renderPrettyTable(rows [][]string, shown []bool, colors []string) []string {
+	var widths [3]int
+	for i, row := range rows {
+		if !shown[i] || len(row) == 1 {
+			continue
+		}
+		for col := range widths {
+			if len(row[col]) > widths[col] {
+				widths[col] = len(row[col])
+			}
+		}
+	}
+
+	var out []string
+	skipped := 0
+	flush := func() {
+		if skipped > 0 {
+			out = append(out, fmt.Sprintf("... %d more", skipped))
+			skipped = 0
+		}
+	}
+	for i, row := range rows {
+		if !shown[i] {
+			skipped++
+			continue
+		}
+		flush()
+		line := row[0]
+		if len(row) > 1 {
+			line = fmt.Sprintf("%-*s -> %-*s = %-*s%s",
+				widths[0], row[0], widths[1], row[1], widths[2], row[2], row[3])
+			line = strings.TrimRight(line, " ")
+		}
+		if colors != nil {
+			line = colors[i] + line + colorReset
+		}
+		out = append(out, line)
+	}
+	flush()
+	return out
+}
@@ -0,0 +1,152 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Pretty tables", func() {
+	exp := Expectation{From: fakeSource{name: "w1"}, To: TargetIP("10.65.1.1").ToMatcher(8055)}
+
+	DescribeTable("prettyColumns",
+		func(line string, columns []string) {
+			Expect(prettyColumns(exp, line)).To(Equal(columns))
+		},
+		Entry("a value without details", "w1 -> 10.65.1.1:8055 = true",
+			[]string{"w1", "10.65.1.1:8055", "true", ""}),
+		Entry("a value with details", "w1 -> 10.65.1.1:8055 = false (timed out)",
+			[]string{"w1", "10.65.1.1:8055", "false", " (timed out)"}),
+		Entry("a line of another expectation", "w2 -> 10.65.1.1:8055 = true",
+			[]string{"w2 -> 10.65.1.1:8055 = true"}),
+		Entry("a line that isn't an expectation", "Final test failed",
+			[]string{"Final test failed"}),
+	)
+
+	DescribeTable("rowStates",
+		func(a *Attempt, states []rowState) {
+			Expect(rowStates(4, a)).To(Equal(states))
+		},
+		Entry("without an attempt", nil,
+			[]rowState{rowPassed, rowPassed, rowPassed, rowPassed}),
+		Entry("mismatches, denials and encapsulation", &Attempt{
+			Mismatches: []MismatchDetail{{Index: 0}},
+			Denials:    []DenialMismatch{{Index: 2}},
+			Encap:      []EncapMismatch{{Index: 3}},
+		}, []rowState{rowFailed, rowPassed, rowFailed, rowFailed}),
+		Entry("known issues and quarantined paths", &Attempt{
+			KnownIssues: []KnownIssue{{Index: 1}},
+			Quarantined: []MismatchDetail{{Index: 2}},
+		}, []rowState{rowPassed, rowExcused, rowExcused, rowPassed}),
+		Entry("a mismatch of an excused row", &Attempt{
+			KnownIssues: []KnownIssue{{Index: 1}},
+			Mismatches:  []MismatchDetail{{Index: 1}},
+		}, []rowState{rowPassed, rowFailed, rowPassed, rowPassed}),
+		Entry("indexes out of range", &Attempt{
+			Mismatches: []MismatchDetail{{Index: -1}, {Index: 4}},
+		}, []rowState{rowPassed, rowPassed, rowPassed, rowPassed}),
+	)
+
+	const (
+		P = rowPassed
+		F = rowFailed
+		X = rowExcused
+	)
+
+	DescribeTable("prettyShown",
+		func(opts []CheckerOpt, states []rowState, shown []bool) {
+			c := &Checker{}
+			for _, o := range opts {
+				o(c)
+			}
+			Expect(c.prettyShown(states)).To(Equal(shown))
+		},
+		Entry("all rows by default", nil,
+			[]rowState{P, F, P},
+			[]bool{true, true, true}),
+		Entry("only the failed rows", []CheckerOpt{CheckWithPrettyContext(0)},
+			[]rowState{P, F, P, X, P},
+			[]bool{false, true, false, true, false}),
+		Entry("the failed rows with context", []CheckerOpt{CheckWithPrettyContext(1)},
+			[]rowState{P, P, F, P, P, P},
+			[]bool{false, true, true, true, false, false}),
+		Entry("context at the edges", []CheckerOpt{CheckWithPrettyContext(2)},
+			[]rowState{F, P, P, P, F},
+			[]bool{true, true, true, true, true}),
+		Entry("all rows if none failed", []CheckerOpt{CheckWithPrettyContext(0)},
+			[]rowState{P, P},
+			[]bool{true, true}),
+		Entry("at most the max rows", []CheckerOpt{CheckWithPrettyMaxRows(2)},
+			[]rowState{P, F, P, P},
+			[]bool{true, true, false, false}),
+		Entry("at most the max rows of the failed ones",
+			[]CheckerOpt{CheckWithPrettyContext(0), CheckWithPrettyMaxRows(2)},
+			[]rowState{F, P, F, F},
+			[]bool{true, false, true, false}),
+	)
+
+	DescribeTable("renderPrettyTable",
+		func(rows [][]string, shown []bool, colors []string, lines []string) {
+			Expect(renderPrettyTable(rows, shown, colors)).To(Equal(lines))
+		},
+		Entry("aligned columns",
+			[][]string{
+				{"w1", "10.65.1.1:8055", "true", ""},
+				{"w10", "w2 on port 8055", "false", " (timed out)"},
+			},
+			[]bool{true, true}, nil,
+			[]string{
+				"w1  -> 10.65.1.1:8055  = true",
+				"w10 -> w2 on port 8055 = false (timed out)",
+			}),
+		Entry("a single column row, which doesn't widen the columns",
+			[][]string{
+				{"w1", "10.65.1.1:8055", "true", ""},
+				{"A line that is longer than the columns"},
+			},
+			[]bool{true, true}, nil,
+			[]string{
+				"w1 -> 10.65.1.1:8055 = true",
+				"A line that is longer than the columns",
+			}),
+		Entry("rows that aren't shown, which don't widen the columns",
+			[][]string{
+				{"w1", "10.65.1.1:8055", "true", ""},
+				{"w100", "10.65.1.1:8055", "true", ""},
+				{"w101", "10.65.1.1:8055", "true", ""},
+				{"w2", "10.65.1.1:8055", "false", ""},
+				{"w102", "10.65.1.1:8055", "true", ""},
+			},
+			[]bool{true, false, false, true, false}, nil,
+			[]string{
+				"w1 -> 10.65.1.1:8055 = true",
+				"... 2 more",
+				"w2 -> 10.65.1.1:8055 = false",
+				"... 1 more",
+			}),
+		Entry("colored rows",
+			[][]string{
+				{"w1", "10.65.1.1:8055", "true", ""},
+				{"w2", "10.65.1.1:8055", "false", ""},
+			},
+			[]bool{true, true}, []string{"<green>", "<red>"},
+			[]string{
+				"<green>w1 -> 10.65.1.1:8055 = true" + colorReset,
+				"<red>w2 -> 10.65.1.1:8055 = false" + colorReset,
+			}),
+	)
+})
@@ -0,0 +1,132 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// probeKey identifies the probe of an expectation: expectations with the same
+// key run the same test-connection command, so they can share a Result.  It
+// holds the fields that checkOptions() turns into options.
+type probeKey struct {
+	source    string
+	sourceIPs string
+	ip, port  string
+
+	srcIP           string
+	srcPort         uint16
+	sourceInterface string
+	vrf             string
+	mark            uint32
+	sourceMAC       string
+	resolver        string
+	tracePath       bool
+	proxyProtocol   bool
+	proxySource     string
+	viaProxy        string
+	observeICMP     bool
+	tcpFastOpen     bool
+	ipv6ExtHeaders  string
+	payloadPattern  string
+	fuzzPayload     bool
+	fuzzSeed        int64
+	ftpMode         FTPMode
+	sctpLocalAddrs  string
+	sctpRemoteAddrs string
+	largeSend       int
+
+	sendLen, recvLen int
+
+	lossDuration time.Duration
+	rateDuration time.Duration
+	attemptRate  int
+
+	parallelFlows int
+	packetRate    int
+	packetSize    int
+	idlePeriod    time.Duration
+	oneWayLatency bool
+}
+
+func probeKeyOf(exp Expectation) probeKey {
+	return probeKey{
+		source:          exp.From.SourceName(),
+		sourceIPs:       strings.Join(exp.From.SourceIPs(), ","),
+		ip:              exp.To.IP,
+		port:            exp.To.Port,
+		srcIP:           exp.srcIP,
+		srcPort:         exp.srcPort,
+		sourceInterface: exp.sourceInterface,
+		vrf:             exp.vrf,
+		mark:            exp.mark,
+		sourceMAC:       exp.sourceMAC,
+		resolver:        exp.resolver,
+		tracePath:       exp.tracePath,
+		proxyProtocol:   exp.proxyProtocol,
+		proxySource:     exp.proxySource,
+		viaProxy:        exp.viaProxy,
+		observeICMP:     exp.icmpReject || exp.noForwardingLoop,
+		tcpFastOpen:     exp.tcpFastOpen,
+		ipv6ExtHeaders:  joinIPv6ExtHeaders(exp.ipv6ExtHeaders),
+		payloadPattern:  exp.payloadPattern,
+		fuzzPayload:     exp.fuzzPayload,
+		fuzzSeed:        exp.fuzzSeed,
+		ftpMode:         exp.ftpMode,
+		sctpLocalAddrs:  strings.Join(exp.sctpLocalAddrs, ","),
+		sctpRemoteAddrs: strings.Join(exp.sctpRemoteAddrs, ","),
+		largeSend:       exp.largeSend,
+		sendLen:         exp.sendLen,
+		recvLen:         exp.recvLen,
+		lossDuration:    exp.ExpectedPacketLoss.Duration,
+		rateDuration:    exp.ExpectedConnRate.Duration,
+		attemptRate:     exp.ExpectedConnRate.AttemptRate,
+		parallelFlows:   exp.parallelFlows,
+		packetRate:      exp.packetRate,
+		packetSize:      exp.packetSize,
+		idlePeriod:      exp.idlePeriod,
+		oneWayLatency:   exp.checksOneWayLatency(),
+	}
+}
+
+// duplicateProbes returns, for each expectation, the index of the first
+// expectation with the same probe, which is its own index unless the probe is
+// a duplicate.  Long-lived connections are never shared since each one signals
+// when it is established.
+func duplicateProbes(expectations []Expectation) []int {
+	first := map[probeKey]int{}
+	dupOf := make([]int, len(expectations))
+	dups := 0
+	for i, exp := range expectations {
+		dupOf[i] = i
+		if exp.longLivedDuration > 0 {
+			continue
+		}
+		k := probeKeyOf(exp)
+		if j, ok := first[k]; ok {
+			dupOf[i] = j
+			dups++
+			continue
+		}
+		first[k] = i
+	}
+	if dups > 0 {
+		log.WithField("duplicates", dups).Debug("Sharing the results of duplicate connectivity probes")
+	}
+	return dupOf
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+// fakeSource is a ConnectionSource that only has a name and IPs.
+type fakeSource struct {
+	name string
+	ips  []string
+}
+
+func (s fakeSource) PreRetryCleanup(ip, port, protocol string, opts ...CheckOption) {}
+
+func (s fakeSource) CanConnectTo(ip, port, protocol string, opts ...CheckOption) *Result {
+	return nil
+}
+
+func (s fakeSource) SourceName() string {
+	return s.name
+}
+
+func (s fakeSource) SourceIPs() []string {
+	return s.ips
+}
+
+var _ = Describe("duplicateProbes", func() {
+	w1 := fakeSource{name: "w1", ips: []string{"10.65.0.1"}}
+	w2 := fakeSource{name: "w2", ips: []string{"10.65.0.2"}}
+	target := TargetIP("10.65.1.1")
+
+	exp := func(src ConnectionSource, expected Expected, port uint16, opts ...ExpectationOption) Expectation {
+		e := Expectation{From: src, Expected: expected}
+		for _, o := range opts {
+			o(&e)
+		}
+		e.To = target.ToMatcher(port)
+		return e
+	}
+
+	DescribeTable("should share the probes of expectations with the same probe",
+		func(a, b Expectation, shared bool) {
+			dupOf := duplicateProbes([]Expectation{a, b})
+			if shared {
+				Expect(dupOf).To(Equal([]int{0, 0}))
+			} else {
+				Expect(dupOf).To(Equal([]int{0, 1}))
+			}
+		},
+		Entry("same probe",
+			exp(w1, Some, 8055), exp(w1, Some, 8055), true),
+		Entry("different expected outcome",
+			exp(w1, Some, 8055), exp(w1, None, 8055), true),
+		Entry("options that don't change the probe",
+			exp(w1, Some, 8055), exp(w1, Some, 8055, ExpectWithSrcIPs("10.65.0.9"), ExpectWithTags("tag")), true),
+		Entry("different source",
+			exp(w1, Some, 8055), exp(w2, Some, 8055), false),
+		Entry("different port",
+			exp(w1, Some, 8055), exp(w1, Some, 8056), false),
+		Entry("different source port",
+			exp(w1, Some, 8055), exp(w1, Some, 8055, ExpectWithSrcPort(1234)), false),
+		Entry("different send length",
+			exp(w1, Some, 8055), exp(w1, Some, 8055, ExpectWithSendLen(1000)), false),
+		Entry("different payload",
+			exp(w1, Some, 8055, ExpectWithPayloadPattern("a")), exp(w1, Some, 8055, ExpectWithPayloadPattern("b")), false),
+		Entry("different loss test",
+			exp(w1, Some, 8055), exp(w1, Some, 8055, ExpectWithLoss(time.Second, 0, -1)), false),
+		Entry("one-way latency",
+			exp(w1, Some, 8055), exp(w1, Some, 8055, ExpectMaxOneWayLatency(time.Millisecond, 0)), false),
+		Entry("long-lived connections",
+			exp(w1, Some, 8055, ExpectWithSurvival(time.Second)), exp(w1, Some, 8055, ExpectWithSurvival(time.Second)), false),
+	)
+
+	It("should point each duplicate at the first expectation with its probe", func() {
+		Expect(duplicateProbes([]Expectation{
+			exp(w1, Some, 8055),
+			exp(w2, Some, 8055),
+			exp(w1, None, 8055),
+			exp(w2, Some, 8055),
+			exp(w1, Some, 8056),
+		})).To(Equal([]int{0, 1, 0, 1, 4}))
+	})
+})
@@ -0,0 +1,100 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// CheckWithProbeLogLevel logs the completion of each probe at the given level
+// rather than at info, for example, at debug to quieten the logs of a large
+// matrix.
+func CheckWithProbeLogLevel(level log.Level) CheckerOpt {
+	return func(c *Checker) {
+		log.Debug("CheckWithProbeLogLevel set")
+		c.probeLogLevelSet = true
+		c.probeLogLevel = level
+	}
+}
+
+// WithProbeLabels names the source and the target of the check, and the
+// attempt of the Checker that it is part of, in the log of the check.
+func WithProbeLabels(source, target string, attempt int) CheckOption {
+	return func(c *CheckCmd) {
+		c.logSource = source
+		c.logTarget = target
+		c.logAttempt = attempt
+	}
+}
+
+// WithProbeLogLevel sets the level at which the completion of the check is
+// logged, info by default.
+func WithProbeLogLevel(level log.Level) CheckOption {
+	return func(c *CheckCmd) {
+		c.logLevel = level
+	}
+}
+
+// Outcomes of a probe, in its log.
+const (
+	ProbeOutcomeConnected      = "connected"
+	ProbeOutcomeNoConnectivity = "no-connectivity"
+	ProbeOutcomeHarnessError   = "harness-error"
+)
+
+// probeOutcome classifies the outcome of a probe for its log.
+func probeOutcome(res *Result, err error) string {
+	switch {
+	case err != nil || (res != nil && res.HarnessErr != nil):
+		return ProbeOutcomeHarnessError
+	case res.HasConnectivity():
+		return ProbeOutcomeConnected
+	default:
+		return ProbeOutcomeNoConnectivity
+	}
+}
+
+// logProbe logs the completion of the check with structured fields, so that
+// tools can build a timeline of each path from the logs: the source, the target,
+// the port, the protocol, the attempt, how long the probe took and its outcome.
+// The output of test-connection is included for debugging.
+func (cmd *CheckCmd) logProbe(logCxt *log.Entry, cName, logMsg string, duration time.Duration,
+	stdout, stderr []byte, exitErr error, res *Result, err error) {
+	source := cmd.logSource
+	if source == "" {
+		source = cName
+	}
+	target := cmd.logTarget
+	if target == "" {
+		target = cmd.ip
+	}
+	fields := log.Fields{
+		"source":   source,
+		"target":   target,
+		"ip":       cmd.ip,
+		"port":     cmd.port,
+		"protocol": cmd.protocol,
+		"duration": duration,
+		"outcome":  probeOutcome(res, err),
+		"stdout":   string(stdout),
+		"stderr":   string(stderr),
+	}
+	if cmd.logAttempt > 0 {
+		fields["attempt"] = cmd.logAttempt
+	}
+	logCxt.WithFields(fields).WithError(exitErr).Log(cmd.logLevel, logMsg)
+}
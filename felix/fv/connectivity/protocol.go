@@ -0,0 +1,364 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+)
+
+// test-connection reports to the checker with a stream of messages on its
+// stdout, one JSON encoded Message per line, each line starting with
+// MessagePrefix.  Its logs go to stderr; any other line on stdout is ignored,
+// as are lines with MessagePrefix that don't parse, once a result arrived.
+// The checker parses the messages as they arrive, so it can react to progress
+// and to established connections while the check is still running.
+
+// MessagePrefix marks a line of stdout as a protocol message.
+const MessagePrefix = "CONNCHECK="
+
+// ExitCodeInvalidArgs is the exit status of test-connection when it rejects its
+// arguments.  It exits with status 1 when it fails to connect, and 2 is the
+// status of a Go panic, so that the checker can tell a bad check from a lack of
+// connectivity and from a crash.
+const ExitCodeInvalidArgs = 3
+
+// ProtocolVersion is the version of the protocol spoken between the checker,
+// test-connection and test-workload.  Increment it when changing the framing of
+// the messages or the meaning of existing fields.  Extensions, that is, new
+// optional fields and the modes that fill them in, are gated by capabilities
+// instead: add a feature to Features and have the checker require it, see
+// CheckCmd.requiredFeatures(), rather than incrementing the version.
+//
+//   - 1: a single RESULT= line at the end of the output, no version field.
+//   - 2: framed messages, versioned requests and responses and capabilities.
+//   - 3: hostname targets, resolved in the source's namespace.
+//   - 4: path traces.
+const ProtocolVersion = 4
+
+// Features of test-connection beyond a basic connectivity check, reported by
+// "test-connection --capabilities".
+const (
+	FeatureFlows      = "flows"
+	FeatureConnRate   = "conn-rate"
+	FeatureLongLived  = "long-lived"
+	FeatureContinuous = "continuous"
+	FeaturePacketRate = "packet-rate"
+	FeaturePacketSize = "packet-size"
+	FeatureIdle       = "idle"
+	FeatureSelfTest   = "self-test"
+	FeatureResolve    = "resolve"
+
+	FeatureOneWayLatency   = "one-way-latency"
+	FeatureSourceInterface = "source-interface"
+	FeatureSocketMark      = "socket-mark"
+	FeatureVRF             = "vrf"
+	FeatureNeighbor        = "neighbor"
+	FeaturePathTrace       = "path-trace"
+	FeatureProxyProtocol   = "proxy-protocol"
+	FeatureViaProxy        = "via-proxy"
+	FeatureICMPErrors      = "icmp-errors"
+	FeatureTCPFastOpen     = "tcp-fastopen"
+	FeatureIPv6ExtHeaders  = "ipv6-ext-headers"
+	FeaturePayload         = "payload"
+	FeatureFTP             = "ftp"
+	FeatureSCTPMultihoming = "sctp-multihoming"
+	FeatureLargeSend       = "large-send"
+)
+
+// Features lists the features supported by this version of test-connection.
+var Features = []string{
+	FeatureFlows,
+	FeatureConnRate,
+	FeatureLongLived,
+	FeatureContinuous,
+	FeaturePacketRate,
+	FeaturePacketSize,
+	FeatureIdle,
+	FeatureSelfTest,
+	FeatureResolve,
+	FeatureOneWayLatency,
+	FeatureSourceInterface,
+	FeatureSocketMark,
+	FeatureVRF,
+	FeatureNeighbor,
+	FeaturePathTrace,
+	FeatureProxyProtocol,
+	FeatureViaProxy,
+	FeatureICMPErrors,
+	FeatureTCPFastOpen,
+	FeatureIPv6ExtHeaders,
+	FeaturePayload,
+	FeatureFTP,
+	FeatureSCTPMultihoming,
+	FeatureLargeSend,
+}
+
+// ProgressInterval is how often test-connection reports the progress of checks
+// that run for a duration.
+const ProgressInterval = time.Second
+
+type MessageType string
+
+const (
+	// MessageProgress carries the statistics of a running check so far.
+	MessageProgress MessageType = "progress"
+	// MessageConnected is sent once a long-lived connection is established.
+	MessageConnected MessageType = "connected"
+	// MessageProbe carries the result of one probe of a continuous check.
+	MessageProbe MessageType = "probe"
+	// MessageResult carries the final result of the check.
+	MessageResult MessageType = "result"
+	// MessageResolved reports how a hostname target was resolved.
+	MessageResolved MessageType = "resolved"
+	// MessagePath carries the path to the target, traced before the check.
+	MessagePath MessageType = "path"
+	// MessageICMPErrors carries the ICMP errors that the client received about
+	// its probes.
+	MessageICMPErrors MessageType = "icmp-errors"
+	// MessageCapabilities is the reply to "test-connection --capabilities".
+	MessageCapabilities MessageType = "capabilities"
+)
+
+type Message struct {
+	Version int
+	Type    MessageType
+
+	Progress     *Progress     `json:",omitempty"`
+	Probe        *ProbeResult  `json:",omitempty"`
+	Result       *Result       `json:",omitempty"`
+	Resolution   *Resolution   `json:",omitempty"`
+	Path         *PathTrace    `json:",omitempty"`
+	ICMPErrors   []ICMPError   `json:",omitempty"`
+	Capabilities *Capabilities `json:",omitempty"`
+}
+
+// Capabilities describes what a test-connection binary supports.
+type Capabilities struct {
+	Version  int
+	Features []string
+}
+
+func (c *Capabilities) Supports(feature string) bool {
+	for _, f := range c.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// supportsAll returns whether the binary supports all of the features.
+func (c *Capabilities) supportsAll(features []string) bool {
+	for _, f := range features {
+		if !c.Supports(f) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c Capabilities) PrintToStdout() {
+	Message{Type: MessageCapabilities, Capabilities: &c}.PrintToStdout()
+}
+
+// Resolution reports the IP that a hostname target was resolved to, and by
+// which DNS server.
+type Resolution struct {
+	Hostname string
+	IP       string
+	Server   string
+}
+
+func (r Resolution) PrintToStdout() {
+	Message{Type: MessageResolved, Resolution: &r}.PrintToStdout()
+}
+
+// Progress reports how a check that runs for a duration is doing.
+type Progress struct {
+	Elapsed time.Duration
+	Stats   Stats
+}
+
+func (p Progress) PrintToStdout() {
+	Message{Type: MessageProgress, Progress: &p}.PrintToStdout()
+}
+
+// PrintConnected reports that a long-lived connection is established.
+func PrintConnected() {
+	Message{Type: MessageConnected}.PrintToStdout()
+}
+
+// stdoutLock stops messages printed from different goroutines from interleaving.
+var stdoutLock sync.Mutex
+
+func (m Message) PrintToStdout() {
+	m.Version = ProtocolVersion
+	encoded, err := json.Marshal(m)
+	if err != nil {
+		log.WithError(err).Panic("Failed to marshall message to stdout")
+	}
+	stdoutLock.Lock()
+	defer stdoutLock.Unlock()
+	fmt.Printf("%s%s\n", MessagePrefix, string(encoded))
+}
+
+// ParseMessage parses a line of test-connection's stdout.  It returns nil if the
+// line is not a protocol message.
+func ParseMessage(line []byte) (*Message, error) {
+	line = bytes.TrimSpace(line)
+	if !bytes.HasPrefix(line, []byte(MessagePrefix)) {
+		return nil, nil
+	}
+	var msg Message
+	if err := json.Unmarshal(line[len(MessagePrefix):], &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+var (
+	capabilitiesLock  sync.Mutex
+	capabilitiesCache = map[string]*Capabilities{}
+	// capabilitiesProbes serialise the probes of each binary, so that the checks
+	// that start together probe it once, without holding up those of other
+	// containers.
+	capabilitiesProbes = map[string]*sync.Mutex{}
+)
+
+// capabilitiesProbeLock returns the lock that serialises the probes of the
+// binary with the given key.
+func capabilitiesProbeLock(key string) *sync.Mutex {
+	capabilitiesLock.Lock()
+	defer capabilitiesLock.Unlock()
+	lock := capabilitiesProbes[key]
+	if lock == nil {
+		lock = &sync.Mutex{}
+		capabilitiesProbes[key] = lock
+	}
+	return lock
+}
+
+// containerCapabilities asks the test-connection binary in the container what it
+// supports, see probeCapabilities().
+func containerCapabilities(cName string) *Capabilities {
+	caps, err := probeCapabilities(cName)
+	Expect(err).NotTo(HaveOccurred())
+	return caps
+}
+
+// probeCapabilities asks the test-connection binary in the container what it
+// supports, see probeCapabilitiesOf().
+func probeCapabilities(cName string) (*Capabilities, error) {
+	return probeCapabilitiesOf(cName, binaryPath(cName))
+}
+
+// probeCapabilitiesOf asks the given test-connection binary in the container what
+// it supports.  Binaries that predate the handshake fail to parse --capabilities,
+// they are reported as version 1 with no features.  It returns an error if
+// test-connection could not be run at all, for example, because the container or
+// the binary is missing.
+func probeCapabilitiesOf(cName, binary string) (*Capabilities, error) {
+	key := cName + ":" + binary
+	probeLock := capabilitiesProbeLock(key)
+	probeLock.Lock()
+	defer probeLock.Unlock()
+	capabilitiesLock.Lock()
+	caps, ok := capabilitiesCache[key]
+	capabilitiesLock.Unlock()
+	if ok {
+		return caps, nil
+	}
+
+	if err := waitForExecToken(context.Background()); err != nil {
+		return nil, err
+	}
+	proc, err := startExec(cName, false, []string{binary, "--capabilities"})
+	if err != nil {
+		return nil, err
+	}
+	var stderr []byte
+	stderrDone := make(chan struct{})
+	go func() {
+		defer close(stderrDone)
+		stderr, _ = io.ReadAll(proc.Stderr())
+	}()
+	scanner := bufio.NewScanner(proc.Stdout())
+	for scanner.Scan() {
+		msg, err := ParseMessage(scanner.Bytes())
+		if err == nil && msg != nil && msg.Capabilities != nil {
+			caps = msg.Capabilities
+		}
+	}
+	<-stderrDone
+	err = proc.Wait()
+
+	if caps == nil {
+		if !bytes.Contains(stderr, []byte("Usage:")) {
+			return nil, fmt.Errorf("failed to run %s in container %s: %v: %s",
+				BinaryName, cName, err, string(bytes.TrimSpace(stderr)))
+		}
+		// An old binary that printed its usage.
+		caps = &Capabilities{Version: 1}
+	}
+
+	log.WithFields(log.Fields{
+		"container":    cName,
+		"binary":       binary,
+		"capabilities": caps,
+	}).Info("Probed test-connection capabilities")
+	capabilitiesLock.Lock()
+	capabilitiesCache[key] = caps
+	capabilitiesLock.Unlock()
+	return caps, nil
+}
+
+// forgetCapabilities drops the cached capabilities of the binaries in the
+// container, after they have been replaced.  It waits for the probes in flight,
+// so that they can't cache the capabilities of the old binaries afterwards.
+func forgetCapabilities(cName string) {
+	capabilitiesLock.Lock()
+	var probeLocks []*sync.Mutex
+	for key, lock := range capabilitiesProbes {
+		if strings.HasPrefix(key, cName+":") {
+			probeLocks = append(probeLocks, lock)
+		}
+	}
+	capabilitiesLock.Unlock()
+
+	for _, lock := range probeLocks {
+		lock.Lock()
+	}
+	capabilitiesLock.Lock()
+	for key := range capabilitiesCache {
+		if strings.HasPrefix(key, cName+":") {
+			delete(capabilitiesCache, key)
+		}
+	}
+	capabilitiesLock.Unlock()
+	for _, lock := range probeLocks {
+		lock.Unlock()
+	}
+}
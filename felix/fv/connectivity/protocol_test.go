@@ -0,0 +1,67 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	. "github.com/projectcalico/calico/felix/fv/connectivity"
+)
+
+var _ = Describe("ParseMessage", func() {
+	It("should parse a message", func() {
+		msg, err := ParseMessage([]byte(MessagePrefix +
+			`{"Version":2,"Type":"result","Result":{"LastResponse":{"SourceAddr":"10.65.0.1:1234"}}}` + "\n"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(msg).NotTo(BeNil())
+		Expect(msg.Version).To(Equal(2))
+		Expect(msg.Type).To(Equal(MessageResult))
+		Expect(msg.Result).NotTo(BeNil())
+		Expect(msg.Result.LastResponse.SourceIP()).To(Equal("10.65.0.1"))
+	})
+
+	It("should parse capabilities", func() {
+		msg, err := ParseMessage([]byte(MessagePrefix +
+			`{"Version":2,"Type":"capabilities","Capabilities":{"Version":2,"Features":["flows","idle"]}}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(msg.Capabilities).NotTo(BeNil())
+		Expect(msg.Capabilities.Supports(FeatureIdle)).To(BeTrue())
+		Expect(msg.Capabilities.Supports(FeatureContinuous)).To(BeFalse())
+	})
+
+	DescribeTable("should ignore lines that aren't messages",
+		func(line string) {
+			msg, err := ParseMessage([]byte(line))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(msg).To(BeNil())
+		},
+		Entry("empty", ""),
+		Entry("blank", "  \n"),
+		Entry("legacy result", `RESULT={"LastResponse":{}}`),
+		Entry("prefix later in the line", "output: "+MessagePrefix+"{}"),
+	)
+
+	DescribeTable("should fail on malformed messages",
+		func(line string) {
+			_, err := ParseMessage([]byte(line))
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("no JSON", MessagePrefix),
+		Entry("truncated", MessagePrefix+`{"Version":2,"Type":"res`),
+		Entry("wrong type", MessagePrefix+`{"Version":"2"}`),
+	)
+})
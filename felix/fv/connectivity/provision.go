@@ -0,0 +1,129 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/projectcalico/calico/felix/fv/utils"
+)
+
+// ProvisionBinaryPath is the test-connection binary that is copied into
+// containers that lack a compatible one, see WithAutoProvision().  A relative
+// path is relative to the working directory of the FV tests.
+var ProvisionBinaryPath = filepath.Join("..", "bin", BinaryName)
+
+// provisionedPath is where test-connection is copied to in a container.  Since
+// the directory might not be on the container's PATH, it is run by its full path.
+const provisionedPath = "/" + BinaryName
+
+// sshProvisionedPath is where test-connection is copied to on an SSHSource's
+// machine: the login directory, since the user might not be able to write to /.
+const sshProvisionedPath = "./" + BinaryName
+
+var (
+	// provisionLock protects binaryPaths and containerProvisions.
+	provisionLock sync.Mutex
+	binaryPaths   = map[string]string{}
+	// containerProvisions serialise the provisioning of each container, so
+	// that the checks that start together copy test-connection into it once,
+	// without holding up the checks of other containers.
+	containerProvisions = map[string]*sync.Mutex{}
+)
+
+// containerProvisionLock returns the lock that serialises the provisioning of
+// the container.
+func containerProvisionLock(cName string) *sync.Mutex {
+	provisionLock.Lock()
+	defer provisionLock.Unlock()
+	lock := containerProvisions[cName]
+	if lock == nil {
+		lock = &sync.Mutex{}
+		containerProvisions[cName] = lock
+	}
+	return lock
+}
+
+// binaryPath returns the command that runs test-connection in the container.
+func binaryPath(cName string) string {
+	provisionLock.Lock()
+	defer provisionLock.Unlock()
+	if p, ok := binaryPaths[cName]; ok {
+		return p
+	}
+	return platformBinaryName(cName)
+}
+
+// ensureProvisioned copies test-connection into the container if it lacks a
+// binary that speaks the current ProtocolVersion with all of its Features.
+func ensureProvisioned(cName string) error {
+	lock := containerProvisionLock(cName)
+	lock.Lock()
+	defer lock.Unlock()
+	provisionLock.Lock()
+	_, ok := binaryPaths[cName]
+	provisionLock.Unlock()
+	if ok {
+		return nil
+	}
+
+	caps, err := probeCapabilitiesOf(cName, platformBinaryName(cName))
+	if err == nil && caps.Version >= ProtocolVersion && caps.supportsAll(Features) {
+		return nil
+	}
+	if containerPlatform(cName) == PlatformWindows {
+		// test-connection only builds for Linux, a Windows image must come with
+		// its own test-connection.exe.
+		return fmt.Errorf("%s in Windows container %s is missing or too old, and there is no Windows build "+
+			"to provision", BinaryName, cName)
+	}
+
+	src, err := filepath.Abs(ProvisionBinaryPath)
+	if err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"container": cName,
+		"binary":    src,
+	}).Info("Provisioning test-connection into container")
+	dst := provisionedPath
+	if h := sshHostFor(cName); h != nil {
+		dst = sshProvisionedPath
+		err = copyToSSHHost(h, src, dst)
+	} else {
+		err = utils.RunMayFail("docker", "cp", src, cName+":"+dst)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to provision %s into container %s: %w", BinaryName, cName, err)
+	}
+	provisionLock.Lock()
+	binaryPaths[cName] = dst
+	provisionLock.Unlock()
+	forgetCapabilities(cName)
+	return nil
+}
+
+// WithAutoProvision makes the check copy test-connection into the source
+// container before the check if the container lacks a compatible binary.  This
+// allows checks from arbitrary images.
+func WithAutoProvision() CheckOption {
+	return func(c *CheckCmd) {
+		c.autoProvision = true
+	}
+}
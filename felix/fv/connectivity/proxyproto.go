@@ -0,0 +1,204 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+// proxyV2Signature starts every PROXY protocol v2 header.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+const (
+	proxyV2CmdLocal = 0x20
+	proxyV2CmdProxy = 0x21
+	proxyV2TCP4     = 0x11
+	proxyV2TCP6     = 0x21
+)
+
+// ProxyHeader is a PROXY protocol v2 header, as sent by a proxy ahead of the
+// connection's data to tell the backend who the original client was.
+type ProxyHeader struct {
+	// SourceAddr and DestAddr are the addresses of the original connection,
+	// empty for a LOCAL header, which a proxy sends for its own connections.
+	SourceAddr string
+	DestAddr   string
+}
+
+func (h *ProxyHeader) String() string {
+	if h.SourceAddr == "" {
+		return "PROXY LOCAL"
+	}
+	return "PROXY " + h.SourceAddr + " -> " + h.DestAddr
+}
+
+// EncodeProxyV2 returns the PROXY protocol v2 header of a TCP connection between
+// the given addresses, both in "ip:port" form and of the same IP family.
+func EncodeProxyV2(src, dst string) ([]byte, error) {
+	srcIP, srcPort, err := parseProxyAddr(src)
+	if err != nil {
+		return nil, err
+	}
+	dstIP, dstPort, err := parseProxyAddr(dst)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.Write(proxyV2Signature)
+	buf.WriteByte(proxyV2CmdProxy)
+	if src4, dst4 := srcIP.To4(), dstIP.To4(); src4 != nil && dst4 != nil {
+		buf.WriteByte(proxyV2TCP4)
+		_ = binary.Write(&buf, binary.BigEndian, uint16(12))
+		buf.Write(src4)
+		buf.Write(dst4)
+	} else if src4 == nil && dst4 == nil {
+		buf.WriteByte(proxyV2TCP6)
+		_ = binary.Write(&buf, binary.BigEndian, uint16(36))
+		buf.Write(srcIP.To16())
+		buf.Write(dstIP.To16())
+	} else {
+		return nil, fmt.Errorf("PROXY header addresses %s and %s are of different IP families", src, dst)
+	}
+	_ = binary.Write(&buf, binary.BigEndian, srcPort)
+	_ = binary.Write(&buf, binary.BigEndian, dstPort)
+	return buf.Bytes(), nil
+}
+
+func parseProxyAddr(addr string) (net.IP, uint16, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, 0, fmt.Errorf("bad PROXY header address %q: %w", addr, err)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, 0, fmt.Errorf("bad PROXY header address %q: not an IP", addr)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, 0, fmt.Errorf("bad PROXY header address %q: %w", addr, err)
+	}
+	return ip, uint16(p), nil
+}
+
+// ReadProxyV2 reads the PROXY protocol v2 header from the start of a connection,
+// if there is one.  It returns nil, without consuming anything, if the data
+// doesn't start with a header.
+func ReadProxyV2(r *bufio.Reader) (*ProxyHeader, error) {
+	sig, err := r.Peek(len(proxyV2Signature))
+	if err != nil || !bytes.Equal(sig, proxyV2Signature) {
+		// Too short to be a header, or not a header; leave the error, if any, to
+		// the reader of the data.
+		return nil, nil
+	}
+	var fixed [16]byte
+	if _, err := io.ReadFull(r, fixed[:]); err != nil {
+		return nil, err
+	}
+	body := make([]byte, binary.BigEndian.Uint16(fixed[14:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+
+	switch fixed[12] {
+	case proxyV2CmdLocal:
+		return &ProxyHeader{}, nil
+	case proxyV2CmdProxy:
+	default:
+		return nil, fmt.Errorf("unsupported PROXY header version/command %#x", fixed[12])
+	}
+
+	var ipLen int
+	switch fixed[13] {
+	case proxyV2TCP4:
+		ipLen = net.IPv4len
+	case proxyV2TCP6:
+		ipLen = net.IPv6len
+	default:
+		return nil, fmt.Errorf("unsupported PROXY header family/protocol %#x", fixed[13])
+	}
+	// Any TLVs follow the addresses, we ignore them.
+	if len(body) < 2*ipLen+4 {
+		return nil, errors.New("truncated PROXY header")
+	}
+	srcIP := net.IP(body[:ipLen])
+	dstIP := net.IP(body[ipLen : 2*ipLen])
+	srcPort := binary.BigEndian.Uint16(body[2*ipLen:])
+	dstPort := binary.BigEndian.Uint16(body[2*ipLen+2:])
+	return &ProxyHeader{
+		SourceAddr: net.JoinHostPort(srcIP.String(), strconv.Itoa(int(srcPort))),
+		DestAddr:   net.JoinHostPort(dstIP.String(), strconv.Itoa(int(dstPort))),
+	}, nil
+}
+
+// WithProxyProtocol makes the client of a TCP check send a PROXY protocol v2
+// header, claiming that the connection came from src, "ip:port".  An empty src
+// sends the connection's own addresses.
+func WithProxyProtocol(src string) CheckOption {
+	return func(c *CheckCmd) {
+		c.proxyProtocol = true
+		c.proxySource = src
+	}
+}
+
+// ExpectWithProxyProtocol sends a PROXY protocol v2 header that claims that the
+// connection came from src, see WithProxyProtocol(), and asserts that the server
+// received it, as a proxy-aware backend would.
+func ExpectWithProxyProtocol(src string) ExpectationOption {
+	return func(e *Expectation) {
+		e.proxyProtocol = true
+		e.proxySource = src
+		e.expProxySource = src
+	}
+}
+
+// ExpectProxyHeaderFrom asserts that the server received a PROXY protocol v2
+// header that names src, "ip:port" or just an IP, as the original client, for
+// example, one added by the dataplane rather than by the client.
+func ExpectProxyHeaderFrom(src string) ExpectationOption {
+	return func(e *Expectation) {
+		e.expProxySource = src
+	}
+}
+
+func (e Expectation) proxyMatches(res *Result) bool {
+	if e.expProxySource == "" && !e.proxyProtocol {
+		return true
+	}
+	h := res.LastResponse.Proxy
+	if h == nil {
+		return false
+	}
+	if e.expProxySource == "" {
+		return true
+	}
+	return h.SourceAddr == e.expProxySource || hostOf(h.SourceAddr) == e.expProxySource
+}
+
+// proxyArg is the --proxy-protocol argument of test-connection, "-" to send the
+// connection's own addresses.
+func proxyArg(src string) string {
+	if src == "" {
+		return "-"
+	}
+	return src
+}
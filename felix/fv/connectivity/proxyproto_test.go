@@ -0,0 +1,99 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity_test
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	. "github.com/projectcalico/calico/felix/fv/connectivity"
+)
+
+var _ = Describe("ReadProxyV2", func() {
+	// The signature of a PROXY protocol v2 header.
+	const sig = "\r\n\r\n\x00\r\nQUIT\n"
+
+	read := func(data []byte) (*ProxyHeader, []byte, error) {
+		r := bufio.NewReader(bytes.NewReader(data))
+		h, err := ReadProxyV2(r)
+		rest, _ := io.ReadAll(r)
+		return h, rest, err
+	}
+
+	DescribeTable("should read the header that EncodeProxyV2 encodes",
+		func(src, dst string) {
+			header, err := EncodeProxyV2(src, dst)
+			Expect(err).NotTo(HaveOccurred())
+
+			h, rest, err := read(append(header, "data"...))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(h).To(Equal(&ProxyHeader{SourceAddr: src, DestAddr: dst}))
+			Expect(string(rest)).To(Equal("data"))
+		},
+		Entry("IPv4", "10.65.0.1:1234", "10.65.1.2:8055"),
+		Entry("IPv6", "[fdc6:3dbc:e983:cbc0::1]:1234", "[fdc6:3dbc:e983:cbc0::2]:8055"),
+	)
+
+	It("should read a LOCAL header", func() {
+		h, rest, err := read([]byte(sig + "\x20\x00\x00\x00data"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(h).To(Equal(&ProxyHeader{}))
+		Expect(h.String()).To(Equal("PROXY LOCAL"))
+		Expect(string(rest)).To(Equal("data"))
+	})
+
+	It("should skip the TLVs after the addresses", func() {
+		header, err := EncodeProxyV2("10.65.0.1:1234", "10.65.1.2:8055")
+		Expect(err).NotTo(HaveOccurred())
+		// Add a 4 byte TLV to the length and the body.
+		header[15] += 4
+		header = append(header, 0x04, 0x00, 0x01, 0xff)
+
+		h, rest, err := read(append(header, "data"...))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(h.SourceAddr).To(Equal("10.65.0.1:1234"))
+		Expect(string(rest)).To(Equal("data"))
+	})
+
+	DescribeTable("should leave data without a header alone",
+		func(data string) {
+			h, rest, err := read([]byte(data))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(h).To(BeNil())
+			Expect(string(rest)).To(Equal(data))
+		},
+		Entry("empty", ""),
+		Entry("shorter than the signature", "\r\n\r\n"),
+		Entry("request", `{"Version":2}`),
+		Entry("PROXY v1", "PROXY TCP4 10.65.0.1 10.65.1.2 1234 8055\r\n"),
+	)
+
+	DescribeTable("should fail on bad headers",
+		func(data string) {
+			_, _, err := read([]byte(data))
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("truncated fixed part", sig+"\x21"),
+		Entry("truncated body", sig+"\x21\x11\x00\x0c\x0a\x41"),
+		Entry("body too short for the addresses", sig+"\x21\x11\x00\x04\x0a\x41\x00\x01"),
+		Entry("unsupported command", sig+"\x22\x11\x00\x00"),
+		Entry("unsupported family", sig+"\x21\x12\x00\x00"),
+	)
+})
@@ -0,0 +1,182 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// QuarantineFileEnv names the environment variable with the path of the
+	// quarantine file, see QuarantineRule.
+	QuarantineFileEnv = "FELIX_FV_QUARANTINE_FILE"
+	// QuarantineEnv names the environment variable with quarantine rules,
+	// separated by semicolons, on top of those of the file.
+	QuarantineEnv = "FELIX_FV_QUARANTINE"
+)
+
+// QuarantineRule matches flaky paths whose failures are downgraded to warnings,
+// so that CI keeps running the rest of the matrix while the path is being
+// investigated.  In the quarantine file, each line holds a rule as the source,
+// the target and the port, separated by spaces, with shell patterns, for
+// example:
+//
+//	# Flaky since the kernel upgrade.
+//	ep1-* 10.65.1.* 8055
+//
+// The target matches the name or the IP of the target, and "#" starts a
+// comment.
+type QuarantineRule struct {
+	From string
+	To   string
+	Port string
+}
+
+func (r QuarantineRule) String() string {
+	return fmt.Sprintf("%s %s %s", r.From, r.To, r.Port)
+}
+
+// Matches returns whether the rule quarantines the path of the expectation.
+func (r QuarantineRule) Matches(exp Expectation) bool {
+	return globMatch(r.From, exp.From.SourceName()) &&
+		(globMatch(r.To, exp.To.TargetName) || globMatch(r.To, exp.To.IP)) &&
+		globMatch(r.Port, exp.To.Port)
+}
+
+func globMatch(pattern, s string) bool {
+	ok, err := path.Match(pattern, s)
+	return err == nil && ok
+}
+
+// ParseQuarantine parses quarantine rules, one per line, see QuarantineRule.
+func ParseQuarantine(r io.Reader) ([]QuarantineRule, error) {
+	var rules []QuarantineRule
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		rule, err := parseQuarantineRule(scanner.Text())
+		if err != nil {
+			return nil, err
+		}
+		if rule != nil {
+			rules = append(rules, *rule)
+		}
+	}
+	return rules, scanner.Err()
+}
+
+func parseQuarantineRule(line string) (*QuarantineRule, error) {
+	if i := strings.Index(line, "#"); i >= 0 {
+		line = line[:i]
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("invalid quarantine rule %q, expected <from> <to> <port>", line)
+	}
+	for _, f := range fields {
+		if _, err := path.Match(f, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q in quarantine rule %q: %w", f, line, err)
+		}
+	}
+	return &QuarantineRule{From: fields[0], To: fields[1], Port: fields[2]}, nil
+}
+
+var (
+	quarantineOnce  sync.Once
+	quarantineRules []QuarantineRule
+	quarantineErr   error
+)
+
+// quarantine returns the rules from QuarantineFileEnv and QuarantineEnv, loaded
+// on first use.  Invalid rules fail every check that looks them up, rather than
+// quietly quarantining nothing.
+func quarantine() []QuarantineRule {
+	quarantineOnce.Do(func() {
+		quarantineRules, quarantineErr = loadQuarantine()
+		if quarantineErr != nil {
+			log.WithError(quarantineErr).Error("Failed to load quarantine rules")
+			return
+		}
+		if len(quarantineRules) > 0 {
+			log.WithField("rules", quarantineRules).Warn("Failures of quarantined connectivity paths will only be logged")
+		}
+	})
+	ExpectWithOffset(2, quarantineErr).NotTo(HaveOccurred(), "Invalid connectivity quarantine")
+	return quarantineRules
+}
+
+func loadQuarantine() ([]QuarantineRule, error) {
+	var rules []QuarantineRule
+	if file := os.Getenv(QuarantineFileEnv); file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open quarantine file: %w", err)
+		}
+		rules, err = ParseQuarantine(f)
+		_ = f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse quarantine file %s: %w", file, err)
+		}
+	}
+	if env := os.Getenv(QuarantineEnv); env != "" {
+		envRules, err := ParseQuarantine(strings.NewReader(strings.ReplaceAll(env, ";", "\n")))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", QuarantineEnv, err)
+		}
+		rules = append(rules, envRules...)
+	}
+	return rules, nil
+}
+
+// quarantinedBy returns the quarantine rule that matches the expectation, if any.
+func quarantinedBy(exp Expectation) *QuarantineRule {
+	for _, r := range quarantine() {
+		if r.Matches(exp) {
+			return &r
+		}
+	}
+	return nil
+}
+
+// reportQuarantined logs the failures of quarantined paths in a check that
+// passed otherwise.
+func reportQuarantined(mismatches []MismatchDetail) {
+	if len(mismatches) == 0 {
+		return
+	}
+	strs := make([]string, len(mismatches))
+	for i, m := range mismatches {
+		strs[i] = fmt.Sprintf("%s (expected %v)", m.ActualPretty, m.Expected)
+		log.WithFields(log.Fields{
+			"source":   m.Source,
+			"target":   m.Target,
+			"expected": m.ExpectedPretty,
+			"actual":   m.ActualPretty,
+			"result":   m.Actual,
+		}).Warn("Quarantined connectivity path failed, ignoring")
+	}
+	AttachToSpec("Quarantined connectivity failures", strs)
+}
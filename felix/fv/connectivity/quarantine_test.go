@@ -0,0 +1,94 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity_test
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	. "github.com/projectcalico/calico/felix/fv/connectivity"
+)
+
+// namedSource is a ConnectionSource that only has a name.
+type namedSource string
+
+func (s namedSource) PreRetryCleanup(ip, port, protocol string, opts ...CheckOption) {}
+
+func (s namedSource) CanConnectTo(ip, port, protocol string, opts ...CheckOption) *Result {
+	return nil
+}
+
+func (s namedSource) SourceName() string {
+	return string(s)
+}
+
+func (s namedSource) SourceIPs() []string {
+	return nil
+}
+
+var _ = Describe("ParseQuarantine", func() {
+	It("should parse rules and skip comments and blank lines", func() {
+		rules, err := ParseQuarantine(strings.NewReader(`
+# Flaky since the kernel upgrade.
+ep1-* 10.65.1.* 8055
+
+  ep2   ep3:*   *   # Trailing comment.
+`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rules).To(Equal([]QuarantineRule{
+			{From: "ep1-*", To: "10.65.1.*", Port: "8055"},
+			{From: "ep2", To: "ep3:*", Port: "*"},
+		}))
+	})
+
+	It("should return no rules for an empty file", func() {
+		rules, err := ParseQuarantine(strings.NewReader("# Nothing quarantined.\n"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rules).To(BeEmpty())
+	})
+
+	DescribeTable("should reject invalid rules",
+		func(line string) {
+			_, err := ParseQuarantine(strings.NewReader(line))
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("too few fields", "ep1 ep2"),
+		Entry("too many fields", "ep1 ep2 8055 tcp"),
+		Entry("bad pattern", "ep1[ ep2 8055"),
+	)
+})
+
+var _ = Describe("QuarantineRule", func() {
+	exp := Expectation{
+		From: namedSource("ep1-1"),
+		To:   TargetIP("10.65.1.2").ToMatcher(8055),
+	}
+
+	DescribeTable("Matches",
+		func(rule QuarantineRule, expected bool) {
+			Expect(rule.Matches(exp)).To(Equal(expected))
+		},
+		Entry("exact", QuarantineRule{From: "ep1-1", To: "10.65.1.2:8055", Port: "8055"}, true),
+		Entry("target by IP", QuarantineRule{From: "ep1-1", To: "10.65.1.2", Port: "8055"}, true),
+		Entry("patterns", QuarantineRule{From: "ep1-*", To: "10.65.1.*", Port: "80*"}, true),
+		Entry("other source", QuarantineRule{From: "ep2-*", To: "*", Port: "*"}, false),
+		Entry("other target", QuarantineRule{From: "*", To: "10.65.2.*", Port: "*"}, false),
+		Entry("other port", QuarantineRule{From: "*", To: "*", Port: "8056"}, false),
+		Entry("pattern must match the whole name", QuarantineRule{From: "ep1", To: "*", Port: "*"}, false),
+	)
+})
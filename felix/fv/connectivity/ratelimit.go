@@ -0,0 +1,66 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// DefaultExecRate is a suggested number of test-connection processes per
+	// second that may be launched with "docker exec", see SetExecRateLimit().
+	DefaultExecRate = 20
+	// DefaultExecBurst is a suggested number of test-connection processes that
+	// may be launched at once, see SetExecRateLimit().
+	DefaultExecBurst = 20
+)
+
+// execLimiter is shared by all the Checkers (and persistent connections) of the
+// process so that specs running in parallel don't overload the docker daemon
+// between them.  It doesn't limit anything until SetExecRateLimit() is called.
+var execLimiter = rate.NewLimiter(rate.Inf, 0)
+
+// SetExecRateLimit configures the token bucket that limits how fast
+// test-connection processes are launched, across all Checkers.  A rate of
+// rate.Inf disables the limit, which is the default.  The bucket is per process:
+// with ginkgo -p, each of the N parallel processes has its own, so the docker
+// daemon may see N times the rate, divide the rate by the number of processes
+// to limit their total.
+func SetExecRateLimit(perSecond rate.Limit, burst int) {
+	Expect(perSecond).To(BeNumerically(">", 0), "Exec rate must be positive")
+	Expect(burst).To(BeNumerically(">", 0), "Exec burst must be positive")
+
+	execLimiter.SetLimit(perSecond)
+	execLimiter.SetBurst(burst)
+}
+
+// waitForExecToken blocks until the rate limiter allows another test-connection
+// process to be launched.  It returns an error, rather than waiting any longer, if
+// the context of the probe is done first.
+func waitForExecToken(ctx context.Context) error {
+	start := time.Now()
+	if err := execLimiter.Wait(ctx); err != nil {
+		return err
+	}
+	if waited := time.Since(start); waited > time.Second {
+		log.WithField("waited", waited).Info("Rate limited launching test-connection")
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"strings"
+	"time"
+)
+
+// CheckReport describes a run of CheckConnectivity(), so that the test can make
+// follow-up assertions on the results, for example, on the latency or the SNAT
+// of a path, without probing again.
+type CheckReport struct {
+	Start    time.Time
+	Duration time.Duration
+
+	// Expectations holds the outcome of each expectation, in the order they
+	// were added to the Checker.
+	Expectations []ExpectationReport
+	// Attempts holds the attempts of the run, in order.  With
+	// CheckWithResultStream(), only the last one has its results.
+	Attempts []Attempt
+
+	// Expected and Actual are the expected and the actual connectivity of the
+	// last attempt, a line per expectation, as in the failure message.  With
+	// CheckWithLazyPretty(), the expectations that passed have empty lines in
+	// Actual.
+	Expected string
+	Actual   string
+
+	// Err is why the run failed, nil if it passed.
+	Err *CheckError
+}
+
+// ExpectationReport is the outcome of an expectation in a CheckReport.
+type ExpectationReport struct {
+	ExpectationResult
+
+	// Result is the result of the expectation on the last attempt, nil if it
+	// wasn't probed.
+	Result *Result
+	// Convergence is how long the expectation took to first pass.
+	Convergence Convergence
+}
+
+// Passed returns whether the run passed.
+func (r *CheckReport) Passed() bool {
+	return r.Err == nil
+}
+
+// Find returns the outcome of the first expectation from the named source to
+// the named target, nil if there is none.
+func (r *CheckReport) Find(source, target string) *ExpectationReport {
+	for i := range r.Expectations {
+		if e := &r.Expectations[i]; e.Source == source && e.Target == target {
+			return e
+		}
+	}
+	return nil
+}
+
+// TryCheckConnectivity is CheckConnectivity() for tests that decide for
+// themselves what a failure means: instead of failing the test, it returns the
+// *CheckError of the run, also in the report's Err.
+func (c *Checker) TryCheckConnectivity(opts ...interface{}) (*CheckReport, error) {
+	c.deferFailure = true
+	defer func() { c.deferFailure = false }()
+
+	report := c.CheckConnectivityWithTimeoutOffset(2, c.context().defaultTimeout(), opts...)
+	if report.Err != nil {
+		return report, report.Err
+	}
+	return report, nil
+}
+
+// checkReport builds the report of the run from its attempts and the failure
+// recorded by fail(), if any.
+func (c *Checker) checkReport(start time.Time, attempts []Attempt, expConnectivity []string) *CheckReport {
+	run := c.runResults(start, attempts, c.failure)
+	report := &CheckReport{
+		Start:    start,
+		Duration: run.Duration,
+		Attempts: attempts,
+		Err:      c.failure,
+	}
+
+	var last Attempt
+	if len(attempts) > 0 {
+		last = attempts[len(attempts)-1]
+	}
+	report.Expected = strings.Join(c.prettyTable(expConnectivity, &last, false), "\n")
+	report.Actual = strings.Join(c.prettyTable(last.Pretty, &last, false), "\n")
+	conv := c.convergence(start, attempts)
+	for i, exp := range run.Expectations {
+		e := ExpectationReport{
+			ExpectationResult: exp,
+			Convergence:       conv[i],
+		}
+		if i < len(last.Results) {
+			e.Result = last.Results[i]
+		}
+		report.Expectations = append(report.Expectations, e)
+	}
+	return report
+}
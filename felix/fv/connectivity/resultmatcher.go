@@ -0,0 +1,56 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import "fmt"
+
+// ExpectWithResultMatcher adds an arbitrary check of the result of the
+// expectation, for tests that need to check something, for example, a new field
+// of the Result, that no option covers yet.  The expectation only passes if f
+// returns nil for its result, the error is shown in the failure message
+// otherwise.  f is called with each result that a probe produced, whether or
+// not the expectation is of connectivity, and can be called more than once for
+// the same result, so it must not have side effects.  The option can be given
+// several times, all the matchers must pass.
+func ExpectWithResultMatcher(f func(*Result) error) ExpectationOption {
+	return func(e *Expectation) {
+		e.resultMatchers = append(e.resultMatchers, f)
+	}
+}
+
+// resultMatcherErr returns the error of the first result matcher that fails,
+// nil if they all pass or there is no result.
+func (e Expectation) resultMatcherErr(res *Result) error {
+	if res == nil || res.HarnessErr != nil {
+		return nil
+	}
+	for _, f := range e.resultMatchers {
+		if err := f(res); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e Expectation) resultMatchersPretty() string {
+	switch n := len(e.resultMatchers); n {
+	case 0:
+		return ""
+	case 1:
+		return " (custom matcher)"
+	default:
+		return fmt.Sprintf(" (%d custom matchers)", n)
+	}
+}
@@ -0,0 +1,285 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/onsi/ginkgo"
+	log "github.com/sirupsen/logrus"
+)
+
+// CheckWithResultsDir makes the Checker record the results of each run of
+// CheckConnectivity(), passed or failed, in a JSON file per spec under dir, see
+// SpecResults.  Use a fresh dir per test run, then LoadResults() and
+// DiffResults() to compare two runs.
+func CheckWithResultsDir(dir string) CheckerOpt {
+	return func(c *Checker) {
+		log.Debug("CheckWithResultsDir set")
+		c.resultsDir = dir
+	}
+}
+
+// SpecResults is the content of the results file of a spec.
+type SpecResults struct {
+	// Spec is the full text of the spec.
+	Spec string
+	// Runs holds the runs of CheckConnectivity() in the spec, in order.
+	Runs []RunResults
+}
+
+// RunResults records a run of CheckConnectivity().
+type RunResults struct {
+	Start    time.Time
+	Duration time.Duration
+	Passed   bool
+	// Kind is the kind of failure, if the run failed.
+	Kind ErrorKind `json:",omitempty"`
+
+	Expectations []ExpectationResult
+	Attempts     []AttemptResult
+	Convergence  []Convergence
+}
+
+// ExpectationResult records the outcome of an expectation in a run.
+type ExpectationResult struct {
+	Source   string
+	Target   string
+	Expected Expected
+	Pretty   string
+	// Passed is whether the expectation passed the last attempt.
+	Passed bool
+}
+
+// Path identifies the expectation across runs.
+func (e ExpectationResult) Path() string {
+	return e.Source + " -> " + e.Target
+}
+
+// AttemptResult records an attempt of a run.
+type AttemptResult struct {
+	Number   int
+	Start    time.Time
+	Duration time.Duration
+	Passed   bool
+	// Results and Pretty hold the result of each expectation.
+	Results []*Result
+	Pretty  []string
+}
+
+// runResults summarises the run.  checkErr is nil if the run passed.
+func (c *Checker) runResults(start time.Time, attempts []Attempt, checkErr *CheckError) RunResults {
+	run := RunResults{
+		Start:    start,
+		Duration: time.Since(start),
+		Passed:   checkErr == nil,
+	}
+	if checkErr != nil {
+		run.Kind = checkErr.Kind
+	}
+
+	failed := map[int]bool{}
+	var expectations []Expectation
+	if len(attempts) > 0 {
+		last := attempts[len(attempts)-1]
+		expectations = last.expectations
+		for _, m := range last.Mismatches {
+			failed[m.Index] = true
+		}
+		for _, m := range last.Denials {
+			failed[m.Index] = true
+		}
+		for _, m := range last.Encap {
+			failed[m.Index] = true
+		}
+	}
+	for i, exp := range expectations {
+		run.Expectations = append(run.Expectations, ExpectationResult{
+			Source:   exp.sourceName(),
+			Target:   exp.To.TargetName,
+			Expected: exp.Expected,
+			Pretty:   c.expectedPretty(exp),
+			Passed:   !failed[i],
+		})
+	}
+	run.Convergence = c.lastConvergence
+	for _, a := range attempts {
+		run.Attempts = append(run.Attempts, AttemptResult{
+			Number:   a.Number,
+			Start:    a.Start,
+			Duration: a.Duration,
+			Passed:   a.Passed,
+			Results:  a.Results,
+			Pretty:   a.Pretty,
+		})
+	}
+	return run
+}
+
+// reportRun records the run in the results file, the JUnit report and the
+// attachments of the spec, if enabled.
+func (c *Checker) reportRun(run RunResults) {
+	if c.resultsDir != "" {
+		c.recordResults(run)
+	}
+	if c.junitDir != "" {
+		c.writeJUnitReport(run)
+	}
+	if c.attachRuns {
+		attachRun(run)
+	}
+}
+
+// recordResults appends the run to the results file of the spec.
+func (c *Checker) recordResults(run RunResults) {
+	if err := os.MkdirAll(c.resultsDir, 0o755); err != nil {
+		log.WithError(err).Warn("Failed to create results directory")
+		return
+	}
+	path := filepath.Join(c.resultsDir, specDirName()+".json")
+	results := SpecResults{Spec: ginkgo.CurrentGinkgoTestDescription().FullTestText}
+	if existing, err := loadSpecResults(path); err == nil {
+		results = *existing
+	} else if !os.IsNotExist(err) {
+		log.WithError(err).WithField("file", path).Warn("Failed to read results file, overwriting it")
+	}
+	results.Runs = append(results.Runs, run)
+
+	data, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		log.WithError(err).Warn("Failed to encode results")
+		return
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		log.WithError(err).WithField("file", path).Warn("Failed to write results file")
+	}
+}
+
+func loadSpecResults(path string) (*SpecResults, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var results SpecResults
+	if err := json.Unmarshal(data, &results); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return &results, nil
+}
+
+// LoadResults loads the results files that CheckWithResultsDir() wrote to dir,
+// keyed by spec.
+func LoadResults(dir string) (map[string]*SpecResults, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	results := map[string]*SpecResults{}
+	for _, p := range paths {
+		r, err := loadSpecResults(p)
+		if err != nil {
+			return nil, err
+		}
+		results[r.Spec] = r
+	}
+	return results, nil
+}
+
+// PathStatus is the outcome of an expectation's path in a test run.
+type PathStatus string
+
+const (
+	PathPassed PathStatus = "passed"
+	PathFailed PathStatus = "failed"
+	// PathMissing means that the run didn't check the path.
+	PathMissing PathStatus = "missing"
+)
+
+// ResultChange is a path whose outcome differs between two test runs.
+type ResultChange struct {
+	Spec string
+	Path string
+	Old  PathStatus
+	New  PathStatus
+}
+
+// NewlyFailed returns whether the path failed in the new run but not in the
+// old one.
+func (c ResultChange) NewlyFailed() bool {
+	return c.New == PathFailed && c.Old != PathFailed
+}
+
+func (c ResultChange) String() string {
+	return fmt.Sprintf("%s: %s: %s -> %s", c.Spec, c.Path, c.Old, c.New)
+}
+
+// DiffResults compares the results of two test runs, as loaded by
+// LoadResults(), and returns the paths whose outcome changed, sorted by spec
+// and path.  A path fails in a run if any check of it in the spec failed.
+func DiffResults(before, after map[string]*SpecResults) []ResultChange {
+	oldStatus := pathStatuses(before)
+	newStatus := pathStatuses(after)
+	keys := map[[2]string]bool{}
+	for k := range oldStatus {
+		keys[k] = true
+	}
+	for k := range newStatus {
+		keys[k] = true
+	}
+
+	var changes []ResultChange
+	for k := range keys {
+		o, n := oldStatus[k], newStatus[k]
+		if o == "" {
+			o = PathMissing
+		}
+		if n == "" {
+			n = PathMissing
+		}
+		if o != n {
+			changes = append(changes, ResultChange{Spec: k[0], Path: k[1], Old: o, New: n})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Spec != changes[j].Spec {
+			return changes[i].Spec < changes[j].Spec
+		}
+		return changes[i].Path < changes[j].Path
+	})
+	return changes
+}
+
+// pathStatuses returns the status of each spec and path of a test run.
+func pathStatuses(results map[string]*SpecResults) map[[2]string]PathStatus {
+	statuses := map[[2]string]PathStatus{}
+	for spec, r := range results {
+		for _, run := range r.Runs {
+			for _, e := range run.Expectations {
+				k := [2]string{spec, e.Path()}
+				if !e.Passed {
+					statuses[k] = PathFailed
+				} else if statuses[k] == "" {
+					statuses[k] = PathPassed
+				}
+			}
+		}
+	}
+	return statuses
+}
@@ -0,0 +1,102 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	. "github.com/projectcalico/calico/felix/fv/connectivity"
+)
+
+var _ = Describe("DiffResults", func() {
+	// spec returns the results of a spec with a run per map of path to whether
+	// it passed.
+	spec := func(name string, runs ...map[string]bool) *SpecResults {
+		r := &SpecResults{Spec: name}
+		for _, paths := range runs {
+			var run RunResults
+			for path, passed := range paths {
+				run.Expectations = append(run.Expectations, ExpectationResult{
+					Source: "w1", Target: path, Passed: passed,
+				})
+			}
+			r.Runs = append(r.Runs, run)
+		}
+		return r
+	}
+	results := func(specs ...*SpecResults) map[string]*SpecResults {
+		m := map[string]*SpecResults{}
+		for _, s := range specs {
+			m[s.Spec] = s
+		}
+		return m
+	}
+
+	DescribeTable("should return the paths whose outcome changed",
+		func(before, after map[string]*SpecResults, changes []ResultChange) {
+			Expect(DiffResults(before, after)).To(Equal(changes))
+		},
+		Entry("no runs", nil, nil, nil),
+		Entry("the same outcomes",
+			results(spec("a", map[string]bool{"w2": true, "w3": false})),
+			results(spec("a", map[string]bool{"w2": true, "w3": false})),
+			nil),
+		Entry("a path that started failing",
+			results(spec("a", map[string]bool{"w2": true})),
+			results(spec("a", map[string]bool{"w2": false})),
+			[]ResultChange{{Spec: "a", Path: "w1 -> w2", Old: PathPassed, New: PathFailed}}),
+		Entry("a path that was fixed",
+			results(spec("a", map[string]bool{"w2": false})),
+			results(spec("a", map[string]bool{"w2": true})),
+			[]ResultChange{{Spec: "a", Path: "w1 -> w2", Old: PathFailed, New: PathPassed}}),
+		Entry("a path that fails in any run of the spec",
+			results(spec("a", map[string]bool{"w2": true}, map[string]bool{"w2": true})),
+			results(spec("a", map[string]bool{"w2": false}, map[string]bool{"w2": true})),
+			[]ResultChange{{Spec: "a", Path: "w1 -> w2", Old: PathPassed, New: PathFailed}}),
+		Entry("paths that were added and removed",
+			results(spec("a", map[string]bool{"w2": true})),
+			results(spec("a", map[string]bool{"w3": false})),
+			[]ResultChange{
+				{Spec: "a", Path: "w1 -> w2", Old: PathPassed, New: PathMissing},
+				{Spec: "a", Path: "w1 -> w3", Old: PathMissing, New: PathFailed},
+			}),
+		Entry("changes sorted by spec and path",
+			results(
+				spec("b", map[string]bool{"w3": true, "w2": true}),
+				spec("a", map[string]bool{"w2": true}),
+			),
+			results(
+				spec("b", map[string]bool{"w3": false, "w2": false}),
+				spec("a", map[string]bool{"w2": false}),
+			),
+			[]ResultChange{
+				{Spec: "a", Path: "w1 -> w2", Old: PathPassed, New: PathFailed},
+				{Spec: "b", Path: "w1 -> w2", Old: PathPassed, New: PathFailed},
+				{Spec: "b", Path: "w1 -> w3", Old: PathPassed, New: PathFailed},
+			}),
+	)
+
+	DescribeTable("ResultChange.NewlyFailed",
+		func(old, new PathStatus, newlyFailed bool) {
+			Expect(ResultChange{Old: old, New: new}.NewlyFailed()).To(Equal(newlyFailed))
+		},
+		Entry("passed to failed", PathPassed, PathFailed, true),
+		Entry("missing to failed", PathMissing, PathFailed, true),
+		Entry("failed to passed", PathFailed, PathPassed, false),
+		Entry("passed to missing", PathPassed, PathMissing, false),
+	)
+})
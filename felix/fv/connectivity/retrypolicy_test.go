@@ -0,0 +1,67 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Expectation.canRetry", func() {
+	exp := func(opts ...ExpectationOption) Expectation {
+		var e Expectation
+		for _, o := range opts {
+			o(&e)
+		}
+		return e
+	}
+
+	DescribeTable("should apply the retry policy of the expectation, or else the Checker's",
+		func(e Expectation, attempts int, elapsed, lastAttemptAt, checkerTimeout time.Duration,
+			retriesDisabled, canRetry bool) {
+			start := time.Now().Add(-elapsed)
+			Expect(e.canRetry(attempts, start, start.Add(lastAttemptAt), checkerTimeout, retriesDisabled)).To(
+				Equal(canRetry))
+		},
+		Entry("within the timeout",
+			exp(), 2, 5*time.Second, 4*time.Second, 10*time.Second, false, true),
+		Entry("after the timeout",
+			exp(), 2, 11*time.Second, 9*time.Second, 10*time.Second, false, false),
+		Entry("after the timeout, but the last attempt started well before it",
+			exp(), 2, 11*time.Second, 5*time.Second, 10*time.Second, false, true),
+		Entry("after the timeout, but after a single attempt",
+			exp(), 1, 11*time.Second, 9*time.Second, 10*time.Second, false, true),
+		Entry("with retries disabled",
+			exp(), 1, time.Duration(0), time.Duration(0), 10*time.Second, true, false),
+		Entry("after the Checker's timeout, within that of the expectation",
+			exp(ExpectWithTimeout(30*time.Second)), 2, 11*time.Second, 9*time.Second, 10*time.Second, false, true),
+		Entry("after the timeout of the expectation, within the Checker's",
+			exp(ExpectWithTimeout(5*time.Second)), 2, 6*time.Second, 4*time.Second, 10*time.Second, false, false),
+		Entry("with retries left, despite retries disabled",
+			exp(ExpectWithRetries(3, time.Second)), 3, time.Duration(0), time.Duration(0), 10*time.Second, true, true),
+		Entry("with retries left, after the Checker's timeout",
+			exp(ExpectWithRetries(3, time.Second)), 3, 11*time.Second, 9*time.Second, 10*time.Second, false, true),
+		Entry("without retries left",
+			exp(ExpectWithRetries(3, time.Second)), 4, time.Duration(0), time.Duration(0), 10*time.Second, false, false),
+		Entry("with no retries",
+			exp(ExpectWithRetries(0, time.Second)), 1, time.Duration(0), time.Duration(0), 10*time.Second, false, false),
+		Entry("with retries left, after the timeout of the expectation",
+			exp(ExpectWithRetries(3, time.Second), ExpectWithTimeout(5*time.Second)),
+			2, 6*time.Second, 4*time.Second, 10*time.Second, false, false),
+	)
+})
@@ -0,0 +1,79 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+)
+
+// CheckWithProbesPerSource limits the probes that run at the same time from
+// each source to n, the rest of its probes wait for a slot, while the probes of
+// different sources still run in parallel.  Sources that know their host, see
+// HostedSource, share the slots of the host.  With n of 1, the probes of each
+// source run one after another.  Dozens of concurrent execs in one container
+// compete for its CPU and distort the loss and latency that the probes measure.
+// Long-lived connections aren't limited, since they must all be up at the same
+// time for the disruption, see CheckWithDisruption().
+func CheckWithProbesPerSource(n int) CheckerOpt {
+	Expect(n).To(BeNumerically(">", 0), "Probes per source must be positive")
+
+	return func(c *Checker) {
+		log.WithField("probes", n).Debug("CheckWithProbesPerSource set")
+		c.probesPerSource = n
+	}
+}
+
+// sourceSlots returns, for each expectation, the semaphore of its source to
+// hold while its probe runs, nil if the probes of the source aren't limited.
+func (c *Checker) sourceSlots(expectations []Expectation) []chan struct{} {
+	slots := make([]chan struct{}, len(expectations))
+	if c.probesPerSource == 0 {
+		return slots
+	}
+	bySource := map[string]chan struct{}{}
+	for i, exp := range expectations {
+		if exp.longLivedDuration > 0 {
+			continue
+		}
+		name := slotKey(exp.From)
+		if bySource[name] == nil {
+			bySource[name] = make(chan struct{}, c.probesPerSource)
+		}
+		slots[i] = bySource[name]
+	}
+	return slots
+}
+
+// slotKey returns the key of the semaphore of the source: the container of the
+// host that it runs on, if it's known, since the sources of a host share its
+// CPU, or else the name of the source.
+func slotKey(src ConnectionSource) string {
+	if hs, ok := src.(HostedSource); ok && hs.HostContainerName() != "" {
+		return hs.HostContainerName()
+	}
+	return src.SourceName()
+}
+
+// inSlot runs the probe while it holds a slot of the semaphore, if any.  The
+// slot is released even if the probe fails the test, so that the other probes
+// of the source don't wait forever.
+func inSlot(slot chan struct{}, probe func() *Result) *Result {
+	if slot != nil {
+		slot <- struct{}{}
+		defer func() { <-slot }()
+	}
+	return probe()
+}
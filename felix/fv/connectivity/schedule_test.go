@@ -0,0 +1,118 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+// hostedSource is a fakeSource that runs on a host container.
+type hostedSource struct {
+	fakeSource
+	host string
+}
+
+func (s hostedSource) HostContainerName() string {
+	return s.host
+}
+
+var _ = Describe("Probe scheduling", func() {
+	w1 := fakeSource{name: "w1"}
+	w2 := fakeSource{name: "w2"}
+	h1w1 := hostedSource{fakeSource: fakeSource{name: "h1-w1"}, host: "felix-0"}
+	h1w2 := hostedSource{fakeSource: fakeSource{name: "h1-w2"}, host: "felix-0"}
+	unhosted := hostedSource{fakeSource: fakeSource{name: "w3"}}
+
+	exp := func(src ConnectionSource, opts ...ExpectationOption) Expectation {
+		e := Expectation{From: src}
+		for _, o := range opts {
+			o(&e)
+		}
+		return e
+	}
+
+	DescribeTable("sourceSlots",
+		// groups numbers the semaphores in the order they are first used, -1
+		// for none.
+		func(probesPerSource int, exps []Expectation, groups []int) {
+			c := &Checker{probesPerSource: probesPerSource}
+			slots := c.sourceSlots(exps)
+			Expect(slots).To(HaveLen(len(exps)))
+
+			var seen []chan struct{}
+			actual := make([]int, len(slots))
+			for i, s := range slots {
+				actual[i] = -1
+				if s == nil {
+					continue
+				}
+				Expect(cap(s)).To(Equal(probesPerSource))
+				for g, other := range seen {
+					if other == s {
+						actual[i] = g
+					}
+				}
+				if actual[i] == -1 {
+					actual[i] = len(seen)
+					seen = append(seen, s)
+				}
+			}
+			Expect(actual).To(Equal(groups))
+		},
+		Entry("unlimited", 0,
+			[]Expectation{exp(w1), exp(w2)},
+			[]int{-1, -1}),
+		Entry("a semaphore per source", 2,
+			[]Expectation{exp(w1), exp(w2), exp(w1)},
+			[]int{0, 1, 0}),
+		Entry("a semaphore per host", 1,
+			[]Expectation{exp(h1w1), exp(w1), exp(h1w2)},
+			[]int{0, 1, 0}),
+		Entry("a source with an unknown host", 1,
+			[]Expectation{exp(unhosted), exp(h1w1), exp(unhosted)},
+			[]int{0, 1, 0}),
+		Entry("long-lived connections", 1,
+			[]Expectation{exp(w1, ExpectWithSurvival(time.Second)), exp(w1)},
+			[]int{-1, 0}),
+	)
+
+	Describe("inSlot", func() {
+		It("should run the probe without a slot", func() {
+			res := &Result{}
+			Expect(inSlot(nil, func() *Result { return res })).To(BeIdenticalTo(res))
+		})
+
+		It("should hold the slot while the probe runs", func() {
+			slot := make(chan struct{}, 1)
+			inSlot(slot, func() *Result {
+				Expect(slot).To(HaveLen(1))
+				return nil
+			})
+			Expect(slot).To(BeEmpty())
+		})
+
+		It("should release the slot if the probe panics", func() {
+			slot := make(chan struct{}, 1)
+			Expect(func() {
+				inSlot(slot, func() *Result { panic("probe failed the test") })
+			}).To(Panic())
+			Expect(slot).To(BeEmpty())
+		})
+	})
+})
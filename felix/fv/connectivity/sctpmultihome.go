@@ -0,0 +1,153 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+// WithSCTPLocalAddrs binds the association of an SCTP check to these local
+// addresses, rather than to all of them, to make it multi-homed on the client
+// side.  They must be of the same family as the target.
+func WithSCTPLocalAddrs(ips ...string) CheckOption {
+	return func(c *CheckCmd) {
+		c.sctpLocalAddrs = ips
+	}
+}
+
+// WithSCTPRemoteAddrs gives the association of an SCTP check these addresses of
+// the target, as well as the target IP, which is the primary path.  The server
+// only accepts the association on all of its addresses if it is bound to all of
+// them, start a test-workload server with workload.WithListenAnyIP().
+func WithSCTPRemoteAddrs(ips ...string) CheckOption {
+	return func(c *CheckCmd) {
+		c.sctpRemoteAddrs = ips
+	}
+}
+
+// ExpectWithSCTPMultihoming checks the connectivity of a multi-homed SCTP
+// association, see WithSCTPLocalAddrs() and WithSCTPRemoteAddrs().  Either list
+// may be empty.
+func ExpectWithSCTPMultihoming(localIPs, remoteIPs []string) ExpectationOption {
+	return func(e *Expectation) {
+		e.sctpLocalAddrs = localIPs
+		e.sctpRemoteAddrs = remoteIPs
+	}
+}
+
+// ExpectSCTPFailover asserts that a multi-homed SCTP association lost its
+// primary path during the check and kept exchanging messages over another one.
+// Like ExpectWithSurvival(), the association is long-lived, for the duration;
+// block the primary path with CheckWithDisruption().
+func ExpectSCTPFailover(duration time.Duration) ExpectationOption {
+	survival := ExpectWithSurvival(duration)
+	return func(e *Expectation) {
+		survival(e)
+		e.sctpFailover = true
+	}
+}
+
+// ExpectSCTPFailover asserts that an SCTP association from the source to the
+// target, multi-homed over the given remote addresses of the target, survives
+// the blocking of its primary path by the function passed with
+// CheckWithDisruption(), by failing over to another path.
+func (c *Checker) ExpectSCTPFailover(from ConnectionSource, to ConnectionTarget, port uint16,
+	duration time.Duration, remoteIPs ...string) {
+
+	Expect(c.Protocol).To(Equal("sctp"), "ExpectSCTPFailover needs an SCTP Checker")
+
+	// The association can't fail over again by retrying.
+	c.RetriesDisabled = true
+
+	c.expect(Some, from, to,
+		ExpectWithPorts(port),
+		ExpectWithSCTPMultihoming(nil, remoteIPs),
+		ExpectSCTPFailover(duration),
+	)
+}
+
+// SCTPAssociation describes the paths of a multi-homed SCTP association.
+type SCTPAssociation struct {
+	// LocalAddrs and PeerAddrs are the addresses at each end of the association.
+	LocalAddrs []string
+	PeerAddrs  []string
+	// Primary is the peer address of the primary path.
+	Primary string
+	// FailedPaths are the peer addresses of the paths that were seen inactive,
+	// or potentially failed, during the check.
+	FailedPaths []string `json:",omitempty"`
+}
+
+// FailedOver returns whether the primary path of the association failed during
+// the check.
+func (a *SCTPAssociation) FailedOver() bool {
+	if a == nil || a.Primary == "" {
+		return false
+	}
+	for _, p := range a.FailedPaths {
+		if p == a.Primary {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *SCTPAssociation) String() string {
+	if a == nil {
+		return "no SCTP association"
+	}
+	s := fmt.Sprintf("SCTP paths %s, primary %s", strings.Join(a.PeerAddrs, ","), a.Primary)
+	if len(a.FailedPaths) > 0 {
+		s += ", failed " + strings.Join(a.FailedPaths, ",")
+	}
+	return s
+}
+
+func (cmd *CheckCmd) sctpArgs() []string {
+	var args []string
+	if len(cmd.sctpLocalAddrs) > 0 {
+		args = append(args, "--sctp-local-addrs="+strings.Join(cmd.sctpLocalAddrs, ","))
+	}
+	if len(cmd.sctpRemoteAddrs) > 0 {
+		args = append(args, "--sctp-remote-addrs="+strings.Join(cmd.sctpRemoteAddrs, ","))
+	}
+	return args
+}
+
+func (e Expectation) sctpMultihomed() bool {
+	return len(e.sctpLocalAddrs) > 0 || len(e.sctpRemoteAddrs) > 0
+}
+
+func (e Expectation) sctpPretty() string {
+	var parts []string
+	if len(e.sctpLocalAddrs) > 0 {
+		parts = append(parts, "local "+strings.Join(e.sctpLocalAddrs, ","))
+	}
+	if len(e.sctpRemoteAddrs) > 0 {
+		parts = append(parts, "remote "+strings.Join(e.sctpRemoteAddrs, ","))
+	}
+	s := " (SCTP multi-homed"
+	if len(parts) > 0 {
+		s += ": " + strings.Join(parts, ", ")
+	}
+	if e.sctpFailover {
+		s += ", fails over"
+	}
+	return s + ")"
+}
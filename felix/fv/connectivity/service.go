@@ -0,0 +1,163 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ResolvingTarget is a ConnectionTarget whose address can change while a check
+// is retried.  The Checker resolves it again before each attempt.
+type ResolvingTarget interface {
+	ConnectionTarget
+	Resolve(explicitPort ...uint16) (*Matcher, error)
+}
+
+// ServiceResolver looks up Kubernetes Services.
+type ServiceResolver interface {
+	Service(namespace, name string) (*v1.Service, error)
+}
+
+// KubeServiceResolver is a ServiceResolver that uses client-go.
+type KubeServiceResolver struct {
+	Client kubernetes.Interface
+}
+
+func (r KubeServiceResolver) Service(namespace, name string) (*v1.Service, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return r.Client.CoreV1().Services(namespace).Get(ctx, name, metav1.GetOptions{})
+}
+
+// ServiceTarget is a ConnectionTarget for a port of a Kubernetes Service, via
+// its ClusterIP or, if NodeIP is set, via its NodePort on that node.  The
+// Service is looked up at check time, and again before each retry, so that
+// checks survive the Service being updated or recreated while they run.
+type ServiceTarget struct {
+	Namespace, Name string
+	// Port is the name or number of the Service port.  An explicit port passed
+	// to the Checker overrides it.
+	Port string
+	// NodeIP, if set, makes the target the Service's NodePort on that IP.
+	NodeIP   string
+	Resolver ServiceResolver
+}
+
+func (t ServiceTarget) targetName(port string) string {
+	name := fmt.Sprintf("svc %s/%s:%s", t.Namespace, t.Name, port)
+	if t.NodeIP != "" {
+		name += " via node " + t.NodeIP
+	}
+	return name
+}
+
+// ToMatcher resolves the Service.  If that fails, it returns a Matcher without
+// an IP; the Checker resolves the Service again before it checks it.
+func (t ServiceTarget) ToMatcher(explicitPort ...uint16) *Matcher {
+	m, err := t.Resolve(explicitPort...)
+	if err != nil {
+		port := t.Port
+		if len(explicitPort) == 1 {
+			port = strconv.Itoa(int(explicitPort[0]))
+		}
+		return &Matcher{TargetName: t.targetName(port), Port: port}
+	}
+	return m
+}
+
+// Resolve looks up the Service and returns a Matcher for its IP and port.
+func (t ServiceTarget) Resolve(explicitPort ...uint16) (*Matcher, error) {
+	if len(explicitPort) > 1 {
+		panic("Only one explicit port allowed with a Service as a connectivity target")
+	}
+	port := t.Port
+	if len(explicitPort) == 1 {
+		port = strconv.Itoa(int(explicitPort[0]))
+	}
+
+	svc, err := t.Resolver.Service(t.Namespace, t.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up service %s/%s: %w", t.Namespace, t.Name, err)
+	}
+
+	var svcPort *v1.ServicePort
+	for i, p := range svc.Spec.Ports {
+		if p.Name == port || strconv.Itoa(int(p.Port)) == port {
+			svcPort = &svc.Spec.Ports[i]
+			break
+		}
+	}
+	if svcPort == nil {
+		return nil, fmt.Errorf("service %s/%s has no port %s", t.Namespace, t.Name, port)
+	}
+
+	m := &Matcher{
+		TargetName: t.targetName(port),
+		Protocol:   "tcp",
+	}
+	if svcPort.Protocol != "" && svcPort.Protocol != v1.ProtocolTCP {
+		m.Protocol = map[v1.Protocol]string{v1.ProtocolUDP: "udp", v1.ProtocolSCTP: "sctp"}[svcPort.Protocol]
+	}
+	if t.NodeIP != "" {
+		if svcPort.NodePort == 0 {
+			return nil, fmt.Errorf("service %s/%s has no node port for port %s", t.Namespace, t.Name, port)
+		}
+		m.IP = t.NodeIP
+		m.Port = strconv.Itoa(int(svcPort.NodePort))
+		return m, nil
+	}
+	if svc.Spec.ClusterIP == "" || svc.Spec.ClusterIP == v1.ClusterIPNone {
+		return nil, fmt.Errorf("service %s/%s has no cluster IP", t.Namespace, t.Name)
+	}
+	m.IP = svc.Spec.ClusterIP
+	m.Port = strconv.Itoa(int(svcPort.Port))
+	return m, nil
+}
+
+// resolveTargets resolves the ResolvingTargets of the expectations again and
+// returns the errors, per expectation.  Expectations whose target fails to
+// resolve keep their previous Matcher.
+func (c *Checker) resolveTargets() []error {
+	errs := make([]error, len(c.expectations))
+	for i := range c.expectations {
+		exp := &c.expectations[i]
+		rt, ok := exp.target.(ResolvingTarget)
+		if !ok {
+			continue
+		}
+		m, err := rt.Resolve(exp.explicitPorts...)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		if m.IP != exp.To.IP || m.Port != exp.To.Port {
+			log.WithFields(log.Fields{
+				"target": m.TargetName,
+				"ip":     m.IP,
+				"port":   m.Port,
+			}).Info("Connectivity target resolved to a new address")
+		}
+		exp.To = m
+	}
+	return errs
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ExpectWithSNATPortInRange asserts that the server saw the connection come from
+// a source port in [lo, hi], the port range of the SNAT on the path.  With
+// ExpectWithParallelFlows(), every flow's source port must be in the range,
+// which exercises the dataplane's port allocation.
+func ExpectWithSNATPortInRange(lo, hi uint16) ExpectationOption {
+	return func(e *Expectation) {
+		e.snatPortMin = lo
+		e.snatPortMax = hi
+	}
+}
+
+// ServerSeenSourcePorts returns the source ports that the server saw, one per
+// successful flow if the check opened parallel flows.
+func (r *Result) ServerSeenSourcePorts() []uint16 {
+	if r == nil {
+		return nil
+	}
+	if len(r.Flows) == 0 {
+		if _, port := splitAddr(r.LastResponse.SourceAddr); port != 0 {
+			return []uint16{port}
+		}
+		return nil
+	}
+	var ports []uint16
+	for _, f := range r.Flows {
+		if !f.Success {
+			continue
+		}
+		if _, port := splitAddr(f.SourceAddr); port != 0 {
+			ports = append(ports, port)
+		}
+	}
+	return ports
+}
+
+func (e Expectation) checksSNATPorts() bool {
+	return e.snatPortMax != 0
+}
+
+// snatPortsOutsideRange returns the source ports that the server saw outside of
+// the expected range.
+func (e Expectation) snatPortsOutsideRange(res *Result) []uint16 {
+	var outside []uint16
+	for _, p := range res.ServerSeenSourcePorts() {
+		if p < e.snatPortMin || p > e.snatPortMax {
+			outside = append(outside, p)
+		}
+	}
+	return outside
+}
+
+func (e Expectation) snatPortsMatch(res *Result) bool {
+	if !e.checksSNATPorts() {
+		return true
+	}
+	return len(res.ServerSeenSourcePorts()) > 0 && len(e.snatPortsOutsideRange(res)) == 0
+}
+
+func (e Expectation) snatPortsPretty(res *Result) string {
+	outside := e.snatPortsOutsideRange(res)
+	if len(outside) == 0 {
+		return fmt.Sprintf(" (SNAT ports in %d-%d)", e.snatPortMin, e.snatPortMax)
+	}
+	ports := make([]string, len(outside))
+	for i, p := range outside {
+		ports[i] = fmt.Sprint(p)
+	}
+	return fmt.Sprintf(" (SNAT ports outside %d-%d: %s)", e.snatPortMin, e.snatPortMax, strings.Join(ports, ","))
+}
@@ -0,0 +1,170 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/config"
+	"github.com/onsi/ginkgo/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// SpecAttachment is a named value that the checks of a spec attached to it, see
+// AttachToSpec().
+type SpecAttachment struct {
+	Name string
+	Text string
+}
+
+var (
+	specAttachmentsLock sync.Mutex
+	specAttachments     []SpecAttachment
+)
+
+// AttachToSpec attaches a named value to the running spec.  It writes the value
+// to the GinkgoWriter, which ginkgo shows for failed specs, and keeps it for the
+// SpecAttachmentsReporter, which writes the attachments of every spec, whether
+// it passed or not, to a file.
+func AttachToSpec(name string, value interface{}) {
+	var text string
+	switch v := value.(type) {
+	case string:
+		text = v
+	case []string:
+		text = strings.Join(v, "\n")
+	default:
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			text = fmt.Sprintf("%v", v)
+		} else {
+			text = string(data)
+		}
+	}
+	_, _ = fmt.Fprintf(ginkgo.GinkgoWriter, "\n[%s]\n%s\n", name, text)
+
+	specAttachmentsLock.Lock()
+	defer specAttachmentsLock.Unlock()
+	specAttachments = append(specAttachments, SpecAttachment{Name: name, Text: text})
+}
+
+// takeSpecAttachments returns the attachments of the running spec and forgets
+// them.
+func takeSpecAttachments() []SpecAttachment {
+	specAttachmentsLock.Lock()
+	defer specAttachmentsLock.Unlock()
+	attachments := specAttachments
+	specAttachments = nil
+	return attachments
+}
+
+// SpecAttachmentsReporter is a ginkgo Reporter that writes the attachments of
+// each spec to a text file per spec under Dir, so that they are kept for the
+// specs that passed, whose GinkgoWriter output ginkgo drops.  Specs without
+// attachments get no file.  Pass it to RunSpecsWithDefaultAndCustomReporters().
+type SpecAttachmentsReporter struct {
+	Dir string
+}
+
+func NewSpecAttachmentsReporter(dir string) *SpecAttachmentsReporter {
+	return &SpecAttachmentsReporter{Dir: dir}
+}
+
+func (r *SpecAttachmentsReporter) SpecSuiteWillBegin(config.GinkgoConfigType, *types.SuiteSummary) {}
+
+func (r *SpecAttachmentsReporter) BeforeSuiteDidRun(*types.SetupSummary) {}
+
+// SpecWillRun drops the attachments of the setup nodes that ran outside a spec.
+func (r *SpecAttachmentsReporter) SpecWillRun(*types.SpecSummary) {
+	takeSpecAttachments()
+}
+
+// SpecDidComplete writes the attachments of the spec.
+func (r *SpecAttachmentsReporter) SpecDidComplete(summary *types.SpecSummary) {
+	attachments := takeSpecAttachments()
+	if len(attachments) == 0 {
+		return
+	}
+
+	spec := ""
+	if len(summary.ComponentTexts) > 1 {
+		spec = strings.Join(summary.ComponentTexts[1:], " ")
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s\nState: %s\n", spec, specState(summary.State))
+	for _, a := range attachments {
+		fmt.Fprintf(&sb, "\n[%s]\n%s\n", a.Name, a.Text)
+	}
+
+	if err := os.MkdirAll(r.Dir, 0o755); err != nil {
+		log.WithError(err).Warn("Failed to create spec attachments directory")
+		return
+	}
+	path := filepath.Join(r.Dir, fileNameOf(spec)+".txt")
+	if err := os.WriteFile(path, []byte(sb.String()), 0o644); err != nil {
+		log.WithError(err).WithField("file", path).Warn("Failed to write spec attachments")
+	}
+}
+
+func (r *SpecAttachmentsReporter) AfterSuiteDidRun(*types.SetupSummary) {}
+
+func (r *SpecAttachmentsReporter) SpecSuiteDidEnd(*types.SuiteSummary) {}
+
+func specState(state types.SpecState) string {
+	switch state {
+	case types.SpecStatePassed:
+		return "passed"
+	case types.SpecStateFailed:
+		return "failed"
+	case types.SpecStatePanicked:
+		return "panicked"
+	case types.SpecStateTimedOut:
+		return "timed out"
+	case types.SpecStateSkipped:
+		return "skipped"
+	case types.SpecStatePending:
+		return "pending"
+	}
+	return "unknown"
+}
+
+// CheckWithSpecAttachments makes the Checker attach the expected and actual
+// connectivity, and the results of each attempt, to the spec with
+// AttachToSpec(), rather than only putting them in the failure message.
+func CheckWithSpecAttachments() CheckerOpt {
+	return func(c *Checker) {
+		log.Debug("CheckWithSpecAttachments set")
+		c.attachRuns = true
+	}
+}
+
+// attachRun attaches the run to the spec.
+func attachRun(run RunResults) {
+	expected := make([]string, len(run.Expectations))
+	for i, e := range run.Expectations {
+		expected[i] = e.Pretty
+	}
+	AttachToSpec("Expected connectivity", expected)
+	if len(run.Attempts) > 0 {
+		AttachToSpec("Actual connectivity", run.Attempts[len(run.Attempts)-1].Pretty)
+	}
+	AttachToSpec("Connectivity attempts", run.Attempts)
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity_test
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/types"
+	. "github.com/onsi/gomega"
+
+	. "github.com/projectcalico/calico/felix/fv/connectivity"
+)
+
+var _ = Describe("SpecAttachmentsReporter", func() {
+	var dir string
+	var r *SpecAttachmentsReporter
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "attachments")
+		Expect(err).NotTo(HaveOccurred())
+		r = NewSpecAttachmentsReporter(dir)
+		r.SpecWillRun(&types.SpecSummary{})
+	})
+
+	AfterEach(func() {
+		_ = os.RemoveAll(dir)
+	})
+
+	It("should write the attachments of a spec that passed", func() {
+		AttachToSpec("Known connectivity issues", []string{"w1 -> w2: issue 123"})
+		AttachToSpec("Connectivity attempts", map[string]int{"attempts": 2})
+		r.SpecDidComplete(&types.SpecSummary{
+			ComponentTexts: []string{"[Top Level]", "policy", "allows w1 to w2"},
+			State:          types.SpecStatePassed,
+		})
+
+		data, err := os.ReadFile(filepath.Join(dir, "policy_allows_w1_to_w2.txt"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(Equal("policy allows w1 to w2\nState: passed\n" +
+			"\n[Known connectivity issues]\nw1 -> w2: issue 123\n" +
+			"\n[Connectivity attempts]\n{\n  \"attempts\": 2\n}\n"))
+	})
+
+	It("should only write the attachments of the spec that completed", func() {
+		AttachToSpec("Left over", "from a setup node")
+		r.SpecWillRun(&types.SpecSummary{})
+		r.SpecDidComplete(&types.SpecSummary{
+			ComponentTexts: []string{"[Top Level]", "a spec without attachments"},
+			State:          types.SpecStateFailed,
+		})
+
+		entries, err := os.ReadDir(dir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(BeEmpty())
+	})
+})
@@ -0,0 +1,152 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/projectcalico/calico/felix/fv/utils"
+)
+
+// sshHostPrefix marks the names under which SSHSources are registered, in place
+// of a container name, so that they can't clash with containers.
+const sshHostPrefix = "ssh:"
+
+// SSHSource is a ConnectionSource that runs test-connection over SSH on a
+// machine outside the docker topology, so that tests can express traffic from
+// outside the cluster, to NodePorts or advertised service IPs, for example, with
+// ordinary expectations.
+//
+// The machine needs test-connection on its PATH, or WithAutoProvision() to copy
+// it there with scp.  SSH must not prompt: authentication has to be with a key
+// and the host key must be known, or StrictHostKeyChecking disabled in Options.
+type SSHSource struct {
+	// Name identifies the machine in the results.
+	Name string
+	// Address is the SSH destination, [user@]host.
+	Address string
+	// Port is the SSH port, 0 for the default.
+	Port int
+	// IdentityFile is the private key to log in with, "" for ssh's default.
+	IdentityFile string
+	// Options are extra ssh -o options, such as "StrictHostKeyChecking=no".
+	Options []string
+	// Sudo runs test-connection with "sudo -n", for source IPs that it has to add
+	// to the machine's interface.
+	Sudo bool
+
+	// IP is the source IP of the probes.  If empty, the machine picks it.
+	IP string
+}
+
+func (s *SSHSource) SourceName() string {
+	if s.IP == "" {
+		return s.Name
+	}
+	return s.Name + " " + s.IP
+}
+
+func (s *SSHSource) SourceIPs() []string {
+	if s.IP == "" {
+		return nil
+	}
+	return []string{s.IP}
+}
+
+func (s *SSHSource) PreRetryCleanup(ip, port, protocol string, opts ...CheckOption) {
+}
+
+func (s *SSHSource) CanConnectTo(ip, port, protocol string, opts ...CheckOption) *Result {
+	var sshOpts []CheckOption
+	if s.IP != "" {
+		sshOpts = append(sshOpts, WithSourceIP(s.IP))
+	}
+	opts = append(sshOpts, opts...)
+	return Check(registerSSHHost(s), "Connection test over SSH", ip, port, protocol, opts...)
+}
+
+// sshArgs returns the arguments of ssh or scp, which differ in the flag for the
+// port, that log into the machine.
+func (s *SSHSource) sshArgs(portFlag string) []string {
+	args := []string{"-o", "BatchMode=yes"}
+	if s.Port != 0 {
+		args = append(args, portFlag, fmt.Sprint(s.Port))
+	}
+	if s.IdentityFile != "" {
+		args = append(args, "-i", s.IdentityFile)
+	}
+	for _, o := range s.Options {
+		args = append(args, "-o", o)
+	}
+	return args
+}
+
+var (
+	sshHostsLock sync.Mutex
+	sshHosts     = map[string]*SSHSource{}
+)
+
+// registerSSHHost makes the SSHSource available to startExec() and returns the
+// name to run commands on it with.
+func registerSSHHost(s *SSHSource) string {
+	sshHostsLock.Lock()
+	defer sshHostsLock.Unlock()
+	name := sshHostPrefix + s.Name
+	sshHosts[name] = s
+	return name
+}
+
+func sshHostFor(name string) *SSHSource {
+	if !strings.HasPrefix(name, sshHostPrefix) {
+		return nil
+	}
+	sshHostsLock.Lock()
+	defer sshHostsLock.Unlock()
+	return sshHosts[name]
+}
+
+func startSSHExec(ctx context.Context, s *SSHSource, stdin bool, cmd []string) (*cliExec, error) {
+	if s.Sudo {
+		cmd = append([]string{"sudo", "-n"}, cmd...)
+	}
+	// ssh passes the command to the remote shell as a single string.
+	quoted := make([]string, len(cmd))
+	for i, arg := range cmd {
+		quoted[i] = shellQuote(arg)
+	}
+	args := s.sshArgs("-p")
+	args = append(args, s.Address, strings.Join(quoted, " "))
+	log.Debugf("Running over SSH on %s: %s", s.Address, strings.Join(cmd, " "))
+	return startCmdExec(ctx, utils.Command("ssh", args...), stdin)
+}
+
+// copyToSSHHost copies a local file to the machine.
+func copyToSSHHost(s *SSHSource, src, dst string) error {
+	args := s.sshArgs("-P")
+	args = append(args, "-p", src, s.Address+":"+dst)
+	return utils.RunMayFail("scp", args...)
+}
+
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_=.,:/@") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
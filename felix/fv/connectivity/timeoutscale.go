@@ -0,0 +1,59 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// CheckWithScaledTimeout scales the timeout of the check with the size of the
+// matrix, since the fixed timeout that suits a few expectations is too short
+// for hundreds once the overhead of docker exec is counted.  The timeout grows
+// by perExpectation for each expectation and, once attempts have been timed, so
+// that it leaves room for minAttempts attempts as long as the longest one.  It
+// never shrinks below the timeout that the check was called with.  A check
+// that fails reports the timeout it used.
+func CheckWithScaledTimeout(perExpectation time.Duration, minAttempts int) CheckerOpt {
+	return func(c *Checker) {
+		log.Debug("CheckWithScaledTimeout set")
+		c.timeoutPerExpectation = perExpectation
+		c.timeoutMinAttempts = minAttempts
+	}
+}
+
+// scaledTimeout returns the timeout of the check, given the one it was called
+// with and the duration of the longest attempt so far.
+func (c *Checker) scaledTimeout(timeout, longestAttempt time.Duration) time.Duration {
+	if timeout == 0 || (c.timeoutPerExpectation == 0 && c.timeoutMinAttempts == 0) {
+		return timeout
+	}
+	scaled := timeout + time.Duration(c.numExpectations())*c.timeoutPerExpectation
+	if byAttempts := time.Duration(c.timeoutMinAttempts) * longestAttempt; byAttempts > scaled {
+		scaled = byAttempts
+	}
+	return scaled
+}
+
+// scaledTimeoutMessage describes the scaled timeout for the failure message.
+func (c *Checker) scaledTimeoutMessage(timeout, scaled, longestAttempt time.Duration) string {
+	if scaled == timeout {
+		return ""
+	}
+	return fmt.Sprintf("\n Timeout scaled from %s to %s for %d expectations and attempts of up to %s.\n",
+		timeout, scaled, c.numExpectations(), longestAttempt)
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the name of the OpenTelemetry tracer of the package.
+const tracerName = "github.com/projectcalico/calico/felix/fv/connectivity"
+
+// tracer returns the tracer for the spans of the checks, see
+// Checker.TracerProvider.
+func (c *Checker) tracer() trace.Tracer {
+	tp := c.TracerProvider
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return tp.Tracer(tracerName)
+}
+
+// WithTraceContext makes the check record its spans, see Checker.TracerProvider,
+// as children of the span in the context.
+func WithTraceContext(ctx context.Context) CheckOption {
+	return func(c *CheckCmd) {
+		c.traceCtx = ctx
+	}
+}
+
+// startSpan starts a span of the check, which does nothing unless the check has
+// a trace context.
+func (cmd *CheckCmd) startSpan(name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	ctx := cmd.traceCtx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return trace.SpanFromContext(ctx).Tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan ends the span, recording the error, if any.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,150 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// CheckWithTranscript writes a transcript of each run of the Checker to a file
+// per spec under dir: the output of every probe and the decisions of the
+// Checker, which attempts passed, what it retried and why it gave up.  Unlike
+// the global log, where the probes of parallel checks interleave, the
+// transcript of a failed spec only holds its own checks.  The runs of a spec
+// are appended to the same file.
+func CheckWithTranscript(dir string) CheckerOpt {
+	return func(c *Checker) {
+		log.Debug("CheckWithTranscript set")
+		c.transcriptDir = dir
+	}
+}
+
+// withTranscript records the output of the check in the transcript.
+func withTranscript(t *transcript) CheckOption {
+	return func(c *CheckCmd) {
+		c.transcript = t
+	}
+}
+
+// transcript is the transcript file of a run of a Checker.  Its methods do
+// nothing on a nil transcript, so that the Checker can write to it whether or
+// not CheckWithTranscript() is set.
+type transcript struct {
+	lock sync.Mutex
+	f    *os.File
+}
+
+// openTranscript opens the transcript file of the spec for appending, it
+// returns nil if the file can't be opened.
+func openTranscript(dir string) *transcript {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		log.WithError(err).Warn("Failed to create transcript directory")
+		return nil
+	}
+	path := filepath.Join(dir, specDirName()+".log")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		log.WithError(err).WithField("file", path).Warn("Failed to open transcript")
+		return nil
+	}
+	return &transcript{f: f}
+}
+
+// printf writes a timestamped entry to the transcript.  Entries that span
+// several lines are indented under the timestamp.
+func (t *transcript) printf(format string, args ...interface{}) {
+	if t == nil {
+		return
+	}
+	msg := strings.TrimRight(fmt.Sprintf(format, args...), "\n")
+	msg = strings.ReplaceAll(msg, "\n", "\n    ")
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if _, err := fmt.Fprintf(t.f, "%s %s\n", time.Now().Format(time.RFC3339Nano), msg); err != nil {
+		log.WithError(err).WithField("file", t.f.Name()).Warn("Failed to write transcript")
+	}
+}
+
+// probe records a run of test-connection.
+func (t *transcript) probe(cName string, args []string, duration time.Duration,
+	stdout, stderr []byte, exitErr error, res *Result, err error) {
+	if t == nil {
+		return
+	}
+	msg := fmt.Sprintf("probe from %s: %s\noutcome: %s after %v",
+		cName, strings.Join(args, " "), probeOutcome(res, err), duration)
+	if exitErr != nil {
+		msg += fmt.Sprintf(" (%v)", exitErr)
+	}
+	if err != nil {
+		msg += fmt.Sprintf("\nharness error: %v", err)
+	}
+	if len(stdout) > 0 {
+		msg += "\nstdout:\n" + string(stdout)
+	}
+	if len(stderr) > 0 {
+		msg += "\nstderr:\n" + string(stderr)
+	}
+	t.printf("%s", msg)
+}
+
+func (t *transcript) close() {
+	if t == nil {
+		return
+	}
+	if err := t.f.Close(); err != nil {
+		log.WithError(err).WithField("file", t.f.Name()).Warn("Failed to close transcript")
+	}
+}
+
+// startTranscript opens the transcript of a run, if CheckWithTranscript() is
+// set.  The caller must call endTranscript() when the run ends.
+func (c *Checker) startTranscript(what string) {
+	if c.transcriptDir == "" {
+		return
+	}
+	c.transcript = openTranscript(c.transcriptDir)
+	expPretty := c.ExpectedConnectivityPretty()
+	c.transcript.printf("%s started with %d expectations:\n%s", what, len(expPretty),
+		strings.Join(expPretty, "\n"))
+}
+
+func (c *Checker) endTranscript() {
+	c.transcript.close()
+	c.transcript = nil
+}
+
+// transcribeAttempt records the outcome of an attempt and the expectations that
+// failed it.
+func (c *Checker) transcribeAttempt(a *Attempt) {
+	if c.transcript == nil {
+		return
+	}
+	msg := attemptSummary(a)
+	for _, m := range a.Mismatches {
+		msg += "\nexpected: " + m.ExpectedPretty + "\nactual:   " + m.ActualPretty
+	}
+	for _, e := range a.HarnessErrors {
+		msg += "\nharness error: " + e.Error()
+	}
+	c.transcript.printf("%s", msg)
+}
@@ -0,0 +1,136 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// connectivityPkg prefixes the names of the functions of this package.
+var connectivityPkg = func() string {
+	pc, _, _, _ := runtime.Caller(0)
+	name := runtime.FuncForPC(pc).Name()
+	slash := strings.LastIndex(name, "/")
+	return name[:slash+strings.Index(name[slash:], ".")+1]
+}()
+
+// creationSite returns the file:line of the first caller outside this package,
+// where the test added an expectation.
+func creationSite() string {
+	pcs := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, connectivityPkg) {
+			return fmt.Sprintf("%s:%d", frame.File, frame.Line)
+		}
+		if !more {
+			return "<unknown>"
+		}
+	}
+}
+
+// ReportUnactivated fails the test, through fail, if any Checker of
+// DefaultCheckerContext has expectations but hasn't been checked since they
+// were added, which usually means that the test forgot to call
+// CheckConnectivity().  The message gives where each of those Checkers got its
+// first expectation and what it expects.  It forgets about the Checkers
+// afterwards, so that they don't fail the next test too.  Call it from an
+// AfterEach, for example:
+//
+//	var _ = AfterEach(func() {
+//		if CurrentGinkgoTestDescription().Failed {
+//			connectivity.ClearUnactivated()
+//			return
+//		}
+//		connectivity.ReportUnactivated(Fail)
+//	})
+func ReportUnactivated(fail func(message string, callerSkip ...int)) {
+	DefaultCheckerContext.reportUnactivated(fail)
+}
+
+// ClearUnactivated forgets about the Checkers of DefaultCheckerContext that
+// haven't been checked, for example, after a test that failed before it got to
+// check them.
+func ClearUnactivated() {
+	DefaultCheckerContext.ClearUnactivated()
+}
+
+// ReportUnactivated is the ReportUnactivated() of the Checkers of the context.
+func (ctx *CheckerContext) ReportUnactivated(fail func(message string, callerSkip ...int)) {
+	ctx.reportUnactivated(fail)
+}
+
+// reportUnactivated fails the test, if need be, on behalf of the caller of
+// ReportUnactivated().
+func (ctx *CheckerContext) reportUnactivated(fail func(message string, callerSkip ...int)) {
+	ctx.lock.Lock()
+	var lines []string
+	for c, site := range ctx.unactivated {
+		lines = append(lines, unactivatedSummary(c, site))
+	}
+	ctx.clearUnactivatedLocked()
+	ctx.lock.Unlock()
+
+	if len(lines) == 0 {
+		return
+	}
+	sort.Strings(lines)
+	fail(fmt.Sprintf("Test bug: %d connectivity Checkers were created but not activated:\n    %s",
+		len(lines), strings.Join(lines, "\n    ")), 2)
+}
+
+// ClearUnactivated is the ClearUnactivated() of the Checkers of the context.
+func (ctx *CheckerContext) ClearUnactivated() {
+	ctx.lock.Lock()
+	defer ctx.lock.Unlock()
+	ctx.clearUnactivatedLocked()
+}
+
+func (ctx *CheckerContext) clearUnactivatedLocked() {
+	ctx.unactivated = map[*Checker]string{}
+	if ctx == DefaultCheckerContext {
+		UnactivatedCheckers.Clear()
+	}
+}
+
+// maxUnactivatedExpectations is how many expectations of an unactivated
+// Checker its summary shows.
+const maxUnactivatedExpectations = 3
+
+// unactivatedSummary describes an unactivated Checker by its creation site and
+// its expectations.
+func unactivatedSummary(c *Checker, site string) string {
+	c.expectationsMutex().Lock()
+	defer c.expectationsMutex().Unlock()
+	var exps []string
+	for i, exp := range c.expectations {
+		if i == maxUnactivatedExpectations {
+			exps = append(exps, fmt.Sprintf("and %d more", len(c.expectations)-i))
+			break
+		}
+		exps = append(exps, exp.sourceName()+" -> "+exp.To.TargetName)
+	}
+	if n := len(c.conntrackExpectations); n > 0 {
+		exps = append(exps, fmt.Sprintf("%d conntrack expectations", n))
+	}
+	if len(exps) == 0 {
+		exps = []string{"nothing"}
+	}
+	return fmt.Sprintf("created at %s, expecting %s", site, strings.Join(exps, ", "))
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// DefaultUnreachableProbes is how many probes BeConsistentlyUnreachableFrom()
+	// sends per match by default.
+	DefaultUnreachableProbes = 3
+	// DefaultUnreachableInterval is the default gap between those probes.
+	DefaultUnreachableInterval = 500 * time.Millisecond
+)
+
+// BeConsistentlyUnreachableFrom matches a ConnectionTarget that the source can't
+// connect to.  Unlike a negated HaveConnectivityTo(), each match sends several
+// probes, spaced by an interval, and only passes if they all fail, so that it
+// doesn't pass by chance while policy is still being programmed.  The options
+// apply to the probes as they do to the Checker's, use ExpectWithPorts() to give
+// the port of an IP target:
+//
+//	Consistently(TargetIP(ip), "5s").Should(BeConsistentlyUnreachableFrom(w[0], ExpectWithPorts(8055)))
+func BeConsistentlyUnreachableFrom(src ConnectionSource, opts ...ExpectationOption) *UnreachableMatcher {
+	return &UnreachableMatcher{
+		Source:   src,
+		Protocol: "tcp",
+		Probes:   DefaultUnreachableProbes,
+		Interval: DefaultUnreachableInterval,
+		opts:     opts,
+	}
+}
+
+type UnreachableMatcher struct {
+	Source   ConnectionSource
+	Protocol string
+	Probes   int
+	Interval time.Duration
+
+	opts []ExpectationOption
+
+	// target, reachedOn and lastResult describe the last match, for the failure
+	// messages.
+	target     *Matcher
+	reachedOn  int
+	lastResult *Result
+}
+
+// WithProtocol returns a copy of the matcher that probes the given protocol.
+func (m *UnreachableMatcher) WithProtocol(protocol string) *UnreachableMatcher {
+	c := *m
+	c.Protocol = protocol
+	return &c
+}
+
+// WithProbes returns a copy of the matcher that sends the given number of
+// probes per match, spaced by interval.
+func (m *UnreachableMatcher) WithProbes(probes int, interval time.Duration) *UnreachableMatcher {
+	c := *m
+	c.Probes = probes
+	c.Interval = interval
+	return &c
+}
+
+func (m *UnreachableMatcher) Match(actual interface{}) (success bool, err error) {
+	target, ok := actual.(ConnectionTarget)
+	if !ok {
+		return false, fmt.Errorf("BeConsistentlyUnreachableFrom expects a ConnectionTarget, got %T", actual)
+	}
+
+	// The port of the target comes from the options, as in Checker.expect().
+	var portsExp Expectation
+	for _, o := range m.opts {
+		o(&portsExp)
+	}
+	m.target = target.ToMatcher(portsExp.explicitPorts...).WithProtocol(m.Protocol).WithOptions(m.opts...)
+	exp := m.target.expectation(m.Source)
+	opts := (&Checker{Protocol: m.Protocol}).checkOptions(exp)
+
+	m.reachedOn = 0
+	m.lastResult = nil
+	for i := 1; i <= m.Probes; i++ {
+		if i > 1 {
+			time.Sleep(m.Interval)
+		}
+		m.Source.PreRetryCleanup(m.target.IP, m.target.Port, m.Protocol, opts...)
+		m.lastResult = m.Source.CanConnectTo(m.target.IP, m.target.Port, m.Protocol, opts...)
+		if m.lastResult != nil && m.lastResult.HarnessErr != nil {
+			return false, m.lastResult.HarnessErr
+		}
+		if m.lastResult.HasConnectivity() {
+			m.reachedOn = i
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (m *UnreachableMatcher) FailureMessage(actual interface{}) (message string) {
+	message = fmt.Sprintf("Expected %v\n\t%v:%v (%s)\nto be unreachable from %v\n\t%+v\nbut probe %d of %d connected",
+		m.target.TargetName, m.target.IP, m.target.Port, m.Protocol,
+		m.Source.SourceName(), m.Source, m.reachedOn, m.Probes)
+	if m.lastResult.HasConnectivity() {
+		message += fmt.Sprintf(", the response came from %s", m.lastResult.LastResponse.SourceAddr)
+	}
+	return
+}
+
+func (m *UnreachableMatcher) NegatedFailureMessage(actual interface{}) (message string) {
+	message = fmt.Sprintf("Expected %v\n\t%v:%v (%s)\nto be reachable from %v\n\t%+v\nbut all %d probes failed",
+		m.target.TargetName, m.target.IP, m.target.Port, m.Protocol,
+		m.Source.SourceName(), m.Source, m.Probes)
+	return
+}
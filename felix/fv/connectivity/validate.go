@@ -0,0 +1,177 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/onsi/ginkgo"
+	log "github.com/sirupsen/logrus"
+)
+
+// CheckWithValidation makes the check Validate() the harness before its first
+// attempt.
+func CheckWithValidation() CheckerOpt {
+	return func(c *Checker) {
+		log.Debug("CheckWithValidation set")
+		c.validate = true
+	}
+}
+
+// Validate checks the test harness, rather than the connectivity: that the
+// container of each source exists, that it has a compatible test-connection
+// binary and that test-connection can reach the loopback of the source.  With
+// AllowFallbackProbes, a source without test-connection only needs the fallback
+// probe of the Checker's protocol, see WithFallbackProbes().  All the
+// problems are reported at once, through OnFail or ginkgo.Fail(), so that a
+// broken harness doesn't show up as bogus "no connectivity" results.
+func (c *Checker) Validate() {
+	c.reportValidationProblems(1)
+}
+
+// reportValidationProblems validates the harness and returns true if it is OK.
+// callerSkip is that of its caller.
+func (c *Checker) reportValidationProblems(callerSkip int) bool {
+	problems := c.validationProblems()
+	if len(problems) == 0 {
+		return true
+	}
+
+	message := fmt.Sprintf("Connectivity check harness is broken:\n    %s",
+		strings.Join(problems, "\n    "))
+	if c.description != "" {
+		message += "\nDescription:\n" + c.description
+	}
+	log.Warn(message)
+
+	c.fail(&CheckError{
+		Kind:               ErrorKindValidation,
+		Message:            message,
+		ValidationProblems: problems,
+	}, callerSkip+1)
+	return false
+}
+
+func (c *Checker) validationProblems() []string {
+	var sources []ConnectionSource
+	seen := map[string]bool{}
+	for _, exp := range c.expectationsSnapshot() {
+		if seen[exp.From.SourceName()] {
+			continue
+		}
+		seen[exp.From.SourceName()] = true
+		sources = append(sources, exp.From)
+	}
+
+	log.WithField("sources", len(sources)).Info("Validating connectivity check harness...")
+	results := make([]*Result, len(sources))
+	var wg sync.WaitGroup
+	for i, src := range sources {
+		wg.Add(1)
+		go func(i int, src ConnectionSource) {
+			defer ginkgo.GinkgoRecover()
+			defer wg.Done()
+			opts := []CheckOption{withPreflight()}
+			if c.AutoProvision {
+				opts = append(opts, WithAutoProvision())
+			}
+			if c.AllowFallbackProbes {
+				opts = append(opts, WithFallbackProbes())
+			}
+			// The self test always uses TCP, the protocol of the Checker picks
+			// the fallback probe.
+			results[i] = src.CanConnectTo("127.0.0.1", "0", c.protocol(), opts...)
+		}(i, src)
+	}
+	wg.Wait()
+
+	var problems []string
+	for i, src := range sources {
+		res := results[i]
+		switch {
+		case res == nil:
+			problems = append(problems, fmt.Sprintf("%s: no result from the self test", src.SourceName()))
+		case res.HarnessErr != nil:
+			problems = append(problems, fmt.Sprintf("%s: %v", src.SourceName(), res.HarnessErr))
+		case res.LastResponse.ErrorStr != "":
+			problems = append(problems, fmt.Sprintf("%s: %s", src.SourceName(), res.LastResponse.ErrorStr))
+		}
+	}
+	return problems
+}
+
+// withPreflight makes the check validate the harness in the source container
+// instead of checking connectivity.
+func withPreflight() CheckOption {
+	return func(c *CheckCmd) {
+		c.preflight = true
+	}
+}
+
+// runPreflight checks that test-connection exists in the container, that it is
+// compatible and that it can reach loopback in the namespace of the check.  Any
+// problem is returned in LastResponse.ErrorStr.
+func (cmd *CheckCmd) runPreflight(cName string) *Result {
+	res := &Result{}
+	caps, err := probeCapabilities(cName)
+	if err != nil {
+		res.LastResponse.ErrorStr = err.Error()
+		return res
+	}
+	if caps.Version < ProtocolVersion || !caps.Supports(FeatureSelfTest) {
+		res.LastResponse.ErrorStr = fmt.Sprintf("%s is protocol version %d, expected %d, "+
+			"the container image needs to be rebuilt", BinaryName, caps.Version, ProtocolVersion)
+		return res
+	}
+
+	if err := waitForExecToken(cmd.context()); err != nil {
+		res.LastResponse.ErrorStr = err.Error()
+		return res
+	}
+	proc, err := startExec(cName, false, []string{binaryPath(cName), "--self-test", cmd.nsPath})
+	if err != nil {
+		res.LastResponse.ErrorStr = err.Error()
+		return res
+	}
+	var stderr []byte
+	stderrDone := make(chan struct{})
+	go func() {
+		defer close(stderrDone)
+		stderr, _ = io.ReadAll(proc.Stderr())
+	}()
+	var selfTest *Result
+	scanner := bufio.NewScanner(proc.Stdout())
+	for scanner.Scan() {
+		msg, err := ParseMessage(scanner.Bytes())
+		if err == nil && msg != nil && msg.Result != nil {
+			selfTest = msg.Result
+		}
+	}
+	<-stderrDone
+	err = proc.Wait()
+
+	switch {
+	case selfTest == nil:
+		res.LastResponse.ErrorStr = fmt.Sprintf("%s self test failed: %v: %s",
+			BinaryName, err, strings.TrimSpace(string(stderr)))
+	case selfTest.LastResponse.ErrorStr != "":
+		res.LastResponse.ErrorStr = "cannot reach loopback: " + selfTest.LastResponse.ErrorStr
+	}
+	return res
+}
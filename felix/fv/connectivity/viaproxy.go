@@ -0,0 +1,48 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import "net/url"
+
+// WithProxy tunnels the TCP connections of the check through a proxy,
+// http://host:port for a proxy that supports CONNECT, or socks5://host:port.
+// Credentials can be given in the URL.  The target sees the connection come
+// from the proxy.
+func WithProxy(url string) CheckOption {
+	return func(c *CheckCmd) {
+		c.viaProxy = url
+	}
+}
+
+// ExpectViaProxy makes the expectation about connecting through the proxy, see
+// WithProxy().  Along with a direct expectation, it expresses policies like
+// "direct access blocked, access via the egress proxy allowed":
+//
+//	cc.ExpectNone(w[0], external, 443)
+//	cc.Expect(Some, w[0], external, ExpectWithPorts(443), ExpectViaProxy("http://10.0.0.5:3128"))
+func ExpectViaProxy(url string) ExpectationOption {
+	return func(e *Expectation) {
+		e.viaProxy = url
+	}
+}
+
+// redactedURL hides the password of a proxy URL, for the logs and messages.
+func redactedURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return s
+	}
+	return u.Redacted()
+}
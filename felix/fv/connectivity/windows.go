@@ -0,0 +1,79 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"strings"
+	"sync"
+)
+
+// Platform is the OS of a container that runs test-connection, which decides how
+// the checker runs it.
+type Platform string
+
+const (
+	PlatformLinux   Platform = "linux"
+	PlatformWindows Platform = "windows"
+)
+
+var (
+	platformsLock sync.Mutex
+	platforms     = map[string]Platform{}
+)
+
+// SetContainerPlatform tells the checker the OS of a container.  Containers are
+// Linux unless set otherwise.  In a Windows container, test-connection is run
+// through powershell as test-connection.exe, which the image must provide, since
+// test-connection only builds for Linux and can't be provisioned.  The checks run
+// in the container's own network, there is no Windows counterpart of
+// WithNamespacePath().
+func SetContainerPlatform(cName string, p Platform) {
+	platformsLock.Lock()
+	defer platformsLock.Unlock()
+	platforms[cName] = p
+}
+
+func containerPlatform(cName string) Platform {
+	platformsLock.Lock()
+	defer platformsLock.Unlock()
+	if p, ok := platforms[cName]; ok {
+		return p
+	}
+	return PlatformLinux
+}
+
+// platformBinaryName returns the name of the test-connection binary on the
+// container's platform.
+func platformBinaryName(cName string) string {
+	if containerPlatform(cName) == PlatformWindows {
+		return BinaryName + ".exe"
+	}
+	return BinaryName
+}
+
+// platformCommand adapts a command to the container's platform.  Windows
+// containers have no sh, so the command is run through powershell, which then
+// exits with the command's status.
+func platformCommand(cName string, cmd []string) []string {
+	if containerPlatform(cName) != PlatformWindows {
+		return cmd
+	}
+	quoted := make([]string, len(cmd))
+	for i, arg := range cmd {
+		quoted[i] = "'" + strings.ReplaceAll(arg, "'", "''") + "'"
+	}
+	script := "& " + strings.Join(quoted, " ") + "; exit $LASTEXITCODE"
+	return []string{"powershell", "-NoProfile", "-NonInteractive", "-Command", script}
+}
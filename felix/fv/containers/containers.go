@@ -696,6 +696,10 @@ func (c *Container) SourceName() string {
 	return c.Name
 }
 
+func (c *Container) HostContainerName() string {
+	return c.Name
+}
+
 func (c *Container) SourceIPs() []string {
 	ips := []string{c.IP}
 	ips = append(ips, c.ExtraSourceIPs...)
@@ -709,6 +713,20 @@ func (c *Container) CanConnectTo(ip, port, protocol string, opts ...connectivity
 	return connectivity.Check(c.Name, "Connection test", ip, port, protocol, opts...)
 }
 
+// HostSource returns a source that probes from the host namespace of the
+// container with the given source IP, which defaults to the container's IP, and
+// bound to the given interface, if not empty.
+func (c *Container) HostSource(ip, iface string) connectivity.HostSource {
+	if ip == "" {
+		ip = c.IP
+	}
+	return connectivity.HostSource{
+		Container: c.Name,
+		IP:        ip,
+		Interface: iface,
+	}
+}
+
 // AttachTCPDump returns tcpdump attached to the container
 func (c *Container) AttachTCPDump(iface string) *tcpdump.TCPDump {
 	return tcpdump.AttachUnavailable(c.GetID(), iface)
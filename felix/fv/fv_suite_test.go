@@ -45,7 +45,8 @@ func init() {
 func TestFv(t *testing.T) {
 	RegisterFailHandler(Fail)
 	junitReporter := reporters.NewJUnitReporter("../report/fv_suite.xml")
-	RunSpecsWithDefaultAndCustomReporters(t, "FV Suite", []Reporter{junitReporter})
+	attachmentsReporter := connectivity.NewSpecAttachmentsReporter("../report/fv_connectivity")
+	RunSpecsWithDefaultAndCustomReporters(t, "FV Suite", []Reporter{junitReporter, attachmentsReporter})
 }
 
 var _ = BeforeEach(func() {
@@ -59,13 +60,12 @@ var _ = JustAfterEach(func() {
 })
 
 var _ = AfterEach(func() {
-	defer connectivity.UnactivatedCheckers.Clear()
 	if CurrentGinkgoTestDescription().Failed {
 		// If the test has already failed, ignore any connectivity checker leak.
+		connectivity.ClearUnactivated()
 		return
 	}
-	Expect(connectivity.UnactivatedCheckers.Len()).To(BeZero(),
-		"Test bug: ConnectivityChecker was created but not activated.")
+	connectivity.ReportUnactivated(Fail)
 })
 
 var _ = AfterSuite(func() {
@@ -0,0 +1,461 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package policymodel evaluates Calico and Kubernetes network policies against a
+// model of the endpoints of a test and generates the expected connectivity
+// matrix for a connectivity.Checker, so that tests can assert that the
+// dataplane matches the model without hand-coding every pair.
+//
+// The model covers workload endpoint policy in the default tier: selectors,
+// namespace selectors, nets, protocols, numeric and named destination ports,
+// and the Allow, Deny, Pass and Log actions.  Policies that use other features
+// are rejected when they are added.  The v3 API has no tiers of its own, all
+// policies are in the default tier, so Pass goes straight to the profiles.
+package policymodel
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	api "github.com/projectcalico/api/pkg/apis/projectcalico/v3"
+	"github.com/projectcalico/api/pkg/lib/numorstring"
+	networkingv1 "k8s.io/api/networking/v1"
+
+	"github.com/projectcalico/calico/libcalico-go/lib/backend/k8s/conversion"
+	"github.com/projectcalico/calico/libcalico-go/lib/selector"
+
+	"github.com/projectcalico/calico/felix/fv/connectivity"
+	"github.com/projectcalico/calico/felix/fv/workload"
+)
+
+// Workload is the source and target of the connectivity of an Endpoint.
+type Workload interface {
+	connectivity.ConnectionSource
+	connectivity.ConnectionTarget
+}
+
+// Endpoint is a workload endpoint of the model.
+type Endpoint struct {
+	Workload  Workload
+	IP        string
+	Namespace string
+	Labels    map[string]string
+	// NamedPorts maps the names of the endpoint's named ports to their numbers.
+	NamedPorts map[string]uint16
+}
+
+// EndpointFromWorkload returns the Endpoint of a workload, with the namespace,
+// labels and named ports of its WorkloadEndpoint.
+func EndpointFromWorkload(w *workload.Workload) Endpoint {
+	e := Endpoint{
+		Workload:   w,
+		IP:         w.IP,
+		NamedPorts: map[string]uint16{},
+	}
+	if wep := w.WorkloadEndpoint; wep != nil {
+		e.Namespace = wep.Namespace
+		e.Labels = wep.Labels
+		for _, p := range wep.Spec.Ports {
+			e.NamedPorts[p.Name] = p.Port
+		}
+	}
+	return e
+}
+
+// selectorLabels returns the labels that policy selectors match the endpoint
+// with, including those that Calico adds.
+func (e *Endpoint) selectorLabels() map[string]string {
+	labels := map[string]string{}
+	for k, v := range e.Labels {
+		labels[k] = v
+	}
+	if e.Namespace != "" {
+		labels[api.LabelNamespace] = e.Namespace
+	}
+	return labels
+}
+
+// policy is a NetworkPolicy or GlobalNetworkPolicy, normalised.
+type policy struct {
+	name      string
+	namespace string // empty for a GlobalNetworkPolicy.
+	order     *float64
+
+	selector   selector.Selector
+	nsSelector selector.Selector // GlobalNetworkPolicy only.
+
+	ingress, egress []api.Rule
+	types           []api.PolicyType
+}
+
+// Model holds the endpoints and policies of a test.
+type Model struct {
+	// Namespaces holds the labels of each namespace, for namespace selectors.
+	Namespaces map[string]map[string]string
+	Endpoints  []Endpoint
+	// ProfileAction is the action for traffic of endpoints without policy in
+	// its direction, or passed by policy: the action of the endpoints' profiles.
+	ProfileAction api.Action
+
+	policies []policy
+}
+
+// New returns a Model with the given profile action, api.Allow for endpoints
+// with the Kubernetes namespace profiles.
+func New(profileAction api.Action, endpoints ...Endpoint) *Model {
+	return &Model{
+		Namespaces:    map[string]map[string]string{},
+		Endpoints:     endpoints,
+		ProfileAction: profileAction,
+	}
+}
+
+// AddGlobalNetworkPolicy adds a GlobalNetworkPolicy to the model.
+func (m *Model) AddGlobalNetworkPolicy(p *api.GlobalNetworkPolicy) error {
+	if p.Spec.DoNotTrack || p.Spec.PreDNAT || p.Spec.ApplyOnForward {
+		return fmt.Errorf("policy %s: host endpoint policy is not supported", p.Name)
+	}
+	pol, err := newPolicy(p.Name, "", p.Spec.Order, p.Spec.Selector, p.Spec.ServiceAccountSelector,
+		p.Spec.Ingress, p.Spec.Egress, p.Spec.Types)
+	if err != nil {
+		return err
+	}
+	if p.Spec.NamespaceSelector != "" {
+		pol.nsSelector, err = selector.Parse(p.Spec.NamespaceSelector)
+		if err != nil {
+			return fmt.Errorf("policy %s: bad namespace selector: %w", p.Name, err)
+		}
+	}
+	m.policies = append(m.policies, pol)
+	return nil
+}
+
+// AddNetworkPolicy adds a NetworkPolicy to the model.
+func (m *Model) AddNetworkPolicy(p *api.NetworkPolicy) error {
+	pol, err := newPolicy(p.Name, p.Namespace, p.Spec.Order, p.Spec.Selector, p.Spec.ServiceAccountSelector,
+		p.Spec.Ingress, p.Spec.Egress, p.Spec.Types)
+	if err != nil {
+		return err
+	}
+	m.policies = append(m.policies, pol)
+	return nil
+}
+
+// AddK8sNetworkPolicy adds a Kubernetes NetworkPolicy to the model, converted
+// as Calico does.  The converted pod selectors only match endpoints with the
+// projectcalico.org/orchestrator=k8s label.
+func (m *Model) AddK8sNetworkPolicy(p *networkingv1.NetworkPolicy) error {
+	kvp, err := conversion.NewConverter().K8sNetworkPolicyToCalico(p)
+	if err != nil {
+		return err
+	}
+	return m.AddNetworkPolicy(kvp.Value.(*api.NetworkPolicy))
+}
+
+func newPolicy(name, namespace string, order *float64, sel, saSel string,
+	ingress, egress []api.Rule, types []api.PolicyType) (policy, error) {
+	if saSel != "" {
+		return policy{}, fmt.Errorf("policy %s: service account selectors are not supported", name)
+	}
+	if sel == "" {
+		sel = "all()"
+	}
+	parsed, err := selector.Parse(sel)
+	if err != nil {
+		return policy{}, fmt.Errorf("policy %s: bad selector: %w", name, err)
+	}
+	for _, r := range append(append([]api.Rule(nil), ingress...), egress...) {
+		if err := checkRuleSupported(r); err != nil {
+			return policy{}, fmt.Errorf("policy %s: %w", name, err)
+		}
+	}
+	if len(types) == 0 {
+		types = []api.PolicyType{api.PolicyTypeIngress}
+		if len(egress) > 0 {
+			types = append(types, api.PolicyTypeEgress)
+		}
+	}
+	return policy{
+		name:      name,
+		namespace: namespace,
+		order:     order,
+		selector:  parsed,
+		ingress:   ingress,
+		egress:    egress,
+		types:     types,
+	}, nil
+}
+
+func checkRuleSupported(r api.Rule) error {
+	switch {
+	case r.HTTP != nil:
+		return fmt.Errorf("HTTP rules are not supported")
+	case r.ICMP != nil || r.NotICMP != nil:
+		return fmt.Errorf("ICMP type and code matches are not supported")
+	case len(r.Source.Ports) > 0 || len(r.Source.NotPorts) > 0:
+		return fmt.Errorf("source port matches are not supported")
+	}
+	for _, e := range []api.EntityRule{r.Source, r.Destination} {
+		if e.ServiceAccounts != nil || e.Services != nil {
+			return fmt.Errorf("service account and service matches are not supported")
+		}
+		for _, s := range []string{e.Selector, e.NotSelector, e.NamespaceSelector} {
+			if s == "" || s == "global()" {
+				continue
+			}
+			if _, err := selector.Parse(s); err != nil {
+				return fmt.Errorf("bad selector %q: %w", s, err)
+			}
+		}
+		for _, n := range append(append([]string(nil), e.Nets...), e.NotNets...) {
+			if _, _, err := net.ParseCIDR(n); err != nil {
+				return fmt.Errorf("bad net %q: %w", n, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (p *policy) hasType(t api.PolicyType) bool {
+	for _, pt := range p.types {
+		if pt == t {
+			return true
+		}
+	}
+	return false
+}
+
+// sortName orders policies with the same order as Felix does.
+func (p *policy) sortName() string {
+	if p.namespace == "" {
+		return p.name
+	}
+	return p.namespace + "/" + p.name
+}
+
+func (m *Model) namespaceLabels(ns string) map[string]string {
+	labels := map[string]string{conversion.NameLabel: ns}
+	for k, v := range m.Namespaces[ns] {
+		labels[k] = v
+	}
+	return labels
+}
+
+func (m *Model) appliesTo(p *policy, e *Endpoint) bool {
+	if p.namespace != "" && p.namespace != e.Namespace {
+		return false
+	}
+	if p.nsSelector != nil && !p.nsSelector.Evaluate(m.namespaceLabels(e.Namespace)) {
+		return false
+	}
+	return p.selector.Evaluate(e.selectorLabels())
+}
+
+// policiesFor returns the policies of the endpoint in the direction, in the
+// order that they apply.
+func (m *Model) policiesFor(e *Endpoint, t api.PolicyType) []*policy {
+	var policies []*policy
+	for i := range m.policies {
+		p := &m.policies[i]
+		if p.hasType(t) && m.appliesTo(p, e) {
+			policies = append(policies, p)
+		}
+	}
+	sort.SliceStable(policies, func(i, j int) bool {
+		oi, oj := policies[i].order, policies[j].order
+		if oi == nil || oj == nil || *oi == *oj {
+			if (oi == nil) != (oj == nil) {
+				return oj == nil
+			}
+			return policies[i].sortName() < policies[j].sortName()
+		}
+		return *oi < *oj
+	})
+	return policies
+}
+
+// Allowed evaluates whether the policies of both endpoints allow traffic from
+// one to the other with the protocol to the port.
+func (m *Model) Allowed(from, to *Endpoint, protocol string, port uint16) bool {
+	return m.allowedIn(from, api.PolicyTypeEgress, from, to, protocol, port) &&
+		m.allowedIn(to, api.PolicyTypeIngress, from, to, protocol, port)
+}
+
+func (m *Model) allowedIn(local *Endpoint, t api.PolicyType, from, to *Endpoint, protocol string, port uint16) bool {
+	policies := m.policiesFor(local, t)
+	if len(policies) == 0 {
+		return m.ProfileAction == api.Allow
+	}
+	for _, p := range policies {
+		rules := p.ingress
+		if t == api.PolicyTypeEgress {
+			rules = p.egress
+		}
+		for _, r := range rules {
+			if !m.ruleMatches(p, r, from, to, protocol, port) {
+				continue
+			}
+			switch r.Action {
+			case api.Allow:
+				return true
+			case api.Deny:
+				return false
+			case api.Pass:
+				return m.ProfileAction == api.Allow
+			}
+			// Log rules don't end the evaluation.
+		}
+	}
+	// Traffic that no policy allowed is dropped at the end of the tier.
+	return false
+}
+
+func (m *Model) ruleMatches(p *policy, r api.Rule, from, to *Endpoint, protocol string, port uint16) bool {
+	proto := protocolNumber(protocol)
+	if r.Protocol != nil && protocolNumber(r.Protocol.String()) != proto {
+		return false
+	}
+	if r.NotProtocol != nil && protocolNumber(r.NotProtocol.String()) == proto {
+		return false
+	}
+	if r.IPVersion != nil && *r.IPVersion != ipVersion(to.IP) {
+		return false
+	}
+	if !m.entityMatches(p, r.Source, from) || !m.entityMatches(p, r.Destination, to) {
+		return false
+	}
+	if len(r.Destination.Ports) > 0 && !portMatches(r.Destination.Ports, to, port) {
+		return false
+	}
+	if len(r.Destination.NotPorts) > 0 && portMatches(r.Destination.NotPorts, to, port) {
+		return false
+	}
+	return true
+}
+
+func (m *Model) entityMatches(p *policy, er api.EntityRule, e *Endpoint) bool {
+	if len(er.Nets) > 0 && !inNets(er.Nets, e.IP) {
+		return false
+	}
+	if len(er.NotNets) > 0 && inNets(er.NotNets, e.IP) {
+		return false
+	}
+	if er.Selector != "" || er.NamespaceSelector != "" {
+		switch {
+		case er.NamespaceSelector == "global()":
+			// Only matches host endpoints and global network sets.
+			return false
+		case er.NamespaceSelector != "":
+			if !mustParse(er.NamespaceSelector).Evaluate(m.namespaceLabels(e.Namespace)) {
+				return false
+			}
+		case p.namespace != "" && e.Namespace != p.namespace:
+			// The selectors of NetworkPolicy rules are limited to its namespace.
+			return false
+		}
+		if er.Selector != "" && !mustParse(er.Selector).Evaluate(e.selectorLabels()) {
+			return false
+		}
+	}
+	if er.NotSelector != "" && mustParse(er.NotSelector).Evaluate(e.selectorLabels()) {
+		return false
+	}
+	return true
+}
+
+// mustParse parses a selector that was validated when its policy was added.
+func mustParse(s string) selector.Selector {
+	sel, err := selector.Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return sel
+}
+
+func inNets(nets []string, ip string) bool {
+	addr := net.ParseIP(ip)
+	for _, n := range nets {
+		if _, cidr, err := net.ParseCIDR(n); err == nil && cidr.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func portMatches(ports []numorstring.Port, to *Endpoint, port uint16) bool {
+	for _, p := range ports {
+		if p.PortName != "" {
+			if named, ok := to.NamedPorts[p.PortName]; ok && named == port {
+				return true
+			}
+			continue
+		}
+		if port >= p.MinPort && port <= p.MaxPort {
+			return true
+		}
+	}
+	return false
+}
+
+var protocolNumbers = map[string]int{
+	"tcp":     6,
+	"udp":     17,
+	"icmp":    1,
+	"icmpv6":  58,
+	"sctp":    132,
+	"udplite": 136,
+}
+
+func protocolNumber(p string) int {
+	if n, ok := protocolNumbers[strings.ToLower(p)]; ok {
+		return n
+	}
+	var n int
+	if _, err := fmt.Sscanf(p, "%d", &n); err == nil {
+		return n
+	}
+	return -1
+}
+
+func ipVersion(ip string) int {
+	if net.ParseIP(ip).To4() != nil {
+		return 4
+	}
+	return 6
+}
+
+// ExpectAll adds the expectations of the model's connectivity matrix to the
+// Checker: for each port, from each endpoint to each other endpoint, some
+// connectivity if the policies allow it, none otherwise.  It uses the Checker's
+// protocol and must not be used with ReverseDirection.
+func (m *Model) ExpectAll(cc *connectivity.Checker, ports ...uint16) {
+	protocol := cc.Protocol
+	if protocol == "" {
+		protocol = "tcp"
+	}
+	for i := range m.Endpoints {
+		for j := range m.Endpoints {
+			if i == j {
+				continue
+			}
+			from, to := &m.Endpoints[i], &m.Endpoints[j]
+			for _, port := range ports {
+				expected := connectivity.Expected(m.Allowed(from, to, protocol, port))
+				cc.Expect(expected, from.Workload, to.Workload, connectivity.ExpectWithPorts(port))
+			}
+		}
+	}
+}
@@ -0,0 +1,211 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package policymodel_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	api "github.com/projectcalico/api/pkg/apis/projectcalico/v3"
+	"github.com/projectcalico/api/pkg/lib/numorstring"
+
+	"github.com/projectcalico/calico/felix/fv/policymodel"
+)
+
+var tcp = numorstring.ProtocolFromString("TCP")
+
+func order(o float64) *float64 {
+	return &o
+}
+
+func gnp(name string, o *float64, sel string, ingress, egress []api.Rule) *api.GlobalNetworkPolicy {
+	p := api.NewGlobalNetworkPolicy()
+	p.Name = name
+	p.Spec.Order = o
+	p.Spec.Selector = sel
+	p.Spec.Ingress = ingress
+	p.Spec.Egress = egress
+	return p
+}
+
+func np(namespace, name string, sel string, ingress []api.Rule) *api.NetworkPolicy {
+	p := api.NewNetworkPolicy()
+	p.Name = name
+	p.Namespace = namespace
+	p.Spec.Selector = sel
+	p.Spec.Ingress = ingress
+	return p
+}
+
+func rule(action api.Action, ports ...numorstring.Port) api.Rule {
+	r := api.Rule{Action: action}
+	if len(ports) > 0 {
+		r.Protocol = &tcp
+		r.Destination.Ports = ports
+	}
+	return r
+}
+
+var _ = Describe("Model", func() {
+	// a and b are in ns1, c is in ns2 with the same labels as b.
+	newModel := func(profileAction api.Action) *policymodel.Model {
+		return policymodel.New(profileAction,
+			policymodel.Endpoint{
+				IP:         "10.65.0.1",
+				Namespace:  "ns1",
+				Labels:     map[string]string{"app": "a"},
+				NamedPorts: map[string]uint16{"http": 8080},
+			},
+			policymodel.Endpoint{
+				IP:         "10.65.0.2",
+				Namespace:  "ns1",
+				Labels:     map[string]string{"app": "b"},
+				NamedPorts: map[string]uint16{"http": 8080},
+			},
+			policymodel.Endpoint{
+				IP:        "10.65.1.1",
+				Namespace: "ns2",
+				Labels:    map[string]string{"app": "b"},
+			},
+		)
+	}
+	const a, b, c = 0, 1, 2
+
+	DescribeTable("Allowed",
+		func(profileAction api.Action, gnps []*api.GlobalNetworkPolicy, nps []*api.NetworkPolicy,
+			from, to int, port uint16, expected bool) {
+			m := newModel(profileAction)
+			for _, p := range gnps {
+				Expect(m.AddGlobalNetworkPolicy(p)).To(Succeed())
+			}
+			for _, p := range nps {
+				Expect(m.AddNetworkPolicy(p)).To(Succeed())
+			}
+			Expect(m.Allowed(&m.Endpoints[from], &m.Endpoints[to], "tcp", port)).To(Equal(expected))
+		},
+
+		Entry("no policy, profile allows", api.Allow, nil, nil, a, b, uint16(8055), true),
+		Entry("no policy, profile denies", api.Action(api.Deny), nil, nil, a, b, uint16(8055), false),
+		Entry("no matching rule drops at the end of the tier", api.Allow,
+			[]*api.GlobalNetworkPolicy{gnp("allow-other", nil, "all()", []api.Rule{
+				rule(api.Allow, numorstring.SinglePort(9000)),
+			}, nil)}, nil, a, b, uint16(8055), false),
+
+		// Ordering.
+		Entry("lower order first, deny", api.Allow, []*api.GlobalNetworkPolicy{
+			gnp("allow", order(20), "all()", []api.Rule{rule(api.Allow)}, nil),
+			gnp("deny", order(10), "all()", []api.Rule{rule(api.Deny)}, nil),
+		}, nil, a, b, uint16(8055), false),
+		Entry("lower order first, allow", api.Allow, []*api.GlobalNetworkPolicy{
+			gnp("allow", order(10), "all()", []api.Rule{rule(api.Allow)}, nil),
+			gnp("deny", order(20), "all()", []api.Rule{rule(api.Deny)}, nil),
+		}, nil, a, b, uint16(8055), true),
+		Entry("same order by name, allow", api.Allow, []*api.GlobalNetworkPolicy{
+			gnp("b-deny", order(10), "all()", []api.Rule{rule(api.Deny)}, nil),
+			gnp("a-allow", order(10), "all()", []api.Rule{rule(api.Allow)}, nil),
+		}, nil, a, b, uint16(8055), true),
+		Entry("same order by name, deny", api.Allow, []*api.GlobalNetworkPolicy{
+			gnp("a-deny", order(10), "all()", []api.Rule{rule(api.Deny)}, nil),
+			gnp("b-allow", order(10), "all()", []api.Rule{rule(api.Allow)}, nil),
+		}, nil, a, b, uint16(8055), false),
+		Entry("no order last", api.Allow, []*api.GlobalNetworkPolicy{
+			gnp("allow", nil, "all()", []api.Rule{rule(api.Allow)}, nil),
+			gnp("deny", order(100), "all()", []api.Rule{rule(api.Deny)}, nil),
+		}, nil, a, b, uint16(8055), false),
+
+		// Pass.
+		Entry("pass to a profile that allows", api.Allow, []*api.GlobalNetworkPolicy{
+			gnp("pass", order(10), "all()", []api.Rule{rule(api.Pass)}, nil),
+			gnp("deny", order(20), "all()", []api.Rule{rule(api.Deny)}, nil),
+		}, nil, a, b, uint16(8055), true),
+		Entry("pass to a profile that denies", api.Action(api.Deny), []*api.GlobalNetworkPolicy{
+			gnp("pass", order(10), "all()", []api.Rule{rule(api.Pass)}, nil),
+			gnp("allow", order(20), "all()", []api.Rule{rule(api.Allow)}, nil),
+		}, nil, a, b, uint16(8055), false),
+		Entry("log doesn't end the evaluation", api.Allow, []*api.GlobalNetworkPolicy{
+			gnp("log-allow", nil, "all()", []api.Rule{rule(api.Log), rule(api.Allow)}, nil),
+		}, nil, a, b, uint16(8055), true),
+
+		// NetworkPolicy namespace scoping.
+		Entry("NetworkPolicy applies in its namespace", api.Allow, nil, []*api.NetworkPolicy{
+			np("ns1", "deny", "all()", []api.Rule{rule(api.Deny)}),
+		}, a, b, uint16(8055), false),
+		Entry("NetworkPolicy doesn't apply in other namespaces", api.Allow, nil, []*api.NetworkPolicy{
+			np("ns1", "deny", "all()", []api.Rule{rule(api.Deny)}),
+		}, a, c, uint16(8055), true),
+		Entry("NetworkPolicy rule selectors are limited to its namespace", api.Allow, nil, []*api.NetworkPolicy{
+			np("ns2", "allow-a", "all()", []api.Rule{{
+				Action: api.Allow,
+				Source: api.EntityRule{Selector: "app == 'a'"},
+			}}),
+		}, a, c, uint16(8055), false),
+		Entry("NetworkPolicy rule with a namespace selector", api.Allow, nil, []*api.NetworkPolicy{
+			np("ns2", "allow-a", "all()", []api.Rule{{
+				Action: api.Allow,
+				Source: api.EntityRule{Selector: "app == 'a'", NamespaceSelector: "all()"},
+			}}),
+		}, a, c, uint16(8055), true),
+
+		// Named ports.
+		Entry("named port", api.Allow, []*api.GlobalNetworkPolicy{
+			gnp("allow-http", nil, "all()", []api.Rule{rule(api.Allow, numorstring.NamedPort("http"))}, nil),
+		}, nil, a, b, uint16(8080), true),
+		Entry("named port, other port", api.Allow, []*api.GlobalNetworkPolicy{
+			gnp("allow-http", nil, "all()", []api.Rule{rule(api.Allow, numorstring.NamedPort("http"))}, nil),
+		}, nil, a, b, uint16(8081), false),
+		Entry("named port the target doesn't have", api.Allow, []*api.GlobalNetworkPolicy{
+			gnp("allow-http", nil, "all()", []api.Rule{rule(api.Allow, numorstring.NamedPort("http"))}, nil),
+		}, nil, a, c, uint16(8080), false),
+
+		// Default types.
+		Entry("ingress only by default, egress from the endpoint", api.Allow, []*api.GlobalNetworkPolicy{
+			gnp("isolate-a", nil, "app == 'a'", nil, nil),
+		}, nil, a, b, uint16(8055), true),
+		Entry("ingress only by default, ingress to the endpoint", api.Allow, []*api.GlobalNetworkPolicy{
+			gnp("isolate-a", nil, "app == 'a'", nil, nil),
+		}, nil, b, a, uint16(8055), false),
+		Entry("egress rules add egress, allowed port", api.Allow, []*api.GlobalNetworkPolicy{
+			gnp("egress-a", nil, "app == 'a'", nil, []api.Rule{rule(api.Allow, numorstring.SinglePort(9000))}),
+		}, nil, a, b, uint16(9000), true),
+		Entry("egress rules add egress, other port", api.Allow, []*api.GlobalNetworkPolicy{
+			gnp("egress-a", nil, "app == 'a'", nil, []api.Rule{rule(api.Allow, numorstring.SinglePort(9000))}),
+		}, nil, a, b, uint16(8055), false),
+		Entry("egress rules keep ingress", api.Allow, []*api.GlobalNetworkPolicy{
+			gnp("egress-a", nil, "app == 'a'", nil, []api.Rule{rule(api.Allow, numorstring.SinglePort(9000))}),
+		}, nil, b, a, uint16(9000), false),
+	)
+
+	DescribeTable("should reject unsupported policies",
+		func(p *api.GlobalNetworkPolicy) {
+			Expect(newModel(api.Allow).AddGlobalNetworkPolicy(p)).NotTo(Succeed())
+		},
+		Entry("host endpoint policy", func() *api.GlobalNetworkPolicy {
+			p := gnp("pre-dnat", nil, "all()", nil, nil)
+			p.Spec.PreDNAT = true
+			return p
+		}()),
+		Entry("service account selector", func() *api.GlobalNetworkPolicy {
+			p := gnp("sa", nil, "all()", nil, nil)
+			p.Spec.ServiceAccountSelector = "all()"
+			return p
+		}()),
+		Entry("HTTP rule", gnp("http", nil, "all()", []api.Rule{{
+			Action: api.Allow,
+			HTTP:   &api.HTTPMatch{Methods: []string{"GET"}},
+		}}, nil)),
+		Entry("bad selector", gnp("bad", nil, "app ==", nil, nil)),
+	)
+})
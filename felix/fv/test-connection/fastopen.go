@@ -0,0 +1,69 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// tcpiOptSYNData is the TCP_INFO option bit that says that the server acked the
+// data in our SYN.
+const tcpiOptSYNData = 0x20
+
+// setFastOpen enables TCP Fast Open on a TCP socket before it connects, so that
+// the kernel sends the first write in the SYN, if it has a cookie for the
+// server.
+func (o *options) setFastOpen(network string, fd int) error {
+	if !o.tcpFastOpen || (network != "tcp" && network != "tcp4" && network != "tcp6") {
+		return nil
+	}
+	if err := unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_FASTOPEN_CONNECT, 1); err != nil {
+		return fmt.Errorf("failed to enable TCP Fast Open: %w", err)
+	}
+	return nil
+}
+
+// primeFastOpen makes a throwaway connection to the server to get a TFO cookie,
+// without which the kernel can't send data in the SYN.  The cookie is cached in
+// the namespace, so this only costs a connection the first time.
+func (o *options) primeFastOpen(localAddr, remoteAddr string) {
+	// Use an ephemeral port, so as not to tie up the source port of the check.
+	host, _, err := net.SplitHostPort(localAddr)
+	if err != nil {
+		host = ""
+	}
+	conn, err := o.dial("tcp", net.JoinHostPort(host, "0"), remoteAddr)
+	if err != nil {
+		log.WithError(err).Warn("Failed to connect to get a TCP Fast Open cookie")
+		return
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+	// The server answers any request, the SYN carries the cookie request and
+	// the SYN-ACK the cookie.
+	if _, err := conn.Write([]byte("{}\n")); err != nil {
+		log.WithError(err).Warn("Failed to send on the TCP Fast Open priming connection")
+		return
+	}
+	if _, err := bufio.NewReader(conn).ReadString('\n'); err != nil {
+		log.WithError(err).Warn("Failed to receive on the TCP Fast Open priming connection")
+	}
+}
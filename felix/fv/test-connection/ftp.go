@@ -0,0 +1,181 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/projectcalico/calico/felix/fv/connectivity"
+)
+
+const defaultFTPTimeout = 10 * time.Second
+
+func parseFTPMode(s string) (connectivity.FTPMode, error) {
+	switch m := connectivity.FTPMode(s); m {
+	case connectivity.FTPPassive, connectivity.FTPActive:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown FTP mode %q, expected passive or active", s)
+}
+
+// tryFTP logs in to the target over a control connection, negotiates a data
+// connection, in passive or active mode, and fetches the server's response over
+// it.  The result describes the data connection.
+func (o *options) tryFTP(targetIP, port, sourceIP, sourcePort string, timeout time.Duration) error {
+	if timeout == 0 {
+		timeout = defaultFTPTimeout
+	}
+	res := connectivity.Result{
+		Stats: connectivity.Stats{
+			RequestsSent: 1,
+		},
+	}
+	resp, clientAddr, err := o.ftpExchange(targetIP, port, sourceIP, sourcePort, time.Now().Add(timeout))
+	if err != nil {
+		res.LastResponse.ErrorStr = err.Error()
+		res.PrintToStdout()
+		return err
+	}
+	res.LastResponse = *resp
+	res.ClientAddr = clientAddr
+	res.Stats.ResponsesReceived = 1
+	res.PrintToStdout()
+	return nil
+}
+
+func (o *options) ftpExchange(targetIP, port, sourceIP, sourcePort string, deadline time.Time) (*connectivity.Response, string, error) {
+	conn, err := o.dial("tcp", net.JoinHostPort(sourceIP, sourcePort), net.JoinHostPort(targetIP, port))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to connect control connection: %w", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(deadline)
+	control := textproto.NewConn(conn)
+
+	cmd := func(code int, format string, args ...interface{}) (string, error) {
+		if err := control.PrintfLine(format, args...); err != nil {
+			return "", err
+		}
+		_, msg, err := control.ReadResponse(code)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", strings.Fields(format)[0], err)
+		}
+		return msg, nil
+	}
+
+	if _, err := cmd(230, "USER anonymous"); err != nil {
+		return nil, "", err
+	}
+
+	localIP := conn.LocalAddr().(*net.TCPAddr).IP
+	v4 := localIP.To4() != nil
+	var data net.Conn
+	var listener net.Listener
+	switch o.ftpMode {
+	case connectivity.FTPPassive:
+		var dataAddr string
+		if v4 {
+			msg, err := cmd(227, "PASV")
+			if err != nil {
+				return nil, "", err
+			}
+			start, end := strings.Index(msg, "("), strings.LastIndex(msg, ")")
+			if start < 0 || end < start {
+				return nil, "", fmt.Errorf("malformed PASV reply %q", msg)
+			}
+			addr, err := connectivity.ParseFTPHostPort(msg[start+1 : end])
+			if err != nil {
+				return nil, "", err
+			}
+			dataAddr = addr.String()
+		} else {
+			msg, err := cmd(229, "EPSV")
+			if err != nil {
+				return nil, "", err
+			}
+			start, end := strings.Index(msg, "("), strings.LastIndex(msg, ")")
+			if start < 0 || end < start {
+				return nil, "", fmt.Errorf("malformed EPSV reply %q", msg)
+			}
+			_, dataPort, err := connectivity.ParseFTPExtAddr(msg[start+1 : end])
+			if err != nil {
+				return nil, "", err
+			}
+			dataAddr = net.JoinHostPort(targetIP, fmt.Sprint(dataPort))
+		}
+		log.WithField("addr", dataAddr).Info("Connecting FTP data connection")
+		data, err = o.dial("tcp", net.JoinHostPort(localIP.String(), "0"), dataAddr)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to connect data connection: %w", err)
+		}
+	case connectivity.FTPActive:
+		listener, err = net.Listen("tcp", net.JoinHostPort(localIP.String(), "0"))
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to listen for data connection: %w", err)
+		}
+		defer listener.Close()
+		addr := listener.Addr().(*net.TCPAddr)
+		if v4 {
+			hostPort, err := connectivity.FTPHostPort(addr)
+			if err != nil {
+				return nil, "", err
+			}
+			_, err = cmd(200, "PORT %s", hostPort)
+		} else {
+			_, err = cmd(200, "EPRT %s", connectivity.FTPExtAddr(addr.IP, addr.Port))
+		}
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	if _, err := cmd(150, "RETR probe"); err != nil {
+		if data != nil {
+			_ = data.Close()
+		}
+		return nil, "", err
+	}
+	if listener != nil {
+		_ = listener.(*net.TCPListener).SetDeadline(deadline)
+		data, err = listener.Accept()
+		if err != nil {
+			return nil, "", fmt.Errorf("server didn't connect data connection: %w", err)
+		}
+	}
+	defer data.Close()
+	_ = data.SetDeadline(deadline)
+
+	raw, err := io.ReadAll(data)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read data connection: %w", err)
+	}
+	var resp connectivity.Response
+	if err := json.Unmarshal(raw, &resp); err != nil {
+		return nil, "", fmt.Errorf("failed to parse response from data connection: %w", err)
+	}
+	if _, _, err := control.ReadResponse(226); err != nil {
+		return nil, "", fmt.Errorf("RETR: %w", err)
+	}
+	_ = control.PrintfLine("QUIT")
+	return &resp, data.LocalAddr().String(), nil
+}
@@ -0,0 +1,187 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+
+	"github.com/projectcalico/calico/felix/fv/connectivity"
+)
+
+// icmpGracePeriod is how long we keep listening for ICMP errors after the check,
+// in case one is still on its way.
+const icmpGracePeriod = 100 * time.Millisecond
+
+// icmpObserver listens for ICMP destination unreachable and time exceeded
+// messages about packets to the target, on a raw socket, since the sockets of
+// the check only report that the connection failed, not who rejected it or that
+// the packets went round in a loop.
+type icmpObserver struct {
+	conn   *icmp.PacketConn
+	v6     bool
+	target net.IP
+	port   int
+
+	lock       sync.Mutex
+	errs       []connectivity.ICMPError
+	done       chan struct{}
+	reportOnce sync.Once
+}
+
+// startICMPObserver starts watching for ICMP errors about our packets to the
+// target.  It must be called in the namespace of the check.  It returns nil,
+// after logging, if it can't watch.
+func startICMPObserver(targetIP, port string) *icmpObserver {
+	target := net.ParseIP(targetIP)
+	if target == nil {
+		log.WithField("target", targetIP).Warn("Can't observe ICMP errors for a target that isn't an IP")
+		return nil
+	}
+	o := &icmpObserver{target: target, v6: target.To4() == nil, done: make(chan struct{})}
+	o.port, _ = strconv.Atoi(port)
+
+	var err error
+	if o.v6 {
+		o.conn, err = icmp.ListenPacket("ip6:ipv6-icmp", "::")
+	} else {
+		o.conn, err = icmp.ListenPacket("ip4:icmp", "0.0.0.0")
+	}
+	if err != nil {
+		log.WithError(err).Warn("Failed to listen for ICMP errors")
+		return nil
+	}
+	go o.loop()
+	// Most failures end with log.Fatal(), which skips the deferred report().
+	log.RegisterExitHandler(o.report)
+	return o
+}
+
+func (o *icmpObserver) loop() {
+	defer close(o.done)
+	proto := 1 // ICMP
+	if o.v6 {
+		proto = 58 // ICMPv6
+	}
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := o.conn.ReadFrom(buf)
+		if err != nil {
+			// Closed by stop().
+			return
+		}
+		msg, err := icmp.ParseMessage(proto, buf[:n])
+		if err != nil {
+			continue
+		}
+		var orig []byte
+		switch body := msg.Body.(type) {
+		case *icmp.DstUnreach:
+			orig = body.Data
+		case *icmp.TimeExceeded:
+			orig = body.Data
+		default:
+			continue
+		}
+		if !o.aboutTarget(orig) {
+			continue
+		}
+		icmpErr := connectivity.ICMPError{
+			From: hostOfAddr(from),
+			V6:   o.v6,
+			Code: msg.Code,
+		}
+		switch t := msg.Type.(type) {
+		case ipv4.ICMPType:
+			icmpErr.Type = int(t)
+		case ipv6.ICMPType:
+			icmpErr.Type = int(t)
+		}
+		log.WithField("error", icmpErr).Info("Received ICMP error")
+		o.lock.Lock()
+		o.errs = append(o.errs, icmpErr)
+		o.lock.Unlock()
+	}
+}
+
+// aboutTarget returns whether the original datagram in an ICMP error was one of
+// ours to the target.
+func (o *icmpObserver) aboutTarget(orig []byte) bool {
+	var dst net.IP
+	var transport []byte
+	if o.v6 {
+		if len(orig) < ipv6.HeaderLen {
+			return false
+		}
+		dst = net.IP(orig[24:40])
+		transport = orig[ipv6.HeaderLen:]
+	} else {
+		if len(orig) < ipv4.HeaderLen {
+			return false
+		}
+		hdrLen := int(orig[0]&0x0f) << 2
+		if len(orig) < hdrLen {
+			return false
+		}
+		dst = net.IP(orig[16:20])
+		transport = orig[hdrLen:]
+	}
+	if !dst.Equal(o.target) {
+		return false
+	}
+	if o.port == 0 || len(transport) < 4 {
+		// No port to match, or not enough of the datagram to tell.
+		return true
+	}
+	// TCP, UDP and SCTP all start with the source and destination ports.
+	return int(binary.BigEndian.Uint16(transport[2:4])) == o.port
+}
+
+// report stops observing and reports the ICMP errors that we received.
+func (o *icmpObserver) report() {
+	if o == nil {
+		return
+	}
+	o.reportOnce.Do(o.doReport)
+}
+
+func (o *icmpObserver) doReport() {
+	time.Sleep(icmpGracePeriod)
+	_ = o.conn.Close()
+	<-o.done
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if len(o.errs) > 0 {
+		connectivity.PrintICMPErrors(o.errs)
+	}
+}
+
+func hostOfAddr(addr net.Addr) string {
+	switch a := addr.(type) {
+	case *net.IPAddr:
+		return a.IP.String()
+	case *net.UDPAddr:
+		return a.IP.String()
+	}
+	return addr.String()
+}
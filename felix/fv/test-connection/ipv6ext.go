@@ -0,0 +1,121 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+
+	"github.com/projectcalico/calico/felix/fv/connectivity"
+)
+
+// fragmentProbeSize is the size that we pad requests to in order to have them
+// fragmented, over the IPv6 minimum MTU that we set on the socket.
+const fragmentProbeSize = 2000
+
+func parseIPv6ExtHeaders(s string) ([]connectivity.IPv6ExtHeader, error) {
+	var hdrs []connectivity.IPv6ExtHeader
+	for _, h := range strings.Split(s, ",") {
+		hdr := connectivity.IPv6ExtHeader(h)
+		switch hdr {
+		case connectivity.IPv6HopByHop, connectivity.IPv6DestOpts, connectivity.IPv6Routing,
+			connectivity.IPv6Fragment:
+			hdrs = append(hdrs, hdr)
+		default:
+			return nil, fmt.Errorf("unknown IPv6 extension header %q", h)
+		}
+	}
+	return hdrs, nil
+}
+
+func (o *options) wantIPv6ExtHeader(hdr connectivity.IPv6ExtHeader) bool {
+	for _, h := range o.ipv6ExtHeaders {
+		if h == hdr {
+			return true
+		}
+	}
+	return false
+}
+
+// padOptionsHeader is a hop-by-hop or destination options header with only a
+// PadN option, which every node must skip.
+var padOptionsHeader = []byte{
+	0, 0, // Next header, filled in by the kernel, and length, in 8-byte units after the first.
+	1, 4, 0, 0, 0, 0, // PadN.
+}
+
+// segmentRoutingHeader is a routing header, of the one type that the kernel
+// lets us set, with the target as the only, already visited, segment, so that
+// the packet goes straight to the target.
+func segmentRoutingHeader(target net.IP) []byte {
+	hdr := []byte{
+		0, 2, // Next header and length, in 8-byte units after the first.
+		4, 0, // Segment routing, no segments left.
+		0, 0, 0, 0, // Last entry, flags and tag.
+	}
+	return append(hdr, target.To16()...)
+}
+
+// setIPv6ExtOpts adds the extension headers that we were asked for to the
+// packets of an IPv6 socket.
+func (o *options) setIPv6ExtOpts(fd int) error {
+	if len(o.ipv6ExtHeaders) == 0 {
+		return nil
+	}
+	if domain, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_DOMAIN); err != nil || domain != unix.AF_INET6 {
+		return nil
+	}
+	if o.wantIPv6ExtHeader(connectivity.IPv6HopByHop) {
+		if err := unix.SetsockoptString(fd, unix.IPPROTO_IPV6, unix.IPV6_HOPOPTS, string(padOptionsHeader)); err != nil {
+			return fmt.Errorf("failed to add hop-by-hop options header: %w", err)
+		}
+	}
+	if o.wantIPv6ExtHeader(connectivity.IPv6DestOpts) {
+		if err := unix.SetsockoptString(fd, unix.IPPROTO_IPV6, unix.IPV6_DSTOPTS, string(padOptionsHeader)); err != nil {
+			return fmt.Errorf("failed to add destination options header: %w", err)
+		}
+	}
+	if o.wantIPv6ExtHeader(connectivity.IPv6Routing) {
+		rthdr := string(segmentRoutingHeader(o.ipv6ExtTarget))
+		if err := unix.SetsockoptString(fd, unix.IPPROTO_IPV6, unix.IPV6_RTHDR, rthdr); err != nil {
+			return fmt.Errorf("failed to add routing header: %w", err)
+		}
+	}
+	if o.wantIPv6ExtHeader(connectivity.IPv6Fragment) {
+		// Fragment our padded requests at the minimum MTU, rather than failing
+		// with EMSGSIZE.
+		if err := unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_MTU_DISCOVER, unix.IPV6_PMTUDISC_DONT); err != nil {
+			return fmt.Errorf("failed to disable PMTU discovery: %w", err)
+		}
+		if err := unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_MTU, 1280); err != nil {
+			return fmt.Errorf("failed to set the MTU: %w", err)
+		}
+	}
+	log.WithField("headers", o.ipv6ExtHeaders).Debug("Added IPv6 extension headers")
+	return nil
+}
+
+// padForFragmentation pads a request so that it has to be fragmented, if we
+// were asked for fragment headers.
+func (o *options) padForFragmentation(req connectivity.Request) connectivity.Request {
+	if o.wantIPv6ExtHeader(connectivity.IPv6Fragment) {
+		req.Padding = strings.Repeat("x", fragmentProbeSize)
+	}
+	return req
+}
@@ -0,0 +1,280 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+
+	"github.com/projectcalico/calico/felix/fv/connectivity"
+)
+
+const defaultNeighborTimeout = 2 * time.Second
+
+func isNeighborProtocol(protocol string) bool {
+	return protocol == connectivity.ProtocolARP || protocol == connectivity.ProtocolNDP
+}
+
+// tryNeighbor sends an ARP request, or an NDP neighbor solicitation, for the
+// target IP from the source interface and waits for the reply.  Since the frame
+// is built by hand, the source MAC and IP can be any that the test wants to
+// claim, so that it can check L2 anti-spoofing and proxy ARP.
+func (o *options) tryNeighbor(targetIP, sourceIP, protocol string, timeout time.Duration) error {
+	if timeout == 0 {
+		timeout = defaultNeighborTimeout
+	}
+	res := connectivity.Result{
+		LastResponse: connectivity.Response{
+			Timestamp:  time.Now(),
+			ServerAddr: targetIP,
+		},
+		Stats: connectivity.Stats{
+			RequestsSent: 1,
+		},
+	}
+	mac, srcIP, err := o.neighborExchange(targetIP, sourceIP, protocol, timeout)
+	res.LastResponse.SourceAddr = srcIP
+	if err != nil {
+		res.LastResponse.ErrorStr = err.Error()
+		res.PrintToStdout()
+		return err
+	}
+	res.Stats.ResponsesReceived = 1
+	res.Neighbor = &connectivity.NeighborReply{MAC: mac.String()}
+	res.PrintToStdout()
+	return nil
+}
+
+func (o *options) neighborExchange(targetIP, sourceIP, protocol string, timeout time.Duration) (net.HardwareAddr, string, error) {
+	ifaceName := o.sourceInterface
+	if ifaceName == "" {
+		ifaceName = "eth0"
+	}
+	iface, err := net.InterfaceByName(ifaceName)
+	if err != nil {
+		return nil, "", err
+	}
+	srcMAC := iface.HardwareAddr
+	spoofed := false
+	if o.sourceMAC != "" {
+		srcMAC, err = net.ParseMAC(o.sourceMAC)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid source MAC %s: %w", o.sourceMAC, err)
+		}
+		spoofed = srcMAC.String() != iface.HardwareAddr.String()
+	}
+
+	target := net.ParseIP(targetIP)
+	if target == nil {
+		return nil, "", fmt.Errorf("invalid target IP %s", targetIP)
+	}
+	v6 := protocol == connectivity.ProtocolNDP
+	src := net.ParseIP(sourceIP)
+	if src == nil || src.IsUnspecified() {
+		src, err = interfaceIP(iface, v6)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	var frame []byte
+	ethType := layers.EthernetTypeARP
+	if v6 {
+		ethType = layers.EthernetTypeIPv6
+		frame, err = neighborSolicitation(srcMAC, src, target)
+	} else {
+		frame, err = arpRequest(srcMAC, src, target)
+	}
+	if err != nil {
+		return nil, src.String(), err
+	}
+
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW, int(htons(uint16(ethType))))
+	if err != nil {
+		return nil, src.String(), err
+	}
+	defer unix.Close(fd)
+	addr := &unix.SockaddrLinklayer{Protocol: htons(uint16(ethType)), Ifindex: iface.Index}
+	if err := unix.Bind(fd, addr); err != nil {
+		return nil, src.String(), err
+	}
+	if spoofed {
+		// The reply goes to the spoofed MAC, which the interface would otherwise
+		// filter out.
+		mreq := unix.PacketMreq{Ifindex: int32(iface.Index), Type: unix.PACKET_MR_PROMISC}
+		if err := unix.SetsockoptPacketMreq(fd, unix.SOL_PACKET, unix.PACKET_ADD_MEMBERSHIP, &mreq); err != nil {
+			return nil, src.String(), err
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"iface":    ifaceName,
+		"protocol": protocol,
+		"srcMAC":   srcMAC,
+		"srcIP":    src,
+		"target":   target,
+	}).Info("Sending neighbor request")
+	dst := &unix.SockaddrLinklayer{Protocol: htons(uint16(ethType)), Ifindex: iface.Index, Halen: 6}
+	copy(dst.Addr[:], frame[0:6])
+	if err := unix.Sendto(fd, frame, 0, dst); err != nil {
+		return nil, src.String(), err
+	}
+
+	deadline := time.Now().Add(timeout)
+	buf := make([]byte, 1500)
+	for {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil, src.String(), errors.New("no neighbor reply before timeout")
+		}
+		tv := unix.NsecToTimeval(remaining.Nanoseconds())
+		if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+			return nil, src.String(), err
+		}
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if err == unix.EAGAIN || err == unix.EINTR {
+			continue
+		} else if err != nil {
+			return nil, src.String(), err
+		}
+		if mac := parseNeighborReply(buf[:n], target); mac != nil {
+			log.WithField("mac", mac).Info("Received neighbor reply")
+			return mac, src.String(), nil
+		}
+	}
+}
+
+func arpRequest(srcMAC net.HardwareAddr, src, target net.IP) ([]byte, error) {
+	if src.To4() == nil || target.To4() == nil {
+		return nil, errors.New("ARP needs IPv4 addresses")
+	}
+	eth := &layers.Ethernet{
+		SrcMAC:       srcMAC,
+		DstMAC:       layers.EthernetBroadcast,
+		EthernetType: layers.EthernetTypeARP,
+	}
+	arp := &layers.ARP{
+		AddrType:          layers.LinkTypeEthernet,
+		Protocol:          layers.EthernetTypeIPv4,
+		HwAddressSize:     6,
+		ProtAddressSize:   4,
+		Operation:         layers.ARPRequest,
+		SourceHwAddress:   srcMAC,
+		SourceProtAddress: src.To4(),
+		DstHwAddress:      make([]byte, 6),
+		DstProtAddress:    target.To4(),
+	}
+	return serialize(eth, arp)
+}
+
+func neighborSolicitation(srcMAC net.HardwareAddr, src, target net.IP) ([]byte, error) {
+	if src.To4() != nil || target.To4() != nil {
+		return nil, errors.New("NDP needs IPv6 addresses")
+	}
+	// Solicitations go to the solicited-node multicast address of the target.
+	dstIP := net.ParseIP("ff02::1:ff00:0")
+	copy(dstIP[13:], target.To16()[13:])
+	dstMAC := net.HardwareAddr{0x33, 0x33, dstIP[12], dstIP[13], dstIP[14], dstIP[15]}
+
+	eth := &layers.Ethernet{
+		SrcMAC:       srcMAC,
+		DstMAC:       dstMAC,
+		EthernetType: layers.EthernetTypeIPv6,
+	}
+	ip6 := &layers.IPv6{
+		Version:    6,
+		NextHeader: layers.IPProtocolICMPv6,
+		HopLimit:   255,
+		SrcIP:      src,
+		DstIP:      dstIP,
+	}
+	icmp := &layers.ICMPv6{
+		TypeCode: layers.CreateICMPv6TypeCode(layers.ICMPv6TypeNeighborSolicitation, 0),
+	}
+	if err := icmp.SetNetworkLayerForChecksum(ip6); err != nil {
+		return nil, err
+	}
+	ns := &layers.ICMPv6NeighborSolicitation{
+		TargetAddress: target,
+		Options: layers.ICMPv6Options{{
+			Type: layers.ICMPv6OptSourceAddress,
+			Data: srcMAC,
+		}},
+	}
+	return serialize(eth, ip6, icmp, ns)
+}
+
+func serialize(ls ...gopacket.SerializableLayer) ([]byte, error) {
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, ls...); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// parseNeighborReply returns the MAC that the frame claims for the target, if it
+// is an ARP reply or a neighbor advertisement for it.
+func parseNeighborReply(frame []byte, target net.IP) net.HardwareAddr {
+	p := gopacket.NewPacket(frame, layers.LayerTypeEthernet, gopacket.Default)
+	if l := p.Layer(layers.LayerTypeARP); l != nil {
+		arp := l.(*layers.ARP)
+		if arp.Operation == layers.ARPReply && net.IP(arp.SourceProtAddress).Equal(target) {
+			return arp.SourceHwAddress
+		}
+		return nil
+	}
+	if l := p.Layer(layers.LayerTypeICMPv6NeighborAdvertisement); l != nil {
+		na := l.(*layers.ICMPv6NeighborAdvertisement)
+		if !na.TargetAddress.Equal(target) {
+			return nil
+		}
+		for _, o := range na.Options {
+			if o.Type == layers.ICMPv6OptTargetAddress && len(o.Data) == 6 {
+				return o.Data
+			}
+		}
+		if eth, ok := p.LinkLayer().(*layers.Ethernet); ok {
+			return eth.SrcMAC
+		}
+	}
+	return nil
+}
+
+func interfaceIP(iface *net.Interface, v6 bool) (net.IP, error) {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range addrs {
+		ipNet, ok := a.(*net.IPNet)
+		if !ok || (ipNet.IP.To4() == nil) != v6 {
+			continue
+		}
+		return ipNet.IP, nil
+	}
+	return nil, fmt.Errorf("interface %s has no address to send from", iface.Name)
+}
+
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
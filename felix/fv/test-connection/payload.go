@@ -0,0 +1,54 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+
+	"github.com/projectcalico/calico/felix/fv/connectivity"
+)
+
+// parsePayload returns the payload for the --payload-pattern and --fuzz-seed
+// arguments, nil if neither is set.
+func parsePayload(patternHex, seedStr string, sendLen int) ([]byte, error) {
+	if patternHex != "" && seedStr != "" {
+		return nil, fmt.Errorf("--payload-pattern and --fuzz-seed are mutually exclusive")
+	}
+	if patternHex != "" {
+		pattern, err := hex.DecodeString(patternHex)
+		if err != nil {
+			return nil, fmt.Errorf("pattern is not hex: %w", err)
+		}
+		return connectivity.PatternPayload(string(pattern), sendLen), nil
+	}
+	if seedStr != "" {
+		seed, err := strconv.ParseInt(seedStr, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return connectivity.FuzzedPayload(seed), nil
+	}
+	return nil, nil
+}
+
+// extraData returns the n bytes to send after the request.
+func (o *options) extraData(n int) []byte {
+	if o.payload != nil && len(o.payload) == n {
+		return o.payload
+	}
+	return make([]byte, n)
+}
@@ -0,0 +1,42 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/projectcalico/calico/felix/fv/connectivity"
+)
+
+// maybeSendProxyHeader sends the PROXY protocol header on a new connection, if
+// we were asked to.
+func (o *options) maybeSendProxyHeader(conn net.Conn) error {
+	if !o.proxyProtocol {
+		return nil
+	}
+	src := o.proxySource
+	if src == "" {
+		src = conn.LocalAddr().String()
+	}
+	header, err := connectivity.EncodeProxyV2(src, conn.RemoteAddr().String())
+	if err != nil {
+		return err
+	}
+	log.WithField("src", src).Debug("Sending PROXY header")
+	_, err = conn.Write(header)
+	return err
+}
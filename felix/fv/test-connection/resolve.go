@@ -0,0 +1,148 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/projectcalico/calico/felix/fv/connectivity"
+)
+
+const resolveTimeout = 5 * time.Second
+
+// maybeResolve resolves the target if it is a hostname rather than an IP, with
+// the given DNS server or the first nameserver of /etc/resolv.conf, and reports
+// the resolution to the checker.
+//
+// It sends the queries itself, from the calling goroutine, because the Go
+// resolver queries from other goroutines, which may not be running in the
+// workload's network namespace.
+func maybeResolve(target, server string) (string, error) {
+	if target == "" || net.ParseIP(target) != nil {
+		return target, nil
+	}
+	if server == "" {
+		var err error
+		server, err = resolvConfNameserver()
+		if err != nil {
+			return "", err
+		}
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+
+	var ip string
+	var err error
+	for _, qtype := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+		ip, err = query(server, target, qtype)
+		if err == nil && ip != "" {
+			break
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	if ip == "" {
+		return "", fmt.Errorf("no address for %s from %s", target, server)
+	}
+	log.WithFields(log.Fields{"hostname": target, "ip": ip, "server": server}).Info("Resolved target")
+	connectivity.Resolution{Hostname: target, IP: ip, Server: server}.PrintToStdout()
+	return ip, nil
+}
+
+func resolvConfNameserver() (string, error) {
+	f, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			return fields[1], nil
+		}
+	}
+	return "", fmt.Errorf("no nameserver in /etc/resolv.conf")
+}
+
+// query sends a single DNS query over UDP and returns the first address of the
+// answer, if any.
+func query(server, hostname string, qtype dnsmessage.Type) (string, error) {
+	name, err := dnsmessage.NewName(strings.TrimSuffix(hostname, ".") + ".")
+	if err != nil {
+		return "", err
+	}
+	id := uint16(rand.Intn(1 << 16))
+	msg := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{
+			Name:  name,
+			Type:  qtype,
+			Class: dnsmessage.ClassINET,
+		}},
+	}
+	packed, err := msg.Pack()
+	if err != nil {
+		return "", err
+	}
+
+	conn, err := net.DialTimeout("udp", server, resolveTimeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(resolveTimeout)); err != nil {
+		return "", err
+	}
+	if _, err := conn.Write(packed); err != nil {
+		return "", err
+	}
+	buf := make([]byte, 1500)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return "", err
+	}
+
+	var reply dnsmessage.Message
+	if err := reply.Unpack(buf[:n]); err != nil {
+		return "", err
+	}
+	if reply.Header.ID != id {
+		return "", fmt.Errorf("DNS reply with unexpected ID from %s", server)
+	}
+	if reply.Header.RCode != dnsmessage.RCodeSuccess {
+		return "", fmt.Errorf("DNS query for %s to %s failed: %v", hostname, server, reply.Header.RCode)
+	}
+	for _, a := range reply.Answers {
+		switch body := a.Body.(type) {
+		case *dnsmessage.AResource:
+			return net.IP(body.A[:]).String(), nil
+		case *dnsmessage.AAAAResource:
+			return net.IP(body.AAAA[:]).String(), nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,215 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/ishidawataru/sctp"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+
+	"github.com/projectcalico/calico/felix/fv/connectivity"
+)
+
+const (
+	// The socket options and constants of linux/sctp.h that the sctp package
+	// lacks.
+	sctpRTOInfo         = 0
+	sctpPeerAddrParams  = 9
+	sctpGetPeerAddrInfo = 15
+	sppHBEnable         = 1
+	sctpInactive        = 0
+	sctpPF              = 1
+
+	// sizeofSockaddrStorage is the size of struct sockaddr_storage.
+	sizeofSockaddrStorage = 128
+	// sizeofPaddrParams is the size of struct sctp_paddrparams up to, but not
+	// including, spp_ipv6_flowlabel, which the kernel accepts.
+	sizeofPaddrParams = 152
+	// sizeofPaddrInfo is the size of struct sctp_paddrinfo.
+	sizeofPaddrInfo = 152
+
+	// A multi-homed association fails over quickly, so that a test needn't wait
+	// for the default retransmission timeouts of several seconds: it gives up
+	// on a path after sctpPathMaxRetrans retransmissions with a timeout of at
+	// most sctpRTOMax, and checks the other paths every sctpHBInterval.
+	sctpRTOMin         = 100 * time.Millisecond
+	sctpRTOMax         = 200 * time.Millisecond
+	sctpPathMaxRetrans = 1
+	sctpHBInterval     = 200 * time.Millisecond
+
+	// sctpFailoverStallTimeout replaces longLivedStallTimeout for a multi-homed
+	// association, which stalls while it fails over.
+	sctpFailoverStallTimeout = 3 * time.Second
+)
+
+func (o *options) sctpMultihomed() bool {
+	return len(o.sctpLocalAddrs) > 0 || len(o.sctpRemoteAddrs) > 0
+}
+
+func parseIPAddrs(s string) ([]net.IPAddr, error) {
+	var addrs []net.IPAddr
+	for _, ipStr := range strings.Split(s, ",") {
+		ip := net.ParseIP(strings.TrimSpace(ipStr))
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP %q", ipStr)
+		}
+		addrs = append(addrs, net.IPAddr{IP: ip})
+	}
+	return addrs, nil
+}
+
+// setSCTPFailoverOpts makes an SCTP socket fail over to another path quickly.
+func setSCTPFailoverOpts(fd int) error {
+	ms := func(d time.Duration) uint32 { return uint32(d.Milliseconds()) }
+
+	// struct sctp_rtoinfo, for all future associations.
+	rto := make([]byte, 16)
+	binary.LittleEndian.PutUint32(rto[4:], ms(sctpRTOMin))
+	binary.LittleEndian.PutUint32(rto[8:], ms(sctpRTOMax))
+	binary.LittleEndian.PutUint32(rto[12:], ms(sctpRTOMin))
+	if err := unix.SetsockoptString(fd, unix.IPPROTO_SCTP, sctpRTOInfo, string(rto)); err != nil {
+		return fmt.Errorf("failed to set SCTP_RTOINFO: %w", err)
+	}
+
+	// struct sctp_paddrparams, for all the paths of future associations.
+	params := make([]byte, sizeofPaddrParams)
+	off := 4 + sizeofSockaddrStorage
+	binary.LittleEndian.PutUint32(params[off:], ms(sctpHBInterval))
+	binary.LittleEndian.PutUint16(params[off+4:], sctpPathMaxRetrans)
+	binary.LittleEndian.PutUint32(params[off+14:], sppHBEnable)
+	if err := unix.SetsockoptString(fd, unix.IPPROTO_SCTP, sctpPeerAddrParams, string(params)); err != nil {
+		return fmt.Errorf("failed to set SCTP_PEER_ADDR_PARAMS: %w", err)
+	}
+	return nil
+}
+
+// sctpPathState returns the spinfo_state of the path to a peer address.
+func sctpPathState(fd int, addr net.IP, port int) (int32, error) {
+	info := make([]byte, sizeofPaddrInfo)
+	sa := info[4 : 4+sizeofSockaddrStorage]
+	if ip4 := addr.To4(); ip4 != nil {
+		binary.LittleEndian.PutUint16(sa, unix.AF_INET)
+		binary.BigEndian.PutUint16(sa[2:], uint16(port))
+		copy(sa[4:], ip4)
+	} else {
+		binary.LittleEndian.PutUint16(sa, unix.AF_INET6)
+		binary.BigEndian.PutUint16(sa[2:], uint16(port))
+		copy(sa[8:], addr.To16())
+	}
+	optlen := uint32(len(info))
+	_, _, errno := unix.Syscall6(unix.SYS_GETSOCKOPT, uintptr(fd), unix.IPPROTO_SCTP, sctpGetPeerAddrInfo,
+		uintptr(unsafe.Pointer(&info[0])), uintptr(unsafe.Pointer(&optlen)), 0)
+	if errno != 0 {
+		return 0, errno
+	}
+	return int32(binary.LittleEndian.Uint32(info[4+sizeofSockaddrStorage:])), nil
+}
+
+// observePaths records the peer addresses whose paths are inactive, or
+// potentially failed, so that a long-lived test can tell that the association
+// failed over.
+func (d *connectedSCTP) observePaths() {
+	conn, ok := d.conn.(*sctp.SCTPConn)
+	if !ok || !d.opts.sctpMultihomed() {
+		return
+	}
+	peers, err := conn.SCTPRemoteAddr(0)
+	if err != nil {
+		log.WithError(err).Warn("Failed to get SCTP peer addresses")
+		return
+	}
+	for _, a := range peers.IPAddrs {
+		state, err := sctpPathState(d.fd, a.IP, peers.Port)
+		if err != nil {
+			log.WithError(err).WithField("addr", a.IP).Warn("Failed to get SCTP path state")
+			continue
+		}
+		if state != sctpInactive && state != sctpPF {
+			continue
+		}
+		if d.failedPaths == nil {
+			d.failedPaths = map[string]bool{}
+		}
+		if !d.failedPaths[a.IP.String()] {
+			log.WithField("addr", a.IP).Info("SCTP path failed")
+			d.failedPaths[a.IP.String()] = true
+		}
+	}
+}
+
+// association returns the paths of a multi-homed association, nil if it isn't
+// multi-homed.
+func (d *connectedSCTP) association() *connectivity.SCTPAssociation {
+	conn, ok := d.conn.(*sctp.SCTPConn)
+	if !ok || !d.opts.sctpMultihomed() {
+		return nil
+	}
+	var a connectivity.SCTPAssociation
+	if local, err := conn.SCTPLocalAddr(0); err == nil {
+		for _, ip := range local.IPAddrs {
+			a.LocalAddrs = append(a.LocalAddrs, ip.IP.String())
+		}
+	}
+	if peers, err := conn.SCTPRemoteAddr(0); err == nil {
+		for _, ip := range peers.IPAddrs {
+			a.PeerAddrs = append(a.PeerAddrs, ip.IP.String())
+		}
+	}
+	if primary, err := conn.SCTPGetPrimaryPeerAddr(); err == nil && len(primary.IPAddrs) > 0 {
+		a.Primary = primary.IPAddrs[0].IP.String()
+	}
+	for p := range d.failedPaths {
+		a.FailedPaths = append(a.FailedPaths, p)
+	}
+	return &a
+}
+
+// sctpControl wraps a socket Control function to also remember the SCTP socket
+// and set the options for a multi-homed association.
+func (d *connectedSCTP) sctpControl(control func(string, string, syscall.RawConn) error) func(string, string, syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		if err := control(network, address, c); err != nil {
+			return err
+		}
+		var err error
+		cerr := c.Control(func(fd uintptr) {
+			d.fd = int(fd)
+			if d.opts.sctpMultihomed() {
+				err = setSCTPFailoverOpts(d.fd)
+			}
+		})
+		if cerr != nil {
+			return cerr
+		}
+		return err
+	}
+}
+
+// sctpAssociationOf returns the paths of the connection, if it is a multi-homed
+// SCTP association.
+func sctpAssociationOf(d protocolDriver) *connectivity.SCTPAssociation {
+	if s, ok := d.(*connectedSCTP); ok {
+		return s.association()
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"syscall"
+
+	reuse "github.com/libp2p/go-reuseport"
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// setSocketOpts applies the socket options that we were asked for to a new
+// socket, before it is bound or connected.
+func (o *options) setSocketOpts(fd int) error {
+	if o.sourceInterface != "" {
+		if err := unix.BindToDevice(fd, o.sourceInterface); err != nil {
+			return fmt.Errorf("failed to bind to interface %s: %w", o.sourceInterface, err)
+		}
+		log.WithField("iface", o.sourceInterface).Debug("Bound socket to interface")
+	} else if o.vrfDevice != "" {
+		// Binding to a VRF device scopes the socket to the VRF's routing table.
+		if err := unix.BindToDevice(fd, o.vrfDevice); err != nil {
+			return fmt.Errorf("failed to bind to VRF %s: %w", o.vrfDevice, err)
+		}
+		log.WithField("vrf", o.vrfDevice).Debug("Bound socket to VRF")
+	}
+	if o.socketMark != 0 {
+		if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_MARK, int(o.socketMark)); err != nil {
+			return fmt.Errorf("failed to set mark %#x: %w", o.socketMark, err)
+		}
+		log.WithField("mark", o.socketMark).Debug("Set socket mark")
+	}
+	return nil
+}
+
+// checkVRF checks that the device exists in the current namespace and is a VRF,
+// rather than binding to a device that silently gives different routing.
+func checkVRF(name string) error {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return fmt.Errorf("failed to find VRF %s: %w", name, err)
+	}
+	if link.Type() != "vrf" {
+		return fmt.Errorf("device %s is a %s, not a VRF", name, link.Type())
+	}
+	return nil
+}
+
+func (o *options) socketOptsControl(network, address string, c syscall.RawConn) error {
+	var err error
+	cerr := c.Control(func(fd uintptr) {
+		err = o.setSocketOpts(int(fd))
+		if err == nil {
+			err = o.setFastOpen(network, int(fd))
+		}
+		if err == nil {
+			err = o.setIPv6ExtOpts(int(fd))
+		}
+	})
+	if cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+// reuseControl sets SO_REUSEADDR and SO_REUSEPORT, like reuse.Control, and
+// then our own socket options.
+func (o *options) reuseControl(network, address string, c syscall.RawConn) error {
+	if err := reuse.Control(network, address, c); err != nil {
+		return err
+	}
+	return o.socketOptsControl(network, address, c)
+}
+
+// dial is reuse.Dial() with our own socket options.
+func (o *options) dial(network, laddr, raddr string) (net.Conn, error) {
+	nla, err := reuse.ResolveAddr(network, laddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve local addr: %w", err)
+	}
+	d := net.Dialer{
+		Control:   o.reuseControl,
+		LocalAddr: nla,
+	}
+	return d.Dial(network, raddr)
+}
+
+// listenPacket is net.ListenPacket() with our own socket options.
+func (o *options) listenPacket(network, address string) (net.PacketConn, error) {
+	lc := net.ListenConfig{Control: o.socketOptsControl}
+	return lc.ListenPacket(context.Background(), network, address)
+}
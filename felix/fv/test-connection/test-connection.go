@@ -19,15 +19,17 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"math"
 	"net"
+	"net/url"
 	"os"
 	"os/exec"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -35,24 +37,29 @@ import (
 	"github.com/docopt/docopt-go"
 	"github.com/google/uuid"
 	"github.com/ishidawataru/sctp"
-	reuse "github.com/libp2p/go-reuseport"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/sys/unix"
 
 	"github.com/projectcalico/calico/felix/fv/cgroup"
 	"github.com/projectcalico/calico/felix/fv/connectivity"
 	"github.com/projectcalico/calico/felix/fv/utils"
+	"github.com/projectcalico/calico/libcalico-go/lib/set"
 )
 
 const usage = `test-connection: test connection to some target, for Felix FV testing.
 
 Usage:
-  test-connection <namespace-path> <ip-address> <port> [--source-ip=<source_ip>] [--source-port=<source>] [--protocol=<protocol>] [--duration=<seconds>] [--loop-with-file=<file>] [--sendlen=<bytes>] [--recvlen=<bytes>] [--log-pongs] [--stdin] [--timeout=<seconds>]
+  test-connection --capabilities
+  test-connection --self-test <namespace-path>
+  test-connection <namespace-path> <ip-address> <port> [--source-ip=<source_ip>] [--source-port=<source>] [--protocol=<protocol>] [--duration=<seconds>] [--loop-with-file=<file>] [--sendlen=<bytes>] [--recvlen=<bytes>] [--log-pongs] [--stdin] [--timeout=<seconds>] [--flows=<n>] [--conn-rate=<cps>] [--long-lived] [--continuous] [--packet-rate=<pps>] [--packet-size=<bytes>] [--idle=<seconds>] [--resolver=<server>] [--source-interface=<iface>] [--mark=<mark>] [--vrf=<vrf>] [--source-mac=<mac>] [--trace-path] [--proxy-protocol=<src>] [--via-proxy=<url>] [--observe-icmp] [--tcp-fastopen] [--ipv6-ext=<headers>] [--payload-pattern=<hex>] [--fuzz-seed=<seed>] [--ftp=<mode>] [--sctp-local-addrs=<ips>] [--sctp-remote-addrs=<ips>] [--large-send=<bytes>] [--one-way-latency]
 
 Options:
+  --capabilities           Print the protocol version and the features that are supported, then exit.
+  --self-test              Check that we can run in the namespace and reach its loopback, then exit.
   --source-ip=<source_ip>  Source IP to use for the connection [default: 0.0.0.0].
   --source-port=<source>   Source port to use for the connection [default: 0].
-  --protocol=<protocol>    Protocol to test tcp (default), udp (connected) udp-noconn (unconnected).
+  --protocol=<protocol>    Protocol to test tcp (default), udp (connected) udp-noconn (unconnected),
+                           arp or ndp (neighbor request from --source-interface or eth0).
   --duration=<seconds>     Total seconds test should run. 0 means run a one off connectivity check. Non-Zero means packets loss test.[default: 0]
   --loop-with-file=<file>  Whether to send messages repeatedly, file is used for synchronization
   --log-pongs              Whether to log every response
@@ -61,10 +68,49 @@ Options:
   --recvlen=<bytes>        Tell the other side to send this many additional bytes
   --stdin                  Read and send data from stdin
   --timeout=<seconds>      Exit after timeout if pong not received
+  --flows=<n>              Number of simultaneous flows to open for a one off check [default: 1].
+  --conn-rate=<cps>        Open new connections at this rate for the duration of the test [default: 0].
+  --long-lived             Keep exchanging messages over one connection for the duration of the test.
+  --continuous             Probe with a new connection every 100ms until stdin is closed.
+  --packet-rate=<pps>      Packets per second to send in a packet loss test [default: 200].
+  --packet-size=<bytes>    Pad packet loss test packets to this size, 0 means no padding [default: 0].
+  --idle=<seconds>         Stay silent for this long after the first exchange of a one off check, then check again [default: 0].
+  --one-way-latency        Calibrate our clock against the server's before a packet loss test or long-lived
+                           connection and report the one-way latency of the test.
+  --resolver=<server>      DNS server to resolve a hostname target with, default: the first nameserver in /etc/resolv.conf.
+  --source-interface=<iface>  Bind the sockets of the test to this interface.
+  --mark=<mark>            Set this fwmark (SO_MARK), decimal or 0x hex, on the sockets of the test.
+  --vrf=<vrf>              Bind the sockets of the test to this VRF device.
+  --source-mac=<mac>       Source MAC to claim in arp and ndp requests, default: the interface's.
+  --trace-path             Trace the path to the target with increasing TTLs before the check.
+  --proxy-protocol=<src>   Start TCP connections with a PROXY protocol v2 header that claims they came from
+                           <src>, ip:port, or "-" for the connection's own address.
+  --via-proxy=<url>        Tunnel TCP connections through this proxy, http://host:port for a proxy that
+                           supports CONNECT, or socks5://host:port.
+  --observe-icmp           Report the ICMP destination unreachable messages that the probes trigger.
+  --tcp-fastopen           Send the first request of TCP connections in the SYN, with TCP Fast Open.
+  --ipv6-ext=<headers>     Add these IPv6 extension headers, comma-separated, to the packets of the test:
+                           hop-by-hop, dst-opts, routing or fragment (udp only).
+  --payload-pattern=<hex>  Send this hex-encoded pattern, repeated to --sendlen bytes or once without it,
+                           as the extra data after the request, instead of zeros.
+  --fuzz-seed=<seed>       Send a random payload, generated from the seed, as the extra data after the request.
+  --ftp=<mode>             Log in to the target with FTP and fetch the response over a data connection, set up
+                           in passive or active mode.
+  --sctp-local-addrs=<ips> Bind the SCTP association to these local IPs, comma-separated.
+  --sctp-remote-addrs=<ips>
+                           Add these IPs of the target, comma-separated, to the SCTP association.
+  --large-send=<bytes>     Send this much extra data after the request in a single write, like --sendlen, and
+                           ask the server to report how it arrived.
+
+If <ip-address> is a hostname, it is resolved in the namespace before connecting.
+
+<namespace-path> is "-" to connect from the current network namespace.
 
 If connection is successful, test-connection exits successfully.
 
-If connection is unsuccessful, test-connection panics and so exits with a failure status.`
+If connection is unsuccessful, test-connection panics and so exits with a failure status.
+
+If the arguments are invalid, test-connection exits with status 3.`
 
 // Note about the --loop-with-file=<FILE> flag:
 //
@@ -83,29 +129,136 @@ If connection is unsuccessful, test-connection panics and so exits with a failur
 const defaultIPv4SourceIP = "0.0.0.0"
 const defaultIPv6SourceIP = "::"
 
+// options are the options of the test, from the command line.  main() sets them
+// before the test starts, the test only reads them.
+type options struct {
+	remoteIPAddr string
+	remotePort   string
+	sourceIPAddr string
+	sourcePort   string
+	protocol     string
+
+	seconds    int
+	loopFile   string
+	sendLen    int
+	recvLen    int
+	logPongs   bool
+	stdin      bool
+	timeout    time.Duration
+	flows      int
+	connRate   int
+	longLived  bool
+	continuous bool
+	packetRate int
+	packetSize int
+	idlePeriod time.Duration
+
+	// oneWayLatency is set by --one-way-latency.
+	oneWayLatency bool
+
+	// sourceInterface is the interface, from --source-interface, that all the
+	// sockets of the test are bound to.  Empty means that the routing table
+	// picks the interface.
+	sourceInterface string
+	// vrfDevice is the VRF, from --vrf, that all the sockets of the test are
+	// bound to, so that they use its routing table.
+	vrfDevice string
+	// socketMark is the fwmark, from --mark, of all the sockets of the test.
+	// Zero means no mark.
+	socketMark uint32
+	// sourceMAC is the MAC, from --source-mac, that ARP and NDP probes claim to
+	// come from.  Empty means the MAC of the interface.
+	sourceMAC string
+
+	// proxyProtocol is set by --proxy-protocol to send a PROXY protocol v2
+	// header at the start of each TCP connection, claiming that it came from
+	// proxySource, or from the connection's own address if that is empty.
+	proxyProtocol bool
+	proxySource   string
+	// viaProxy is the proxy, from --via-proxy, that TCP connections are
+	// tunneled through, nil to connect directly.
+	viaProxy *url.URL
+
+	// observeICMP is set by --observe-icmp to report the ICMP destination
+	// unreachable and time exceeded messages that our probes trigger.
+	observeICMP bool
+	// tcpFastOpen is set by --tcp-fastopen to send the first request of TCP
+	// connections in the SYN, with TCP Fast Open.
+	tcpFastOpen bool
+	// ipv6ExtHeaders are the IPv6 extension headers, from --ipv6-ext, to add to
+	// the packets of the test.
+	ipv6ExtHeaders []connectivity.IPv6ExtHeader
+	// ipv6ExtTarget is the target of the test, which the routing header routes
+	// through.
+	ipv6ExtTarget net.IP
+
+	// payload is set by --payload-pattern or --fuzz-seed to the extra data to
+	// send after the request, instead of --sendlen zeros.
+	payload []byte
+	// largeSend is set by --large-send to ask the server to report how it
+	// received the extra data, which is sent in a single write.
+	largeSend bool
+
+	// ftpMode is set by --ftp to fetch the response over the data connection of
+	// an FTP-style session, rather than over the connection to the target.
+	ftpMode connectivity.FTPMode
+
+	// sctpLocalAddrs and sctpRemoteAddrs are set by --sctp-local-addrs and
+	// --sctp-remote-addrs to make the SCTP association multi-homed.
+	sctpLocalAddrs, sctpRemoteAddrs []net.IPAddr
+}
+
 func main() {
 	log.SetLevel(log.InfoLevel)
 
 	// If we've been told to, move into this felix's cgroup.
 	cgroup.MaybeMoveToFelixCgroupv2()
 
-	arguments, err := docopt.ParseArgs(usage, nil, "v0.1")
+	// Until the arguments are checked, a fatal error means that they are invalid.
+	// Exit with a status of its own for that, rather than the status 1 that
+	// means no connectivity.
+	log.StandardLogger().ExitFunc = exitInvalidArgs
+	parser := &docopt.Parser{HelpHandler: func(err error, usage string) {
+		if err != nil {
+			fmt.Fprintln(os.Stderr, usage)
+			exitInvalidArgs(1)
+		}
+		fmt.Println(usage)
+		os.Exit(0)
+	}}
+	arguments, err := parser.ParseArgs(usage, nil, "v0.1")
 	if err != nil {
 		println(usage)
 		log.WithError(err).Fatal("Failed to parse usage")
 	}
 	log.WithField("args", arguments).Info("Parsed arguments")
+	if arguments["--capabilities"].(bool) {
+		connectivity.Capabilities{
+			Version:  connectivity.ProtocolVersion,
+			Features: connectivity.Features,
+		}.PrintToStdout()
+		return
+	}
+	if arguments["--self-test"].(bool) {
+		selfTest(arguments["<namespace-path>"].(string))
+		return
+	}
 	namespacePath := arguments["<namespace-path>"].(string)
 	ipAddress := arguments["<ip-address>"].(string)
 	protocol := arguments["--protocol"].(string)
 	port := ""
 	sourcePort := ""
-	// No such thing as a port for raw IP.
-	if !strings.HasPrefix(protocol, "ip") {
+	// No such thing as a port for raw IP or neighbor requests.
+	if !strings.HasPrefix(protocol, "ip") && !isNeighborProtocol(protocol) {
 		port = arguments["<port>"].(string)
 		sourcePort = arguments["--source-port"].(string)
 	}
 	sourceIpAddress := arguments["--source-ip"].(string)
+	o := options{
+		protocol:   protocol,
+		remotePort: port,
+		sourcePort: sourcePort,
+	}
 	if debug, err := arguments.Bool("--debug"); err == nil && debug {
 		log.SetLevel(log.DebugLevel)
 		log.Debug("Debug logging enabled")
@@ -114,76 +267,300 @@ func main() {
 	sendLenStr, _ := arguments["--sendlen"].(string)
 	recvLenStr, _ := arguments["--recvlen"].(string)
 
-	sendLen := 0
 	if sendLenStr != "" {
-		sendLen, _ = strconv.Atoi(sendLenStr)
+		o.sendLen, _ = strconv.Atoi(sendLenStr)
 	}
-	recvLen := 0
 	if recvLenStr != "" {
-		recvLen, _ = strconv.Atoi(recvLenStr)
-	}
-
-	// Set default for source IP. If we're using IPv6 as indicated by ipAddress
-	// and no --source-ip option was provided, set the source IP to the default
-	// IPv6 address.
-	if strings.Contains(ipAddress, ":") && sourceIpAddress == defaultIPv4SourceIP {
-		sourceIpAddress = defaultIPv6SourceIP
+		o.recvLen, _ = strconv.Atoi(recvLenStr)
 	}
 
 	duration := arguments["--duration"].(string)
-	seconds, err := strconv.Atoi(duration)
+	o.seconds, err = strconv.Atoi(duration)
 	if err != nil {
 		// panic on error
 		log.WithField("duration", duration).Fatal("Invalid duration argument")
 	}
-	loopFile := ""
 	if arg, ok := arguments["--loop-with-file"]; ok && arg != nil {
-		loopFile = arg.(string)
+		o.loopFile = arg.(string)
 	}
 
-	logPongs, err := arguments.Bool("--log-pongs")
+	o.logPongs, err = arguments.Bool("--log-pongs")
 	if err != nil {
 		log.WithError(err).Fatal("Invalid --log-pongs")
 	}
 
-	stdin, err := arguments.Bool("--stdin")
+	o.stdin, err = arguments.Bool("--stdin")
 	if err != nil {
 		log.WithError(err).Fatal("Invalid --stdin")
 	}
 
-	var timeout time.Duration
-
 	if toval := arguments["--timeout"]; toval != nil {
 		timeoutSecs, err := strconv.ParseFloat(toval.(string), 64)
 		if err != nil {
 			// panic on error
-			log.WithField("timeout", timeout).Fatal("Invalid --timeout argument")
+			log.WithField("timeout", o.timeout).Fatal("Invalid --timeout argument")
+		}
+		o.timeout = time.Duration(timeoutSecs * float64(time.Second))
+	}
+
+	o.flows, err = strconv.Atoi(arguments["--flows"].(string))
+	if err != nil || o.flows < 1 {
+		log.WithField("flows", arguments["--flows"]).Fatal("Invalid --flows argument")
+	}
+	if o.flows > 1 && (o.seconds != 0 || o.loopFile != "" || o.stdin) {
+		log.Fatal("--flows is only supported for one off connectivity checks")
+	}
+	o.connRate, err = strconv.Atoi(arguments["--conn-rate"].(string))
+	if err != nil || o.connRate < 0 {
+		log.WithField("conn-rate", arguments["--conn-rate"]).Fatal("Invalid --conn-rate argument")
+	}
+	if o.connRate > 0 && (o.seconds == 0 || o.loopFile != "" || o.stdin || o.flows > 1) {
+		log.Fatal("--conn-rate requires --duration and is not supported with other modes")
+	}
+	o.longLived, err = arguments.Bool("--long-lived")
+	if err != nil {
+		log.WithError(err).Fatal("Invalid --long-lived")
+	}
+	if o.longLived && (o.seconds == 0 || o.loopFile != "" || o.stdin || o.flows > 1 || o.connRate > 0) {
+		log.Fatal("--long-lived requires --duration and is not supported with other modes")
+	}
+
+	o.oneWayLatency, err = arguments.Bool("--one-way-latency")
+	if err != nil {
+		log.WithError(err).Fatal("Invalid --one-way-latency")
+	}
+	if o.oneWayLatency && (o.seconds == 0 || o.loopFile != "" || o.stdin || o.flows > 1 || o.connRate > 0) {
+		log.Fatal("--one-way-latency requires --duration and is not supported with other modes")
+	}
+
+	o.continuous, err = arguments.Bool("--continuous")
+	if err != nil {
+		log.WithError(err).Fatal("Invalid --continuous")
+	}
+	if o.continuous && (o.seconds != 0 || o.loopFile != "" || o.stdin || o.flows > 1 || o.connRate > 0 || o.longLived) {
+		log.Fatal("--continuous is not supported with other modes")
+	}
+
+	o.packetRate, err = strconv.Atoi(arguments["--packet-rate"].(string))
+	if err != nil || o.packetRate < 1 {
+		log.WithField("packet-rate", arguments["--packet-rate"]).Fatal("Invalid --packet-rate argument")
+	}
+	o.packetSize, err = strconv.Atoi(arguments["--packet-size"].(string))
+	if err != nil || o.packetSize < 0 || o.packetSize > connectivity.MaxPacketSize {
+		log.WithField("packet-size", arguments["--packet-size"]).Fatal("Invalid --packet-size argument")
+	}
+
+	idleSecs, err := strconv.ParseFloat(arguments["--idle"].(string), 64)
+	if err != nil || idleSecs < 0 {
+		log.WithField("idle", arguments["--idle"]).Fatal("Invalid --idle argument")
+	}
+	o.idlePeriod = time.Duration(idleSecs * float64(time.Second))
+
+	resolver, _ := arguments["--resolver"].(string)
+	o.sourceInterface, _ = arguments["--source-interface"].(string)
+	o.vrfDevice, _ = arguments["--vrf"].(string)
+	o.sourceMAC, _ = arguments["--source-mac"].(string)
+	tracePathFirst, err := arguments.Bool("--trace-path")
+	if err != nil {
+		log.WithError(err).Fatal("Invalid --trace-path")
+	}
+	if o.sourceInterface != "" && o.vrfDevice != "" {
+		// A socket can only be bound to one device, the interface implies its VRF.
+		log.Fatal("--source-interface and --vrf are mutually exclusive")
+	}
+	if markStr, ok := arguments["--mark"].(string); ok {
+		mark, err := strconv.ParseUint(markStr, 0, 32)
+		if err != nil {
+			log.WithField("mark", markStr).Fatal("Invalid --mark argument")
+		}
+		o.socketMark = uint32(mark)
+	}
+	if proxyStr, ok := arguments["--proxy-protocol"].(string); ok {
+		if protocol != "tcp" {
+			log.Fatal("--proxy-protocol is only supported for tcp")
+		}
+		o.proxyProtocol = true
+		if proxyStr != "-" {
+			o.proxySource = proxyStr
+		}
+	}
+	o.observeICMP, err = arguments.Bool("--observe-icmp")
+	if err != nil {
+		log.WithError(err).Fatal("Invalid --observe-icmp")
+	}
+	o.tcpFastOpen, err = arguments.Bool("--tcp-fastopen")
+	if err != nil {
+		log.WithError(err).Fatal("Invalid --tcp-fastopen")
+	}
+	if o.tcpFastOpen && protocol != "tcp" {
+		log.Fatal("--tcp-fastopen is only supported for tcp")
+	}
+	if extStr, ok := arguments["--ipv6-ext"].(string); ok {
+		o.ipv6ExtHeaders, err = parseIPv6ExtHeaders(extStr)
+		if err != nil {
+			log.WithError(err).Fatal("Invalid --ipv6-ext argument")
+		}
+		if o.wantIPv6ExtHeader(connectivity.IPv6Fragment) && !strings.HasPrefix(protocol, "udp") {
+			log.Fatal("--ipv6-ext=fragment is only supported for udp")
+		}
+	}
+	patternHex, _ := arguments["--payload-pattern"].(string)
+	fuzzSeed, _ := arguments["--fuzz-seed"].(string)
+	o.payload, err = parsePayload(patternHex, fuzzSeed, o.sendLen)
+	if err != nil {
+		log.WithError(err).Fatal("Invalid payload arguments")
+	}
+	if o.payload != nil {
+		o.sendLen = len(o.payload)
+	}
+	if modeStr, ok := arguments["--ftp"].(string); ok {
+		if protocol != "tcp" {
+			log.Fatal("--ftp is only supported for tcp")
+		}
+		o.ftpMode, err = parseFTPMode(modeStr)
+		if err != nil {
+			log.WithError(err).Fatal("Invalid --ftp argument")
+		}
+	}
+	if ipsStr, ok := arguments["--sctp-local-addrs"].(string); ok {
+		if protocol != "sctp" {
+			log.Fatal("--sctp-local-addrs is only supported for sctp")
+		}
+		o.sctpLocalAddrs, err = parseIPAddrs(ipsStr)
+		if err != nil {
+			log.WithError(err).Fatal("Invalid --sctp-local-addrs argument")
+		}
+	}
+	if ipsStr, ok := arguments["--sctp-remote-addrs"].(string); ok {
+		if protocol != "sctp" {
+			log.Fatal("--sctp-remote-addrs is only supported for sctp")
+		}
+		o.sctpRemoteAddrs, err = parseIPAddrs(ipsStr)
+		if err != nil {
+			log.WithError(err).Fatal("Invalid --sctp-remote-addrs argument")
+		}
+	}
+	if largeSendStr, ok := arguments["--large-send"].(string); ok {
+		if protocol != "tcp" {
+			log.Fatal("--large-send is only supported for tcp")
+		}
+		if o.payload != nil {
+			log.Fatal("--large-send can't be combined with a payload")
+		}
+		o.sendLen, err = strconv.Atoi(largeSendStr)
+		if err != nil || o.sendLen <= 0 {
+			log.WithError(err).Fatal("Invalid --large-send argument")
+		}
+		o.largeSend = true
+	}
+	if proxyStr, ok := arguments["--via-proxy"].(string); ok {
+		if protocol != "tcp" {
+			log.Fatal("--via-proxy is only supported for tcp")
+		}
+		o.viaProxy, err = parseViaProxy(proxyStr)
+		if err != nil {
+			log.WithError(err).Fatal("Invalid --via-proxy argument")
 		}
-		timeout = time.Duration(timeoutSecs * float64(time.Second))
+	}
+	if o.idlePeriod > 0 && (o.seconds != 0 || o.loopFile != "" || o.stdin || o.flows > 1 || o.continuous) {
+		log.Fatal("--idle is only supported for one off connectivity checks")
 	}
 
+	if (o.flows > 1 || o.connRate > 0 || o.continuous) && sourcePort != "" && sourcePort != "0" {
+		log.Fatal("--flows, --conn-rate and --continuous require an ephemeral source port")
+	}
+	log.StandardLogger().ExitFunc = os.Exit
+
 	log.Infof("Test connection from namespace %v IP %v port %v to IP %v port %v proto %v "+
-		"max duration %d seconds, timeout %v logging pongs (%v), stdin %v",
-		namespacePath, sourceIpAddress, sourcePort, ipAddress, port, protocol, seconds, timeout, logPongs, stdin)
+		"max duration %d seconds, timeout %v logging pongs (%v), stdin %v, flows %d, conn rate %d, long-lived %v, "+
+		"continuous %v, packet rate %d, packet size %d, idle %v",
+		namespacePath, sourceIpAddress, sourcePort, ipAddress, port, protocol, o.seconds, o.timeout, o.logPongs, o.stdin,
+		o.flows, o.connRate, o.longLived, o.continuous, o.packetRate, o.packetSize, o.idlePeriod)
 
-	if loopFile == "" {
+	if o.loopFile == "" && !o.continuous {
 		// I found that configuring the timeouts on all the network calls was a bit fiddly.  Since
 		// it leaves the process hung if one of them is missed, use a global timeout instead.
 		go func() {
-			timeout := time.Duration(seconds + 2)
-			time.Sleep(timeout * time.Second)
+			globalTimeout := time.Duration(o.seconds+2) * time.Second
+			if o.connRate > 0 {
+				// Allow the connections opened at the end of the test to complete.
+				globalTimeout += o.timeout
+			}
+			if o.idlePeriod > 0 {
+				// Allow for the idle period and the check after it.
+				globalTimeout += o.idlePeriod + o.timeout
+			}
+			if net.ParseIP(ipAddress) == nil {
+				// Allow for resolving the hostname.
+				globalTimeout += 2 * resolveTimeout
+			}
+			if tracePathFirst {
+				globalTimeout += connectivity.PathTraceMaxHops * connectivity.PathTraceHopTimeout
+			}
+			if o.oneWayLatency {
+				globalTimeout += calibrationTimeout
+			}
+			time.Sleep(globalTimeout)
 			log.Fatal("Timed out")
 		}()
 	}
 
+	connect := func() error {
+		// Resolve the target, if it's a hostname, from the namespace.
+		targetIP, err := maybeResolve(ipAddress, resolver)
+		if err != nil {
+			return err
+		}
+
+		// Set default for source IP. If we're using IPv6 as indicated by targetIP
+		// and no --source-ip option was provided, set the source IP to the default
+		// IPv6 address.
+		sourceIP := sourceIpAddress
+		if strings.Contains(targetIP, ":") && sourceIP == defaultIPv4SourceIP {
+			sourceIP = defaultIPv6SourceIP
+		}
+
+		if isNeighborProtocol(protocol) {
+			// Neighbor requests are built by hand, the source IP needn't be ours.
+			return o.tryNeighbor(targetIP, sourceIP, protocol, o.timeout)
+		}
+
+		if o.vrfDevice != "" {
+			err = checkVRF(o.vrfDevice)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Add an interface for the source IP if any.
+		err = o.maybeAddAddr(sourceIP)
+		if err != nil {
+			return err
+		}
+
+		if tracePathFirst {
+			o.tracePath(targetIP, sourceIP).PrintToStdout()
+		}
+		if len(o.ipv6ExtHeaders) > 0 {
+			o.ipv6ExtTarget = net.ParseIP(targetIP)
+			if o.ipv6ExtTarget.To4() != nil {
+				return fmt.Errorf("--ipv6-ext needs an IPv6 target, got %s", targetIP)
+			}
+		}
+		if o.observeICMP {
+			defer startICMPObserver(targetIP, port).report()
+		}
+		if o.ftpMode != "" {
+			return o.tryFTP(targetIP, port, sourceIP, sourcePort, o.timeout)
+		}
+		o.remoteIPAddr = targetIP
+		o.sourceIPAddr = sourceIP
+		return tryConnect(&o)
+	}
+
 	if namespacePath == "-" {
-		// Add the source IP (if set) to eth0.
-		err = maybeAddAddr(sourceIpAddress)
 		// Test connection from wherever we are already running.
-		if err == nil {
-			err = tryConnect(ipAddress, port, sourceIpAddress, sourcePort, protocol,
-				seconds, loopFile, sendLen, recvLen, logPongs, stdin, timeout)
-		}
+		err = connect()
 	} else {
 		// Get the specified network namespace (representing a workload).
 		var namespace ns.NetNS
@@ -195,13 +572,7 @@ func main() {
 
 		// Now, in that namespace, try connecting to the target.
 		err = namespace.Do(func(_ ns.NetNS) error {
-			// Add an interface for the source IP if any.
-			e := maybeAddAddr(sourceIpAddress)
-			if e != nil {
-				return e
-			}
-			return tryConnect(ipAddress, port, sourceIpAddress, sourcePort, protocol,
-				seconds, loopFile, sendLen, recvLen, logPongs, stdin, timeout)
+			return connect()
 		})
 	}
 
@@ -210,35 +581,207 @@ func main() {
 	}
 }
 
-func maybeAddAddr(sourceIP string) error {
+// exitInvalidArgs exits with the status that tells the checker that we rejected
+// our arguments, whatever the code.
+func exitInvalidArgs(int) {
+	os.Exit(connectivity.ExitCodeInvalidArgs)
+}
+
+// selfTest checks that we can enter the namespace and connect to ourselves over
+// loopback in it, so that the checker can tell a broken harness from a lack of
+// connectivity.
+func selfTest(namespacePath string) {
+	var err error
+	if namespacePath == "-" {
+		err = tryLoopback()
+	} else {
+		var namespace ns.NetNS
+		namespace, err = ns.GetNS(namespacePath)
+		if err == nil {
+			err = namespace.Do(func(_ ns.NetNS) error {
+				return tryLoopback()
+			})
+		}
+	}
+
+	res := connectivity.Result{
+		Stats: connectivity.Stats{
+			RequestsSent: 1,
+		},
+	}
+	if err != nil {
+		log.WithError(err).Warn("Self test failed")
+		res.LastResponse.ErrorStr = err.Error()
+	} else {
+		res.Stats.ResponsesReceived = 1
+	}
+	res.PrintToStdout()
+}
+
+func tryLoopback() error {
+	const selfTestTimeout = 2 * time.Second
+	const payload = "ping"
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.Copy(conn, conn)
+	}()
+
+	conn, err := net.DialTimeout("tcp", l.Addr().String(), selfTestTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(selfTestTimeout)); err != nil {
+		return err
+	}
+	if _, err := conn.Write([]byte(payload)); err != nil {
+		return err
+	}
+	buf := make([]byte, len(payload))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return err
+	}
+	if string(buf) != payload {
+		return fmt.Errorf("unexpected echo over loopback: %q", buf)
+	}
+	return nil
+}
+
+func (o *options) maybeAddAddr(sourceIP string) error {
 	if sourceIP != defaultIPv4SourceIP && sourceIP != defaultIPv6SourceIP {
-		if !strings.Contains(sourceIP, ":") {
-			sourceIP += "/32"
-		} else {
-			sourceIP += "/128"
+		family := "inet "
+		if strings.Contains(sourceIP, ":") {
+			family = "inet6 "
 		}
 
-		// Check if the IP is already set on eth0.
-		out, err := exec.Command("ip", "a", "show", "dev", "eth0").Output()
+		// Check if the IP is already set on any interface.  In a workload, only eth0
+		// has addresses but, in the host namespace, the source IP may be that of any
+		// of the host's interfaces, with any prefix length.
+		out, err := exec.Command("ip", "a").Output()
 		if err != nil {
 			return err
 		}
-		if strings.Contains(string(out), sourceIP) {
-			log.Infof("IP addr %s already exists on eth0, skip adding IP", sourceIP)
+		if strings.Contains(string(out), family+sourceIP+"/") {
+			log.Infof("IP addr %s already exists, skip adding IP", sourceIP)
 			return nil
 		}
-		cmd := exec.Command("ip", "addr", "add", sourceIP, "dev", "eth0")
+
+		if !strings.Contains(sourceIP, ":") {
+			sourceIP += "/32"
+		} else {
+			sourceIP += "/128"
+		}
+		dev := "eth0"
+		if o.sourceInterface != "" {
+			dev = o.sourceInterface
+		} else if o.vrfDevice != "" {
+			dev = o.vrfDevice
+		}
+		cmd := exec.Command("ip", "addr", "add", sourceIP, "dev", dev)
 		return cmd.Run()
 	}
 	return nil
 }
 
 type statistics struct {
+	// Totals of the data sent and received, updated atomically since the
+	// packet loss test sends and receives on different goroutines.
+	bytesSent     int64
+	bytesReceived int64
+
 	totalReq   int
 	totalReply int
+	// delivered is the highest count of our requests that the server said
+	// it had received.
+	delivered int
+
+	reordered          int
+	maxReorderDistance int
+	duplicates         int
+
+	// Per connectivity.LossBucketSize breakdown of a packet loss test.
+	lock      sync.Mutex
+	buckets   []connectivity.Stats
+	seqBucket []int // Maps request sequence number to its bucket.
+}
+
+func (s *statistics) recordSent(seq int, bucket int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for len(s.buckets) <= bucket {
+		s.buckets = append(s.buckets, connectivity.Stats{})
+	}
+	s.buckets[bucket].RequestsSent++
+	for len(s.seqBucket) <= seq {
+		s.seqBucket = append(s.seqBucket, -1)
+	}
+	s.seqBucket[seq] = bucket
+}
+
+// snapshot returns the totals of the buckets recorded so far.
+func (s *statistics) snapshot() connectivity.Stats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var stats connectivity.Stats
+	for _, b := range s.buckets {
+		stats.RequestsSent += b.RequestsSent
+		stats.ResponsesReceived += b.ResponsesReceived
+	}
+	stats.RequestsDelivered = s.delivered
+	stats.BytesSent = atomic.LoadInt64(&s.bytesSent)
+	stats.BytesReceived = atomic.LoadInt64(&s.bytesReceived)
+	return stats
+}
+
+// recordDelivered records the server's count of the requests it received, from
+// a response.  Responses can arrive out of order so only the highest count
+// counts.
+func (s *statistics) recordDelivered(resp connectivity.Response) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if resp.RequestsReceived > s.delivered {
+		s.delivered = resp.RequestsReceived
+	}
+}
+
+// resetBytes forgets the data sent and received so far, before the test
+// starts.
+func (s *statistics) resetBytes() {
+	atomic.StoreInt64(&s.bytesSent, 0)
+	atomic.StoreInt64(&s.bytesReceived, 0)
+}
+
+// addTotals fills in the per-direction totals of the test.
+func (s *statistics) addTotals(stats *connectivity.Stats) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	stats.RequestsDelivered = s.delivered
+	stats.BytesSent = atomic.LoadInt64(&s.bytesSent)
+	stats.BytesReceived = atomic.LoadInt64(&s.bytesReceived)
+}
+
+func (s *statistics) recordReceived(seq int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if seq >= len(s.seqBucket) || s.seqBucket[seq] < 0 {
+		log.WithField("sequence", seq).Warn("Received response for unknown request")
+		return
+	}
+	s.buckets[s.seqBucket[seq]].ResponsesReceived++
 }
 
 type testConn struct {
+	opts *options
 	stat statistics
 
 	config   connectivity.ConnConfig
@@ -248,8 +791,40 @@ type testConn struct {
 	sendLen int
 	recvLen int
 	stdin   bool
+
+	// Packets per second and size of the packets of a packet loss test.
+	packetRate int
+	packetSize int
+
+	// How long a one off check stays silent before checking the connection again.
+	idlePeriod time.Duration
+}
+
+// send and receive wrap those of the protocol driver, counting the data in the
+// statistics.
+func (tc *testConn) send(msg []byte) error {
+	err := tc.protocol.Send(msg)
+	if err == nil {
+		atomic.AddInt64(&tc.stat.bytesSent, int64(len(msg)))
+	}
+	return err
+}
+
+func (tc *testConn) receive() ([]byte, error) {
+	data, err := tc.protocol.Receive()
+	atomic.AddInt64(&tc.stat.bytesReceived, int64(len(data)))
+	return data, err
 }
 
+const (
+	// maxResponseOverhead bounds the size of a response beyond the request
+	// that it echoes, including the IP header that raw sockets read.
+	maxResponseOverhead = 4 << 10
+	// receiveBufferSize is the size of the buffers that responses are read
+	// into, big enough for the response to a packet of the largest size.
+	receiveBufferSize = connectivity.MaxPacketSize + maxResponseOverhead
+)
+
 type protocolDriver interface {
 	Connect() error
 	Send(msg []byte) error
@@ -258,10 +833,22 @@ type protocolDriver interface {
 	SetReadDeadline(t time.Time) error
 
 	MTU() (int, error)
+	// TCPInfo returns nil for protocols other than TCP.
+	TCPInfo() (*connectivity.TCPInfo, error)
+	// LocalAddr returns the local address of the connection, "" if unknown.
+	LocalAddr() string
+}
+
+// localAddrOf returns the local address of the connection, "" if unknown.
+func localAddrOf(conn interface{ LocalAddr() net.Addr }) string {
+	addr := conn.LocalAddr()
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
 }
 
-func NewTestConn(remoteIpAddr, remotePort, sourceIpAddr, sourcePort, protocol string,
-	duration time.Duration, sendLen, recvLen int, stdin bool) (*testConn, error) {
+func NewTestConn(o *options, duration time.Duration) (*testConn, error) {
 	err := utils.RunCommand("ip", "r")
 	if err != nil {
 		return nil, err
@@ -269,56 +856,62 @@ func NewTestConn(remoteIpAddr, remotePort, sourceIpAddr, sourcePort, protocol st
 
 	var localAddr string
 	var remoteAddr string
-	if strings.Contains(remoteIpAddr, ":") {
-		localAddr = "[" + sourceIpAddr + "]"
-		remoteAddr = "[" + remoteIpAddr + "]"
+	if strings.Contains(o.remoteIPAddr, ":") {
+		localAddr = "[" + o.sourceIPAddr + "]"
+		remoteAddr = "[" + o.remoteIPAddr + "]"
 	} else {
-		localAddr = sourceIpAddr
-		remoteAddr = remoteIpAddr
+		localAddr = o.sourceIPAddr
+		remoteAddr = o.remoteIPAddr
 	}
 
-	if !strings.HasPrefix(protocol, "ip") {
+	if !strings.HasPrefix(o.protocol, "ip") {
 		// All the protocols apart from our raw IP protocol have ports.
-		localAddr += ":" + sourcePort
-		remoteAddr += ":" + remotePort
+		localAddr += ":" + o.sourcePort
+		remoteAddr += ":" + o.remotePort
 	}
 
-	log.Infof("Connecting from %v to %v over %s", localAddr, remoteAddr, protocol)
+	log.Infof("Connecting from %v to %v over %s", localAddr, remoteAddr, o.protocol)
 
 	var driver protocolDriver
 
-	if strings.HasPrefix(protocol, "ip") {
+	if strings.HasPrefix(o.protocol, "ip") {
 		driver = &rawIP{
+			opts:       o,
 			localAddr:  localAddr,
 			remoteAddr: remoteAddr,
-			protocol:   protocol,
+			protocol:   o.protocol,
 		}
 	} else {
-		switch protocol {
+		switch o.protocol {
 		case "udp":
 			driver = &connectedUDP{
+				opts:       o,
 				localAddr:  localAddr,
 				remoteAddr: remoteAddr,
 			}
 		case "udp-recvmsg":
 			driver = &connectedUDP{
+				opts:        o,
 				localAddr:   localAddr,
 				remoteAddr:  remoteAddr,
 				useReadFrom: true,
 			}
 		case "udp-noconn":
 			driver = &unconnectedUDP{
+				opts:       o,
 				localAddr:  localAddr,
 				remoteAddr: remoteAddr,
 			}
 		case "sctp":
 			driver = &connectedSCTP{
-				sourcePort:   sourcePort,
-				remoteIpAddr: remoteIpAddr,
-				remotePort:   remotePort,
+				opts:         o,
+				sourcePort:   o.sourcePort,
+				remoteIpAddr: o.remoteIPAddr,
+				remotePort:   o.remotePort,
 			}
 		default:
 			driver = &connectedTCP{
+				opts:       o,
 				localAddr:  localAddr,
 				remoteAddr: remoteAddr,
 			}
@@ -335,28 +928,49 @@ func NewTestConn(remoteIpAddr, remotePort, sourceIpAddr, sourcePort, protocol st
 		connType = connectivity.ConnectionTypePing
 	} else {
 		connType = connectivity.ConnectionTypeStream
-		if protocol != "udp" {
+		if o.protocol != "udp" {
 			log.Fatal("Wrong protocol for packets loss test")
 		}
 	}
 
 	log.Infof("%s connection established from %v to %v", connType, localAddr, remoteAddr)
 	return &testConn{
-		config:   connectivity.ConnConfig{ConnType: connType, ConnID: uuid.NewString()},
-		protocol: driver,
-		duration: duration,
-		sendLen:  sendLen,
-		recvLen:  recvLen,
-		stdin:    stdin,
+		opts:       o,
+		config:     connectivity.ConnConfig{ConnType: connType, ConnID: uuid.NewString()},
+		protocol:   driver,
+		duration:   duration,
+		sendLen:    o.sendLen,
+		recvLen:    o.recvLen,
+		stdin:      o.stdin,
+		packetRate: o.packetRate,
+		packetSize: o.packetSize,
+		idlePeriod: o.idlePeriod,
 	}, nil
 
 }
 
-func tryConnect(remoteIPAddr, remotePort, sourceIPAddr, sourcePort, protocol string,
-	seconds int, loopFile string, sendLen, recvLen int, logPongs, stdin bool, timeout time.Duration) error {
+// tryConnect does the test that the options ask for.
+func tryConnect(o *options) error {
+	if o.flows > 1 {
+		return tryConnectParallelFlows(o)
+	}
+
+	if o.continuous {
+		return tryConnectContinuously(o)
+	}
 
-	tc, err := NewTestConn(remoteIPAddr, remotePort, sourceIPAddr, sourcePort, protocol,
-		time.Duration(seconds)*time.Second, sendLen, recvLen, stdin)
+	if o.connRate > 0 {
+		return tryConnectAtRate(o)
+	}
+
+	// A long-lived connection is a series of pings over one connection rather
+	// than a packet loss stream.
+	connDuration := time.Duration(o.seconds) * time.Second
+	if o.longLived {
+		connDuration = 0
+	}
+
+	tc, err := NewTestConn(o, connDuration)
 	if err != nil {
 		tc.sendErrorResp(err)
 		log.WithError(err).Fatal("Failed to create TestConn")
@@ -365,14 +979,14 @@ func tryConnect(remoteIPAddr, remotePort, sourceIPAddr, sourcePort, protocol str
 		_ = tc.Close()
 	}()
 
-	if remotePort == "6443" {
+	if o.remotePort == "6443" {
 		// Testing for connectivity to the Kubernetes API server.  If we reach here, we're
 		// good.  Skip sending and receiving any data, as that would need TLS.
 		connectivity.Result{
 			LastResponse: connectivity.Response{
 				Timestamp:  time.Now(),
-				SourceAddr: sourceIPAddr,
-				ServerAddr: remoteIPAddr,
+				SourceAddr: o.sourceIPAddr,
+				ServerAddr: o.remoteIPAddr,
 				Request: connectivity.Request{
 					Payload: "Dummy request: TCP handshake only for API server connection testing",
 				},
@@ -386,14 +1000,14 @@ func tryConnect(remoteIPAddr, remotePort, sourceIPAddr, sourcePort, protocol str
 		return nil
 	}
 
-	if remotePort == "5473" {
+	if o.remotePort == "5473" {
 		// Testing for connectivity to Typha. If we reach here, we're good.
 		// Skip sending and receiving any data.
 		connectivity.Result{
 			LastResponse: connectivity.Response{
 				Timestamp:  time.Now(),
-				SourceAddr: sourceIPAddr,
-				ServerAddr: remoteIPAddr,
+				SourceAddr: o.sourceIPAddr,
+				ServerAddr: o.remoteIPAddr,
 				Request: connectivity.Request{
 					Payload: "Dummy request: TCP handshake only for Typha connection testing",
 				},
@@ -407,12 +1021,16 @@ func tryConnect(remoteIPAddr, remotePort, sourceIPAddr, sourcePort, protocol str
 		return nil
 	}
 
-	if loopFile != "" {
-		return tc.tryLoopFile(loopFile, logPongs, timeout)
+	if o.loopFile != "" {
+		return tc.tryLoopFile(o.loopFile, o.logPongs, o.timeout)
+	}
+
+	if o.longLived {
+		return tc.tryLongLived(time.Duration(o.seconds) * time.Second)
 	}
 
 	if tc.config.ConnType == connectivity.ConnectionTypePing {
-		return tc.tryConnectOnceOff(timeout)
+		return tc.tryConnectOnceOff(o.timeout)
 	}
 
 	return tc.tryConnectWithPacketLoss()
@@ -422,6 +1040,7 @@ func (tc *testConn) GetTestMessage(sequence int) connectivity.Request {
 	req := tc.config.GetTestMessage(sequence)
 	req.SendSize = tc.sendLen
 	req.ResponseSize = tc.recvLen
+	req.LargeSend = tc.opts.largeSend && tc.sendLen > 0
 
 	return req
 }
@@ -440,7 +1059,7 @@ func (tc *testConn) tryLoopFile(loopFile string, logPongs bool, timeout time.Dur
 	var zeroTime time.Time
 
 	for {
-		err = tc.protocol.Send(msg)
+		err = tc.send(msg)
 		if err != nil {
 			log.WithError(err).Fatal("Failed to send")
 		}
@@ -457,7 +1076,7 @@ func (tc *testConn) tryLoopFile(loopFile string, logPongs bool, timeout time.Dur
 			}
 		}
 		var err error
-		respRaw, err = tc.protocol.Receive()
+		respRaw, err = tc.receive()
 		if err == nil {
 			if logPongs {
 				fmt.Println("PONG")
@@ -491,6 +1110,7 @@ func (tc *testConn) tryLoopFile(loopFile string, logPongs bool, timeout time.Dur
 			log.WithField("reply", resp).Fatal("Unexpected response")
 		}
 		tc.stat.totalReply++
+		tc.stat.recordDelivered(resp)
 
 		lastResponse = resp
 		if !ls.Next() {
@@ -504,10 +1124,239 @@ func (tc *testConn) tryLoopFile(loopFile string, logPongs bool, timeout time.Dur
 			ResponsesReceived: tc.stat.totalReply,
 		},
 	}
+	tc.stat.addTotals(&res.Stats)
+	res.PrintToStdout()
+	return nil
+}
+
+const (
+	// longLivedInterval is how often a long-lived connection exchanges a message.
+	longLivedInterval = 100 * time.Millisecond
+	// longLivedStallTimeout is how long a long-lived connection waits for a
+	// response before it considers the connection stalled.
+	longLivedStallTimeout = time.Second
+)
+
+// tryLongLived keeps exchanging messages over the connection for the duration and
+// stops at the first failure.  It reports that it is connected once the first
+// exchange succeeded so that the caller can disrupt the established connection.
+func (tc *testConn) tryLongLived(duration time.Duration) error {
+	log.Infof("Doing long-lived connection test for %v...", duration)
+
+	var lastResponse connectivity.Response
+	var errStr string
+	var cut *connectivity.ConnectionCut
+	oneWay := tc.maybeCalibrate()
+	start := time.Now()
+	end := start.Add(duration)
+	nextProgress := start.Add(connectivity.ProgressInterval)
+
+	stallTimeout := longLivedStallTimeout
+	if tc.opts.sctpMultihomed() {
+		stallTimeout = sctpFailoverStallTimeout
+	}
+
+	for seq := 0; ; seq++ {
+		resp, err := tc.exchangeWithin(seq, stallTimeout)
+		received := time.Now()
+		if err != nil {
+			log.WithError(err).WithField("sequence", seq).Warn("Long-lived connection failed")
+			errStr = err.Error()
+			cut = &connectivity.ConnectionCut{
+				Time:  time.Now(),
+				Reset: err != errStalled,
+			}
+			break
+		}
+		lastResponse = resp
+		if s, ok := tc.protocol.(*connectedSCTP); ok {
+			s.observePaths()
+		}
+		if oneWay != nil {
+			oneWay.Add(connectivity.LatencySample{
+				Sent:     resp.Request.Timestamp,
+				Server:   resp.Timestamp,
+				Received: received,
+			})
+		}
+		if seq == 0 {
+			connectivity.PrintConnected()
+		}
+		if received.After(nextProgress) {
+			connectivity.Progress{
+				Elapsed: received.Sub(start),
+				Stats: connectivity.Stats{
+					RequestsSent:      tc.stat.totalReq,
+					ResponsesReceived: tc.stat.totalReply,
+				},
+			}.PrintToStdout()
+			nextProgress = nextProgress.Add(connectivity.ProgressInterval)
+		}
+		if time.Now().After(end) {
+			break
+		}
+		time.Sleep(longLivedInterval)
+	}
+
+	tcpInfo, err := tc.protocol.TCPInfo()
+	if err != nil {
+		log.WithError(err).Warn("Failed to get TCP_INFO")
+	}
+
+	lastResponse.ErrorStr = errStr
+	res := connectivity.Result{
+		LastResponse: lastResponse,
+		Stats: connectivity.Stats{
+			RequestsSent:      tc.stat.totalReq,
+			ResponsesReceived: tc.stat.totalReply,
+			OneWay:            oneWay,
+		},
+		ConnectionCut: cut,
+		TCPInfo:       tcpInfo,
+		SCTP:          sctpAssociationOf(tc.protocol),
+	}
+	tc.stat.addTotals(&res.Stats)
 	res.PrintToStdout()
+
 	return nil
 }
 
+var errStalled = errors.New("connection stalled")
+
+const (
+	// calibrationExchanges is the number of exchanges of the clock calibration handshake.
+	calibrationExchanges = 10
+	// calibrationTimeout caps the time that the handshake takes, it runs before
+	// the test starts and so on top of its duration.
+	calibrationTimeout = time.Second
+	// calibrationExchangeTimeout is how long the handshake waits for each response.
+	calibrationExchangeTimeout = 200 * time.Millisecond
+)
+
+// maybeCalibrate returns the accumulator of the one-way latency of the test,
+// calibrated against the server's clock, or nil if --one-way-latency wasn't
+// given.  The exchanges of the calibration aren't counted in the statistics of
+// the test.
+func (tc *testConn) maybeCalibrate() *connectivity.OneWayLatency {
+	if !tc.opts.oneWayLatency {
+		return nil
+	}
+	oneWay := connectivity.NewOneWayLatency(tc.calibrateClockOffset())
+	tc.stat.resetBytes()
+	return oneWay
+}
+
+// calibrateClockOffset does a few request/response exchanges, separate from the
+// test traffic, to estimate the offset between our clock and the server's.
+func (tc *testConn) calibrateClockOffset() time.Duration {
+	config := connectivity.ConnConfig{
+		ConnType: connectivity.ConnectionTypeCalibration,
+		ConnID:   uuid.NewString(),
+	}
+	defer func() {
+		if err := tc.protocol.SetReadDeadline(time.Time{}); err != nil {
+			log.WithError(err).Warn("Failed to reset read deadline after calibration.")
+		}
+	}()
+
+	var samples []connectivity.LatencySample
+	end := time.Now().Add(calibrationTimeout)
+	for seq := 0; seq < calibrationExchanges; seq++ {
+		deadline := time.Now().Add(calibrationExchangeTimeout)
+		if deadline.After(end) {
+			deadline = end
+		}
+		if !deadline.After(time.Now()) {
+			log.Warn("Ran out of time for the calibration handshake.")
+			break
+		}
+		req := config.GetTestMessage(seq)
+		msg, err := json.Marshal(req)
+		if err != nil {
+			log.WithError(err).Panic("Failed to marshall request")
+		}
+		if err := tc.send(msg); err != nil {
+			log.WithError(err).Warn("Failed to send calibration request.")
+			break
+		}
+		if err := tc.protocol.SetReadDeadline(deadline); err != nil {
+			log.WithError(err).Warn("Failed to set read deadline.")
+			break
+		}
+		respRaw, err := tc.receive()
+		received := time.Now()
+		if err != nil {
+			log.WithError(err).Warn("Failed to receive calibration response.")
+			continue
+		}
+		var resp connectivity.Response
+		if err := json.Unmarshal(respRaw, &resp); err != nil || !resp.Request.Equal(req) {
+			log.WithError(err).Warn("Unexpected calibration response.")
+			continue
+		}
+		samples = append(samples, connectivity.LatencySample{
+			Sent:     req.Timestamp,
+			Server:   resp.Timestamp,
+			Received: received,
+		})
+	}
+
+	offset := connectivity.EstimateClockOffset(samples)
+	log.WithFields(log.Fields{
+		"samples": len(samples),
+		"offset":  offset,
+	}).Info("Calibrated clock offset")
+	return offset
+}
+
+// exchangeWithin sends the test message with the given sequence number and waits
+// for the matching response for at most the given time.
+func (tc *testConn) exchangeWithin(seq int, wait time.Duration) (connectivity.Response, error) {
+	var resp connectivity.Response
+
+	req := tc.GetTestMessage(seq)
+	msg, err := json.Marshal(req)
+	if err != nil {
+		log.WithError(err).Panic("Failed to marshall request")
+	}
+	if err := tc.send(msg); err != nil {
+		return resp, err
+	}
+	tc.stat.totalReq++
+
+	type recvResult struct {
+		raw []byte
+		err error
+	}
+	// Buffered so that the receiver does not leak if we give up on it.
+	recvC := make(chan recvResult, 1)
+	go func() {
+		raw, err := tc.receive()
+		recvC <- recvResult{raw: raw, err: err}
+	}()
+
+	select {
+	case r := <-recvC:
+		if r.err != nil {
+			return resp, r.err
+		}
+		if err := json.Unmarshal(r.raw, &resp); err != nil {
+			return resp, err
+		}
+	case <-time.After(wait):
+		log.Warnf("No response within %v", wait)
+		return resp, errStalled
+	}
+
+	if !resp.Request.Equal(req) {
+		return resp, fmt.Errorf("unexpected response: %+v", resp)
+	}
+	tc.stat.totalReply++
+	tc.stat.recordDelivered(resp)
+
+	return resp, nil
+}
+
 func (tc *testConn) sendErrorResp(err error) {
 	var resp connectivity.Response
 	resp.ErrorStr = err.Error()
@@ -528,12 +1377,17 @@ func (tc *testConn) tryConnectOnceOff(timeout time.Duration) error {
 		defer func() {
 			close(done)
 		}()
+		// The timeout applies to each exchange, before and after the idle period.
+		totalTimeout := timeout
+		if tc.idlePeriod > 0 {
+			totalTimeout = 2*timeout + tc.idlePeriod
+		}
 		go func() {
 			select {
 			case <-done:
 				return
-			case <-time.After(timeout):
-				log.Fatalf("Timed out after %.1fs", timeout.Seconds())
+			case <-time.After(totalTimeout):
+				log.Fatalf("Timed out after %.1fs", totalTimeout.Seconds())
 			}
 		}()
 	}
@@ -542,14 +1396,14 @@ func (tc *testConn) tryConnectOnceOff(timeout time.Duration) error {
 		var buf bytes.Buffer
 		count, err := io.Copy(&buf, os.Stdin)
 		log.WithError(err).WithField("count", count).Info("Read message bytes from stdin")
-		err = tc.protocol.Send(buf.Bytes())
+		err = tc.send(buf.Bytes())
 		if err != nil {
 			log.WithError(err).Panic("Failed to send stdin request")
 		}
 		return nil
 	}
 
-	req := tc.GetTestMessage(0)
+	req := tc.opts.padForFragmentation(tc.GetTestMessage(0))
 	msg, err := json.Marshal(req)
 	if err != nil {
 		log.WithError(err).Panic("Failed to marshall request")
@@ -562,18 +1416,18 @@ func (tc *testConn) tryConnectOnceOff(timeout time.Duration) error {
 		return err
 	}
 
-	err = tc.protocol.Send(msg)
+	err = tc.send(msg)
 	if err != nil {
 		log.WithError(err).Fatal("Failed to send")
 	}
 
 	if tc.sendLen > 0 {
-		if err := tc.protocol.Send(make([]byte, tc.sendLen)); err != nil {
+		if err := tc.send(tc.opts.extraData(tc.sendLen)); err != nil {
 			log.WithError(err).Fatal("Failed send extra bytes")
 		}
 	}
 
-	respRaw, err := tc.protocol.Receive()
+	respRaw, err := tc.receive()
 	if err != nil {
 		tc.sendErrorResp(err)
 		log.WithError(err).Fatal("Failed to receive")
@@ -588,9 +1442,10 @@ func (tc *testConn) tryConnectOnceOff(timeout time.Duration) error {
 	if !resp.Request.Equal(req) {
 		log.WithField("reply", resp).Fatal("Unexpected response")
 	}
+	tc.stat.recordDelivered(resp)
 
 	if tc.recvLen > 0 {
-		bytes, err := tc.protocol.Receive()
+		bytes, err := tc.receive()
 		if len(bytes) < tc.recvLen {
 			log.WithError(err).WithField("received extra bytes", len(bytes)).Fatal("Receive too short")
 		}
@@ -604,29 +1459,339 @@ func (tc *testConn) tryConnectOnceOff(timeout time.Duration) error {
 		log.WithError(err).Fatal("Failed to get MTU")
 	}
 
+	stats := connectivity.Stats{
+		RequestsSent:      1,
+		ResponsesReceived: 1,
+	}
+
+	if tc.idlePeriod > 0 {
+		log.Infof("Idling for %v before resuming traffic", tc.idlePeriod)
+		time.Sleep(tc.idlePeriod)
+
+		// The extra data was only for the first exchange.
+		tc.sendLen, tc.recvLen = 0, 0
+		wait := timeout
+		if wait == 0 {
+			wait = longLivedStallTimeout
+		}
+		stats.RequestsSent++
+		idleResp, err := tc.exchangeWithin(1, wait)
+		if err != nil {
+			log.WithError(err).Warn("Connection failed after idle period")
+			resp.ErrorStr = fmt.Sprintf("connection failed after idling for %v: %v", tc.idlePeriod, err)
+		} else {
+			resp = idleResp
+			stats.ResponsesReceived++
+		}
+	}
+
+	tcpInfo, err := tc.protocol.TCPInfo()
+	if err != nil {
+		log.WithError(err).Warn("Failed to get TCP_INFO")
+	}
+
+	tc.stat.addTotals(&stats)
 	res := connectivity.Result{
 		LastResponse: resp,
+		Stats:        stats,
+		ClientMTU:    mtuPair,
+		TCPInfo:      tcpInfo,
+		ClientAddr:   tc.protocol.LocalAddr(),
+		SCTP:         sctpAssociationOf(tc.protocol),
+	}
+	res.PrintToStdout()
+
+	return nil
+}
+
+// tryConnectParallelFlows opens --flows flows simultaneously, each with its own
+// connection, and does a single request/response exchange on each of them.
+func tryConnectParallelFlows(o *options) error {
+	flows := o.flows
+
+	log.Infof("Doing parallel flows test with %d flows...", flows)
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	var lastResponse connectivity.Response
+	flowResults := make([]connectivity.FlowResult, flows)
+
+	for i := 0; i < flows; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			start := time.Now()
+			resp, err := pingOneFlow(o)
+			if err != nil {
+				log.WithError(err).WithField("flow", i).Warn("Flow failed")
+				flowResults[i] = connectivity.FlowResult{ErrorStr: err.Error()}
+				return
+			}
+			flowResults[i] = connectivity.FlowResult{
+				Success:    true,
+				Latency:    time.Since(start),
+				SourceAddr: resp.SourceAddr,
+			}
+
+			lock.Lock()
+			lastResponse = resp
+			lock.Unlock()
+		}(i)
+	}
+	wg.Wait()
+
+	res := connectivity.Result{
+		LastResponse: lastResponse,
 		Stats: connectivity.Stats{
-			RequestsSent:      1,
-			ResponsesReceived: 1,
+			RequestsSent: flows,
+		},
+		Flows: flowResults,
+	}
+	res.Stats.ResponsesReceived = res.SuccessfulFlows()
+	res.PrintToStdout()
+
+	return nil
+}
+
+// tryConnectAtRate opens a new connection, and does a single request/response exchange on
+// it, at --conn-rate (connections per second) for the duration.
+func tryConnectAtRate(o *options) error {
+	duration := time.Duration(o.seconds) * time.Second
+	connRate := o.connRate
+
+	log.Infof("Doing connection rate test at %d connections/s for %v...", connRate, duration)
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	var lastResponse connectivity.Response
+	attempted := 0
+	succeeded := 0
+
+	start := time.Now()
+	ticker := time.NewTicker(time.Second / time.Duration(connRate))
+	defer ticker.Stop()
+	done := time.After(duration)
+
+loop:
+	for {
+		select {
+		case <-done:
+			break loop
+		case <-ticker.C:
+			attempted++
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				resp, err := pingOneFlow(o)
+				if err != nil {
+					log.WithError(err).Debug("Connection failed")
+					return
+				}
+				lock.Lock()
+				succeeded++
+				lastResponse = resp
+				lock.Unlock()
+			}()
+		}
+	}
+	// The rate includes the wait for the connections that are still in flight,
+	// they count towards it once they complete.
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	log.Infof("Connection rate test done, %d/%d connections succeeded in %v", succeeded, attempted, elapsed)
+
+	res := connectivity.Result{
+		LastResponse: lastResponse,
+		Stats: connectivity.Stats{
+			RequestsSent:      attempted,
+			ResponsesReceived: succeeded,
 		},
-		ClientMTU: mtuPair,
+		ConnectionRate: float64(succeeded) / elapsed.Seconds(),
 	}
 	res.PrintToStdout()
 
 	return nil
 }
 
+// tryConnectContinuously probes with a new connection every
+// connectivity.ContinuousProbeInterval, printing the result of each probe, until
+// stdin is closed.
+func tryConnectContinuously(o *options) error {
+	log.Info("Doing continuous connectivity test...")
+
+	stop := make(chan struct{})
+	go func() {
+		_, err := io.Copy(io.Discard, os.Stdin)
+		log.WithError(err).Info("Stdin closed, stopping continuous test")
+		close(stop)
+	}()
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	var lastResponse connectivity.Response
+	attempted := 0
+	succeeded := 0
+
+	ticker := time.NewTicker(connectivity.ContinuousProbeInterval)
+	defer ticker.Stop()
+
+loop:
+	for {
+		select {
+		case <-stop:
+			break loop
+		case <-ticker.C:
+			attempted++
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				probe := connectivity.ProbeResult{Time: time.Now()}
+				resp, err := pingOneFlowWithin(o)
+
+				lock.Lock()
+				defer lock.Unlock()
+				if err != nil {
+					probe.ErrorStr = err.Error()
+				} else {
+					probe.Success = true
+					probe.Latency = time.Since(probe.Time)
+					succeeded++
+					lastResponse = resp
+				}
+				probe.PrintToStdout()
+			}()
+		}
+	}
+	wg.Wait()
+
+	res := connectivity.Result{
+		LastResponse: lastResponse,
+		Stats: connectivity.Stats{
+			RequestsSent:      attempted,
+			ResponsesReceived: succeeded,
+		},
+	}
+	res.PrintToStdout()
+
+	return nil
+}
+
+// pingOneFlowWithin is pingOneFlow with a limit on the overall time taken,
+// including connecting.
+func pingOneFlowWithin(o *options) (connectivity.Response, error) {
+	timeout := o.timeout
+
+	if timeout <= 0 {
+		return pingOneFlow(o)
+	}
+
+	type pingResult struct {
+		resp connectivity.Response
+		err  error
+	}
+	// Buffered so that the ping does not leak if we give up on it.
+	resultC := make(chan pingResult, 1)
+	go func() {
+		resp, err := pingOneFlow(o)
+		resultC <- pingResult{resp: resp, err: err}
+	}()
+
+	select {
+	case r := <-resultC:
+		return r.resp, r.err
+	case <-time.After(timeout):
+		return connectivity.Response{}, fmt.Errorf("timed out after %v", timeout)
+	}
+}
+
+func pingOneFlow(o *options) (connectivity.Response, error) {
+	var resp connectivity.Response
+
+	tc, err := NewTestConn(o, 0)
+	if err != nil {
+		return resp, err
+	}
+	defer func() {
+		_ = tc.Close()
+	}()
+
+	if o.timeout > 0 {
+		if err := tc.protocol.SetReadDeadline(time.Now().Add(o.timeout)); err != nil {
+			return resp, err
+		}
+	}
+
+	req := tc.GetTestMessage(0)
+	msg, err := json.Marshal(req)
+	if err != nil {
+		return resp, err
+	}
+	if err := tc.send(msg); err != nil {
+		return resp, err
+	}
+	if tc.sendLen > 0 {
+		if err := tc.send(tc.opts.extraData(tc.sendLen)); err != nil {
+			return resp, err
+		}
+	}
+
+	respRaw, err := tc.receive()
+	if err != nil {
+		return resp, err
+	}
+	if err := json.Unmarshal(respRaw, &resp); err != nil {
+		return resp, err
+	}
+	if !resp.Request.Equal(req) {
+		return resp, fmt.Errorf("unexpected response: %+v", resp)
+	}
+
+	if tc.recvLen > 0 {
+		extra, err := tc.receive()
+		if err != nil {
+			return resp, err
+		}
+		if len(extra) < tc.recvLen {
+			return resp, fmt.Errorf("received %d extra bytes, expected %d", len(extra), tc.recvLen)
+		}
+	}
+
+	return resp, nil
+}
+
 func (tc *testConn) tryConnectWithPacketLoss() error {
 	ctx, cancel := context.WithTimeout(context.Background(), tc.duration)
 	defer cancel()
 	reqDone := make(chan int)
 
-	log.Info("Start packet loss testing.")
+	log.Infof("Start packet loss testing at %d packets/s.", tc.packetRate)
 
 	var wg sync.WaitGroup
 
 	var lastResponse connectivity.Response
+	oneWay := tc.maybeCalibrate()
+	start := time.Now()
+
+	// Report progress until the test completes.
+	progressDone := make(chan struct{})
+	defer close(progressDone)
+	go func() {
+		ticker := time.NewTicker(connectivity.ProgressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-progressDone:
+				return
+			case <-ticker.C:
+				connectivity.Progress{
+					Elapsed: time.Since(start),
+					Stats:   tc.stat.snapshot(),
+				}.PrintToStdout()
+			}
+		}
+	}()
 
 	// Start a reader
 	wg.Add(1)
@@ -635,13 +1800,14 @@ func (tc *testConn) tryConnectWithPacketLoss() error {
 
 		lastSequence := 0
 		count := 0
-		outOfOrder := 0
-		maxGap := 0
+		highestSequence := -1
+		seen := set.New[int]()
 		for {
 			select {
 			case reqTotal := <-reqDone:
-				log.Infof("Reader completed.total req %d, total reply %d, last reply %d, outOfOrder %d, maxGap %d",
-					reqTotal, count, lastSequence, outOfOrder, maxGap)
+				log.Infof("Reader completed.total req %d, total reply %d, last reply %d, reordered %d, "+
+					"max reorder distance %d, duplicates %d",
+					reqTotal, count, lastSequence, tc.stat.reordered, tc.stat.maxReorderDistance, tc.stat.duplicates)
 
 				if count > reqTotal {
 					log.Fatal("Got more packets than we sent")
@@ -656,7 +1822,8 @@ func (tc *testConn) tryConnectWithPacketLoss() error {
 					log.WithError(err).Warn("Failed to set read deadline.")
 					continue
 				}
-				respRaw, err := tc.protocol.Receive()
+				respRaw, err := tc.receive()
+				received := time.Now()
 
 				if e, ok := err.(net.Error); ok && e.Timeout() {
 					// This was a timeout. Nothing to read.
@@ -677,14 +1844,34 @@ func (tc *testConn) tryConnectWithPacketLoss() error {
 
 				lastSequence, err = tc.config.GetTestMessageSequence(resp.Request.Payload)
 				if err != nil {
-					log.WithError(err).Fatal("Failed to get test message sequence from payload")
+					// For example, a late response to the calibration handshake.
+					log.WithError(err).Warning("Failed to get test message sequence from payload")
+					continue
+				}
+
+				tc.stat.recordDelivered(resp)
+				if seen.Contains(lastSequence) {
+					tc.stat.duplicates++
+					continue
+				}
+				seen.Add(lastSequence)
+				tc.stat.recordReceived(lastSequence)
+				if oneWay != nil {
+					oneWay.Add(connectivity.LatencySample{
+						Sent:     resp.Request.Timestamp,
+						Server:   resp.Timestamp,
+						Received: received,
+					})
 				}
 
-				if lastSequence != count {
-					outOfOrder++
-					if gap := int(math.Abs(float64(lastSequence - count))); gap > maxGap {
-						maxGap = gap
+				// A response is reordered if it arrives after a response to a later request.
+				if lastSequence < highestSequence {
+					tc.stat.reordered++
+					if d := highestSequence - lastSequence; d > tc.stat.maxReorderDistance {
+						tc.stat.maxReorderDistance = d
 					}
+				} else {
+					highestSequence = lastSequence
 				}
 
 				count++
@@ -715,8 +1902,19 @@ func (tc *testConn) tryConnectWithPacketLoss() error {
 				if err != nil {
 					log.WithError(err).Panic("Failed to marshall request")
 				}
+				if pad := tc.packetSize - len(msg) - len(`,"Padding":""`) - 1; pad > 0 {
+					// Pad up to the requested size, including the newline added by Send.
+					req.Padding = strings.Repeat("x", pad)
+					msg, err = json.Marshal(req)
+					if err != nil {
+						log.WithError(err).Panic("Failed to marshall request")
+					}
+				}
 
-				err = tc.protocol.Send(msg)
+				// Record the request before sending it, the response can
+				// beat us back otherwise.
+				tc.stat.recordSent(count, int(time.Since(start)/connectivity.LossBucketSize))
+				err = tc.send(msg)
 				if err != nil {
 					log.WithError(err).Fatal("Failed to send")
 				}
@@ -727,8 +1925,7 @@ func (tc *testConn) tryConnectWithPacketLoss() error {
 				// which is not the right kind of packet loss we want to trace.
 				// watch -n 1 'cat  /proc/net/udp' to monitor udp buffer overflow.
 
-				// Max 200 packets per second.
-				time.Sleep(5 * time.Millisecond)
+				time.Sleep(time.Second / time.Duration(tc.packetRate))
 			}
 		}
 
@@ -742,8 +1939,17 @@ func (tc *testConn) tryConnectWithPacketLoss() error {
 		Stats: connectivity.Stats{
 			RequestsSent:      tc.stat.totalReq,
 			ResponsesReceived: tc.stat.totalReply,
+			BucketSize:        connectivity.LossBucketSize,
+			Buckets:           tc.stat.buckets,
+
+			Reordered:          tc.stat.reordered,
+			MaxReorderDistance: tc.stat.maxReorderDistance,
+			Duplicates:         tc.stat.duplicates,
+
+			OneWay: oneWay,
 		},
 	}
+	tc.stat.addTotals(&res.Stats)
 	res.PrintToStdout()
 
 	return nil
@@ -804,6 +2010,7 @@ func (l *loopState) Next() bool {
 // connectedUDP abstracts a connected UDP stream.  I.e. it calls connect() to bind the local end of
 // the socket.  It can optionally use RecvFrom() when reading form the other side.
 type connectedUDP struct {
+	opts        *options
 	conn        *net.UDPConn
 	r           *bufio.Reader
 	localAddr   string
@@ -827,14 +2034,14 @@ func (d *connectedUDP) Connect() error {
 	// Since we specify the source port rather than use an ephemeral port, if
 	// the SO_REUSEADDR and SO_REUSEPORT options are not set, when we make
 	// another call to this program, the original port is in post-close wait
-	// state and bind fails.  The reuse library implements a Dial() that sets
+	// state and bind fails.  Our dial(), like the reuse library's Dial(), sets
 	// these options.
-	conn, err := reuse.Dial("udp", d.localAddr, d.remoteAddr)
+	conn, err := d.opts.dial("udp", d.localAddr, d.remoteAddr)
 	if err != nil {
 		return err
 	}
 	d.conn = conn.(*net.UDPConn)
-	d.r = bufio.NewReader(d.conn)
+	d.r = bufio.NewReaderSize(d.conn, receiveBufferSize)
 	return nil
 }
 
@@ -846,7 +2053,7 @@ func (d *connectedUDP) Send(msg []byte) error {
 
 func (d *connectedUDP) Receive() ([]byte, error) {
 	if d.useReadFrom {
-		bufIn := make([]byte, 8<<10)
+		bufIn := make([]byte, receiveBufferSize)
 		n, from, err := d.conn.ReadFrom(bufIn)
 		if err != nil {
 			log.WithError(err).Error("Failed to read from")
@@ -861,13 +2068,25 @@ func (d *connectedUDP) Receive() ([]byte, error) {
 	}
 }
 
+func (d *connectedUDP) TCPInfo() (*connectivity.TCPInfo, error) {
+	return nil, nil
+}
+
 func (d *connectedUDP) MTU() (int, error) {
 	return utils.ConnMTU(d.conn)
 }
 
+func (d *connectedUDP) LocalAddr() string {
+	if d.conn == nil {
+		return ""
+	}
+	return localAddrOf(d.conn)
+}
+
 // unconnectedUDP abstracts an unconnected UDP stream.  I.e. it calls ListenPacket() to open the local side
 // of the connection than then it uses SendTo and RecvFrom.
 type unconnectedUDP struct {
+	opts               *options
 	conn               net.PacketConn
 	localAddr          string
 	remoteAddr         string
@@ -883,7 +2102,7 @@ func (d *unconnectedUDP) Close() error {
 
 func (d *unconnectedUDP) Connect() error {
 	log.Info("'Connecting' unconnected UDP")
-	conn, err := net.ListenPacket("udp", d.localAddr)
+	conn, err := d.opts.listenPacket("udp", d.localAddr)
 	if err != nil {
 		log.WithError(err).Fatal("Failed to listen UDP")
 	}
@@ -910,7 +2129,7 @@ func (d *unconnectedUDP) Send(msg []byte) error {
 }
 
 func (d *unconnectedUDP) Receive() ([]byte, error) {
-	bufIn := make([]byte, 8<<10)
+	bufIn := make([]byte, receiveBufferSize)
 	n, from, err := d.conn.ReadFrom(bufIn)
 	if err != nil {
 		log.WithError(err).Error("Failed to read from")
@@ -920,16 +2139,28 @@ func (d *unconnectedUDP) Receive() ([]byte, error) {
 	return bufIn[:n], err
 }
 
+func (d *unconnectedUDP) TCPInfo() (*connectivity.TCPInfo, error) {
+	return nil, nil
+}
+
 func (d *unconnectedUDP) MTU() (int, error) {
 	return 0, nil
 }
 
+func (d *unconnectedUDP) LocalAddr() string {
+	if d.conn == nil {
+		return ""
+	}
+	return localAddrOf(d.conn)
+}
+
 func (d *unconnectedUDP) SetReadDeadline(t time.Time) error {
 	return d.conn.SetReadDeadline(t)
 }
 
 // connectedSCTP abstracts an SCTP stream.
 type connectedSCTP struct {
+	opts         *options
 	sourcePort   string
 	remoteIpAddr string
 	remotePort   string
@@ -937,11 +2168,17 @@ type connectedSCTP struct {
 	conn net.Conn
 	r    *bufio.Reader
 	w    *bufio.Writer
+
+	// fd is the socket of conn, and failedPaths the peer addresses whose paths
+	// failed, of a multi-homed association.
+	fd          int
+	failedPaths map[string]bool
 }
 
 // rawIP implements a raw IP connection on the given protocol number.  I.e. is sends the message as the body of the
 // IP packet with no additional header.
 type rawIP struct {
+	opts               *options
 	localAddr          string
 	remoteAddr         string
 	protocol           string
@@ -966,7 +2203,7 @@ func (d *rawIP) Connect() error {
 		return err
 	}
 
-	d.conn, err = net.ListenPacket(d.protocol, d.localAddr)
+	d.conn, err = d.opts.listenPacket(d.protocol, d.localAddr)
 	if err != nil {
 		return fmt.Errorf("failed to connect: %w", err)
 	}
@@ -984,7 +2221,7 @@ func (d *rawIP) Send(msg []byte) error {
 }
 
 func (d *rawIP) Receive() ([]byte, error) {
-	bufIn := make([]byte, 8<<10)
+	bufIn := make([]byte, receiveBufferSize)
 	n, from, err := d.conn.ReadFrom(bufIn)
 	if err != nil {
 		log.WithError(err).Error("Failed to read from")
@@ -994,10 +2231,21 @@ func (d *rawIP) Receive() ([]byte, error) {
 	return bufIn[:n], err
 }
 
+func (d *rawIP) TCPInfo() (*connectivity.TCPInfo, error) {
+	return nil, nil
+}
+
 func (d *rawIP) MTU() (int, error) {
 	return 0, nil
 }
 
+func (d *rawIP) LocalAddr() string {
+	if d.conn == nil {
+		return ""
+	}
+	return localAddrOf(d.conn)
+}
+
 func (d *rawIP) SetReadDeadline(t time.Time) error {
 	return d.conn.SetReadDeadline(t)
 }
@@ -1012,6 +2260,9 @@ func (d *connectedSCTP) Connect() error {
 		return err
 	}
 	laddr := &sctp.SCTPAddr{IPAddrs: []net.IPAddr{*lip}, Port: lport}
+	if len(d.opts.sctpLocalAddrs) > 0 {
+		laddr.IPAddrs = d.opts.sctpLocalAddrs
+	}
 	rip, err := net.ResolveIPAddr("ip", d.remoteIpAddr)
 	if err != nil {
 		return err
@@ -1020,20 +2271,20 @@ func (d *connectedSCTP) Connect() error {
 	if err != nil {
 		return err
 	}
-	raddr := &sctp.SCTPAddr{IPAddrs: []net.IPAddr{*rip}, Port: rport}
+	raddr := &sctp.SCTPAddr{IPAddrs: append([]net.IPAddr{*rip}, d.opts.sctpRemoteAddrs...), Port: rport}
 	// Since we specify the source port rather than use an ephemeral port, if
 	// the SO_REUSEADDR and SO_REUSEPORT options are not set, when we make
 	// another call to this program, the original port is in post-close wait
 	// state and bind fails. The reuse.Dial() does not support SCTP, but the
 	// SCTP library has a SocketConfig that accepts a Control function
-	// (provided by reuse) that sets these options.
-	sCfg := sctp.SocketConfig{Control: reuse.Control}
+	// (based on reuse's) that sets these options.
+	sCfg := sctp.SocketConfig{Control: d.sctpControl(d.opts.reuseControl)}
 	d.conn, err = sCfg.Dial("sctp", laddr, raddr)
 	if err != nil {
 		return err
 	}
 
-	d.r = bufio.NewReader(d.conn)
+	d.r = bufio.NewReaderSize(d.conn, receiveBufferSize)
 	d.w = bufio.NewWriter(d.conn)
 
 	return nil
@@ -1058,10 +2309,21 @@ func (d *connectedSCTP) Close() error {
 	return d.conn.Close()
 }
 
+func (d *connectedSCTP) TCPInfo() (*connectivity.TCPInfo, error) {
+	return nil, nil
+}
+
 func (d *connectedSCTP) MTU() (int, error) {
 	return 0, nil
 }
 
+func (d *connectedSCTP) LocalAddr() string {
+	if d.conn == nil {
+		return ""
+	}
+	return localAddrOf(d.conn)
+}
+
 func (d *connectedSCTP) SetReadDeadline(t time.Time) error {
 	return d.conn.SetReadDeadline(t)
 }
@@ -1123,7 +2385,7 @@ func (c *tcpConn6Raw) Write(f func(fd uintptr) (done bool)) error {
 	panic("not implemeneted")
 }
 
-func tcpForceV6(ip net.IP, port int) (net.Conn, error) {
+func (o *options) tcpForceV6(ip net.IP, port int) (net.Conn, error) {
 	s, err := unix.Socket(unix.AF_INET6, unix.SOCK_STREAM, unix.IPPROTO_TCP)
 	if err != nil {
 		return nil, err
@@ -1139,6 +2401,11 @@ func tcpForceV6(ip net.IP, port int) (net.Conn, error) {
 		return nil, err
 	}
 
+	err = o.setSocketOpts(s)
+	if err != nil {
+		return nil, err
+	}
+
 	saddr := unix.SockaddrInet6{
 		Port: port,
 	}
@@ -1158,6 +2425,7 @@ func tcpForceV6(ip net.IP, port int) (net.Conn, error) {
 
 // connectedTCP abstracts an SCTP stream.
 type connectedTCP struct {
+	opts       *options
 	localAddr  string
 	remoteAddr string
 
@@ -1170,7 +2438,7 @@ func (d *connectedTCP) Connect() error {
 	// Since we specify the source port rather than use an ephemeral port, if
 	// the SO_REUSEADDR and SO_REUSEPORT options are not set, when we make
 	// another call to this program, the original port is in post-close wait
-	// state and bind fails.  The reuse library implements a Dial() that sets
+	// state and bind fails.  Our dial(), like the reuse library's Dial(), sets
 	// these options.
 
 	var conn net.Conn
@@ -1186,23 +2454,40 @@ func (d *connectedTCP) Connect() error {
 			var err error
 
 			p, _ := strconv.Atoi(port)
-			if conn, err = tcpForceV6(ip, p); err != nil {
+			if conn, err = d.opts.tcpForceV6(ip, p); err != nil {
 				return fmt.Errorf("failed creating v6 connection for ip %s", d.remoteAddr)
 			}
 		}
 	}
 
+	if conn == nil && d.opts.viaProxy != nil {
+		var err error
+		conn, err = d.opts.dialViaProxy(d.localAddr, d.remoteAddr)
+		if err != nil {
+			return err
+		}
+	}
+
+	if conn == nil && d.opts.tcpFastOpen {
+		d.opts.primeFastOpen(d.localAddr, d.remoteAddr)
+	}
+
 	if conn == nil {
 		var err error
-		conn, err = reuse.Dial("tcp", d.localAddr, d.remoteAddr)
+		conn, err = d.opts.dial("tcp", d.localAddr, d.remoteAddr)
 		if err != nil {
 			return err
 		}
 	}
 
+	if err := d.opts.maybeSendProxyHeader(conn); err != nil {
+		_ = conn.Close()
+		return err
+	}
+
 	d.conn = conn
 
-	d.r = bufio.NewReader(d.conn)
+	d.r = bufio.NewReaderSize(d.conn, receiveBufferSize)
 	d.w = bufio.NewWriter(d.conn)
 	return nil
 }
@@ -1234,6 +2519,26 @@ func (d *connectedTCP) MTU() (int, error) {
 	return utils.ConnMTU(d.conn.(utils.HasSyscallConn))
 }
 
+func (d *connectedTCP) LocalAddr() string {
+	if d.conn == nil {
+		return ""
+	}
+	return localAddrOf(d.conn)
+}
+
+func (d *connectedTCP) TCPInfo() (*connectivity.TCPInfo, error) {
+	info, err := utils.ConnTCPInfo(d.conn.(utils.HasSyscallConn))
+	if err != nil {
+		return nil, err
+	}
+	return &connectivity.TCPInfo{
+		Retransmits:  int(info.Total_retrans),
+		RTT:          time.Duration(info.Rtt) * time.Microsecond,
+		MSS:          int(info.Snd_mss),
+		SYNDataAcked: info.Options&tcpiOptSYNData != 0,
+	}, nil
+}
+
 func (d *connectedTCP) SetReadDeadline(t time.Time) error {
 	return d.conn.SetReadDeadline(t)
 }
@@ -0,0 +1,183 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+
+	"github.com/projectcalico/calico/felix/fv/connectivity"
+)
+
+// tracePathBasePort is the first UDP port that path trace probes go to, like
+// traceroute, each hop uses the next port.
+const tracePathBasePort = 33434
+
+// sizeofSockExtendedErr is the size of struct sock_extended_err, which precedes
+// the offender's address in an IP_RECVERR message.
+const sizeofSockExtendedErr = 16
+
+// hopReply is what a path trace probe with a given TTL got back.
+type hopReply struct {
+	from    net.IP // nil if nothing answered.
+	reached bool   // the target answered.
+	final   bool   // the hop rejected the probe, there is no point going further.
+}
+
+// tracePath finds the path to the target by sending UDP probes with increasing
+// TTLs and reading the ICMP errors that they provoke from the socket's error
+// queue (IP_RECVERR), which doesn't need a raw socket.  The probes carry the same
+// socket options as the check so that they take the same path.
+func (o *options) tracePath(targetIP, sourceIP string) connectivity.PathTrace {
+	var trace connectivity.PathTrace
+	for ttl := 1; ttl <= connectivity.PathTraceMaxHops; ttl++ {
+		reply, err := o.probeHop(targetIP, sourceIP, ttl)
+		if err != nil {
+			log.WithError(err).WithField("ttl", ttl).Warn("Path trace probe failed")
+			break
+		}
+		hop := connectivity.PathTraceSilentHop
+		if reply.from != nil {
+			hop = reply.from.String()
+		}
+		trace.Hops = append(trace.Hops, hop)
+		if reply.reached {
+			trace.Reached = true
+			break
+		}
+		if reply.final {
+			break
+		}
+	}
+	log.WithField("path", trace.String()).Info("Traced path")
+	return trace
+}
+
+func (o *options) probeHop(targetIP, sourceIP string, ttl int) (*hopReply, error) {
+	target := net.ParseIP(targetIP)
+	if target == nil {
+		return nil, fmt.Errorf("invalid target IP %s", targetIP)
+	}
+	v6 := target.To4() == nil
+	family, level, recvErrOpt, ttlOpt := unix.AF_INET, unix.SOL_IP, unix.IP_RECVERR, unix.IP_TTL
+	if v6 {
+		family, level, recvErrOpt, ttlOpt = unix.AF_INET6, unix.SOL_IPV6, unix.IPV6_RECVERR, unix.IPV6_UNICAST_HOPS
+	}
+
+	fd, err := unix.Socket(family, unix.SOCK_DGRAM, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer unix.Close(fd)
+	if err := o.setSocketOpts(fd); err != nil {
+		return nil, err
+	}
+	if err := unix.SetsockoptInt(fd, level, recvErrOpt, 1); err != nil {
+		return nil, err
+	}
+	if err := unix.SetsockoptInt(fd, level, ttlOpt, ttl); err != nil {
+		return nil, err
+	}
+	if src := net.ParseIP(sourceIP); src != nil && !src.IsUnspecified() {
+		if err := unix.Bind(fd, sockaddr(src, 0)); err != nil {
+			return nil, err
+		}
+	}
+	if err := unix.Connect(fd, sockaddr(target, tracePathBasePort+ttl)); err != nil {
+		return nil, err
+	}
+	if _, err := unix.Write(fd, []byte("path-trace")); err != nil {
+		return nil, err
+	}
+
+	timeoutMs := int(connectivity.PathTraceHopTimeout.Milliseconds())
+	fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN | unix.POLLERR}}
+	n, err := unix.Poll(fds, timeoutMs)
+	if err != nil && err != unix.EINTR {
+		return nil, err
+	}
+	if n == 0 {
+		return &hopReply{}, nil
+	}
+	if fds[0].Revents&unix.POLLERR == 0 {
+		// The target's server answered the probe.
+		return &hopReply{from: target, reached: true}, nil
+	}
+
+	buf := make([]byte, 512)
+	oob := make([]byte, 512)
+	_, oobn, _, _, err := unix.Recvmsg(fd, buf, oob, unix.MSG_ERRQUEUE)
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range msgs {
+		if m.Header.Level != int32(level) || m.Header.Type != int32(recvErrOpt) {
+			continue
+		}
+		return parseRecvErr(m.Data, v6), nil
+	}
+	return &hopReply{}, nil
+}
+
+// parseRecvErr parses a sock_extended_err, followed by the address of the node
+// that sent the ICMP error.
+func parseRecvErr(data []byte, v6 bool) *hopReply {
+	if len(data) < sizeofSockExtendedErr {
+		return &hopReply{}
+	}
+	origin, icmpType, icmpCode := data[4], data[5], data[6]
+	offender := data[sizeofSockExtendedErr:]
+
+	reply := &hopReply{}
+	if v6 {
+		if len(offender) >= unix.SizeofSockaddrInet6 {
+			reply.from = net.IP(append([]byte(nil), offender[8:24]...))
+		}
+	} else if len(offender) >= unix.SizeofSockaddrInet4 {
+		reply.from = net.IP(append([]byte(nil), offender[4:8]...))
+	}
+
+	switch {
+	case origin == unix.SO_EE_ORIGIN_ICMP && icmpType == 11,
+		origin == unix.SO_EE_ORIGIN_ICMP6 && icmpType == 3:
+		// Time exceeded: an intermediate hop.
+	case origin == unix.SO_EE_ORIGIN_ICMP && icmpType == 3 && icmpCode == 3,
+		origin == unix.SO_EE_ORIGIN_ICMP6 && icmpType == 1 && icmpCode == 4:
+		// Port unreachable: only the target sends those.
+		reply.reached = true
+	default:
+		// Any other unreachable: the probe was rejected on the way.
+		reply.final = true
+	}
+	return reply
+}
+
+func sockaddr(ip net.IP, port int) unix.Sockaddr {
+	if ip4 := ip.To4(); ip4 != nil {
+		sa := &unix.SockaddrInet4{Port: port}
+		copy(sa.Addr[:], ip4)
+		return sa
+	}
+	sa := &unix.SockaddrInet6{Port: port}
+	copy(sa.Addr[:], ip.To16())
+	return sa
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/proxy"
+)
+
+// parseViaProxy parses the --via-proxy URL, which must be http:// for a proxy
+// that supports CONNECT or socks5://.
+func parseViaProxy(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "socks5" {
+		return nil, fmt.Errorf("unsupported proxy scheme %q, expected http or socks5", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("proxy URL %q has no host", s)
+	}
+	return u, nil
+}
+
+// dialViaProxy opens a TCP connection to raddr through the proxy, binding our
+// end of the connection to the proxy to laddr.
+func (o *options) dialViaProxy(laddr, raddr string) (net.Conn, error) {
+	log.WithFields(log.Fields{"proxy": o.viaProxy.Redacted(), "target": raddr}).Debug("Connecting via proxy")
+	if o.viaProxy.Scheme == "socks5" {
+		var auth *proxy.Auth
+		if o.viaProxy.User != nil {
+			password, _ := o.viaProxy.User.Password()
+			auth = &proxy.Auth{User: o.viaProxy.User.Username(), Password: password}
+		}
+		d, err := proxy.SOCKS5("tcp", o.viaProxy.Host, auth, localDialer{opts: o, laddr: laddr})
+		if err != nil {
+			return nil, err
+		}
+		return d.Dial("tcp", raddr)
+	}
+
+	conn, err := o.dial("tcp", laddr, o.viaProxy.Host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to proxy: %w", err)
+	}
+	req := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n", raddr, raddr)
+	if o.viaProxy.User != nil {
+		password, _ := o.viaProxy.User.Password()
+		creds := base64.StdEncoding.EncodeToString([]byte(o.viaProxy.User.Username() + ":" + password))
+		req += "Proxy-Authorization: Basic " + creds + "\r\n"
+	}
+	if _, err := conn.Write([]byte(req + "\r\n")); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to send CONNECT to proxy: %w", err)
+	}
+	head, err := readResponseHead(conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to read CONNECT response from proxy: %w", err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(head)), &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to read CONNECT response from proxy: %w", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		_ = conn.Close()
+		return nil, fmt.Errorf("proxy refused CONNECT to %s: %s", raddr, resp.Status)
+	}
+	return conn, nil
+}
+
+// readResponseHead reads the status line and headers of the proxy's response a
+// byte at a time, so that none of the tunneled data that may follow is consumed.
+func readResponseHead(conn net.Conn) ([]byte, error) {
+	var head []byte
+	b := make([]byte, 1)
+	for !bytes.HasSuffix(head, []byte("\r\n\r\n")) {
+		if len(head) > 64<<10 {
+			return nil, errors.New("response headers too long")
+		}
+		if _, err := conn.Read(b); err != nil {
+			return nil, err
+		}
+		head = append(head, b[0])
+	}
+	return head, nil
+}
+
+// localDialer dials from a local address with our socket options.
+type localDialer struct {
+	opts  *options
+	laddr string
+}
+
+func (l localDialer) Dial(network, addr string) (net.Conn, error) {
+	return l.opts.dial(network, l.laddr, addr)
+}
@@ -0,0 +1,57 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// fastOpenQueueLen is the length of the queue of TCP Fast Open connections that
+// haven't completed the handshake yet.
+const fastOpenQueueLen = 16
+
+// listenTCP listens for TCP connections, with TCP Fast Open enabled if
+// fastOpen is set, so that clients can test it.  The kernel only accepts data in
+// the SYN if net.ipv4.tcp_fastopen enables the server side, see
+// enableFastOpen().
+func listenTCP(addr string, fastOpen bool) (net.Listener, error) {
+	if !fastOpen {
+		return net.Listen("tcp", addr)
+	}
+	lc := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			return c.Control(func(fd uintptr) {
+				err := unix.SetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_FASTOPEN, fastOpenQueueLen)
+				if err != nil {
+					log.WithError(err).Info("Failed to enable TCP Fast Open")
+				}
+			})
+		},
+	}
+	return lc.Listen(context.Background(), "tcp", addr)
+}
+
+// enableFastOpen enables both the client and server sides of TCP Fast Open in
+// the current namespace.
+func enableFastOpen() {
+	if err := writeProcSys("/proc/sys/net/ipv4/tcp_fastopen", "3"); err != nil {
+		log.WithError(err).Info("Failed to enable TCP Fast Open")
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/projectcalico/calico/felix/fv/connectivity"
+)
+
+// ftpDataTimeout is how long we wait for the data connection of an FTP
+// session.
+const ftpDataTimeout = 10 * time.Second
+
+// isFTPCommand returns whether a connection starts with an FTP command, rather
+// than one of our JSON requests.
+func isFTPCommand(r *bufio.Reader) bool {
+	b, err := r.Peek(1)
+	return err == nil && b[0] >= 'A' && b[0] <= 'Z'
+}
+
+// serveFTP speaks enough FTP on a control connection for a client to fetch a
+// response over a data connection, in passive or active mode.  The client speaks
+// first, we don't send a greeting.  The response describes the data connection.
+func serveFTP(conn net.Conn, r *bufio.Reader, namespace ns.NetNS) {
+	logCxt := log.WithField("remoteAddr", conn.RemoteAddr())
+	localAddr := conn.LocalAddr().(*net.TCPAddr)
+
+	var pasv net.Listener
+	var activeAddr *net.TCPAddr
+	defer func() {
+		if pasv != nil {
+			_ = pasv.Close()
+		}
+	}()
+
+	reply := func(format string, a ...interface{}) bool {
+		_, err := fmt.Fprintf(conn, format+"\r\n", a...)
+		if err != nil {
+			logCxt.WithError(err).Error("Failed to write FTP reply")
+		}
+		return err == nil
+	}
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			if err != io.EOF {
+				logCxt.WithError(err).Error("Failed to read FTP command")
+			}
+			return
+		}
+		cmd, arg, _ := strings.Cut(strings.TrimSpace(line), " ")
+		logCxt.WithFields(log.Fields{"cmd": cmd, "arg": arg}).Info("FTP command")
+
+		ok := true
+		switch strings.ToUpper(cmd) {
+		case "USER":
+			ok = reply("230 Logged in.")
+		case "PASV", "EPSV":
+			if pasv != nil {
+				_ = pasv.Close()
+			}
+			activeAddr = nil
+			err = namespace.Do(func(_ ns.NetNS) error {
+				pasv, err = net.Listen("tcp", net.JoinHostPort(localAddr.IP.String(), "0"))
+				return err
+			})
+			if err != nil {
+				logCxt.WithError(err).Error("Failed to listen for FTP data connection")
+				ok = reply("425 Can't open data connection.")
+				break
+			}
+			pasvAddr := pasv.Addr().(*net.TCPAddr)
+			if strings.ToUpper(cmd) == "EPSV" {
+				ok = reply("229 Entering Extended Passive Mode (%s).", connectivity.FTPExtAddr(nil, pasvAddr.Port))
+				break
+			}
+			hostPort, err := connectivity.FTPHostPort(pasvAddr)
+			if err != nil {
+				ok = reply("522 Use EPSV.")
+				break
+			}
+			ok = reply("227 Entering Passive Mode (%s).", hostPort)
+		case "PORT":
+			activeAddr, err = connectivity.ParseFTPHostPort(arg)
+			if err != nil {
+				ok = reply("501 %v.", err)
+				break
+			}
+			ok = reply("200 PORT command successful.")
+		case "EPRT":
+			ip, port, err := connectivity.ParseFTPExtAddr(arg)
+			if err != nil || ip == nil {
+				ok = reply("501 Malformed EPRT.")
+				break
+			}
+			activeAddr = &net.TCPAddr{IP: ip, Port: port}
+			ok = reply("200 EPRT command successful.")
+		case "RETR":
+			if !reply("150 Opening data connection.") {
+				return
+			}
+			err = sendFTPData(namespace, localAddr, pasv, activeAddr)
+			if err != nil {
+				logCxt.WithError(err).Error("FTP data connection failed")
+				ok = reply("425 Can't open data connection.")
+				break
+			}
+			ok = reply("226 Transfer complete.")
+		case "QUIT":
+			reply("221 Goodbye.")
+			return
+		default:
+			ok = reply("502 Command not implemented.")
+		}
+		if !ok {
+			return
+		}
+	}
+}
+
+// sendFTPData opens the data connection, by accepting it on the passive listener
+// or connecting to the client's address in active mode, and sends the response
+// over it.
+func sendFTPData(namespace ns.NetNS, localAddr *net.TCPAddr, pasv net.Listener, activeAddr *net.TCPAddr) error {
+	var data net.Conn
+	var err error
+	switch {
+	case activeAddr != nil:
+		err = namespace.Do(func(_ ns.NetNS) error {
+			d := net.Dialer{
+				LocalAddr: &net.TCPAddr{IP: localAddr.IP},
+				Timeout:   ftpDataTimeout,
+			}
+			data, err = d.Dial("tcp", activeAddr.String())
+			return err
+		})
+	case pasv != nil:
+		_ = pasv.(*net.TCPListener).SetDeadline(time.Now().Add(ftpDataTimeout))
+		data, err = pasv.Accept()
+	default:
+		return fmt.Errorf("no PASV, EPSV, PORT or EPRT before RETR")
+	}
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+
+	response := connectivity.Response{
+		Version:    connectivity.ProtocolVersion,
+		Timestamp:  time.Now(),
+		SourceAddr: data.RemoteAddr().String(),
+		ServerAddr: data.LocalAddr().String(),
+		ReceivedOn: interfaceWithAddr(namespace, data.LocalAddr()),
+		Namespace:  namespace.Path(),
+		Protocol:   data.LocalAddr().Network(),
+	}
+	return json.NewEncoder(data).Encode(&response)
+}
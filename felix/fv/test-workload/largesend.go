@@ -0,0 +1,42 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/projectcalico/calico/felix/fv/utils"
+)
+
+// largeSendReadSize is the size of the reads of a large send, big enough for
+// the reads to show how much data arrives at once.
+const largeSendReadSize = 256 << 10
+
+// receivedSegmentSize returns the size of the biggest segments received on a
+// TCP connection, zero if unknown.
+func receivedSegmentSize(conn net.Conn) int {
+	hsc, ok := conn.(utils.HasSyscallConn)
+	if !ok {
+		return 0
+	}
+	info, err := utils.ConnTCPInfo(hsc)
+	if err != nil {
+		log.WithError(err).Warn("Failed to get TCP_INFO")
+		return 0
+	}
+	return int(info.Rcv_mss)
+}
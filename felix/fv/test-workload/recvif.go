@@ -0,0 +1,159 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// packetReader reads packets along with the index of the interface that they
+// arrived on, where the socket supports it.
+type packetReader struct {
+	net.PacketConn
+	v4 *ipv4.PacketConn
+	v6 *ipv6.PacketConn
+
+	// names caches the interface names, since looking them up means switching
+	// into the namespace.
+	names map[int]string
+}
+
+func newPacketReader(logCxt *log.Entry, p net.PacketConn) *packetReader {
+	r := &packetReader{PacketConn: p, names: map[int]string{}}
+	var ip net.IP
+	switch a := p.LocalAddr().(type) {
+	case *net.UDPAddr:
+		ip = a.IP
+	case *net.IPAddr:
+		ip = a.IP
+	}
+	// A socket bound to the unspecified IP is usually dual stack, which reports
+	// the interface of IPv4 packets through the IPv6 options.
+	var err error
+	if ip.To4() == nil || ip.IsUnspecified() {
+		r.v6 = ipv6.NewPacketConn(p)
+		if err = r.v6.SetControlMessage(ipv6.FlagInterface, true); err != nil {
+			r.v6 = nil
+		}
+	}
+	if r.v6 == nil && (ip.To4() != nil || ip.IsUnspecified()) {
+		r.v4 = ipv4.NewPacketConn(p)
+		if err = r.v4.SetControlMessage(ipv4.FlagInterface, true); err != nil {
+			r.v4 = nil
+		}
+	}
+	if err != nil {
+		logCxt.WithError(err).Info("Can't tell the receiving interface of packets")
+	}
+	return r
+}
+
+// ReadFromInterface reads a packet, returning the index of the interface that it
+// arrived on, or 0 if unknown.
+func (r *packetReader) ReadFromInterface(b []byte) (n int, addr net.Addr, ifIndex int, err error) {
+	switch {
+	case r.v4 != nil:
+		var cm *ipv4.ControlMessage
+		n, cm, addr, err = r.v4.ReadFrom(b)
+		if cm != nil {
+			ifIndex = cm.IfIndex
+		}
+	case r.v6 != nil:
+		var cm *ipv6.ControlMessage
+		n, cm, addr, err = r.v6.ReadFrom(b)
+		if cm != nil {
+			ifIndex = cm.IfIndex
+		}
+	default:
+		n, addr, err = r.ReadFrom(b)
+	}
+	return
+}
+
+// InterfaceName returns the name of the interface with the given index in the
+// namespace, or "" if unknown.
+func (r *packetReader) InterfaceName(namespace ns.NetNS, ifIndex int) string {
+	name, ok := r.names[ifIndex]
+	if !ok {
+		name = interfaceName(namespace, ifIndex)
+		r.names[ifIndex] = name
+	}
+	return name
+}
+
+// interfaceName returns the name of the interface with the given index in the
+// namespace, or "" if unknown.
+func interfaceName(namespace ns.NetNS, ifIndex int) (name string) {
+	if ifIndex == 0 {
+		return ""
+	}
+	_ = namespace.Do(func(_ ns.NetNS) error {
+		iface, err := net.InterfaceByIndex(ifIndex)
+		if err != nil {
+			log.WithError(err).WithField("index", ifIndex).Info("Failed to look up interface")
+			return nil
+		}
+		name = iface.Name
+		return nil
+	})
+	return
+}
+
+// interfaceWithAddr returns the name of the interface that has the address of
+// the local end of a connection, which is where connections to it arrive unless
+// routing inside the namespace sends them elsewhere.  Unlike for packets, the
+// kernel doesn't report the receiving interface of stream sockets.
+func interfaceWithAddr(namespace ns.NetNS, addr net.Addr) (name string) {
+	var ip net.IP
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		ip = a.IP
+	default:
+		host, _, err := net.SplitHostPort(addr.String())
+		if err != nil {
+			return ""
+		}
+		ip = net.ParseIP(host)
+	}
+	if ip == nil {
+		return ""
+	}
+	_ = namespace.Do(func(_ ns.NetNS) error {
+		ifaces, err := net.Interfaces()
+		if err != nil {
+			log.WithError(err).Info("Failed to list interfaces")
+			return nil
+		}
+		for _, iface := range ifaces {
+			addrs, err := iface.Addrs()
+			if err != nil {
+				continue
+			}
+			for _, a := range addrs {
+				if ipNet, ok := a.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+					name = iface.Name
+					return nil
+				}
+			}
+		}
+		return nil
+	})
+	return
+}
@@ -43,7 +43,7 @@ const usage = `test-workload, test workload for Felix FV testing.
 If <interface-name> is "", the workload will start in the current namespace.
 
 Usage:
-  test-workload [--protocol=<protocol>] [--namespace-path=<path>] [--sidecar-iptables] [--up-lo] [--mtu=<mtu>] [--listen-any-ip] <interface-name> <ip-address> <ports>
+  test-workload [--protocol=<protocol>] [--namespace-path=<path>] [--sidecar-iptables] [--up-lo] [--mtu=<mtu>] [--listen-any-ip] [--tcp-fast-open] <interface-name> <ip-address> <ports>
 `
 
 func main() {
@@ -79,6 +79,10 @@ func main() {
 		listenAnyIP = true
 	}
 
+	// TCP Fast Open changes how the TCP handshake looks to the dataplane, so
+	// only the workloads of the specs that test it enable it.
+	tcpFastOpen := arguments["--tcp-fast-open"].(bool)
+
 	ports := strings.Split(portsStr, ",")
 
 	var namespace ns.NetNS
@@ -178,6 +182,10 @@ func main() {
 				log.WithError(err).Info("Failed to set dev lo up")
 			}
 
+			if tcpFastOpen {
+				enableFastOpen()
+			}
+
 			if strings.Contains(ipAddress, ":") {
 				// Make sure ipv6 is enabled in the container/pod network namespace.
 				// Without these sysctls enabled, interfaces will come up but they won't get a link local IPv6 address,
@@ -315,8 +323,32 @@ func main() {
 				}()
 			}
 
-			decoder := json.NewDecoder(conn)
+			receivedOn := ""
+			network := ""
+			if conn.LocalAddr() != nil {
+				receivedOn = interfaceWithAddr(namespace, conn.LocalAddr())
+				network = conn.LocalAddr().Network()
+			}
+
+			// A PROXY protocol header, if any, comes before the first request.
+			r := bufio.NewReader(conn)
+			proxyHeader, err := connectivity.ReadProxyV2(r)
+			if err != nil {
+				log.WithError(err).Error("failed to read PROXY header")
+				return
+			}
+			if proxyHeader != nil {
+				log.WithField("header", proxyHeader).Info("Received PROXY header")
+			}
+
+			if _, isTCP := conn.(*net.TCPConn); isTCP && isFTPCommand(r) {
+				serveFTP(conn, r, namespace)
+				return
+			}
+
+			decoder := json.NewDecoder(r)
 			w := bufio.NewWriter(conn)
+			received := 0
 
 			for {
 				var request connectivity.Request
@@ -326,16 +358,27 @@ func main() {
 					log.WithError(err).Error("failed to read request")
 					return
 				}
+				if !connectivity.IsCalibrationMessage(request.Payload) {
+					received++
+				}
 
+				var largeSend *connectivity.LargeSendStats
 				if request.SendSize > 0 {
 					rcv := request.SendSize
 					buff := make([]byte, 4096)
+					if request.LargeSend {
+						buff = make([]byte, largeSendReadSize)
+						largeSend = &connectivity.LargeSendStats{}
+					}
 
-					r := decoder.Buffered()
+					buffered := decoder.Buffered()
 
 					for rcv > 0 {
-						n, err := r.Read(buff)
+						n, err := buffered.Read(buff)
 						rcv -= n
+						if largeSend != nil && n > 0 {
+							largeSend.AddRead(n)
+						}
 						if err == io.EOF {
 							break
 						}
@@ -344,18 +387,24 @@ func main() {
 					for rcv > 0 {
 						var err error
 						n := 0
-						if rcv < 4096 {
-							n, err = conn.Read(buff[:rcv])
+						if rcv < len(buff) {
+							n, err = r.Read(buff[:rcv])
 						} else {
-							n, err = conn.Read(buff)
+							n, err = r.Read(buff)
 						}
 						rcv -= n
+						if largeSend != nil {
+							largeSend.AddRead(n)
+						}
 						if err != nil {
 							log.Errorf("Reading from connection failed. %d bytes too short\n", rcv)
 							return
 						}
 					}
 				}
+				if largeSend != nil {
+					largeSend.SegmentSize = receivedSegmentSize(conn)
+				}
 
 				seenSrc := "<unknown>"
 				seenLocal := "<unknown>"
@@ -367,10 +416,18 @@ func main() {
 				}
 
 				response := connectivity.Response{
+					Version:    connectivity.ProtocolVersion,
 					Timestamp:  time.Now(),
 					SourceAddr: seenSrc,
 					ServerAddr: seenLocal,
+					ReceivedOn: receivedOn,
+					Namespace:  namespace.Path(),
+					Protocol:   network,
+					Proxy:      proxyHeader,
+					LargeSend:  largeSend,
 					Request:    request,
+
+					RequestsReceived: received,
 				}
 
 				respBytes, err := json.Marshal(&response)
@@ -431,7 +488,7 @@ func main() {
 				panicIfError(err)
 				logCxt.Info("Listening for raw IP packets")
 
-				go loopRespondingToPackets(logCxt, p)
+				go loopRespondingToPackets(logCxt, p, namespace)
 			} else if protocol == "udp" {
 				// Since UDP is connectionless, we can't use Listen() as we do for TCP.  Instead,
 				// we use ListenPacket so that we can directly send/receive individual packets.
@@ -440,7 +497,7 @@ func main() {
 				panicIfError(err)
 				logCxt.Info("Listening for UDP connections")
 
-				go loopRespondingToPackets(logCxt, p)
+				go loopRespondingToPackets(logCxt, p, namespace)
 			} else if protocol == "sctp" {
 				portInt, err := strconv.Atoi(port)
 				panicIfError(err)
@@ -450,6 +507,11 @@ func main() {
 					IPAddrs: []net.IPAddr{*netIP},
 					Port:    portInt,
 				}
+				if anyIP, _ := arguments.Bool("--listen-any-ip"); anyIP {
+					// Bound to all of our addresses, the association is
+					// multi-homed, if the client gives it them.
+					sAddrs.IPAddrs = nil
+				}
 				logCxt.Info("About to listen for SCTP connections")
 				l, err := sctp.ListenSCTP("sctp", sAddrs)
 				panicIfError(err)
@@ -464,7 +526,7 @@ func main() {
 				}()
 			} else {
 				logCxt.Info("About to listen for TCP connections")
-				l, err := net.Listen("tcp", myAddr)
+				l, err := listenTCP(myAddr, tcpFastOpen)
 				panicIfError(err)
 				logCxt.Info("Listening for TCP connections")
 				go func() {
@@ -484,11 +546,20 @@ func main() {
 	panicIfError(err)
 }
 
-func loopRespondingToPackets(logCxt *log.Entry, p net.PacketConn) {
+// maxCountedClients bounds the number of clients whose requests a packet server
+// counts at a time.
+const maxCountedClients = 4096
+
+func loopRespondingToPackets(logCxt *log.Entry, p net.PacketConn, namespace ns.NetNS) {
 	defer p.Close()
+	r := newPacketReader(logCxt, p)
+	// Big enough for the padded requests of packet loss tests.
+	buffer := make([]byte, 64<<10)
+	// Requests received per client connection, for the clients to tell the
+	// loss of their requests from the loss of our responses.
+	received := map[string]int{}
 	for {
-		buffer := make([]byte, 1024)
-		n, addr, err := p.ReadFrom(buffer)
+		n, addr, ifIndex, err := r.ReadFromInterface(buffer)
 		panicIfError(err)
 
 		var request connectivity.Request
@@ -497,12 +568,26 @@ func loopRespondingToPackets(logCxt *log.Entry, p net.PacketConn) {
 			logCxt.WithError(err).WithField("remoteAddr", addr).Info("Failed to parse data")
 			continue
 		}
+		if len(received) >= maxCountedClients {
+			// Forget about clients that are long gone.
+			received = map[string]int{}
+		}
+		client := addr.String() + "/" + connectivity.TestMessageConnection(request.Payload)
+		if !connectivity.IsCalibrationMessage(request.Payload) {
+			received[client]++
+		}
 
 		response := connectivity.Response{
+			Version:    connectivity.ProtocolVersion,
 			Timestamp:  time.Now(),
 			SourceAddr: addr.String(),
 			ServerAddr: p.LocalAddr().String(),
+			ReceivedOn: r.InterfaceName(namespace, ifIndex),
+			Namespace:  namespace.Path(),
+			Protocol:   p.LocalAddr().Network(),
 			Request:    request,
+
+			RequestsReceived: received[client],
 		}
 
 		data, err := json.Marshal(&response)
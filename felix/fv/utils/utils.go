@@ -28,6 +28,7 @@ import (
 	"github.com/kelseyhightower/envconfig"
 	. "github.com/onsi/gomega"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
 
 	api "github.com/projectcalico/api/pkg/apis/projectcalico/v3"
 
@@ -259,6 +260,30 @@ func ConnMTU(hsc HasSyscallConn) (int, error) {
 	return mtu, nil
 }
 
+// ConnTCPInfo returns the TCP_INFO of a TCP connection.
+func ConnTCPInfo(hsc HasSyscallConn) (*unix.TCPInfo, error) {
+	c, err := hsc.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var info *unix.TCPInfo
+	var sysErr error
+	err = c.Control(func(fd uintptr) {
+		info, sysErr = unix.GetsockoptTCPInfo(int(fd), unix.IPPROTO_TCP, unix.TCP_INFO)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if sysErr != nil {
+		return nil, sysErr
+	}
+
+	return info, nil
+}
+
 func UpdateFelixConfig(client client.Interface, deltaFn func(*api.FelixConfiguration)) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -61,6 +61,7 @@ type Workload struct {
 	isRunning             bool
 	isSpoofing            bool
 	listenAnyIP           bool
+	tcpFastOpen           bool
 
 	cleanupLock sync.Mutex
 }
@@ -134,6 +135,15 @@ func WithListenAnyIP() Opt {
 	}
 }
 
+// WithTCPFastOpen enables TCP Fast Open in the workload's namespace and on its
+// TCP server, for probes with connectivity.ExpectWithTCPFastOpen() or
+// connectivity.ExpectTCPFastOpenAccepted().
+func WithTCPFastOpen() Opt {
+	return func(w *Workload) {
+		w.tcpFastOpen = true
+	}
+}
+
 func New(c *infrastructure.Felix, name, profile, ip, ports, protocol string, opts ...Opt) *Workload {
 	workloadIdx++
 	n := fmt.Sprintf("%s-idx%v", name, workloadIdx)
@@ -213,6 +223,10 @@ func (w *Workload) Start() error {
 		command += " --listen-any-ip"
 	}
 
+	if w.tcpFastOpen {
+		command += " --tcp-fast-open"
+	}
+
 	w.runCmd = utils.Command("docker", "exec", w.C.Name, "sh", "-c", command)
 	w.outPipe, err = w.runCmd.StdoutPipe()
 	if err != nil {
@@ -390,6 +404,11 @@ func (w *Workload) SourceName() string {
 	return w.Name
 }
 
+// HostContainerName returns the name of the container that hosts the workload.
+func (w *Workload) HostContainerName() string {
+	return w.C.Name
+}
+
 func (w *Workload) SourceIPs() []string {
 	return []string{w.IP}
 }
@@ -620,6 +639,7 @@ func (w *Workload) ToMatcher(explicitPort ...uint16) *connectivity.Matcher {
 		Port:       port,
 		TargetName: fmt.Sprintf("%s on port %s", w.Name, port),
 		Protocol:   "tcp",
+		Host:       w.C.Name,
 	}
 }
 
@@ -778,6 +798,7 @@ func (p *Port) ToMatcher(explicitPort ...uint16) *connectivity.Matcher {
 		IP:         p.Workload.IP,
 		Port:       fmt.Sprint(p.Port),
 		TargetName: fmt.Sprintf("%s on port %d", p.Workload.Name, p.Port),
+		Host:       p.Workload.C.Name,
 	}
 }
 